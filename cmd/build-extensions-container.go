@@ -6,8 +6,10 @@ import (
 
 	cosamodel "github.com/coreos/coreos-assembler/internal/pkg/cosa"
 	"github.com/coreos/coreos-assembler/internal/pkg/cosash"
+	"github.com/coreos/coreos-assembler/internal/pkg/events"
 	cosa "github.com/coreos/coreos-assembler/pkg/builds"
 	"github.com/pkg/errors"
+	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v2"
 
 	"crypto/sha256"
@@ -18,6 +20,16 @@ import (
 	"time"
 )
 
+var cmdBuildExtensionsContainer = &cobra.Command{
+	Use:     "buildextend-extensions-container",
+	Aliases: []string{"build-extensions-container"},
+	Short:   "Build the extensions container for the latest build",
+	Args:    cobra.NoArgs,
+	RunE: func(c *cobra.Command, args []string) error {
+		return buildExtensionContainer()
+	},
+}
+
 // hotfix is an element in hotfixes.yaml which is a repo-locked RPM set.
 type hotfix struct {
 	// URL for associated bug
@@ -77,24 +89,19 @@ func downloadHotfixes(srcdir, configpath, destdir string) error {
 	return nil
 }
 
-func generateHotfixes() (string, error) {
+func generateHotfixes(w *cosamodel.Workdir) (string, error) {
 	hotfixesTmpdir, err := os.MkdirTemp("", "")
 	if err != nil {
 		return "", err
 	}
 	defer os.RemoveAll(hotfixesTmpdir)
 
-	variant, err := cosamodel.GetVariant()
-	if err != nil {
-		return "", err
-	}
-
-	wd, err := os.Getwd()
+	variant, err := w.Variant()
 	if err != nil {
 		return "", err
 	}
 
-	srcdir := filepath.Join(wd, "src")
+	srcdir := w.Path("src")
 	p := fmt.Sprintf("%s/config/hotfixes-%s.yaml", srcdir, variant)
 	if _, err := os.Stat(p); err == nil {
 		err := downloadHotfixes(srcdir, p, hotfixesTmpdir)
@@ -105,7 +112,11 @@ func generateHotfixes() (string, error) {
 		fmt.Printf("No %s found\n", p)
 	}
 
-	out := filepath.Join(wd, "tmp/hotfixes.tar")
+	tmpdir, err := w.TmpDir()
+	if err != nil {
+		return "", err
+	}
+	out := filepath.Join(tmpdir, "hotfixes.tar")
 
 	// Serialize the hotfix RPMs into a tarball which we can pass via a virtio
 	// device to the qemu process.
@@ -121,19 +132,26 @@ func generateHotfixes() (string, error) {
 }
 
 func buildExtensionContainer() error {
-	cosaBuild, buildPath, err := cosa.ReadBuild("builds", "", "")
+	w, err := cosamodel.OpenWorkdir(".")
+	if err != nil {
+		return err
+	}
+	cosaBuild, buildPath, err := cosa.ReadBuild(w.BuildsDir(), "", "")
 	if err != nil {
 		return err
 	}
 	buildID := cosaBuild.BuildID
 	fmt.Printf("Generating extensions container for build: %s\n", buildID)
 
-	hotfixPath, err := generateHotfixes()
-	if err != nil {
+	phase := events.StartPhase("hotfixes")
+	hotfixPath, err := generateHotfixes(w)
+	if err := phase.End(err); err != nil {
 		return fmt.Errorf("generating hotfixes failed: %w", err)
 	}
 
 	arch := cosa.BuilderArch()
+	// runvm has no Go equivalent yet, so it still needs the shell state
+	// set up by prepare_build.
 	sh, err := cosash.NewCosaSh()
 	if err != nil {
 		return err
@@ -148,7 +166,8 @@ func buildExtensionContainer() error {
 		" -device virtio-blk,serial=hotfixes,drive=hotfixes" +
 		" -- /usr/lib/coreos-assembler/build-extensions-container.sh " + arch +
 		" /dev/virtio-ports/ociarchiveout " + buildID
-	if err := sh.Process(process); err != nil {
+	phase = events.StartPhase("runvm")
+	if err := phase.End(sh.Process(process)); err != nil {
 		return errors.Wrapf(err, "calling build-extensions-container.sh")
 	}
 	// Find the temporary directory allocated by the shell process, and put the OCI archive in its final place
@@ -185,11 +204,12 @@ func buildExtensionContainer() error {
 		SizeInBytes:     float64(stat.Size()),
 		SkipCompression: true,
 	}
+	events.Default().Artifact(targetPath, stat.Size(), sha256sum)
 	fmt.Printf("Generating meta.json `extensions` entry for: %s\n", buildID)
 	// The /tmp/extensions.json file is generated as part of the extension container build process.
 	// For more details, refer to:
 	// https://github.com/openshift/os/blob/master/extensions/Dockerfile
-	extensionsFilePath:= "./tmp/extensions.json"
+	extensionsFilePath := w.Path("tmp", "extensions.json")
 	fileContent, err := os.ReadFile(extensionsFilePath)
 	if err != nil {
 		fmt.Printf("Error reading JSON file: %v\n", err)
@@ -209,8 +229,8 @@ func buildExtensionContainer() error {
 		extensionsInterfaceMap[key] = value
 	}
 
-	cosaBuild.Extensions = &cosa.Extensions {
-		Manifest:        extensionsInterfaceMap,
+	cosaBuild.Extensions = &cosa.Extensions{
+		Manifest: extensionsInterfaceMap,
 	}
 	cosaBuild.MetaStamp = float64(time.Now().UnixNano())
 	newBytes, err := json.MarshalIndent(cosaBuild, "", "    ")
@@ -223,17 +243,13 @@ func buildExtensionContainer() error {
 		return errors.Wrapf(err, "writing %s", extensions_container_meta_path)
 	}
 	defer os.Remove(extensions_container_meta_path)
-	workdir, err := filepath.Abs(".")
-	if err != nil {
-		return err
-	}
 	abs_new_json, err := filepath.Abs(extensions_container_meta_path)
 	if err != nil {
 		return err
 	}
 	// Calling `cosa meta` as it locks the file and we need to make sure no other process writes to the file at the same time.
 	// Golang does not appear to have a public api to lock files at the moment. https://github.com/coreos/coreos-assembler/issues/3149
-	if err := exec.Command("cosa", "meta", "--workdir", workdir, "--build", buildID, "--artifact-json", abs_new_json).Run(); err != nil {
+	if err := exec.Command("cosa", "meta", "--workdir", w.Dir, "--build", buildID, "--artifact-json", abs_new_json).Run(); err != nil {
 		return errors.Wrapf(err, "calling `cosa meta`")
 	}
 	return nil
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+type BuildMirrorOptions struct {
+	From       string
+	To         string
+	Arches     []string
+	StagingDir string
+	S3Endpoint string
+}
+
+var (
+	buildMirrorOpts BuildMirrorOptions
+
+	cmdBuildMirror = &cobra.Command{
+		Use:   "buildmirror [BUILDID...]",
+		Short: "cosa buildmirror --from LOCATION --to LOCATION [BUILDID...]",
+		Long: "Copy builds between build stores, verifying every artifact against " +
+			"its sha256 in meta.json. A store is a local builds directory, an " +
+			"http(s):// URL or an s3://bucket/prefix location. Interrupted " +
+			"mirrors can be re-run and resume where they stopped. Defaults to " +
+			"the latest build of the source.",
+		RunE: runBuildMirrorCmd,
+	}
+)
+
+func runBuildMirrorCmd(c *cobra.Command, args []string) error {
+	if buildMirrorOpts.From == "" || buildMirrorOpts.To == "" {
+		return fmt.Errorf("--from and --to are required")
+	}
+	ctx := context.Background()
+	src, err := builds.OpenBuildStore(ctx, buildMirrorOpts.From, buildMirrorOpts.S3Endpoint)
+	if err != nil {
+		return err
+	}
+	dst, err := builds.OpenBuildStore(ctx, buildMirrorOpts.To, buildMirrorOpts.S3Endpoint)
+	if err != nil {
+		return err
+	}
+
+	bj, err := builds.FetchBuilds(ctx, src)
+	if err != nil {
+		return err
+	}
+	ids := args
+	if len(ids) == 0 {
+		latest, ok := bj.Latest()
+		if !ok {
+			return builds.ErrNoBuildsFound
+		}
+		ids = []string{latest}
+	}
+
+	workdir := buildMirrorOpts.StagingDir
+	if workdir == "" {
+		if workdir, err = os.MkdirTemp("", "cosa-buildmirror-"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(workdir)
+	}
+
+	c.SilenceUsage = true
+	for _, id := range ids {
+		entry, ok := bj.Get(id)
+		if !ok {
+			return fmt.Errorf("build %s not found in %s", id, src)
+		}
+		arches := buildMirrorOpts.Arches
+		if len(arches) == 0 {
+			arches = entry.Arches
+		}
+		for _, arch := range arches {
+			log.WithFields(log.Fields{"build": id, "arch": arch}).Infof("Mirroring %s to %s", src, dst)
+			if err := builds.MirrorBuild(ctx, src, dst, id, arch, workdir); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func init() {
+	cmdBuildMirror.Flags().StringVarP(
+		&buildMirrorOpts.From, "from", "", "",
+		"Build store to copy from")
+	cmdBuildMirror.Flags().StringVarP(
+		&buildMirrorOpts.To, "to", "", "",
+		"Build store to copy to")
+	cmdBuildMirror.Flags().StringSliceVarP(
+		&buildMirrorOpts.Arches, "arch", "", nil,
+		"Architectures to mirror (default: all arches of the build)")
+	cmdBuildMirror.Flags().StringVarP(
+		&buildMirrorOpts.StagingDir, "staging-dir", "", "",
+		"Directory to stage artifacts in; reuse it to resume interrupted transfers (default: a temporary directory)")
+	cmdBuildMirror.Flags().StringVarP(
+		&buildMirrorOpts.S3Endpoint, "s3-endpoint", "", "",
+		"Endpoint of an S3-compatible service to use instead of AWS, e.g. MinIO")
+}
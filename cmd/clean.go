@@ -1,46 +1,73 @@
-// See usage below
 package main
 
 import (
 	"fmt"
+	"os"
+	"path/filepath"
 
 	"github.com/coreos/coreos-assembler/internal/pkg/bashexec"
-	"github.com/coreos/coreos-assembler/internal/pkg/cosash"
+	cosamodel "github.com/coreos/coreos-assembler/internal/pkg/cosa"
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	"github.com/spf13/cobra"
 )
 
-func runClean(argv []string) error {
-	const cleanUsage = `Usage: coreos-assembler clean --help
-coreos-assembler clean [--all]
+type CleanOptions struct {
+	All    bool
+	DryRun bool
+}
 
-Delete all build artifacts.  Use --all to also clean the cache/ directory.
-`
+var (
+	cleanOpts CleanOptions
 
-	all := false
-	for _, arg := range argv {
-		switch arg {
-		case "h":
-		case "--help":
-			fmt.Print(cleanUsage)
-			return nil
-		case "-a":
-		case "--all":
-			all = true
-		default:
-			return fmt.Errorf("unrecognized option: %s", arg)
-		}
+	cmdClean = &cobra.Command{
+		Use:   "clean",
+		Short: "cosa clean [--all] [--dry-run]",
+		Long: "Delete all build artifacts. Use --all to also clean the cache/ " +
+			"directory. Use --dry-run to report what would be removed without " +
+			"deleting anything.",
+		Args: cobra.ExactArgs(0),
+		RunE: runCleanCmd,
 	}
+)
 
-	sh, err := cosash.NewCosaSh()
-	if err != nil {
-		return err
+func runCleanCmd(c *cobra.Command, args []string) error {
+	all := cleanOpts.All
+	dryRun := cleanOpts.DryRun
+
+	// Refuse to run outside of a cosa workdir rather than deleting
+	// the contents of whatever directory we happen to be in.
+	if _, err := os.Stat("builds"); err != nil {
+		return fmt.Errorf("not a coreos-assembler workdir (missing builds/): %w", err)
 	}
-	// XXX: why do we need to prepare_build here?
-	if _, err := sh.PrepareBuild(""); err != nil {
-		return err
+
+	dirs := []string{"builds", "tmp"}
+	if all {
+		dirs = append(dirs, "cache")
+	} else {
+		fmt.Println("Note: retaining cache/")
+	}
+
+	if dryRun {
+		if bj, err := builds.GetBuilds("builds"); err == nil {
+			for _, b := range bj.Builds {
+				fmt.Printf("Would remove build %s\n", b.ID)
+			}
+		}
+		var total int64
+		for _, d := range dirs {
+			size, err := builds.DiskUsage(d)
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Would clean %s/ (%s)\n", d, formatBytes(size))
+			total += size
+		}
+		fmt.Printf("Would free %s\n", formatBytes(total))
+		return nil
 	}
 
 	if all {
-		priv, err := sh.HasPrivileges()
+		priv, err := cosamodel.HasPrivileges()
 		if err != nil {
 			return err
 		}
@@ -51,8 +78,37 @@ Delete all build artifacts.  Use --all to also clean the cache/ directory.
 		if err := bashexec.Run("cleanup cache", cmd); err != nil {
 			return err
 		}
-	} else {
-		fmt.Println("Note: retaining cache/")
 	}
-	return bashexec.Run("cleanup", "rm -rf builds/* tmp/*")
+	for _, d := range []string{"builds", "tmp"} {
+		if err := removeDirContents(d); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeDirContents deletes everything inside dir, but not dir itself.
+func removeDirContents(dir string) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	for _, e := range entries {
+		if err := os.RemoveAll(filepath.Join(dir, e.Name())); err != nil {
+			return fmt.Errorf("cleaning %s: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+func init() {
+	cmdClean.Flags().BoolVarP(
+		&cleanOpts.All, "all", "a", false,
+		"Also clean the cache/ directory")
+	cmdClean.Flags().BoolVarP(
+		&cleanOpts.DryRun, "dry-run", "n", false,
+		"Report what would be removed without deleting anything")
 }
@@ -6,26 +6,13 @@ import (
 	"io"
 	"os"
 	"os/exec"
-	"sort"
+	"path/filepath"
 	"strings"
 	"syscall"
-)
-
-// commands we'd expect to use in the local dev path
-var buildCommands = []string{"init", "fetch", "build", "osbuild", "run", "prune", "clean", "list"}
-var advancedBuildCommands = []string{"import", "buildfetch", "buildupload", "oc-adm-release", "push-container"}
-var buildextendCommands = []string{"aliyun", "applehv", "aws", "azure", "digitalocean", "exoscale", "extensions-container", "gcp", "hyperv", "ibmcloud", "kubevirt", "live", "metal", "metal4k", "nutanix", "openstack", "oraclecloud", "qemu", "secex", "virtualbox", "vmware", "vultr"}
 
-var utilityCommands = []string{"aws-replicate", "coreos-prune", "compress", "copy-container", "diff", "koji-upload", "kola", "push-container-manifest", "remote-build-container", "remote-session", "sign", "tag", "update-variant"}
-var otherCommands = []string{"shell", "meta"}
-
-func init() {
-	// Note buildCommands is intentionally listed in frequency order
-	sort.Strings(advancedBuildCommands)
-	sort.Strings(buildextendCommands)
-	sort.Strings(utilityCommands)
-	sort.Strings(otherCommands)
-}
+	"github.com/coreos/coreos-assembler/internal/pkg/events"
+	"github.com/spf13/cobra"
+)
 
 func wrapCommandErr(err error) error {
 	if err == nil {
@@ -37,41 +24,40 @@ func wrapCommandErr(err error) error {
 	return err
 }
 
-func printCommands(title string, cmds []string) {
-	fmt.Printf("%s:\n", title)
-	var prefix string
-	if title == "Platform builds" {
-		prefix = "buildextend-"
-	}
-	for _, cmd := range cmds {
-		fmt.Printf("  %s%s\n", prefix, cmd)
-	}
-}
-
-func printUsage() {
-	fmt.Println("Usage: coreos-assembler CMD ...")
-	printCommands("Build commands", buildCommands)
-	printCommands("Advanced build commands", advancedBuildCommands)
-	printCommands("Platform builds", buildextendCommands)
-	printCommands("Utility commands", utilityCommands)
-	printCommands("Other commands", otherCommands)
-}
-
 func run(argv []string) error {
 	if err := initializeGlobalState(argv); err != nil {
 		return fmt.Errorf("failed to initialize global state: %w", err)
 	}
 
-	var cmd string
-	if len(argv) > 0 {
-		cmd = argv[0]
+	// --events-json is parsed by hand since it has to apply to the
+	// legacy scripts too, which do their own argument parsing.
+	var eventsPath string
+	if len(argv) > 1 && argv[0] == "--events-json" {
+		eventsPath = argv[1]
+		argv = argv[2:]
+	} else if len(argv) > 0 && strings.HasPrefix(argv[0], "--events-json=") {
+		eventsPath = strings.TrimPrefix(argv[0], "--events-json=")
 		argv = argv[1:]
 	}
 
-	if cmd == "" || cmd == "--help" {
-		printUsage()
+	root := newRootCommand(libDir)
+	if len(argv) == 0 {
+		_ = root.Help()
 		os.Exit(1)
 	}
+	root.SetArgs(argv)
+
+	// Shell completion and help are served locally and produce no events.
+	cmd := argv[0]
+	switch cmd {
+	case "help", "--help", "-h", "completion",
+		cobra.ShellCompRequestCmd, cobra.ShellCompNoDescRequestCmd:
+		return root.Execute()
+	}
+
+	if err := initEvents(eventsPath, cmd, argv[1:]); err != nil {
+		return err
+	}
 
 	// if the COREOS_ASSEMBLER_REMOTE_SESSION environment variable is
 	// set then we "intercept" the command here and redirect it to
@@ -79,26 +65,16 @@ func run(argv []string) error {
 	// via `podman --remote` on a remote machine.
 	session, ok := os.LookupEnv("COREOS_ASSEMBLER_REMOTE_SESSION")
 	if ok && session != "" && cmd != "remote-session" {
-		argv = append([]string{"exec", "--", cmd}, argv...)
-		cmd = "remote-session"
+		root.SetArgs(append([]string{"remote-session", "exec", "--"}, argv...))
 	}
 
-	// Manual argument parsing here for now; once we get to "phase 1"
-	// of the Go conversion we can vendor cobra (and other libraries)
-	// at the toplevel.
-	switch cmd {
-	case "clean":
-		return runClean(argv)
-	case "update-variant":
-		return runUpdateVariant(argv)
-	case "remote-session":
-		return runRemoteSession(argv)
-	case "build-extensions-container", // old alias
-		"buildextend-extensions-container":
-		return buildExtensionContainer()
-	}
-
-	target := fmt.Sprintf("/usr/lib/coreos-assembler/cmd-%s", cmd)
+	return root.Execute()
+}
+
+// runLegacyCommand executes one of the cmd-* scripts installed
+// alongside coreos-assembler.
+func runLegacyCommand(cmd string, argv []string) error {
+	target := filepath.Join(libDir, "cmd-"+cmd)
 	_, err := os.Stat(target)
 	if err != nil {
 		if os.IsNotExist(err) {
@@ -111,6 +87,7 @@ func run(argv []string) error {
 	c.Stdin = os.Stdin
 	c.Stdout = os.Stdout
 	c.Stderr = os.Stderr
+	events.Attach(c)
 	if err := c.Run(); err != nil {
 		fmt.Fprintf(os.Stderr, "failed to execute cmd-%s: %v\n", cmd, err.Error())
 		return err
@@ -118,6 +95,24 @@ func run(argv []string) error {
 	return nil
 }
 
+// initEvents enables the event stream if requested with --events-json or
+// inherited from a parent process via COSA_EVENTS_FD.
+func initEvents(path, cmd string, argv []string) error {
+	var e *events.Emitter
+	var err error
+	if path != "" {
+		e, err = events.Open(path, cmd)
+	} else {
+		e, err = events.FromEnv(cmd)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open event stream: %w", err)
+	}
+	events.SetDefault(e)
+	e.CommandStart(argv)
+	return nil
+}
+
 func initializeGlobalState(argv []string) error {
 	// Set PYTHONUNBUFFERED=1 so that we get unbuffered output. We should
 	// be able to do this on the shebang lines but env doesn't support args
@@ -193,6 +188,7 @@ func initializeGlobalState(argv []string) error {
 
 func main() {
 	err := run(os.Args[1:])
+	events.Default().Status(err)
 	if err != nil {
 		if exitErr, ok := err.(*exec.ExitError); ok {
 			// In this case the command we ran gave a non-zero exit
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	"github.com/spf13/cobra"
+)
+
+type DiffOptions struct {
+	Workdir string
+	From    string
+	To      string
+	Arch    string
+	Format  string
+}
+
+var (
+	diffOpts DiffOptions
+
+	cmdDiff = &cobra.Command{
+		Use:   "diff",
+		Short: "cosa diff --format=json|markdown [--from BUILDID] [--to BUILDID]",
+		Long: "Produce a structured diff of two builds covering packages, " +
+			"advisories, kernel arguments, artifacts, ostree metadata and " +
+			"cloud uploads. Defaults to the previous and the latest build.",
+		Args: cobra.ExactArgs(0),
+		RunE: runDiffCmd,
+	}
+)
+
+// readDiffTarget loads a build along with its commitmeta.json and, if the
+// commit is available in the local ostree repo, its image config kargs.
+func readDiffTarget(buildsDir, buildID, arch string) (*builds.DiffTarget, error) {
+	b, dir, err := builds.ReadBuild(buildsDir, buildID, arch)
+	if err != nil {
+		return nil, err
+	}
+	t := &builds.DiffTarget{Build: b}
+	if cm, err := builds.ReadCommitMeta(filepath.Join(dir, builds.CosaCommitMetaJSON)); err == nil {
+		t.CommitMeta = cm
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	t.KernelArgs = readImageKargs(filepath.Join(diffOpts.Workdir, "tmp/repo"), b.OstreeCommit)
+	return t, nil
+}
+
+// readImageKargs returns the extra-kargs of the image.json embedded in
+// an ostree commit, or nil if the commit isn't in the repo.
+func readImageKargs(repo, commit string) []string {
+	if commit == "" {
+		return nil
+	}
+	out, err := exec.Command("ostree", "--repo="+repo, "cat", commit,
+		"/usr/share/coreos-assembler/image.json").Output()
+	if err != nil {
+		return nil
+	}
+	var image struct {
+		ExtraKargs []string `json:"extra-kargs"`
+	}
+	if err := json.Unmarshal(out, &image); err != nil {
+		return nil
+	}
+	if image.ExtraKargs == nil {
+		return []string{}
+	}
+	return image.ExtraKargs
+}
+
+func runDiffCmd(c *cobra.Command, args []string) error {
+	buildsDir := filepath.Join(diffOpts.Workdir, "builds")
+	bj, err := builds.GetBuilds(buildsDir)
+	if err != nil {
+		return err
+	}
+	resolve := func(id string, fallback int) (string, error) {
+		if id != "" && id != "latest" {
+			return id, nil
+		}
+		if id == "latest" {
+			fallback = 0
+		}
+		if len(bj.Builds) <= fallback {
+			return "", fmt.Errorf("not enough builds to diff")
+		}
+		return bj.Builds[fallback].ID, nil
+	}
+	// default to the previous and the latest build
+	fromFallback := 1
+	if diffOpts.To != "" && diffOpts.From == "" {
+		fromFallback = 0
+	}
+	from, err := resolve(diffOpts.From, fromFallback)
+	if err != nil {
+		return err
+	}
+	to, err := resolve(diffOpts.To, 0)
+	if err != nil {
+		return err
+	}
+	if from == to {
+		return fmt.Errorf("from and to builds are the same")
+	}
+
+	a, err := readDiffTarget(buildsDir, from, diffOpts.Arch)
+	if err != nil {
+		return err
+	}
+	b, err := readDiffTarget(buildsDir, to, diffOpts.Arch)
+	if err != nil {
+		return err
+	}
+	d := builds.DiffTargets(a, b)
+
+	switch diffOpts.Format {
+	case "json":
+		out, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	case "markdown":
+		fmt.Print(d.Markdown())
+	default:
+		return fmt.Errorf("unknown format %q; expected json or markdown", diffOpts.Format)
+	}
+	return nil
+}
+
+func init() {
+	cmdDiff.Flags().StringVarP(
+		&diffOpts.Workdir, "workdir", "", ".",
+		"Path to workdir")
+	cmdDiff.Flags().StringVarP(
+		&diffOpts.From, "from", "", "",
+		"First build ID")
+	cmdDiff.Flags().StringVarP(
+		&diffOpts.To, "to", "", "",
+		"Second build ID")
+	cmdDiff.Flags().StringVarP(
+		&diffOpts.Arch, "arch", "", "",
+		"Architecture of builds")
+	cmdDiff.Flags().StringVarP(
+		&diffOpts.Format, "format", "", "json",
+		"Output format: json or markdown")
+}
+
+// runDiff executes the structured diff if --format is given, and otherwise
+// the legacy diff script which drives the individual differs.
+func runDiff(argv []string) error {
+	for _, arg := range argv {
+		if arg == "--format" || strings.HasPrefix(arg, "--format=") {
+			cmdDiff.SetArgs(argv)
+			return cmdDiff.Execute()
+		}
+	}
+	return runLegacyCommand("diff", argv)
+}
@@ -0,0 +1,309 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	"github.com/spf13/cobra"
+)
+
+// Let's just hardcode this here for now
+const defaultKeepLastN = 3
+
+type PruneOptions struct {
+	Workdir       string
+	DryRun        bool
+	JSON          bool
+	KeepLastN     int
+	KeepNewerThan string
+	KeepTagged    bool
+	Builds        []string
+	PkgCache      bool
+}
+
+var (
+	pruneOpts PruneOptions
+
+	cmdPrune = &cobra.Command{
+		Use:   "prune",
+		Short: "cosa prune [options]",
+		Long: "Remove previous builds from the workdir. Builds are kept if any " +
+			"of the enabled keep policies selects them. DO NOT USE on " +
+			"production pipelines.",
+		Args: cobra.ExactArgs(0),
+		RunE: runPruneCmd,
+	}
+)
+
+// parseAge parses a duration which additionally accepts a "d" suffix
+// for days, e.g. "14d".
+func parseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	if days, ok := strings.CutSuffix(s, "d"); ok {
+		n, err := strconv.Atoi(days)
+		if err != nil {
+			return 0, fmt.Errorf("invalid age %q: %w", s, err)
+		}
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	return time.ParseDuration(s)
+}
+
+func runPruneCmd(c *cobra.Command, args []string) error {
+	// Package cache pruning is still handled by the legacy script
+	if pruneOpts.PkgCache {
+		argv := []string{"--pkgcache"}
+		if pruneOpts.DryRun {
+			argv = append(argv, "--dry-run")
+		}
+		return runLegacyCommand("prune", argv)
+	}
+
+	buildsDir := filepath.Join(pruneOpts.Workdir, "builds")
+	bj, err := builds.GetBuilds(buildsDir)
+	if err != nil {
+		return err
+	}
+
+	var plan *builds.PrunePlan
+	if len(pruneOpts.Builds) > 0 {
+		plan, err = planExplicitPrune(buildsDir, bj, pruneOpts.Builds)
+	} else {
+		age, perr := parseAge(pruneOpts.KeepNewerThan)
+		if perr != nil {
+			return perr
+		}
+		plan, err = builds.PlanPrune(buildsDir, bj, builds.PrunePolicy{
+			KeepLastN:     pruneOpts.KeepLastN,
+			KeepNewerThan: age,
+			KeepTagged:    pruneOpts.KeepTagged,
+		}, time.Now())
+	}
+	if err != nil {
+		return err
+	}
+
+	if pruneOpts.DryRun {
+		return printPrunePlan(plan)
+	}
+
+	for _, cand := range plan.Keep {
+		if len(cand.Tags) > 0 {
+			fmt.Printf("Skipping tagged build %s (%s)\n", cand.ID, strings.Join(cand.Tags, ", "))
+		}
+	}
+	if err := plan.Apply(buildsDir); err != nil {
+		return err
+	}
+	if err := pruneOstreeRefs(pruneOpts.Workdir, plan); err != nil {
+		return err
+	}
+	return pruneBlobRefs(pruneOpts.Workdir, buildsDir)
+}
+
+// planExplicitPrune builds a plan removing exactly the given build IDs.
+// Tagged builds are refused.
+func planExplicitPrune(dir string, bj *builds.BuildsJSON, ids []string) (*builds.PrunePlan, error) {
+	plan, err := builds.PlanPrune(dir, bj, builds.PrunePolicy{}, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	want := make(map[string]bool)
+	for _, id := range ids {
+		if !bj.Has(id) {
+			return nil, fmt.Errorf("failed to find build ID: %s", id)
+		}
+		if tags := bj.TagsFor(id); len(tags) > 0 {
+			return nil, fmt.Errorf("build %s is tagged (%s)", id, strings.Join(tags, ", "))
+		}
+		want[id] = true
+	}
+	keep := plan.Keep[:0]
+	for _, cand := range plan.Keep {
+		if want[cand.ID] {
+			cand.Reason = "explicitly requested"
+			plan.Remove = append(plan.Remove, cand)
+		} else {
+			keep = append(keep, cand)
+		}
+	}
+	plan.Keep = keep
+	return plan, nil
+}
+
+func printPrunePlan(plan *builds.PrunePlan) error {
+	if pruneOpts.JSON {
+		out, err := json.MarshalIndent(plan, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+		return nil
+	}
+	for _, cand := range plan.Keep {
+		fmt.Printf("Would keep build %s: %s\n", cand.ID, cand.Reason)
+	}
+	for _, cand := range plan.Remove {
+		fmt.Printf("Would prune build %s (%s)\n", cand.ID, formatBytes(cand.Size))
+	}
+	fmt.Printf("Would free %s across %d builds\n", formatBytes(plan.Bytes()), len(plan.Remove))
+	return nil
+}
+
+// pruneOstreeRefs deletes the refs of pruned builds from the tmp/repo
+// ostree repository, if there is one.
+func pruneOstreeRefs(workdir string, plan *builds.PrunePlan) error {
+	repo := filepath.Join(workdir, "tmp/repo")
+	if _, err := os.Stat(repo); err != nil {
+		return nil
+	}
+	for _, cand := range plan.Remove {
+		cmd := exec.Command("ostree", "--repo="+repo, "refs", "--delete", cand.ID)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("deleting ref %s: %w", cand.ID, err)
+		}
+	}
+	return nil
+}
+
+// blobRefPrefix is the prefix of the refs of container image layers in an
+// ostree repository.
+const blobRefPrefix = "ostree/container/blob/"
+
+// pruneBlobRefs deletes the layer refs in the tmp/repo ostree repository
+// which no remaining build's OCI manifest references.
+func pruneBlobRefs(workdir, buildsDir string) error {
+	repo := filepath.Join(workdir, "tmp/repo")
+	if _, err := os.Stat(repo); err != nil {
+		return nil
+	}
+	bj, err := builds.GetBuilds(buildsDir)
+	if err != nil {
+		return err
+	}
+	referenced := make(map[string]bool)
+	for _, e := range bj.Builds {
+		for _, arch := range e.Arches {
+			digests, err := ociManifestLayers(buildsDir, e.ID, arch)
+			if err != nil {
+				return err
+			}
+			for _, digest := range digests {
+				referenced[blobRefPrefix+strings.ReplaceAll(digest, ":", "_3A_")] = true
+			}
+		}
+	}
+
+	out, err := exec.Command("ostree", "--repo="+repo, "refs", "--list", "ostree/container/blob").Output()
+	if err != nil {
+		return fmt.Errorf("listing blob refs: %w", err)
+	}
+	var unowned []string
+	for _, ref := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if ref != "" && !referenced[ref] {
+			unowned = append(unowned, ref)
+		}
+	}
+	if len(unowned) == 0 {
+		return nil
+	}
+	fmt.Printf("Deleting %d blob refs\n", len(unowned))
+	cmd := exec.Command("ostree", append([]string{"--repo=" + repo, "refs", "--delete"}, unowned...)...)
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("deleting blob refs: %w", err)
+	}
+	return nil
+}
+
+// ociManifestLayers returns the layer digests of the OCI manifest of a
+// build, if it has one locally; e.g. only its ostree may have been fetched.
+func ociManifestLayers(buildsDir, id, arch string) ([]string, error) {
+	dir := filepath.Join(buildsDir, id, arch)
+	b, err := builds.ParseBuild(filepath.Join(dir, builds.CosaMetaJSON))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if b.BuildArtifacts == nil || b.BuildArtifacts.OciManifest == nil {
+		return nil, nil
+	}
+	data, err := os.ReadFile(filepath.Join(dir, b.BuildArtifacts.OciManifest.Path))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var manifest struct {
+		Layers []struct {
+			Digest string `json:"digest"`
+		} `json:"layers"`
+	}
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("parsing OCI manifest of %s: %w", id, err)
+	}
+	var digests []string
+	for _, l := range manifest.Layers {
+		digests = append(digests, l.Digest)
+	}
+	return digests, nil
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
+
+func init() {
+	cmdPrune.Flags().StringVarP(
+		&pruneOpts.Workdir, "workdir", "", ".",
+		"Path to workdir")
+	cmdPrune.Flags().BoolVarP(
+		&pruneOpts.DryRun, "dry-run", "", false,
+		"Don't actually delete anything; report what would be pruned")
+	cmdPrune.Flags().BoolVarP(
+		&pruneOpts.JSON, "json", "", false,
+		"With --dry-run, print the prune plan as JSON")
+	cmdPrune.Flags().IntVarP(
+		&pruneOpts.KeepLastN, "keep-last-n", "", defaultKeepLastN,
+		"Number of untagged builds to keep (0 disables this policy)")
+	cmdPrune.Flags().StringVarP(
+		&pruneOpts.KeepNewerThan, "keep-newer-than", "", "",
+		"Also keep builds younger than this age (e.g. 72h or 14d)")
+	cmdPrune.Flags().BoolVarP(
+		&pruneOpts.KeepTagged, "keep-tagged", "", true,
+		"Never prune builds with a tag pointing to them")
+	cmdPrune.Flags().StringArrayVarP(
+		&pruneOpts.Builds, "build", "", []string{},
+		"Explicitly prune BUILDID")
+	cmdPrune.Flags().BoolVarP(
+		&pruneOpts.PkgCache, "pkgcache", "", false,
+		"Prune refs packages from the pkgcache instead of builds")
+	cmdPrune.MarkFlagsMutuallyExclusive("build", "keep-last-n")
+	if err := cmdPrune.RegisterFlagCompletionFunc("build", completeBuildIDsFlag); err != nil {
+		panic(err)
+	}
+}
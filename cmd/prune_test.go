@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+)
+
+func TestOciManifestLayers(t *testing.T) {
+	dir := t.TempDir()
+	writeBuild := func(id, meta string) {
+		p := filepath.Join(dir, id, "x86_64")
+		if err := os.MkdirAll(p, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(p, builds.CosaMetaJSON), []byte(meta), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeBuild("with-manifest", `{"buildid": "with-manifest", "images": {"oci-manifest": {"path": "manifest.json", "sha256": "x"}}}`)
+	manifest := `{"layers": [{"digest": "sha256:aaa"}, {"digest": "sha256:bbb"}]}`
+	if err := os.WriteFile(filepath.Join(dir, "with-manifest", "x86_64", "manifest.json"), []byte(manifest), 0644); err != nil {
+		t.Fatal(err)
+	}
+	writeBuild("manifest-not-fetched", `{"buildid": "manifest-not-fetched", "images": {"oci-manifest": {"path": "manifest.json", "sha256": "x"}}}`)
+	writeBuild("no-manifest", `{"buildid": "no-manifest"}`)
+
+	cases := map[string]string{
+		"with-manifest":        "[sha256:aaa sha256:bbb]",
+		"manifest-not-fetched": "[]",
+		"no-manifest":          "[]",
+		"missing":              "[]",
+	}
+	for id, expected := range cases {
+		digests, err := ociManifestLayers(dir, id, "x86_64")
+		if err != nil {
+			t.Errorf("%s: %v", id, err)
+			continue
+		}
+		if got := fmt.Sprint(digests); got != expected {
+			t.Errorf("%s: expected %s, got %s", id, expected, got)
+		}
+	}
+}
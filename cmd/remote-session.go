@@ -1,21 +1,42 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
+	"text/tabwriter"
+	"time"
 
+	"github.com/coreos/coreos-assembler/internal/pkg/remotesync"
 	"github.com/spf13/cobra"
 )
 
+// Labels set on remote session containers so that they can be found
+// again by `remote-session list` and `remote-session gc`.
+const (
+	sessionLabel        = "coreos-assembler.remote-session"
+	sessionWorkdirLabel = "coreos-assembler.remote-session.workdir"
+	sessionExpiresLabel = "coreos-assembler.remote-session.expires"
+)
+
+// podmanBin is the podman binary used to talk to the remote
+var podmanBin = "podman"
+
 type RemoteSessionOptions struct {
 	CreateImage      string
 	CreateExpiration string
 	CreateWorkdir    string
 	CreateEnv        []string
 	SyncQuiet        bool
+	SyncExcludes     []string
+	ListJSON         bool
+	GCMaxAge         time.Duration
+	GCDryRun         bool
+	AttachCommand    string
 }
 
 var (
@@ -24,7 +45,9 @@ var (
 	cmdRemoteSession = &cobra.Command{
 		Use:   "remote-session",
 		Short: "cosa remote-session [command]",
-		Long:  "Initiate and use remote sessions for COSA execution.",
+		Long: "Initiate and use remote sessions for COSA execution. The remote " +
+			"is the podman service in CONTAINER_HOST; for testing it can be a " +
+			"local podman socket, e.g. unix://$XDG_RUNTIME_DIR/podman/podman.sock.",
 	}
 
 	cmdRemoteSessionCreate = &cobra.Command{
@@ -71,13 +94,132 @@ var (
 		Short: "sync files/directories to/from the remote",
 		Long: "sync files/directories to/from the remote. The symantics here " +
 			"are similar to rsync or scp. Provide `:from to` or `from :to`. " +
-			"The argument with the leading ':' will represent the remote.",
+			"The argument with the leading ':' will represent the remote. " +
+			"Only files whose size, mtime or sha256 differ are transferred.",
 		Args:    cobra.MinimumNArgs(2),
 		PreRunE: preRunCheckEnv,
 		RunE:    runSync,
 	}
+
+	cmdRemoteSessionList = &cobra.Command{
+		Use:     "list",
+		Short:   "List all remote sessions on the remote",
+		Long:    "List all remote sessions on the remote with their age, workdir and image.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: preRunCheckEnv,
+		RunE:    runList,
+	}
+
+	cmdRemoteSessionGC = &cobra.Command{
+		Use:   "gc",
+		Short: "Remove expired remote sessions",
+		Long: "Remove remote sessions which are past their expiration, are " +
+			"exited or stopped, or are older than --max-age. Use this to " +
+			"reap sessions orphaned by failed CI jobs.",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: preRunCheckEnv,
+		RunE:    runGC,
+	}
+
+	cmdRemoteSessionAttach = &cobra.Command{
+		Use:   "attach [SESSION]",
+		Short: "Get a shell in a running remote session",
+		Long: "Get an interactive shell in a running remote session. " +
+			"Defaults to the session in COREOS_ASSEMBLER_REMOTE_SESSION.",
+		Args:              cobra.MaximumNArgs(1),
+		PreRunE:           preRunCheckEnv,
+		RunE:              runAttach,
+		ValidArgsFunction: completeSessionIDs,
+	}
 )
 
+// remoteSession is a remote session container, as listed by
+// `podman ps --format json`.
+type remoteSession struct {
+	ID      string            `json:"Id"`
+	Names   []string          `json:"Names"`
+	Image   string            `json:"Image"`
+	State   string            `json:"State"`
+	Created int64             `json:"Created"`
+	Labels  map[string]string `json:"Labels"`
+}
+
+// Workdir returns the COSA working directory of the session.
+func (s *remoteSession) Workdir() string {
+	return s.Labels[sessionWorkdirLabel]
+}
+
+// Expires returns when the session expires, or the zero time if it
+// never does.
+func (s *remoteSession) Expires() time.Time {
+	t, err := time.Parse(time.RFC3339, s.Labels[sessionExpiresLabel])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Age returns how long ago the session was created.
+func (s *remoteSession) Age(now time.Time) time.Duration {
+	return now.Sub(time.Unix(s.Created, 0))
+}
+
+// reapReason returns why the session should be garbage collected, or ""
+// if it should be kept. Sessions which are still being created, or are
+// paused or stopping, are only reaped once expired.
+func (s *remoteSession) reapReason(now time.Time, maxAge time.Duration) string {
+	switch s.State {
+	case "exited", "stopped":
+		return s.State
+	}
+	if exp := s.Expires(); !exp.IsZero() && now.After(exp) {
+		return "expired"
+	}
+	if maxAge > 0 && s.Age(now) > maxAge {
+		return fmt.Sprintf("older than %s", maxAge)
+	}
+	return ""
+}
+
+// parseSleepDuration parses a duration as accepted by sleep(1): a number
+// with an optional s, m, h or d suffix. "infinity" is returned as 0.
+func parseSleepDuration(s string) (time.Duration, error) {
+	if s == "infinity" {
+		return 0, nil
+	}
+	num, unit := s, time.Second
+	switch {
+	case strings.HasSuffix(s, "s"):
+		num = strings.TrimSuffix(s, "s")
+	case strings.HasSuffix(s, "m"):
+		num, unit = strings.TrimSuffix(s, "m"), time.Minute
+	case strings.HasSuffix(s, "h"):
+		num, unit = strings.TrimSuffix(s, "h"), time.Hour
+	case strings.HasSuffix(s, "d"):
+		num, unit = strings.TrimSuffix(s, "d"), 24*time.Hour
+	}
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid expiration %q", s)
+	}
+	return time.Duration(n * float64(unit)), nil
+}
+
+// formatAge formats a duration coarsely, e.g. "2d3h" or "45m".
+func formatAge(d time.Duration) string {
+	days := int(d.Hours()) / 24
+	hours := int(d.Hours()) % 24
+	minutes := int(d.Minutes()) % 60
+	switch {
+	case days > 0:
+		return fmt.Sprintf("%dd%dh", days, hours)
+	case hours > 0:
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		return fmt.Sprintf("%dm", minutes)
+	}
+}
+
 // Function to determine if stdin is a terminal or not.
 func isatty() bool {
 	cmd := exec.Command("tty")
@@ -122,11 +264,23 @@ func preRunCheckEnv(c *cobra.Command, args []string) error {
 	// We need to check COREOS_ASSEMBLER_REMOTE_SESSION. For create
 	// we need to make sure it's not set. For all other commands we
 	// need to make sure it is set.
+	// list and gc operate on all sessions, and attach can also be
+	// given the session as an argument.
 	remoteSessionVarIsSet := envVarIsSet("COREOS_ASSEMBLER_REMOTE_SESSION")
-	if c.Use == "create" && remoteSessionVarIsSet {
-		return envVarError("COREOS_ASSEMBLER_REMOTE_SESSION", false)
-	} else if c.Use != "create" && !remoteSessionVarIsSet {
-		return envVarError("COREOS_ASSEMBLER_REMOTE_SESSION", true)
+	switch c.Name() {
+	case "create":
+		if remoteSessionVarIsSet {
+			return envVarError("COREOS_ASSEMBLER_REMOTE_SESSION", false)
+		}
+	case "list", "gc":
+	case "attach":
+		if len(args) == 0 && !remoteSessionVarIsSet {
+			return envVarError("COREOS_ASSEMBLER_REMOTE_SESSION", true)
+		}
+	default:
+		if !remoteSessionVarIsSet {
+			return envVarError("COREOS_ASSEMBLER_REMOTE_SESSION", true)
+		}
 	}
 	return nil
 }
@@ -147,6 +301,20 @@ func runCreate(c *cobra.Command, args []string) error {
 		"--userns=keep-id:uid=1000,gid=1000",
 		"--device=/dev/kvm", "--device=/dev/fuse", "--tmpfs=/tmp",
 		"--init", "--entrypoint=/usr/bin/sleep"}
+	// Label the container so that list and gc can find it again. The
+	// expiration is computed from the local clock.
+	expiration, err := parseSleepDuration(remoteSessionOpts.CreateExpiration)
+	if err != nil {
+		return err
+	}
+	podmanargs = append(podmanargs,
+		"--label", sessionLabel+"=true",
+		"--label", fmt.Sprintf("%s=%s", sessionWorkdirLabel, remoteSessionOpts.CreateWorkdir))
+	if expiration > 0 {
+		expires := time.Now().Add(expiration).UTC().Format(time.RFC3339)
+		podmanargs = append(podmanargs,
+			"--label", fmt.Sprintf("%s=%s", sessionExpiresLabel, expires))
+	}
 	// Add in any env vars that were specified.
 	for _, env := range remoteSessionOpts.CreateEnv {
 		podmanargs = append(podmanargs, "--env", env)
@@ -154,7 +322,7 @@ func runCreate(c *cobra.Command, args []string) error {
 	podmanargs = append(podmanargs,
 		remoteSessionOpts.CreateImage,
 		remoteSessionOpts.CreateExpiration)
-	cmd := exec.Command("podman", podmanargs...)
+	cmd := exec.Command(podmanBin, podmanargs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -165,7 +333,7 @@ func runCreate(c *cobra.Command, args []string) error {
 func runDestroy(c *cobra.Command, args []string) error {
 	session := os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION")
 	podmanargs := []string{"--remote", "rm", "-f", session}
-	cmd := exec.Command("podman", podmanargs...)
+	cmd := exec.Command(podmanBin, podmanargs...)
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
@@ -181,7 +349,7 @@ func runExec(c *cobra.Command, args []string) error {
 	session := os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION")
 	podmanargs = append(podmanargs, session, "cosa")
 	podmanargs = append(podmanargs, args...)
-	cmd := exec.Command("podman", podmanargs...)
+	cmd := exec.Command(podmanBin, podmanargs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -203,7 +371,7 @@ func runPS(c *cobra.Command, args []string) error {
 	session := os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION")
 	podmanargs := []string{"--remote", "ps", "-a",
 		fmt.Sprintf("--filter=id=%s", session)}
-	cmd := exec.Command("podman", podmanargs...)
+	cmd := exec.Command(podmanBin, podmanargs...)
 	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
@@ -212,46 +380,180 @@ func runPS(c *cobra.Command, args []string) error {
 
 // runSync provides an rsync-like interface that allows
 // files to be copied to/from the remote. It uses
-// `podman --remote exec` as the transport for rsync (see [1])
+// `podman --remote exec` as the transport for a tar stream
+// of only the files that changed (see the remotesync package).
 //
 // One of the arguments here must be prepended with a `:`. This
-// argument will represent the path on the remote. This function
-// will substitute `:` with `$COREOS_ASSEMBLER_REMOTE_SESSION:`.
-// That environment var just contains the container ID on the remote.
-//
-// [1] https://github.com/moby/moby/issues/13660
+// argument will represent the path on the remote; it is either
+// the destination, or the only source.
 func runSync(c *cobra.Command, args []string) error {
 	// check arguments. Need one with pre-pended ':'
-	found := 0
+	found := -1
 	for index, arg := range args {
 		if strings.HasPrefix(arg, ":") {
-			args[index] = fmt.Sprintf("%s%s",
-				os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION"), arg)
-			found++
+			if found >= 0 {
+				found = -1
+				break
+			}
+			found = index
 		}
 	}
-	if found != 1 {
+	if found < 0 {
 		return fmt.Errorf("Must pass in a single arg with `:` prepended")
 	}
-	// build command and execute
-	rsyncargs := []string{"-ah", "--no-owner", "--no-group", "--mkpath", "--blocking-io",
-		"--compress", "--rsh", "podman --remote exec -i"}
+
+	session := os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION")
+	remote := func(argv ...string) *exec.Cmd {
+		return exec.Command(podmanBin, append([]string{"--remote", "exec", "-i", session}, argv...)...)
+	}
+	opts := remotesync.Options{Excludes: remoteSessionOpts.SyncExcludes}
 	if !remoteSessionOpts.SyncQuiet {
-		rsyncargs = append(rsyncargs, "-v")
+		opts.Progress = os.Stdout
+	}
+
+	var stats remotesync.Stats
+	var err error
+	last := len(args) - 1
+	switch found {
+	case last:
+		stats, err = remotesync.Push(remote, args[:last], strings.TrimPrefix(args[last], ":"), opts)
+	case 0:
+		if last != 1 {
+			return fmt.Errorf("Only a single source can be copied from the remote")
+		}
+		stats, err = remotesync.Pull(remote, strings.TrimPrefix(args[0], ":"), args[1], opts)
+	default:
+		return fmt.Errorf("The arg with `:` prepended must be the first or the last")
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Sent %d files and %d directories (%s, %s on the wire); skipped %d unchanged files (%s)\n",
+		stats.Files, stats.Dirs, formatBytes(stats.Bytes), formatBytes(stats.WireBytes),
+		stats.Skipped, formatBytes(stats.SkippedBytes))
+	return nil
+}
+
+// listSessions returns all the remote sessions on the remote.
+func listSessions() ([]remoteSession, error) {
+	cmd := exec.Command(podmanBin, "--remote", "ps", "-a",
+		fmt.Sprintf("--filter=label=%s", sessionLabel), "--format=json")
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing remote sessions: %w", err)
+	}
+	var sessions []remoteSession
+	if err := json.Unmarshal(out, &sessions); err != nil {
+		return nil, fmt.Errorf("parsing podman ps output: %w", err)
+	}
+	return sessions, nil
+}
+
+// Lists all the remote sessions on the remote, including the ones
+// belonging to other users of a shared builder.
+func runList(c *cobra.Command, args []string) error {
+	sessions, err := listSessions()
+	if err != nil {
+		return err
+	}
+	if remoteSessionOpts.ListJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(sessions)
+	}
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "SESSION\tSTATE\tAGE\tEXPIRES\tWORKDIR\tIMAGE")
+	for _, s := range sessions {
+		expires := "never"
+		if exp := s.Expires(); !exp.IsZero() {
+			expires = exp.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%.12s\t%s\t%s\t%s\t%s\t%s\n", s.ID, s.State,
+			formatAge(s.Age(now)), expires, s.Workdir(), s.Image)
+	}
+	return w.Flush()
+}
+
+// Removes the remote sessions which expired, exited without being
+// removed, or are older than --max-age.
+func runGC(c *cobra.Command, args []string) error {
+	sessions, err := listSessions()
+	if err != nil {
+		return err
+	}
+	now := time.Now()
+	var failed int
+	for _, s := range sessions {
+		reason := s.reapReason(now, remoteSessionOpts.GCMaxAge)
+		if reason == "" {
+			continue
+		}
+		if remoteSessionOpts.GCDryRun {
+			fmt.Printf("Would remove session %.12s (%s)\n", s.ID, reason)
+			continue
+		}
+		fmt.Printf("Removing session %.12s (%s)\n", s.ID, reason)
+		cmd := exec.Command(podmanBin, "--remote", "rm", "-f", s.ID)
+		cmd.Stdout = io.Discard
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			fmt.Fprintf(os.Stderr, "failed to remove session %.12s: %v\n", s.ID, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to remove %d sessions", failed)
+	}
+	return nil
+}
+
+// Opens an interactive shell in a running remote session.
+func runAttach(c *cobra.Command, args []string) error {
+	session := os.Getenv("COREOS_ASSEMBLER_REMOTE_SESSION")
+	if len(args) > 0 {
+		session = args[0]
+	}
+	podmanargs := []string{"--remote", "exec", "-i"}
+	if isatty() {
+		podmanargs = append(podmanargs, "-t")
 	}
-	rsyncargs = append(rsyncargs, args...)
-	cmd := exec.Command("rsync", rsyncargs...)
+	podmanargs = append(podmanargs, session, remoteSessionOpts.AttachCommand)
+	cmd := exec.Command(podmanBin, podmanargs...)
+	cmd.Stdin = os.Stdin
 	cmd.Stdout = os.Stdout
 	cmd.Stderr = os.Stderr
 	return cmd.Run()
 }
 
+// completeSessionIDs completes the IDs of the running remote sessions.
+func completeSessionIDs(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	sessions, err := listSessions()
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var ret []string
+	for _, s := range sessions {
+		if s.State == "running" {
+			ret = append(ret, fmt.Sprintf("%.12s\t%s", s.ID, s.Workdir()))
+		}
+	}
+	return ret, cobra.ShellCompDirectiveNoFileComp
+}
+
 func init() {
 	cmdRemoteSession.AddCommand(cmdRemoteSessionCreate)
 	cmdRemoteSession.AddCommand(cmdRemoteSessionDestroy)
 	cmdRemoteSession.AddCommand(cmdRemoteSessionExec)
 	cmdRemoteSession.AddCommand(cmdRemoteSessionPS)
 	cmdRemoteSession.AddCommand(cmdRemoteSessionSync)
+	cmdRemoteSession.AddCommand(cmdRemoteSessionList)
+	cmdRemoteSession.AddCommand(cmdRemoteSessionGC)
+	cmdRemoteSession.AddCommand(cmdRemoteSessionAttach)
 
 	// cmdRemoteSessionCreate options
 	cmdRemoteSessionCreate.Flags().StringVarP(
@@ -272,10 +574,25 @@ func init() {
 	cmdRemoteSessionSync.Flags().BoolVarP(
 		&remoteSessionOpts.SyncQuiet, "quiet", "", false,
 		"Make the sync output less verbose")
-}
+	cmdRemoteSessionSync.Flags().StringArrayVarP(
+		&remoteSessionOpts.SyncExcludes, "exclude", "", []string{},
+		"Skip files matching this pattern; may be given multiple times")
+
+	// cmdRemoteSessionList options
+	cmdRemoteSessionList.Flags().BoolVarP(
+		&remoteSessionOpts.ListJSON, "json", "", false,
+		"Print the sessions as JSON")
+
+	// cmdRemoteSessionGC options
+	cmdRemoteSessionGC.Flags().DurationVarP(
+		&remoteSessionOpts.GCMaxAge, "max-age", "", 0,
+		"Also remove sessions older than this, regardless of their expiration (e.g. 24h)")
+	cmdRemoteSessionGC.Flags().BoolVarP(
+		&remoteSessionOpts.GCDryRun, "dry-run", "", false,
+		"Only report the sessions that would be removed")
 
-// execute the cmdRemoteSession cobra command
-func runRemoteSession(argv []string) error {
-	cmdRemoteSession.SetArgs(argv)
-	return cmdRemoteSession.Execute()
+	// cmdRemoteSessionAttach options
+	cmdRemoteSessionAttach.Flags().StringVarP(
+		&remoteSessionOpts.AttachCommand, "command", "", "/bin/bash",
+		"The command to run in the session")
 }
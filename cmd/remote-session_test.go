@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakePodman installs a podman stand-in which logs its arguments and
+// prints psOutput for `podman ps`.
+func fakePodman(t *testing.T, psOutput string) string {
+	t.Helper()
+	dir := t.TempDir()
+	log := filepath.Join(dir, "podman.log")
+	if err := os.WriteFile(filepath.Join(dir, "ps.json"), []byte(psOutput), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := fmt.Sprintf(`#!/bin/sh
+echo "$@" >> %s
+if [ "$2" = ps ]; then cat %s; fi
+`, log, filepath.Join(dir, "ps.json"))
+	bin := filepath.Join(dir, "podman")
+	if err := os.WriteFile(bin, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	orig := podmanBin
+	podmanBin = bin
+	t.Cleanup(func() { podmanBin = orig })
+	return log
+}
+
+func TestRemoteSessionGC(t *testing.T) {
+	now := time.Now()
+	past := now.Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := now.Add(time.Hour).UTC().Format(time.RFC3339)
+	log := fakePodman(t, fmt.Sprintf(`[
+  {"Id": "aaaaaaaaaaaa1111", "State": "running", "Created": %d, "Labels": {"%s": "%s"}},
+  {"Id": "bbbbbbbbbbbb2222", "State": "running", "Created": %d, "Labels": {"%s": "%s"}},
+  {"Id": "cccccccccccc3333", "State": "exited", "Created": %d, "Labels": {}},
+  {"Id": "dddddddddddd4444", "State": "running", "Created": %d, "Labels": {}},
+  {"Id": "eeeeeeeeeeee5555", "State": "running", "Created": %d, "Labels": {}},
+  {"Id": "ffffffffffff6666", "State": "created", "Created": %d, "Labels": {}},
+  {"Id": "0000000000007777", "State": "paused", "Created": %d, "Labels": {}},
+  {"Id": "1111111111118888", "State": "stopped", "Created": %d, "Labels": {}}
+]`, now.Unix(), sessionExpiresLabel, past,
+		now.Unix(), sessionExpiresLabel, future,
+		now.Unix(),
+		now.Add(-48*time.Hour).Unix(),
+		now.Unix(),
+		now.Unix(),
+		now.Unix(),
+		now.Unix()))
+
+	remoteSessionOpts.GCMaxAge = 24 * time.Hour
+	t.Cleanup(func() { remoteSessionOpts.GCMaxAge = 0 })
+	if err := runGC(cmdRemoteSessionGC, nil); err != nil {
+		t.Fatal(err)
+	}
+	out, err := os.ReadFile(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var removed []string
+	for _, l := range strings.Split(strings.TrimSpace(string(out)), "\n") {
+		if id, ok := strings.CutPrefix(l, "--remote rm -f "); ok {
+			removed = append(removed, id)
+		}
+	}
+	// sessions being created or paused are kept
+	expected := []string{"aaaaaaaaaaaa1111", "cccccccccccc3333", "dddddddddddd4444", "1111111111118888"}
+	if strings.Join(removed, " ") != strings.Join(expected, " ") {
+		t.Errorf("expected %v to be removed, got %v", expected, removed)
+	}
+}
+
+func TestParseSleepDuration(t *testing.T) {
+	for s, expected := range map[string]time.Duration{
+		"infinity": 0,
+		"90":       90 * time.Second,
+		"1.5h":     90 * time.Minute,
+		"30m":      30 * time.Minute,
+		"2d":       48 * time.Hour,
+	} {
+		d, err := parseSleepDuration(s)
+		if err != nil || d != expected {
+			t.Errorf("%s: expected %s, got %s (%v)", s, expected, d, err)
+		}
+	}
+	if _, err := parseSleepDuration("soon"); err == nil {
+		t.Errorf("expected an error for an invalid duration")
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	"github.com/spf13/cobra"
+)
+
+// libDir is where the cmd-* scripts are installed
+var libDir = "/usr/lib/coreos-assembler"
+
+// commands we'd expect to use in the local dev path
+var buildCommands = []string{"init", "fetch", "build", "osbuild", "run", "prune", "clean", "list"}
+var advancedBuildCommands = []string{"import", "buildfetch", "buildmirror", "buildupload", "oc-adm-release", "push-container"}
+var utilityCommands = []string{"aws-replicate", "coreos-prune", "compress", "copy-container", "diff", "koji-upload", "kola", "push-container-manifest", "remote-build-container", "remote-session", "sign", "tag", "update-variant", "verify-build"}
+
+// Command groups, in the order they are listed in the help
+const (
+	groupBuild       = "build"
+	groupAdvanced    = "advanced"
+	groupBuildextend = "buildextend"
+	groupUtility     = "utility"
+	groupOther       = "other"
+)
+
+// buildIDFlags are the flags of the cmd-* scripts that take a build ID
+var buildIDFlags = []string{"--build", "--from", "--to", "--parent-build", "--autolock"}
+
+// artifactCommands are the cmd-* scripts whose positional arguments are
+// artifact names
+var artifactCommands = []string{"osbuild"}
+
+func init() {
+	// Note buildCommands is intentionally listed in frequency order
+	sort.Strings(advancedBuildCommands)
+	sort.Strings(utilityCommands)
+}
+
+// newRootCommand returns the command tree: the native Go commands and
+// every cmd-* script installed in libdir.
+func newRootCommand(libdir string) *cobra.Command {
+	// Keep buildCommands in frequency order
+	cobra.EnableCommandSorting = false
+
+	root := &cobra.Command{
+		Use:   "coreos-assembler",
+		Short: "Build and test CoreOS-style operating systems",
+		Long: "coreos-assembler (cosa) builds and tests CoreOS-style operating systems.\n\n" +
+			"Global flags must come before the command:\n" +
+			"  --events-json PATH   Append machine-readable events to PATH",
+		SilenceErrors: true,
+		SilenceUsage:  true,
+		Args:          cobra.NoArgs,
+		RunE: func(c *cobra.Command, args []string) error {
+			return c.Help()
+		},
+	}
+	root.AddGroup(
+		&cobra.Group{ID: groupBuild, Title: "Build commands:"},
+		&cobra.Group{ID: groupAdvanced, Title: "Advanced build commands:"},
+		&cobra.Group{ID: groupBuildextend, Title: "Platform builds:"},
+		&cobra.Group{ID: groupUtility, Title: "Utility commands:"},
+		&cobra.Group{ID: groupOther, Title: "Other commands:"},
+	)
+	root.SetHelpCommandGroupID(groupOther)
+	root.SetCompletionCommandGroupID(groupOther)
+
+	native := []*cobra.Command{
+		cmdBuildExtensionsContainer,
+		cmdBuildMirror,
+		cmdClean,
+		newDiffCommand(),
+		cmdPrune,
+		cmdRemoteSession,
+		cmdUpdateVariant,
+		cmdVerifyBuild,
+	}
+	known := make(map[string]bool)
+	for _, c := range native {
+		known[c.Name()] = true
+		for _, a := range c.Aliases {
+			known[a] = true
+		}
+	}
+	var legacy []*cobra.Command
+	for _, name := range discoverLegacyCommands(libdir) {
+		if !known[name] {
+			legacy = append(legacy, newLegacyCommand(libdir, name))
+		}
+	}
+
+	cmds := append(native, legacy...)
+	for _, c := range cmds {
+		c.GroupID = commandGroup(c.Name())
+	}
+	sort.SliceStable(cmds, func(i, j int) bool {
+		return commandRank(cmds[i].Name()) < commandRank(cmds[j].Name())
+	})
+	root.AddCommand(cmds...)
+	return root
+}
+
+// discoverLegacyCommands returns the names of the cmd-* scripts in libdir.
+func discoverLegacyCommands(libdir string) []string {
+	matches, err := filepath.Glob(filepath.Join(libdir, "cmd-*"))
+	if err != nil {
+		return nil
+	}
+	var ret []string
+	for _, m := range matches {
+		fi, err := os.Stat(m)
+		if err != nil || fi.IsDir() || fi.Mode()&0111 == 0 {
+			continue
+		}
+		ret = append(ret, strings.TrimPrefix(filepath.Base(m), "cmd-"))
+	}
+	return ret
+}
+
+// newLegacyCommand wraps a cmd-* script. The script parses its own
+// arguments, and `cosa help CMD` shows the script's own --help.
+func newLegacyCommand(libdir, name string) *cobra.Command {
+	c := &cobra.Command{
+		Use:                name,
+		Short:              fmt.Sprintf("Run cmd-%s", name),
+		DisableFlagParsing: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runLegacyCommand(name, args)
+		},
+		ValidArgsFunction: completeLegacyArgs(name),
+	}
+	c.SetHelpFunc(func(c *cobra.Command, args []string) {
+		script := exec.Command(filepath.Join(libdir, "cmd-"+name), "--help")
+		script.Stdout = c.OutOrStdout()
+		script.Stderr = c.ErrOrStderr()
+		if err := script.Run(); err != nil {
+			fmt.Fprintf(c.ErrOrStderr(), "cmd-%s --help: %v\n", name, err)
+		}
+	})
+	return c
+}
+
+// newDiffCommand wraps runDiff, which picks between the structured diff
+// and the legacy script depending on the arguments.
+func newDiffCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:                "diff",
+		Short:              cmdDiff.Short,
+		Long:               cmdDiff.Long,
+		DisableFlagParsing: true,
+		RunE: func(c *cobra.Command, args []string) error {
+			return runDiff(args)
+		},
+		ValidArgsFunction: completeLegacyArgs("diff"),
+	}
+}
+
+// commandGroup returns the help group of a command.
+func commandGroup(name string) string {
+	if strings.HasPrefix(name, "buildextend-") {
+		return groupBuildextend
+	}
+	for _, g := range []struct {
+		id   string
+		cmds []string
+	}{
+		{groupBuild, buildCommands},
+		{groupAdvanced, advancedBuildCommands},
+		{groupUtility, utilityCommands},
+	} {
+		for _, c := range g.cmds {
+			if c == name {
+				return g.id
+			}
+		}
+	}
+	return groupOther
+}
+
+// commandRank orders buildCommands by frequency and everything else
+// alphabetically after them.
+func commandRank(name string) int {
+	for i, c := range buildCommands {
+		if c == name {
+			return i
+		}
+	}
+	return len(buildCommands)
+}
+
+// completeLegacyArgs completes the arguments of a cmd-* script. Since the
+// script parses its own flags, this only knows about the flags taking a
+// build ID or an artifact name.
+func completeLegacyArgs(name string) cobra.CompletionFunc {
+	return func(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+		workdir := "."
+		for i, arg := range args {
+			if arg == "--workdir" && i+1 < len(args) {
+				workdir = args[i+1]
+			} else if v, ok := strings.CutPrefix(arg, "--workdir="); ok {
+				workdir = v
+			}
+		}
+
+		if len(args) > 0 {
+			prev := args[len(args)-1]
+			for _, f := range buildIDFlags {
+				if prev == f {
+					return completeBuildIDs(workdir)
+				}
+			}
+			if prev == "--artifact" {
+				return builds.GetCommandBuildableArtifacts(), cobra.ShellCompDirectiveNoFileComp
+			}
+			if prev == "--workdir" {
+				return nil, cobra.ShellCompDirectiveFilterDirs
+			}
+		}
+		if strings.HasPrefix(toComplete, "-") {
+			return nil, cobra.ShellCompDirectiveNoFileComp
+		}
+		for _, a := range artifactCommands {
+			if a == name {
+				return builds.GetCommandBuildableArtifacts(), cobra.ShellCompDirectiveNoFileComp
+			}
+		}
+		return nil, cobra.ShellCompDirectiveDefault
+	}
+}
+
+// completeBuildIDs returns the build IDs in builds.json of the workdir,
+// newest first.
+func completeBuildIDs(workdir string) ([]string, cobra.ShellCompDirective) {
+	bj, err := builds.GetBuilds(filepath.Join(workdir, "builds"))
+	if err != nil {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	var ret []string
+	for _, b := range bj.Builds {
+		ret = append(ret, b.ID)
+	}
+	return ret, cobra.ShellCompDirectiveNoFileComp
+}
+
+// completeBuildIDArg completes a single positional build ID of a native
+// command with a --workdir flag.
+func completeBuildIDArg(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	if len(args) > 0 {
+		return nil, cobra.ShellCompDirectiveNoFileComp
+	}
+	return completeBuildIDsFlag(c, args, toComplete)
+}
+
+// completeBuildIDsFlag completes build IDs in the workdir given by the
+// --workdir flag of the command, if any.
+func completeBuildIDsFlag(c *cobra.Command, args []string, toComplete string) ([]string, cobra.ShellCompDirective) {
+	workdir := "."
+	if f := c.Flags().Lookup("workdir"); f != nil && f.Value.String() != "" {
+		workdir = f.Value.String()
+	}
+	return completeBuildIDs(workdir)
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/pkg/builds"
+)
+
+// newFixtureLibDir creates a libdir with a few cmd-* scripts.
+func newFixtureLibDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	scripts := map[string]os.FileMode{
+		"cmd-build":             0755,
+		"cmd-buildextend-aws":   0755,
+		"cmd-frobnicate":        0755,
+		"cmd-clean":             0755,
+		"cmd-not-executable":    0644,
+		"cmd-diff":              0755,
+		"cmd-osbuild":           0755,
+		"cmd-remote-session":    0755,
+		"supermin-init-prelude": 0755,
+	}
+	for name, mode := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("#!/bin/sh\necho \"usage: $0\"\n"), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestCommandTree(t *testing.T) {
+	root := newRootCommand(newFixtureLibDir(t))
+
+	expected := map[string]string{
+		"build":                            groupBuild,
+		"clean":                            groupBuild,
+		"buildmirror":                      groupAdvanced,
+		"buildextend-aws":                  groupBuildextend,
+		"buildextend-extensions-container": groupBuildextend,
+		"diff":                             groupUtility,
+		"remote-session":                   groupUtility,
+		"frobnicate":                       groupOther,
+	}
+	for name, group := range expected {
+		c, _, err := root.Find([]string{name})
+		if err != nil || c == root {
+			t.Errorf("command %s not found: %v", name, err)
+			continue
+		}
+		if c.GroupID != group {
+			t.Errorf("%s: expected group %s, got %s", name, group, c.GroupID)
+		}
+	}
+	if c, _, err := root.Find([]string{"not-executable"}); err == nil && c != root {
+		t.Errorf("non-executable scripts should not be commands")
+	}
+	// native commands take precedence over scripts of the same name
+	if c, _, _ := root.Find([]string{"clean"}); c != cmdClean {
+		t.Errorf("clean should be the native command")
+	}
+	if c, _, _ := root.Find([]string{"build-extensions-container"}); c != cmdBuildExtensionsContainer {
+		t.Errorf("build-extensions-container should be an alias")
+	}
+
+	// buildCommands come first, in frequency order
+	var names []string
+	for _, c := range root.Commands() {
+		names = append(names, c.Name())
+	}
+	if !strings.HasPrefix(strings.Join(names, " "), "build osbuild prune clean") {
+		t.Errorf("unexpected command order: %v", names)
+	}
+}
+
+func TestCompletion(t *testing.T) {
+	workdir := t.TempDir()
+	buildsDir := filepath.Join(workdir, "builds")
+	if err := os.Mkdir(buildsDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, id := range []string{"41.20250101.0", "41.20250102.0"} {
+		if err := builds.Insert(buildsDir, id, "x86_64"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	complete := func(args ...string) []string {
+		t.Helper()
+		root := newRootCommand(newFixtureLibDir(t))
+		var out bytes.Buffer
+		root.SetOut(&out)
+		root.SetArgs(append([]string{"__completeNoDesc"}, args...))
+		if err := root.Execute(); err != nil {
+			t.Fatal(err)
+		}
+		var ret []string
+		for _, l := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+			if !strings.HasPrefix(l, ":") {
+				ret = append(ret, l)
+			}
+		}
+		return ret
+	}
+
+	// a legacy script
+	got := complete("buildextend-aws", "--workdir", workdir, "--build", "")
+	if strings.Join(got, " ") != "41.20250102.0 41.20250101.0" {
+		t.Errorf("unexpected build IDs: %v", got)
+	}
+	// a native command
+	got = complete("prune", "--workdir", workdir, "--build", "")
+	if len(got) != 2 {
+		t.Errorf("unexpected build IDs: %v", got)
+	}
+	got = complete("verify-build", "--workdir="+workdir, "41")
+	if len(got) != 2 {
+		t.Errorf("unexpected build IDs: %v", got)
+	}
+
+	got = complete("osbuild", "")
+	if len(got) == 0 || got[0] != "extensions" {
+		t.Errorf("expected artifact names, got %v", got)
+	}
+	got = complete("buildextend-aws", "--artifact", "")
+	if len(got) != len(builds.GetCommandBuildableArtifacts()) {
+		t.Errorf("expected artifact names, got %v", got)
+	}
+}
+
+func TestLegacyHelp(t *testing.T) {
+	root := newRootCommand(newFixtureLibDir(t))
+	var out bytes.Buffer
+	root.SetOut(&out)
+	root.SetArgs([]string{"help", "frobnicate"})
+	if err := root.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "usage: ") || !strings.Contains(out.String(), "cmd-frobnicate") {
+		t.Errorf("expected the script's own help, got %q", out.String())
+	}
+}
@@ -1,40 +1,31 @@
-// See usage below
 package main
 
 import (
 	"fmt"
 	"os"
-)
 
-func runUpdateVariant(argv []string) error {
-	const updateVariantUsage = `Usage: coreos-assembler update-variant --help <variant> <version>
+	"github.com/spf13/cobra"
+)
 
-Update symlinks for manifests in the config repo to use the specified version
+var cmdUpdateVariant = &cobra.Command{
+	Use:   "update-variant VARIANT VERSION",
+	Short: "cosa update-variant VARIANT VERSION",
+	Long: `Update symlinks for manifests in the config repo to use the specified version
 for the given variant.
 
-Use the "default" variant to update the default manifests with a variant suffix.
+Use the "default" variant to update the default manifests with a variant suffix.`,
+	Example: `  # Update the rhel-coreos-9 variant to RHEL 9.2
+  coreos-assembler update-variant rhel-coreos-9 rhel-9.2
 
-Example to update the rhel-coreos-9 variant to RHEL 9.2:
-$ coreos-assembler update-variant rhel-coreos-9 rhel-9.2
-
-Example to set SCOS as the default manifest:
-$ coreos-assembler update-variant default scos
-`
-	for _, arg := range argv {
-		switch arg {
-		case "-h":
-		case "--help":
-			fmt.Print(updateVariantUsage)
-			return nil
-		}
-	}
+  # Set SCOS as the default manifest
+  coreos-assembler update-variant default scos`,
+	Args: cobra.ExactArgs(2),
+	RunE: runUpdateVariantCmd,
+}
 
-	if len(argv) != 2 {
-		fmt.Print(updateVariantUsage)
-		return nil
-	}
-	variant := argv[0]
-	version := argv[1]
+func runUpdateVariantCmd(c *cobra.Command, args []string) error {
+	variant := args[0]
+	version := args[1]
 
 	var suffix string
 	if variant == "default" {
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"github.com/coreos/coreos-assembler/internal/pkg/events"
+	"github.com/coreos/coreos-assembler/pkg/builds"
+	"github.com/spf13/cobra"
+)
+
+type VerifyBuildOptions struct {
+	Workdir string
+	Arch    string
+	JSON    bool
+	Jobs    int
+}
+
+var (
+	verifyBuildOpts VerifyBuildOptions
+
+	cmdVerifyBuild = &cobra.Command{
+		Use:   "verify-build [BUILDID]",
+		Short: "cosa verify-build [BUILDID]",
+		Long: "Verify the size and sha256 of every artifact listed in a build's " +
+			"meta.json, including the uncompressed digests of compressed " +
+			"artifacts. Defaults to the latest build.",
+		Args:              cobra.MaximumNArgs(1),
+		RunE:              runVerifyBuildCmd,
+		ValidArgsFunction: completeBuildIDArg,
+	}
+)
+
+func runVerifyBuildCmd(c *cobra.Command, args []string) error {
+	var buildID string
+	if len(args) > 0 {
+		buildID = args[0]
+	}
+	build, dir, err := builds.ReadBuild(filepath.Join(verifyBuildOpts.Workdir, "builds"), buildID, verifyBuildOpts.Arch)
+	if err != nil {
+		return err
+	}
+	report, err := build.VerifyArtifacts(dir, verifyBuildOpts.Jobs)
+	if err != nil {
+		return err
+	}
+
+	if verifyBuildOpts.JSON {
+		out, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(out))
+	} else {
+		printVerifyReport(report)
+	}
+
+	for _, a := range append(report.Missing(), report.Corrupt()...) {
+		events.Warning("artifact %s (%s) is %s", a.Name, a.Path, a.Status)
+	}
+
+	// The report has already been printed, so don't let cobra
+	// print the usage on top of it.
+	c.SilenceUsage = true
+	if !report.OK() {
+		return fmt.Errorf("build %s/%s failed verification", report.BuildID, report.Arch)
+	}
+	return nil
+}
+
+func printVerifyReport(report *builds.VerifyReport) {
+	fmt.Printf("Verifying build %s/%s\n", report.BuildID, report.Arch)
+	for _, a := range report.Artifacts {
+		fmt.Printf("  %-8s %s (%s)\n", a.Status, a.Name, a.Path)
+		for _, e := range a.Errors {
+			fmt.Printf("           %s\n", e)
+		}
+	}
+	for _, u := range report.Unreferenced {
+		fmt.Printf("  %-8s %s\n", "unref", u)
+	}
+	fmt.Printf("%d artifacts: %d missing, %d corrupt, %d unreferenced files\n",
+		len(report.Artifacts), len(report.Missing()), len(report.Corrupt()), len(report.Unreferenced))
+}
+
+func init() {
+	cmdVerifyBuild.Flags().StringVarP(
+		&verifyBuildOpts.Workdir, "workdir", "", ".",
+		"Path to workdir")
+	cmdVerifyBuild.Flags().StringVarP(
+		&verifyBuildOpts.Arch, "arch", "", "",
+		"Architecture of the build to verify (default: the host arch)")
+	cmdVerifyBuild.Flags().BoolVarP(
+		&verifyBuildOpts.JSON, "json", "", false,
+		"Print the report as JSON")
+	cmdVerifyBuild.Flags().IntVarP(
+		&verifyBuildOpts.Jobs, "jobs", "j", 0,
+		"Number of artifacts to hash in parallel (default: number of CPUs)")
+}
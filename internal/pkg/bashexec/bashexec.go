@@ -20,6 +20,8 @@ import (
 	"os/exec"
 	"strings"
 	"syscall"
+
+	"github.com/coreos/coreos-assembler/internal/pkg/events"
 )
 
 // StrictMode enables http://redsymbol.net/articles/unofficial-bash-strict-mode/
@@ -53,6 +55,7 @@ func NewBashRunner(name, src string, args ...string) (*BashRunner, error) {
 	}
 	cmd.Stdin = os.Stdin
 	cmd.ExtraFiles = append(cmd.ExtraFiles, f)
+	events.Attach(cmd)
 
 	return &BashRunner{
 		name: name,
@@ -65,7 +68,8 @@ func (r *BashRunner) Exec() error {
 	r.cmd.Stdin = os.Stdin
 	r.cmd.Stdout = os.Stdout
 	r.cmd.Stderr = os.Stderr
-	err := r.cmd.Run()
+	phase := r.startPhase()
+	err := phase.End(r.cmd.Run())
 	if err != nil {
 		return fmt.Errorf("failed to execute internal script %s: %w", r.name, err)
 	}
@@ -74,13 +78,23 @@ func (r *BashRunner) Exec() error {
 
 // Run spawns the script, gathering stdout/stderr into a buffer that is displayed only on error.
 func (r *BashRunner) Run() error {
+	phase := r.startPhase()
 	buf, err := r.cmd.CombinedOutput()
-	if err != nil {
+	if err := phase.End(err); err != nil {
 		return fmt.Errorf("failed to execute internal script %s: %w\n%s", r.name, err, buf)
 	}
 	return nil
 }
 
+// startPhase reports a named script as a phase on the event stream.
+// Anonymous scripts are not reported.
+func (r *BashRunner) startPhase() *events.Phase {
+	if r.name == "" {
+		return nil
+	}
+	return events.StartPhase(r.name)
+}
+
 // Run spawns a named script (without any arguments),
 // gathering stdout/stderr into a buffer that is displayed only on error.
 func Run(name, cmd string) error {
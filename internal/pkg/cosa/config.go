@@ -0,0 +1,99 @@
+package cosa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// ErrAmbiguousConfig is returned when both the YAML and JSON flavor
+	// of a config file exist
+	ErrAmbiguousConfig = errors.New("found both JSON and YAML versions of config file")
+
+	// ErrManifestNotFound is returned when the variant's manifest is missing
+	ErrManifestNotFound = errors.New("failed to find manifest")
+)
+
+// Config is the resolved set of config files for the workdir's variant.
+// The fields mirror the shell variables set by `prepare_build`.
+type Config struct {
+	// Dir is src/config
+	Dir string
+	// Variant is the config variant, or "" for the default
+	Variant string
+	// Manifest is the rpm-ostree treefile
+	Manifest string
+	// Image is the image.yaml
+	Image string
+	// ManifestLock is the arch lockfile
+	ManifestLock string
+	// ManifestLockOverrides is the lockfile overrides for all arches
+	ManifestLockOverrides string
+	// ManifestLockArchOverrides is the lockfile overrides for basearch
+	ManifestLockArchOverrides string
+	// Platforms is platforms.yaml
+	Platforms string
+	// GitRepo is the git checkout the config came from. This differs
+	// from Dir when the workdir was initialized from a config-git
+	// subdirectory.
+	GitRepo string
+}
+
+// Config resolves the config files of the workdir for basearch. It fails
+// with ErrManifestNotFound if the variant's manifest does not exist.
+func (w *Workdir) Config(basearch string) (*Config, error) {
+	variant, err := w.Variant()
+	if err != nil {
+		return nil, err
+	}
+	c := &Config{
+		Dir:     w.Path("src", "config"),
+		Variant: variant,
+		GitRepo: w.Path("src", "config"),
+	}
+	if variant != "" {
+		c.Manifest = filepath.Join(c.Dir, fmt.Sprintf("manifest-%s.yaml", variant))
+		c.Image = filepath.Join(c.Dir, fmt.Sprintf("image-%s.yaml", variant))
+	} else {
+		c.Manifest = filepath.Join(c.Dir, "manifest.yaml")
+		c.Image = filepath.Join(c.Dir, "image.yaml")
+	}
+	if _, err := os.Stat(c.Manifest); err != nil {
+		return nil, fmt.Errorf("%s: %w", c.Manifest, ErrManifestNotFound)
+	}
+
+	// for the base lockfile, we default to JSON since that's what
+	// rpm-ostree actually outputs
+	if c.ManifestLock, err = pickYAMLOrElseJSON(filepath.Join(c.Dir, "manifest-lock."+basearch), "json"); err != nil {
+		return nil, err
+	}
+	if c.ManifestLockOverrides, err = pickYAMLOrElseJSON(filepath.Join(c.Dir, "manifest-lock.overrides"), "yaml"); err != nil {
+		return nil, err
+	}
+	if c.ManifestLockArchOverrides, err = pickYAMLOrElseJSON(filepath.Join(c.Dir, "manifest-lock.overrides."+basearch), "yaml"); err != nil {
+		return nil, err
+	}
+	c.Platforms = filepath.Join(c.Dir, "platforms.yaml")
+	if _, err := os.Stat(w.Path("src", "config-git")); err == nil {
+		c.GitRepo = w.Path("src", "config-git")
+	}
+	return c, nil
+}
+
+// pickYAMLOrElseJSON returns whichever of base.json and base.yaml
+// exists, or base.<def> if neither does.
+func pickYAMLOrElseJSON(base, def string) (string, error) {
+	_, jerr := os.Stat(base + ".json")
+	_, yerr := os.Stat(base + ".yaml")
+	switch {
+	case jerr == nil && yerr == nil:
+		return "", fmt.Errorf("%s: %w", base, ErrAmbiguousConfig)
+	case jerr == nil:
+		return base + ".json", nil
+	case yerr == nil:
+		return base + ".yaml", nil
+	}
+	return base + "." + def, nil
+}
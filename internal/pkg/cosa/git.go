@@ -0,0 +1,81 @@
+package cosa
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// GitInfo describes the git checkout a build input came from. It is
+// serialized the same way as by `prepare_git_artifacts` in cmdlib.sh,
+// e.g. into coreos-assembler-config-git.json.
+type GitInfo struct {
+	Date string `json:"date"`
+	Git  GitRef `json:"git"`
+}
+
+// GitRef is the git state of a checkout. Unknown values are "unknown".
+type GitRef struct {
+	Commit string `json:"commit"`
+	Origin string `json:"origin"`
+	Branch string `json:"branch"`
+	Dirty  string `json:"dirty"`
+}
+
+// GetGitInfo inspects the git checkout at dir.
+func GetGitInfo(dir string) (*GitInfo, error) {
+	git := func(args ...string) (string, error) {
+		cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+		out, err := cmd.Output()
+		if err != nil {
+			return "", fmt.Errorf("git %s in %s: %w", strings.Join(args, " "), dir, err)
+		}
+		return strings.TrimSpace(string(out)), nil
+	}
+
+	ref := GitRef{
+		Origin: "unknown",
+		Dirty:  "false",
+	}
+	var err error
+	if ref.Commit, err = git("rev-parse", "HEAD"); err != nil {
+		return nil, err
+	}
+	if ref.Branch, err = git("rev-parse", "--abbrev-ref", "HEAD"); err != nil {
+		return nil, err
+	}
+	if err := exec.Command("git", "-C", dir, "diff", "--quiet", "--exit-code").Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return nil, err
+		}
+		ref.Dirty = "true"
+	}
+
+	// When the checkout is shallow or a detached HEAD, assume the
+	// origin is the remote; otherwise use the upstream of the branch.
+	remote := "origin"
+	if _, serr := os.Stat(filepath.Join(dir, ".git", "shallow")); serr != nil && ref.Branch != "HEAD" {
+		remote = "unknown"
+		if head, err := git("symbolic-ref", "-q", "HEAD"); err == nil {
+			if r, err := git("for-each-ref", "--format=%(upstream:remotename)", head); err == nil && r != "" {
+				remote = r
+			}
+		}
+	}
+	if url, err := git("remote", "get-url", remote); err == nil {
+		ref.Origin = url
+	}
+
+	return &GitInfo{
+		Date: time.Now().UTC().Format(time.RFC3339),
+		Git:  ref,
+	}, nil
+}
+
+// ConfigGitInfo inspects the git checkout of the workdir's config.
+func (c *Config) ConfigGitInfo() (*GitInfo, error) {
+	return GetGitInfo(c.GitRepo)
+}
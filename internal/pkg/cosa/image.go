@@ -0,0 +1,224 @@
+package cosa
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// ImageDefaultsPath is where the defaults for image.yaml are installed
+const ImageDefaultsPath = "/usr/lib/coreos-assembler/image-default.yaml"
+
+// Manifest is the subset of an rpm-ostree treefile cosa itself reads.
+// Includes are not followed; use `rpm-ostree compose tree --print-only`
+// for the flattened treefile.
+type Manifest struct {
+	Variables map[string]interface{} `yaml:"variables"`
+	Metadata  map[string]interface{} `yaml:"metadata"`
+}
+
+// LoadManifest reads the top-level treefile at path.
+func LoadManifest(path string) (*Manifest, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err := yaml.Unmarshal(contents, &m); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &m, nil
+}
+
+// BuildWithBuildah reports whether the config should be built with
+// buildah rather than rpm-ostree. COSA_BUILD_WITH_BUILDAH overrides the
+// manifest's metadata.build_with_buildah.
+func (c *Config) BuildWithBuildah() (bool, error) {
+	if v, ok := os.LookupEnv("COSA_BUILD_WITH_BUILDAH"); ok && v != "" {
+		return v == "1", nil
+	}
+	m, err := LoadManifest(c.Manifest)
+	if err != nil {
+		return false, err
+	}
+	b, _ := m.Metadata["build_with_buildah"].(bool)
+	return b, nil
+}
+
+// GenerateImageJSON flattens imageYAML, substituting the variables of the
+// manifest at manifestPath, and overlays it on the image.yaml defaults.
+// This is the content of the image.json embedded in the ostree commit.
+func GenerateImageJSON(imageYAML, manifestPath, defaultsPath string) (map[string]interface{}, error) {
+	m, err := LoadManifest(manifestPath)
+	if err != nil {
+		return nil, err
+	}
+	contents, err := os.ReadFile(defaultsPath)
+	if err != nil {
+		return nil, err
+	}
+	r := make(map[string]interface{})
+	if err := yaml.Unmarshal(contents, &r); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", defaultsPath, err)
+	}
+	flat, err := FlattenImageYAML(imageYAML, m.Variables)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range flat {
+		r[k] = v
+	}
+	return r, nil
+}
+
+// WriteImageJSON writes the image.json for the config to out.
+func (c *Config) WriteImageJSON(out string) error {
+	r, err := GenerateImageJSON(c.Image, c.Manifest, ImageDefaultsPath)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(out, data, 0644)
+}
+
+// FlattenImageYAML reads an image.yaml and the chain of files it includes.
+// Values in a file take precedence over those it includes, except that
+// lists are merged. Each file is first expanded as a Python format string
+// with vars, which come from the manifest's variables.
+func FlattenImageYAML(path string, vars map[string]interface{}) (map[string]interface{}, error) {
+	base := make(map[string]interface{})
+	for {
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		expanded, err := pyFormat(string(contents), vars)
+		if err != nil {
+			return nil, fmt.Errorf("expanding %s: %w", path, err)
+		}
+		src := make(map[string]interface{})
+		if err := yaml.Unmarshal([]byte(expanded), &src); err != nil {
+			return nil, fmt.Errorf("parsing %s: %w", path, err)
+		}
+
+		// extra-kargs always exists in the result, even if empty
+		for _, m := range []map[string]interface{}{base, src} {
+			if _, ok := m["extra-kargs"]; !ok {
+				m["extra-kargs"] = []interface{}{}
+			}
+		}
+		base = mergeDicts(base, src)
+
+		include, ok := src["include"].(string)
+		if !ok {
+			return base, nil
+		}
+		delete(base, "include")
+		path = filepath.Join(filepath.Dir(path), include)
+	}
+}
+
+// mergeDicts merges y into x recursively. Values in x take precedence,
+// except that lists are concatenated without duplicates.
+func mergeDicts(x, y map[string]interface{}) map[string]interface{} {
+	ret := make(map[string]interface{}, len(x)+len(y))
+	for k, v := range y {
+		ret[k] = v
+	}
+	for k, xv := range x {
+		yv, ok := y[k]
+		if !ok {
+			ret[k] = xv
+			continue
+		}
+		xm, xok := xv.(map[string]interface{})
+		ym, yok := yv.(map[string]interface{})
+		if xok && yok {
+			ret[k] = mergeDicts(xm, ym)
+			continue
+		}
+		xl, xok := xv.([]interface{})
+		yl, yok := yv.([]interface{})
+		if xok && yok {
+			ret[k] = mergeLists(xl, yl)
+			continue
+		}
+		ret[k] = xv
+	}
+	return ret
+}
+
+// mergeLists appends the items of y missing from x to x.
+func mergeLists(x, y []interface{}) []interface{} {
+	ret := append([]interface{}{}, x...)
+	for _, yi := range y {
+		found := false
+		for _, xi := range ret {
+			if reflect.DeepEqual(xi, yi) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ret = append(ret, yi)
+		}
+	}
+	return ret
+}
+
+// pyFormat implements the subset of Python's str.format used by
+// image.yaml: "{name}" is replaced by the variable and "{{" and "}}"
+// are literal braces.
+func pyFormat(s string, vars map[string]interface{}) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '{' && i+1 < len(s) && s[i+1] == '{':
+			b.WriteByte('{')
+			i++
+		case c == '}' && i+1 < len(s) && s[i+1] == '}':
+			b.WriteByte('}')
+			i++
+		case c == '{':
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return "", fmt.Errorf("unterminated '{' at offset %d", i)
+			}
+			name := s[i+1 : i+end]
+			v, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable %q", name)
+			}
+			b.WriteString(pyStr(v))
+			i += end
+		case c == '}':
+			return "", fmt.Errorf("single '}' at offset %d", i)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String(), nil
+}
+
+// pyStr formats a YAML scalar the way Python's str() would.
+func pyStr(v interface{}) string {
+	switch t := v.(type) {
+	case bool:
+		if t {
+			return "True"
+		}
+		return "False"
+	case nil:
+		return "None"
+	}
+	return fmt.Sprint(v)
+}
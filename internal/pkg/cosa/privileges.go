@@ -0,0 +1,90 @@
+package cosa
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// capSysAdmin is CAP_SYS_ADMIN from linux/capability.h
+const capSysAdmin = 21
+
+var (
+	privilegedOnce sync.Once
+	privileged     bool
+	privilegedErr  error
+)
+
+// HasPrivileges reports whether cosa can use sudo and mount filesystems
+// directly rather than running privileged operations in a supermin VM.
+// It is the equivalent of `has_privileges` in cmdlib.sh, and likewise
+// honors FORCE_UNPRIVILEGED and a COSA_PRIVILEGED inherited from a
+// parent cosa process. The result is computed once per process.
+func HasPrivileges() (bool, error) {
+	privilegedOnce.Do(func() {
+		privileged, privilegedErr = checkPrivileges()
+		if privilegedErr == nil {
+			v := "0"
+			if privileged {
+				v = "1"
+			}
+			privilegedErr = os.Setenv("COSA_PRIVILEGED", v)
+		}
+	})
+	return privileged, privilegedErr
+}
+
+func checkPrivileges() (bool, error) {
+	if v := os.Getenv("COSA_PRIVILEGED"); v != "" {
+		return v == "1", nil
+	}
+	if os.Getenv("FORCE_UNPRIVILEGED") != "" {
+		fmt.Fprintln(os.Stderr, "info: Detected FORCE_UNPRIVILEGED; using virt")
+		return false, nil
+	}
+	hasCap, err := hasBoundingCap(capSysAdmin)
+	if err != nil {
+		return false, err
+	}
+	if !hasCap {
+		fmt.Fprintln(os.Stderr, "info: Missing CAP_SYS_ADMIN; using virt")
+		return false, nil
+	}
+	if os.Getuid() != 0 {
+		if err := exec.Command("sudo", "-n", "true").Run(); err != nil {
+			fmt.Fprintln(os.Stderr, "info: Missing sudo privs; using virt")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// hasBoundingCap reports whether a capability is in the bounding set of
+// the current process.
+func hasBoundingCap(capability uint) (bool, error) {
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		v, ok := strings.CutPrefix(s.Text(), "CapBnd:")
+		if !ok {
+			continue
+		}
+		mask, err := strconv.ParseUint(strings.TrimSpace(v), 16, 64)
+		if err != nil {
+			return false, fmt.Errorf("parsing CapBnd: %w", err)
+		}
+		return mask&(1<<capability) != 0, nil
+	}
+	if err := s.Err(); err != nil {
+		return false, err
+	}
+	return false, fmt.Errorf("no CapBnd in /proc/self/status")
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
+	"path/filepath"
 )
 
 const initConfigPath = "src/config.json"
@@ -14,7 +15,17 @@ type configVariant struct {
 
 // GetVariant finds the configured variant, or "" if unset
 func GetVariant() (string, error) {
-	contents, err := os.ReadFile(initConfigPath)
+	return readVariant(initConfigPath)
+}
+
+// Variant finds the variant the workdir was initialized with, or "" if
+// unset.
+func (w *Workdir) Variant() (string, error) {
+	return readVariant(w.Path(filepath.FromSlash(initConfigPath)))
+}
+
+func readVariant(path string) (string, error) {
+	contents, err := os.ReadFile(path)
 	if err != nil {
 		if !os.IsNotExist(err) {
 			return "", err
@@ -24,7 +35,7 @@ func GetVariant() (string, error) {
 
 	var variantData configVariant
 	if err := json.Unmarshal(contents, &variantData); err != nil {
-		return "", fmt.Errorf("parsing %s: %w", initConfigPath, err)
+		return "", fmt.Errorf("parsing %s: %w", path, err)
 	}
 
 	return variantData.Variant, nil
@@ -0,0 +1,121 @@
+package cosa
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// accessWriteOK is W_OK from unistd.h
+const accessWriteOK = 0x2
+
+var (
+	// ErrNotWorkdir is returned when a directory is not a cosa workdir
+	ErrNotWorkdir = errors.New("no builds/ found; did you run coreos-assembler init?")
+
+	// ErrWorkdirNotWritable is returned when the workdir cannot be written to
+	ErrWorkdirNotWritable = errors.New("workdir is not writable")
+)
+
+// Workdir is a coreos-assembler working directory, as created by
+// `cosa init`. It is the Go equivalent of the state `prepare_build`
+// in cmdlib.sh sets up in shell variables.
+type Workdir struct {
+	// Dir is the absolute path to the workdir
+	Dir string
+}
+
+// OpenWorkdir returns the workdir at dir, which must contain builds/.
+func OpenWorkdir(dir string) (*Workdir, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	fi, err := os.Stat(filepath.Join(abs, "builds"))
+	if err != nil || !fi.IsDir() {
+		return nil, fmt.Errorf("%s: %w", abs, ErrNotWorkdir)
+	}
+	return &Workdir{Dir: abs}, nil
+}
+
+// FindWorkdir returns the workdir containing start, searching upwards
+// through its parents.
+func FindWorkdir(start string) (*Workdir, error) {
+	dir, err := filepath.Abs(start)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if w, err := OpenWorkdir(dir); err == nil {
+			return w, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return nil, fmt.Errorf("%s: %w", start, ErrNotWorkdir)
+		}
+		dir = parent
+	}
+}
+
+// Path returns a path relative to the workdir.
+func (w *Workdir) Path(elem ...string) string {
+	return filepath.Join(append([]string{w.Dir}, elem...)...)
+}
+
+// BuildsDir returns the builds/ directory.
+func (w *Workdir) BuildsDir() string {
+	return w.Path("builds")
+}
+
+// TmpDir returns the tmp/ directory, creating it if needed since older
+// versions of `cosa init` did not.
+func (w *Workdir) TmpDir() (string, error) {
+	tmp := w.Path("tmp")
+	if err := os.MkdirAll(tmp, 0755); err != nil {
+		return "", err
+	}
+	return tmp, nil
+}
+
+// CheckWritable returns ErrWorkdirNotWritable if the workdir cannot be
+// written to.
+func (w *Workdir) CheckWritable() error {
+	if err := syscall.Access(w.Dir, accessWriteOK); err != nil {
+		return fmt.Errorf("%s: %w", w.Dir, ErrWorkdirNotWritable)
+	}
+	return nil
+}
+
+// AllocateTmpBuildDir returns a fresh tmp/build.<imageType> directory,
+// removing any left over from a previous build of the same type. With an
+// empty imageType the directory is tmp/build.
+func (w *Workdir) AllocateTmpBuildDir(imageType string) (string, error) {
+	if err := w.CheckWritable(); err != nil {
+		return "", err
+	}
+	tmp, err := w.TmpDir()
+	if err != nil {
+		return "", err
+	}
+	name := "build"
+	if imageType != "" {
+		name += "." + imageType
+	}
+	dir := filepath.Join(tmp, name)
+	if err := os.RemoveAll(dir); err != nil {
+		return "", err
+	}
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
+// IsTransient reports whether the workdir was initialized with
+// `cosa init --transient`.
+func (w *Workdir) IsTransient() bool {
+	_, err := os.Stat(w.Path("tmp", "cosa-transient"))
+	return err == nil
+}
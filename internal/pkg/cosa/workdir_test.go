@@ -0,0 +1,173 @@
+package cosa
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFixture populates dir with files, creating parent directories.
+func writeFixture(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// newFixtureWorkdir creates a workdir as `cosa init` would, with a
+// "rhcos" variant config.
+func newFixtureWorkdir(t *testing.T) *Workdir {
+	t.Helper()
+	dir := t.TempDir()
+	writeFixture(t, dir, map[string]string{
+		"builds/.keep":                            "",
+		"src/config.json":                         `{"coreos-assembler.config-variant": "rhcos"}`,
+		"src/config/manifest-rhcos.yaml":          "variables:\n  osversion: rhel-9.6\n  stream: \"4.19\"\nmetadata:\n  build_with_buildah: true\n",
+		"src/config/image-rhcos.yaml":             "include: image-base.yaml\nextra-kargs:\n  - console=tty0\n  - mitigations=auto\nrootfs: \"xfs\"\nimage-name: \"rhcos-{stream}-{osversion}\"\n",
+		"src/config/image-base.yaml":              "extra-kargs:\n  - mitigations=auto,nosmt\n  - console=tty0\nrootfs: ext4\nbootfs: xfs\nbraces: \"{{literal}}\"\n",
+		"src/config/manifest-lock.overrides.yaml": "",
+		"image-default.yaml":                      "bootfs: ext4\nrootfs: xfs\ncomposefs: false\nextra-kargs: []\n",
+	})
+	w, err := OpenWorkdir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestWorkdir(t *testing.T) {
+	w := newFixtureWorkdir(t)
+
+	if _, err := OpenWorkdir(t.TempDir()); !errors.Is(err, ErrNotWorkdir) {
+		t.Errorf("expected ErrNotWorkdir, got %v", err)
+	}
+	found, err := FindWorkdir(w.Path("src", "config"))
+	if err != nil || found.Dir != w.Dir {
+		t.Errorf("expected to find %s, got %v (%v)", w.Dir, found, err)
+	}
+
+	tmp, err := w.AllocateTmpBuildDir("extensions-container")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmp != w.Path("tmp", "build.extensions-container") {
+		t.Errorf("unexpected tmp build dir %s", tmp)
+	}
+	stale := filepath.Join(tmp, "stale")
+	if err := os.WriteFile(stale, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.AllocateTmpBuildDir("extensions-container"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(stale); !os.IsNotExist(err) {
+		t.Errorf("tmp build dir should be fresh")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	w := newFixtureWorkdir(t)
+	c, err := w.Config("x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Variant != "rhcos" || filepath.Base(c.Manifest) != "manifest-rhcos.yaml" || filepath.Base(c.Image) != "image-rhcos.yaml" {
+		t.Errorf("variant not resolved: %+v", c)
+	}
+	if filepath.Base(c.ManifestLock) != "manifest-lock.x86_64.json" {
+		t.Errorf("lockfile should default to JSON, got %s", c.ManifestLock)
+	}
+	if filepath.Base(c.ManifestLockOverrides) != "manifest-lock.overrides.yaml" {
+		t.Errorf("unexpected overrides %s", c.ManifestLockOverrides)
+	}
+	if b, err := c.BuildWithBuildah(); err != nil || !b {
+		t.Errorf("expected build_with_buildah from the manifest, got %v (%v)", b, err)
+	}
+
+	writeFixture(t, w.Dir, map[string]string{"src/config/manifest-lock.overrides.json": "{}"})
+	if _, err := w.Config("x86_64"); !errors.Is(err, ErrAmbiguousConfig) {
+		t.Errorf("expected ErrAmbiguousConfig, got %v", err)
+	}
+	if err := os.Remove(c.Manifest); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Config("x86_64"); !errors.Is(err, ErrManifestNotFound) {
+		t.Errorf("expected ErrManifestNotFound, got %v", err)
+	}
+}
+
+func TestGenerateImageJSON(t *testing.T) {
+	w := newFixtureWorkdir(t)
+	c, err := w.Config("x86_64")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := GenerateImageJSON(c.Image, c.Manifest, w.Path("image-default.yaml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]interface{}{
+		"bootfs":      "xfs",
+		"rootfs":      "xfs",
+		"composefs":   false,
+		"image-name":  "rhcos-4.19-rhel-9.6",
+		"braces":      "{literal}",
+		"extra-kargs": []interface{}{"console=tty0", "mitigations=auto", "mitigations=auto,nosmt"},
+	}
+	if !reflect.DeepEqual(r, expected) {
+		t.Errorf("unexpected image.json:\n got %v\nwant %v", r, expected)
+	}
+
+	if _, err := pyFormat("{undefined}", nil); err == nil {
+		t.Errorf("undefined variables should be an error")
+	}
+}
+
+func TestGetGitInfo(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	git("init", "-q", "-b", "main")
+	writeFixture(t, dir, map[string]string{"manifest.yaml": "ref: test\n"})
+	git("add", ".")
+	git("commit", "-q", "-m", "init")
+	git("remote", "add", "origin", "https://example.com/config.git")
+
+	info, err := GetGitInfo(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Git.Branch != "main" || info.Git.Dirty != "false" || len(info.Git.Commit) != 40 {
+		t.Errorf("unexpected git info: %+v", info.Git)
+	}
+	// main has no upstream, so its remote is unknown
+	if info.Git.Origin != "unknown" {
+		t.Errorf("expected unknown origin, got %s", info.Git.Origin)
+	}
+
+	writeFixture(t, dir, map[string]string{"manifest.yaml": "ref: changed\n"})
+	git("checkout", "-q", "--detach")
+	if info, err = GetGitInfo(dir); err != nil {
+		t.Fatal(err)
+	}
+	if info.Git.Dirty != "true" || info.Git.Origin != "https://example.com/config.git" {
+		t.Errorf("detached dirty checkout should use origin: %+v", info.Git)
+	}
+}
@@ -9,6 +9,10 @@
 // although this is not strictly required.  The Go APIs here call dynamically
 // into the bash process by writing to its stdin, and can receive serialized
 // data back over a pipe on file descriptor 3.
+//
+// Most of that state now has a typed equivalent in internal/pkg/cosa,
+// which new code should prefer; this remains for helpers such as runvm
+// that are still only implemented in shell.
 package cosash
 
 import (
@@ -22,6 +26,7 @@ import (
 	"syscall"
 
 	"github.com/coreos/coreos-assembler/internal/pkg/bashexec"
+	"github.com/coreos/coreos-assembler/internal/pkg/events"
 )
 
 // CosaSh is a companion shell process which accepts commands
@@ -77,6 +82,8 @@ func NewCosaSh() (*CosaSh, error) {
 		return nil, err
 	}
 	cmd.ExtraFiles = append(cmd.ExtraFiles, cmdout)
+	// Scripts run by the shell can emit events too
+	events.Attach(cmd)
 
 	// Start the process
 	if err := cmd.Start(); err != nil {
@@ -165,14 +172,16 @@ func (sh *CosaSh) Process(buf string) error {
 
 // PrepareBuild prepares for a build, returning the newly allocated build directory
 func (sh *CosaSh) PrepareBuild(artifact_name string) (string, error) {
+	phase := events.StartPhase("prepare-build")
 	if artifact_name != "" {
 		if err := sh.Process(fmt.Sprintf("IMAGE_TYPE=%s", artifact_name)); err != nil {
-			return "", err
+			return "", phase.End(err)
 		}
 	}
-	return sh.ProcessWithReply(`prepare_build
+	dir, err := sh.ProcessWithReply(`prepare_build
 pwd >&3
 `)
+	return dir, phase.End(err)
 }
 
 // HasPrivileges checks if we can use sudo
@@ -0,0 +1,278 @@
+// Package events implements the opt-in machine-readable event stream of
+// cosa commands, meant for CI wrappers that would otherwise scrape logs.
+//
+// When COSA_EVENTS_FD names an open file descriptor (or `cosa
+// --events-json PATH` is used), commands write one JSON object per line
+// to it: the start of the command, the start and end of each phase,
+// every artifact produced, warnings, and the final status. Child
+// processes inherit the stream, so events from nested commands and
+// scripts are interleaved; the pid and command fields tell them apart.
+package events
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// EnvFD is the environment variable naming the events file descriptor
+const EnvFD = "COSA_EVENTS_FD"
+
+// Event types
+const (
+	TypeCommandStart = "command-start"
+	TypePhaseStart   = "phase-start"
+	TypePhaseEnd     = "phase-end"
+	TypeArtifact     = "artifact"
+	TypeWarning      = "warning"
+	TypeStatus       = "status"
+)
+
+// Final and phase statuses
+const (
+	StatusSuccess = "success"
+	StatusFailure = "failure"
+)
+
+// Event is a single line of the event stream.
+type Event struct {
+	Type    string    `json:"type"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command,omitempty"`
+	Pid     int       `json:"pid"`
+	Phase   string    `json:"phase,omitempty"`
+	// Duration is in seconds, for phase-end and status events
+	Duration float64 `json:"duration,omitempty"`
+	Path     string  `json:"path,omitempty"`
+	Size     int64   `json:"size,omitempty"`
+	Sha256   string  `json:"sha256,omitempty"`
+	Status   string  `json:"status,omitempty"`
+	Message  string  `json:"message,omitempty"`
+}
+
+// Emitter writes events for a command. A nil Emitter discards events, so
+// callers never need to check whether the stream is enabled.
+type Emitter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	file    *os.File
+	command string
+	start   time.Time
+	failed  bool
+}
+
+// New returns an Emitter writing events for command to w.
+func New(w io.Writer, command string) *Emitter {
+	e := &Emitter{
+		w:       w,
+		command: command,
+		start:   time.Now(),
+	}
+	if f, ok := w.(*os.File); ok {
+		e.file = f
+	}
+	return e
+}
+
+// FromEnv returns an Emitter for the file descriptor in COSA_EVENTS_FD,
+// or nil if it is unset.
+func FromEnv(command string) (*Emitter, error) {
+	v := os.Getenv(EnvFD)
+	if v == "" {
+		return nil, nil
+	}
+	fd, err := strconv.Atoi(v)
+	if err != nil || fd < 0 {
+		return nil, fmt.Errorf("invalid %s=%q", EnvFD, v)
+	}
+	return New(os.NewFile(uintptr(fd), "events"), command), nil
+}
+
+// Open returns an Emitter appending events to the file at path.
+func Open(path, command string) (*Emitter, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return New(f, command), nil
+}
+
+// Emit writes an event, filling in the time, pid and command. Each event
+// is a single write so that lines from concurrent processes sharing the
+// stream do not interleave. Failing to write events is reported once
+// but otherwise ignored; it must never fail the build.
+func (e *Emitter) Emit(ev Event) {
+	if e == nil {
+		return
+	}
+	if ev.Time.IsZero() {
+		ev.Time = time.Now().UTC()
+	}
+	if ev.Command == "" {
+		ev.Command = e.command
+	}
+	ev.Pid = os.Getpid()
+	buf, err := json.Marshal(ev)
+	if err != nil {
+		return
+	}
+	buf = append(buf, '\n')
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.failed {
+		return
+	}
+	if _, err := e.w.Write(buf); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: failed to write to event stream: %v\n", err)
+		e.failed = true
+	}
+}
+
+// CommandStart emits the start of the command with its arguments.
+func (e *Emitter) CommandStart(argv []string) {
+	msg, _ := json.Marshal(argv)
+	e.Emit(Event{Type: TypeCommandStart, Message: string(msg)})
+}
+
+// Status emits the final status of the command.
+func (e *Emitter) Status(err error) {
+	if e == nil {
+		return
+	}
+	ev := Event{
+		Type:     TypeStatus,
+		Status:   StatusSuccess,
+		Duration: time.Since(e.start).Seconds(),
+	}
+	if err != nil {
+		ev.Status = StatusFailure
+		ev.Message = err.Error()
+	}
+	e.Emit(ev)
+}
+
+// Warning emits a warning.
+func (e *Emitter) Warning(format string, args ...interface{}) {
+	e.Emit(Event{Type: TypeWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// Artifact emits an artifact produced at path, with its size and digest.
+func (e *Emitter) Artifact(path string, size int64, sha256sum string) {
+	e.Emit(Event{Type: TypeArtifact, Path: path, Size: size, Sha256: sha256sum})
+}
+
+// ArtifactFile emits an artifact, hashing the file at path. Nothing is
+// hashed when events are disabled.
+func (e *Emitter) ArtifactFile(path string) error {
+	if e == nil {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	n, err := io.Copy(h, f)
+	if err != nil {
+		return err
+	}
+	e.Artifact(path, n, hex.EncodeToString(h.Sum(nil)))
+	return nil
+}
+
+// Phase is a timed step of a command.
+type Phase struct {
+	e     *Emitter
+	name  string
+	start time.Time
+}
+
+// Phase emits the start of a phase; call End on the result when it is done.
+func (e *Emitter) Phase(name string) *Phase {
+	e.Emit(Event{Type: TypePhaseStart, Phase: name})
+	return &Phase{e: e, name: name, start: time.Now()}
+}
+
+// End emits the end of the phase and its duration, and returns err so
+// it can wrap a return statement.
+func (p *Phase) End(err error) error {
+	if p == nil {
+		return err
+	}
+	ev := Event{
+		Type:     TypePhaseEnd,
+		Phase:    p.name,
+		Status:   StatusSuccess,
+		Duration: time.Since(p.start).Seconds(),
+	}
+	if err != nil {
+		ev.Status = StatusFailure
+		ev.Message = err.Error()
+	}
+	p.e.Emit(ev)
+	return err
+}
+
+// Attach passes the event stream to a child process that has not been
+// started yet, as an extra file descriptor named in its environment.
+func (e *Emitter) Attach(cmd *exec.Cmd) {
+	if e == nil || e.file == nil {
+		return
+	}
+	cmd.ExtraFiles = append(cmd.ExtraFiles, e.file)
+	fd := 2 + len(cmd.ExtraFiles)
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env, fmt.Sprintf("%s=%d", EnvFD, fd))
+}
+
+var (
+	stdMu sync.Mutex
+	std   *Emitter
+)
+
+// SetDefault sets the Emitter used by the package-level functions.
+func SetDefault(e *Emitter) {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	std = e
+}
+
+// Default returns the Emitter used by the package-level functions, which
+// is nil unless the event stream is enabled.
+func Default() *Emitter {
+	stdMu.Lock()
+	defer stdMu.Unlock()
+	return std
+}
+
+// StartPhase emits the start of a phase on the default Emitter.
+func StartPhase(name string) *Phase {
+	return Default().Phase(name)
+}
+
+// Warning emits a warning on the default Emitter.
+func Warning(format string, args ...interface{}) {
+	Default().Warning(format, args...)
+}
+
+// ArtifactFile emits an artifact on the default Emitter.
+func ArtifactFile(path string) error {
+	return Default().ArtifactFile(path)
+}
+
+// Attach passes the default event stream to a child process.
+func Attach(cmd *exec.Cmd) {
+	Default().Attach(cmd)
+}
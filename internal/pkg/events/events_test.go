@@ -0,0 +1,109 @@
+package events
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var ret []Event
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		var ev Event
+		if err := json.Unmarshal(s.Bytes(), &ev); err != nil {
+			t.Fatalf("invalid event %q: %v", s.Text(), err)
+		}
+		ret = append(ret, ev)
+	}
+	return ret
+}
+
+func TestEmitter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.json")
+	e, err := Open(path, "build")
+	if err != nil {
+		t.Fatal(err)
+	}
+	artifact := filepath.Join(t.TempDir(), "fcos.qcow2")
+	if err := os.WriteFile(artifact, []byte("qcow2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	e.CommandStart([]string{"--strict"})
+	p := e.Phase("compose")
+	if err := e.ArtifactFile(artifact); err != nil {
+		t.Fatal(err)
+	}
+	e.Warning("%d packages downgraded", 2)
+	if err := p.End(errors.New("rpm-ostree failed")); err == nil {
+		t.Errorf("End should return the error it was given")
+	}
+
+	// a child process writes to the inherited stream
+	cmd := exec.Command("/bin/sh", "-c", `echo '{"type": "warning", "message": "from child"}' >&${COSA_EVENTS_FD}`)
+	e.Attach(cmd)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("child failed: %v: %s", err, out)
+	}
+	e.Status(nil)
+
+	got := readEvents(t, path)
+	types := []string{TypeCommandStart, TypePhaseStart, TypeArtifact, TypeWarning, TypePhaseEnd, TypeWarning, TypeStatus}
+	if len(got) != len(types) {
+		t.Fatalf("expected %d events, got %d: %+v", len(types), len(got), got)
+	}
+	for i, ty := range types {
+		if got[i].Type != ty {
+			t.Errorf("event %d: expected %s, got %s", i, ty, got[i].Type)
+		}
+	}
+	if got[0].Command != "build" || got[0].Pid != os.Getpid() || got[0].Message != `["--strict"]` {
+		t.Errorf("unexpected command-start: %+v", got[0])
+	}
+	if got[2].Size != 5 || got[2].Sha256 == "" || got[2].Path != artifact {
+		t.Errorf("unexpected artifact: %+v", got[2])
+	}
+	if got[4].Phase != "compose" || got[4].Status != StatusFailure || got[4].Message != "rpm-ostree failed" {
+		t.Errorf("unexpected phase-end: %+v", got[4])
+	}
+	if got[5].Message != "from child" {
+		t.Errorf("expected the child's event, got %+v", got[5])
+	}
+	if got[6].Status != StatusSuccess {
+		t.Errorf("unexpected status: %+v", got[6])
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	t.Setenv(EnvFD, "")
+	e, err := FromEnv("build")
+	if err != nil || e != nil {
+		t.Fatalf("events should be disabled: %v %v", e, err)
+	}
+	// none of these may panic
+	e.CommandStart(nil)
+	e.Phase("compose").End(nil)
+	e.Warning("ignored")
+	e.Status(nil)
+	cmd := exec.Command("true")
+	e.Attach(cmd)
+	if cmd.Env != nil || len(cmd.ExtraFiles) != 0 {
+		t.Errorf("a disabled stream should not be passed to children")
+	}
+
+	t.Setenv(EnvFD, "not-a-fd")
+	if _, err := FromEnv("build"); err == nil {
+		t.Errorf("expected an error for an invalid %s", EnvFD)
+	}
+}
@@ -0,0 +1,635 @@
+// Package remotesync copies file trees to and from a remote reachable only
+// through a command transport, such as `podman --remote exec`.
+//
+// Both trees are listed first and only the entries which changed are
+// sent, as a gzip-compressed tar stream. Files are unchanged if their
+// size, permissions and modification time (in whole seconds, as preserved
+// by tar) match; if only the modification time differs, the sha256 of
+// both copies decides. Directories are sent when missing or when their
+// permissions differ, so empty directories and directory modes are
+// preserved. Entries missing from the source are not deleted from the
+// destination.
+//
+// Paths follow rsync: a source with a trailing slash copies the contents
+// of the directory, and one without copies the directory itself into
+// the destination.
+package remotesync
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Exec returns a command running argv on the remote, with stdin, stdout
+// and stderr connected to the local process.
+type Exec func(argv ...string) *exec.Cmd
+
+// Options configure a sync.
+type Options struct {
+	// Excludes are patterns of paths to skip. Patterns without a slash
+	// match any path component, others match the path relative to the
+	// parent of the source. Excluding a directory skips its contents.
+	Excludes []string
+	// Progress, if set, is given the path of each file sent.
+	Progress io.Writer
+}
+
+// Stats summarize a sync.
+type Stats struct {
+	// Files and Bytes count the files sent and their size
+	Files int
+	Bytes int64
+	// Dirs counts the directories created or whose mode was updated
+	Dirs int
+	// WireBytes is the size of the tar stream actually sent
+	WireBytes int64
+	// Skipped and SkippedBytes count the unchanged files not sent
+	Skipped      int
+	SkippedBytes int64
+}
+
+func (s *Stats) add(o Stats) {
+	s.Files += o.Files
+	s.Bytes += o.Bytes
+	s.Dirs += o.Dirs
+	s.WireBytes += o.WireBytes
+	s.Skipped += o.Skipped
+	s.SkippedBytes += o.SkippedBytes
+}
+
+// entry is a file, directory or symlink in a tree.
+type entry struct {
+	Dir     bool
+	Symlink bool
+	Mode    fs.FileMode
+	Size    int64
+	ModTime int64
+	Target  string
+}
+
+// manifest maps paths relative to the parent of the source to entries
+type manifest map[string]entry
+
+// splitSource returns the directory a source is relative to and the name
+// of the tree under it, which is "." for the contents of a directory.
+func splitSource(src string) (string, string) {
+	if strings.HasSuffix(src, "/") {
+		return path.Clean(src), "."
+	}
+	src = path.Clean(src)
+	return path.Dir(src), path.Base(src)
+}
+
+// Push copies local sources to the directory dst on the remote.
+func Push(remote Exec, srcs []string, dst string, opts Options) (Stats, error) {
+	var total Stats
+	for _, src := range srcs {
+		base, name := splitSource(src)
+		local, err := localManifest(base, name, opts.Excludes)
+		if err != nil {
+			return total, err
+		}
+		other, err := remoteManifest(remote, dst, name)
+		if err != nil {
+			return total, err
+		}
+		send, stats, err := plan(local, other,
+			func(files []string) (map[string]string, error) { return localChecksums(base, files) },
+			func(files []string) (map[string]string, error) { return remoteChecksums(remote, dst, files) })
+		if err != nil {
+			return total, err
+		}
+		if len(send) > 0 {
+			wire, err := push(remote, base, dst, send, local, opts.Progress)
+			if err != nil {
+				return total, err
+			}
+			stats.WireBytes = wire
+		}
+		total.add(stats)
+	}
+	return total, nil
+}
+
+// Pull copies src on the remote to the local directory dst.
+func Pull(remote Exec, src, dst string, opts Options) (Stats, error) {
+	base, name := splitSource(src)
+	other, err := remoteManifest(remote, base, name)
+	if err != nil {
+		return Stats{}, err
+	}
+	for p := range other {
+		if isExcluded(p, opts.Excludes) {
+			delete(other, p)
+		}
+	}
+	local, err := localManifest(dst, name, nil)
+	if err != nil {
+		return Stats{}, err
+	}
+	send, stats, err := plan(other, local,
+		func(files []string) (map[string]string, error) { return remoteChecksums(remote, base, files) },
+		func(files []string) (map[string]string, error) { return localChecksums(dst, files) })
+	if err != nil {
+		return Stats{}, err
+	}
+	if len(send) > 0 {
+		wire, err := pull(remote, base, dst, send, opts.Progress)
+		if err != nil {
+			return stats, err
+		}
+		stats.WireBytes = wire
+	}
+	return stats, nil
+}
+
+// plan returns the paths of src which differ in dst, in order, so that
+// directories come before their contents. Files whose size matches but
+// modification time differs are compared by checksum.
+func plan(src, dst manifest, srcSums, dstSums func([]string) (map[string]string, error)) ([]string, Stats, error) {
+	var stats Stats
+	var send, unsure []string
+	for p, s := range src {
+		d, ok := dst[p]
+		switch {
+		case !ok || s.Dir != d.Dir || s.Symlink != d.Symlink || s.Mode != d.Mode || s.Target != d.Target:
+			send = append(send, p)
+		case s.Dir:
+			// the mtime of a directory changes with its contents
+		case s.Size != d.Size:
+			send = append(send, p)
+		case s.ModTime == d.ModTime || s.Symlink:
+			stats.Skipped++
+			stats.SkippedBytes += s.Size
+		default:
+			unsure = append(unsure, p)
+		}
+	}
+	if len(unsure) > 0 {
+		sort.Strings(unsure)
+		a, err := srcSums(unsure)
+		if err != nil {
+			return nil, stats, err
+		}
+		b, err := dstSums(unsure)
+		if err != nil {
+			return nil, stats, err
+		}
+		for _, p := range unsure {
+			if a[p] != "" && a[p] == b[p] {
+				stats.Skipped++
+				stats.SkippedBytes += src[p].Size
+			} else {
+				send = append(send, p)
+			}
+		}
+	}
+	sort.Strings(send)
+	for _, p := range send {
+		switch {
+		case src[p].Dir:
+			stats.Dirs++
+		case src[p].Symlink:
+			stats.Files++
+		default:
+			stats.Files++
+			stats.Bytes += src[p].Size
+		}
+	}
+	return send, stats, nil
+}
+
+// isExcluded reports whether rel or one of its parent directories
+// matches a pattern.
+func isExcluded(rel string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return false
+	}
+	parts := strings.Split(rel, "/")
+	for i := range parts {
+		prefix := strings.Join(parts[:i+1], "/")
+		for _, pat := range patterns {
+			var ok bool
+			if strings.Contains(pat, "/") {
+				ok, _ = path.Match(strings.Trim(pat, "/"), prefix)
+			} else {
+				ok, _ = path.Match(pat, parts[i])
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// localManifest lists the files, directories and symlinks of the tree name
+// under base. The root of the tree is only listed if it is named, as the
+// destination itself is not synced.
+func localManifest(base, name string, excludes []string) (manifest, error) {
+	m := make(manifest)
+	root := filepath.Join(base, name)
+	if _, err := os.Lstat(root); os.IsNotExist(err) {
+		return m, nil
+	}
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(base, p)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		if rel != "." && isExcluded(rel, excludes) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if rel == "." || !(d.IsDir() || d.Type().IsRegular() || d.Type()&fs.ModeSymlink != 0) {
+			return nil
+		}
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+		e := entry{Mode: fi.Mode().Perm(), ModTime: fi.ModTime().Unix()}
+		switch {
+		case d.IsDir():
+			e.Dir = true
+		case d.Type().IsRegular():
+			e.Size = fi.Size()
+		default:
+			e.Symlink = true
+			if e.Target, err = os.Readlink(p); err != nil {
+				return err
+			}
+		}
+		m[rel] = e
+		return nil
+	})
+	return m, err
+}
+
+// findScript lists the tree $2 under $1 as NUL-terminated records of
+// type, size, mtime, mode, symlink target and path.
+const findScript = `cd "$1" 2>/dev/null || exit 0
+[ -e "$2" ] || [ -L "$2" ] || exit 0
+exec find "$2" \( -type f -o -type d -o -type l \) -printf '%y\t%s\t%T@\t%m\t%l\t%p\0'`
+
+// remoteManifest lists the files, directories and symlinks of the tree
+// name under base on the remote.
+func remoteManifest(remote Exec, base, name string) (manifest, error) {
+	cmd := remote("sh", "-c", findScript, "sh", base, name)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("listing %s on the remote: %w", path.Join(base, name), err)
+	}
+	return parseFindOutput(out)
+}
+
+func parseFindOutput(out []byte) (manifest, error) {
+	m := make(manifest)
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		if len(rec) == 0 {
+			continue
+		}
+		fields := strings.SplitN(string(rec), "\t", 6)
+		if len(fields) != 6 {
+			return nil, fmt.Errorf("invalid find output %q", rec)
+		}
+		name := strings.TrimPrefix(fields[5], "./")
+		if name == "." {
+			continue
+		}
+		size, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid size in find output %q", rec)
+		}
+		mtime, err := strconv.ParseFloat(fields[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mtime in find output %q", rec)
+		}
+		mode, err := strconv.ParseUint(fields[3], 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode in find output %q", rec)
+		}
+		e := entry{Mode: fs.FileMode(mode).Perm(), ModTime: int64(mtime)}
+		switch fields[0] {
+		case "d":
+			e.Dir = true
+		case "l":
+			e.Symlink = true
+			e.Target = fields[4]
+		default:
+			e.Size = size
+		}
+		m[name] = e
+	}
+	return m, nil
+}
+
+func localChecksums(base string, files []string) (map[string]string, error) {
+	ret := make(map[string]string, len(files))
+	for _, f := range files {
+		fh, err := os.Open(filepath.Join(base, f))
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, fh)
+		fh.Close()
+		if err != nil {
+			return nil, err
+		}
+		ret[f] = hex.EncodeToString(h.Sum(nil))
+	}
+	return ret, nil
+}
+
+// remoteChecksums hashes files under base on the remote. Missing files
+// are left out of the result.
+func remoteChecksums(remote Exec, base string, files []string) (map[string]string, error) {
+	cmd := remote("sh", "-c", `cd "$1" && exec xargs -0 -r sha256sum -z --`, "sh", base)
+	cmd.Stdin = nulList(files)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("hashing files on the remote: %w", err)
+	}
+	ret := make(map[string]string, len(files))
+	for _, rec := range bytes.Split(out, []byte{0}) {
+		sum, name, ok := strings.Cut(string(rec), "  ")
+		if ok {
+			ret[name] = sum
+		}
+	}
+	return ret, nil
+}
+
+func nulList(files []string) io.Reader {
+	var b bytes.Buffer
+	for _, f := range files {
+		b.WriteString(f)
+		b.WriteByte(0)
+	}
+	return &b
+}
+
+// countingWriter counts the bytes written through it
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// push sends files under base as a compressed tar stream extracted in dst
+// on the remote, returning the size of the stream.
+func push(remote Exec, base, dst string, files []string, m manifest, progress io.Writer) (int64, error) {
+	cmd := remote("sh", "-c", `mkdir -p "$1" && exec tar -x -z -p -f - -C "$1"`, "sh", dst)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	cw := &countingWriter{w: stdin}
+	gz, err := gzip.NewWriterLevel(cw, gzip.BestSpeed)
+	if err != nil {
+		return 0, err
+	}
+	werr := writeTar(gz, base, files, m, progress)
+	if err := gz.Close(); werr == nil {
+		werr = err
+	}
+	if err := stdin.Close(); werr == nil {
+		werr = err
+	}
+	if err := cmd.Wait(); err != nil {
+		return cw.n, fmt.Errorf("extracting on the remote: %w", err)
+	}
+	return cw.n, werr
+}
+
+func writeTar(w io.Writer, base string, files []string, m manifest, progress io.Writer) error {
+	tw := tar.NewWriter(w)
+	for _, f := range files {
+		p := filepath.Join(base, f)
+		fi, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(fi, m[f].Target)
+		if err != nil {
+			return err
+		}
+		hdr.Name = f
+		if fi.IsDir() {
+			hdr.Name += "/"
+		}
+		hdr.ModTime = fi.ModTime().Truncate(time.Second)
+		hdr.Uname, hdr.Gname = "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if progress != nil {
+			fmt.Fprintln(progress, f)
+		}
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		fh, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, fh)
+		fh.Close()
+		if err != nil {
+			return fmt.Errorf("sending %s: %w", f, err)
+		}
+	}
+	return tw.Close()
+}
+
+// pull receives files under base on the remote as a compressed tar stream
+// extracted in dst, returning the size of the stream.
+func pull(remote Exec, base, dst string, files []string, progress io.Writer) (int64, error) {
+	cmd := remote("sh", "-c", `cd "$1" && exec tar -c -z -f - --no-recursion --null -T -`, "sh", base)
+	cmd.Stdin = nulList(files)
+	cmd.Stderr = os.Stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return 0, err
+	}
+	cr := &countingReader{r: stdout}
+	gz, rerr := gzip.NewReader(bufio.NewReader(cr))
+	if rerr == nil {
+		rerr = readTar(gz, dst, progress)
+		// drain the trailing padding so tar doesn't get SIGPIPE
+		_, _ = io.Copy(io.Discard, gz)
+	}
+	_, _ = io.Copy(io.Discard, cr)
+	if err := cmd.Wait(); err != nil && rerr == nil {
+		rerr = fmt.Errorf("archiving on the remote: %w", err)
+	}
+	return cr.n, rerr
+}
+
+// readTar extracts a tar stream in dst, preserving modification times and
+// modes. Directory modes are applied last, so that a read-only directory
+// can still be filled. Entries are never written through symlinks, and
+// symlinks may only point inside dst, so a stream can't write outside of
+// it.
+func readTar(r io.Reader, dst string, progress io.Writer) error {
+	tr := tar.NewReader(r)
+	var dirs []string
+	var modes []fs.FileMode
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if path.IsAbs(name) || name == ".." || strings.HasPrefix(name, "../") {
+			return fmt.Errorf("refusing to extract %q outside of %s", hdr.Name, dst)
+		}
+		p := filepath.Join(dst, filepath.FromSlash(name))
+		if err := mkdirInside(dst, path.Dir(name)); err != nil {
+			return err
+		}
+		if progress != nil {
+			fmt.Fprintln(progress, name)
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			// a directory replaces a symlink, rather than going through it
+			if fi, err := os.Lstat(p); err == nil && fi.Mode()&fs.ModeSymlink != 0 {
+				if err := os.Remove(p); err != nil {
+					return err
+				}
+			}
+			if err := mkdirInside(dst, name); err != nil {
+				return err
+			}
+			dirs = append(dirs, p)
+			modes = append(modes, hdr.FileInfo().Mode().Perm())
+		case tar.TypeSymlink:
+			target := path.Join(path.Dir(name), hdr.Linkname)
+			if path.IsAbs(hdr.Linkname) || target == ".." || strings.HasPrefix(target, "../") {
+				return fmt.Errorf("refusing to extract symlink %q to %q outside of %s", hdr.Name, hdr.Linkname, dst)
+			}
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			if err := os.Symlink(hdr.Linkname, p); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			// write to a temporary file so an interrupted sync never
+			// leaves a truncated file with a current mtime
+			tmp := p + ".remotesync-partial"
+			if err := os.RemoveAll(tmp); err != nil {
+				return err
+			}
+			// O_EXCL doesn't follow a symlink created meanwhile
+			f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, hdr.FileInfo().Mode().Perm())
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, tr)
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			// the umask applies to new files
+			if err == nil {
+				err = os.Chmod(tmp, hdr.FileInfo().Mode().Perm())
+			}
+			if err == nil {
+				err = os.Chtimes(tmp, hdr.ModTime, hdr.ModTime)
+			}
+			if err == nil {
+				err = os.Rename(tmp, p)
+			}
+			if err != nil {
+				os.Remove(tmp)
+				return fmt.Errorf("receiving %s: %w", name, err)
+			}
+		}
+	}
+	for i := len(dirs) - 1; i >= 0; i-- {
+		if err := os.Chmod(dirs[i], modes[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mkdirInside creates the directory name, a clean slash-separated path
+// relative to dst, and its parents. It refuses to go through symlinks,
+// which could lead outside of dst.
+func mkdirInside(dst, name string) error {
+	if name == "." {
+		return nil
+	}
+	p := dst
+	for _, c := range strings.Split(name, "/") {
+		p = filepath.Join(p, c)
+		fi, err := os.Lstat(p)
+		switch {
+		case os.IsNotExist(err):
+			if err := os.Mkdir(p, 0755); err != nil {
+				return err
+			}
+		case err != nil:
+			return err
+		case fi.Mode()&fs.ModeSymlink != 0:
+			return fmt.Errorf("refusing to extract through symlink %s", p)
+		case !fi.IsDir():
+			return fmt.Errorf("%s is not a directory", p)
+		}
+	}
+	return nil
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,330 @@
+package remotesync
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// local runs the "remote" commands on the local host
+func local(argv ...string) *exec.Cmd {
+	return exec.Command(argv[0], argv[1:]...)
+}
+
+func writeTree(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		p := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func readFile(t *testing.T, p string) string {
+	t.Helper()
+	b, err := os.ReadFile(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func checkStats(t *testing.T, what string, s Stats, files, skipped int) {
+	t.Helper()
+	if s.Files != files || s.Skipped != skipped {
+		t.Errorf("%s: expected %d files sent and %d skipped, got %+v", what, files, skipped, s)
+	}
+	if files > 0 && s.WireBytes == 0 {
+		t.Errorf("%s: nothing was sent: %+v", what, s)
+	}
+	if files == 0 && s.WireBytes != 0 {
+		t.Errorf("%s: nothing should have been sent: %+v", what, s)
+	}
+}
+
+func checkMode(t *testing.T, p string, expected fs.FileMode) {
+	t.Helper()
+	fi, err := os.Lstat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.Mode().Perm() != expected {
+		t.Errorf("%s: expected mode %o, got %o", p, expected, fi.Mode().Perm())
+	}
+}
+
+func TestPush(t *testing.T) {
+	for _, tool := range []string{"find", "tar", "sha256sum", "xargs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	src := t.TempDir()
+	remote := filepath.Join(t.TempDir(), "srv", "builds")
+	writeTree(t, src, map[string]string{
+		"41.1/x86_64/meta.json":  `{"buildid": "41.1"}`,
+		"41.1/x86_64/disk.qcow2": "qcow2",
+		"41.2/x86_64/meta.json":  `{"buildid": "41.2"}`,
+		"41.2/x86_64/cache.tmp":  "scratch",
+		"41.2/x86_64/ostree.log": strings.Repeat("compressible ", 10000),
+	})
+	if err := os.Symlink("41.2", filepath.Join(src, "latest")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(src, "tmp"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(src, "41.1/x86_64/disk.qcow2"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	opts := Options{Excludes: []string{"*.tmp"}}
+
+	s, err := Push(local, []string{src + "/"}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, "first push", s, 5, 0)
+	if s.Dirs != 5 {
+		t.Errorf("expected 5 directories to be sent, got %d", s.Dirs)
+	}
+	if s.WireBytes >= s.Bytes {
+		t.Errorf("the tar stream should be compressed: %+v", s)
+	}
+	if readFile(t, filepath.Join(remote, "41.1/x86_64/disk.qcow2")) != "qcow2" {
+		t.Errorf("disk.qcow2 not pushed")
+	}
+	checkMode(t, filepath.Join(remote, "41.1/x86_64/disk.qcow2"), 0600)
+	checkMode(t, filepath.Join(remote, "tmp"), 0700)
+	if target, err := os.Readlink(filepath.Join(remote, "latest")); err != nil || target != "41.2" {
+		t.Errorf("symlink not pushed: %s %v", target, err)
+	}
+	if _, err := os.Stat(filepath.Join(remote, "41.2/x86_64/cache.tmp")); !os.IsNotExist(err) {
+		t.Errorf("excluded file was pushed")
+	}
+
+	s, err = Push(local, []string{src + "/"}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, "second push", s, 0, 5)
+	if s.Dirs != 0 {
+		t.Errorf("unchanged directories should not be sent, got %d", s.Dirs)
+	}
+
+	// a mode change alone is sent
+	if err := os.Chmod(filepath.Join(src, "tmp"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Push(local, []string{src + "/"}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.Dirs != 1 {
+		t.Errorf("expected the directory mode to be updated, got %+v", s)
+	}
+	checkMode(t, filepath.Join(remote, "tmp"), 0755)
+
+	// a touched file is compared by checksum, a modified one is sent
+	future := time.Now().Add(time.Hour)
+	if err := os.Chtimes(filepath.Join(src, "41.1/x86_64/meta.json"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, src, map[string]string{"41.1/x86_64/disk.qcow2": "QCOW2"})
+	if err := os.Chtimes(filepath.Join(src, "41.1/x86_64/disk.qcow2"), future, future); err != nil {
+		t.Fatal(err)
+	}
+	s, err = Push(local, []string{src + "/"}, remote, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, "third push", s, 1, 4)
+	if readFile(t, filepath.Join(remote, "41.1/x86_64/disk.qcow2")) != "QCOW2" {
+		t.Errorf("modified disk.qcow2 not pushed")
+	}
+}
+
+func TestPull(t *testing.T) {
+	for _, tool := range []string{"find", "tar", "sha256sum", "xargs"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s not available", tool)
+		}
+	}
+	remote := t.TempDir()
+	dst := t.TempDir()
+	writeTree(t, remote, map[string]string{
+		"builds/41.1/x86_64/meta.json":  `{"buildid": "41.1"}`,
+		"builds/41.2/x86_64/meta.json":  `{"buildid": "41.2"}`,
+		"builds/41.2/x86_64/disk.qcow2": "qcow2",
+	})
+	if err := os.Mkdir(filepath.Join(remote, "builds/41.2/x86_64/empty"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	// only the new build should be pulled
+	writeTree(t, dst, map[string]string{
+		"builds/41.1/x86_64/meta.json": `{"buildid": "41.1"}`,
+	})
+	stamp := time.Unix(1700000000, 0)
+	for _, dir := range []string{remote, dst} {
+		if err := os.Chtimes(filepath.Join(dir, "builds/41.1/x86_64/meta.json"), stamp, stamp); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	s, err := Pull(local, filepath.Join(remote, "builds"), dst, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, "first pull", s, 2, 1)
+	p := filepath.Join(dst, "builds/41.2/x86_64/disk.qcow2")
+	if readFile(t, p) != "qcow2" {
+		t.Errorf("disk.qcow2 not pulled")
+	}
+	fi, err := os.Stat(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rfi, err := os.Stat(filepath.Join(remote, "builds/41.2/x86_64/disk.qcow2"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fi.ModTime().Unix() != rfi.ModTime().Unix() {
+		t.Errorf("mtime not preserved: %s != %s", fi.ModTime(), rfi.ModTime())
+	}
+	checkMode(t, filepath.Join(dst, "builds/41.2/x86_64/empty"), 0700)
+
+	s, err = Pull(local, filepath.Join(remote, "builds"), dst, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkStats(t, "second pull", s, 0, 3)
+}
+
+// tarEntry is a tar header and, for regular files, their content
+type tarEntry struct {
+	hdr     tar.Header
+	content string
+}
+
+func tarStream(t *testing.T, entries []tarEntry) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, e := range entries {
+		hdr := e.hdr
+		hdr.Size = int64(len(e.content))
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(e.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return &buf
+}
+
+func TestReadTarEscapes(t *testing.T) {
+	passwd := tarEntry{tar.Header{Name: "a/passwd", Typeflag: tar.TypeReg, Mode: 0644}, "root::0:0"}
+	for _, tt := range []struct {
+		name    string
+		planted string // if set, dst/a is a symlink to it beforehand
+		entries []tarEntry
+		fail    bool
+	}{
+		{
+			name: "absolute symlink",
+			entries: []tarEntry{
+				{tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "OUTSIDE"}, ""},
+				passwd,
+			},
+			fail: true,
+		},
+		{
+			name: "escaping symlink",
+			entries: []tarEntry{
+				{tar.Header{Name: "lib/a", Typeflag: tar.TypeSymlink, Linkname: "../../outside"}, ""},
+			},
+			fail: true,
+		},
+		{
+			name: "file through a symlink of the stream",
+			entries: []tarEntry{
+				{tar.Header{Name: "b", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+				{tar.Header{Name: "a", Typeflag: tar.TypeSymlink, Linkname: "b"}, ""},
+				passwd,
+			},
+			fail: true,
+		},
+		{
+			name:    "file through a symlink already there",
+			planted: "OUTSIDE",
+			entries: []tarEntry{passwd},
+			fail:    true,
+		},
+		{
+			name:    "directory replacing a symlink",
+			planted: "OUTSIDE",
+			entries: []tarEntry{
+				{tar.Header{Name: "a", Typeflag: tar.TypeDir, Mode: 0755}, ""},
+				passwd,
+			},
+		},
+		{
+			name: "symlink inside",
+			entries: []tarEntry{
+				{tar.Header{Name: "lib/a", Typeflag: tar.TypeSymlink, Linkname: "../data"}, ""},
+			},
+		},
+	} {
+		dst := t.TempDir()
+		outside := t.TempDir()
+		entries := make([]tarEntry, 0, len(tt.entries))
+		for _, e := range tt.entries {
+			if e.hdr.Linkname == "OUTSIDE" {
+				e.hdr.Linkname = outside
+			}
+			entries = append(entries, e)
+		}
+		if tt.planted != "" {
+			if err := os.Symlink(outside, filepath.Join(dst, "a")); err != nil {
+				t.Fatal(err)
+			}
+		}
+		err := readTar(tarStream(t, entries), dst, nil)
+		if tt.fail && err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+		} else if !tt.fail && err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+		}
+		if _, err := os.Lstat(filepath.Join(outside, "passwd")); err == nil {
+			t.Errorf("%s: file written outside of the destination", tt.name)
+		}
+	}
+}
+
+func TestIsExcluded(t *testing.T) {
+	for rel, expected := range map[string]bool{
+		"builds/41.1/x86_64/disk.qcow2": true,
+		"builds/41.1/x86_64/meta.json":  false,
+		"builds/41.2/x86_64/meta.json":  true,
+		"cache/foo":                     true,
+		"src/cache":                     true,
+		"src/config/cache.yaml":         false,
+	} {
+		if isExcluded(rel, []string{"*.qcow2", "builds/41.2", "cache"}) != expected {
+			t.Errorf("%s: expected excluded=%v", rel, expected)
+		}
+	}
+}
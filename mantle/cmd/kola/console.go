@@ -36,6 +36,9 @@ Check console output for expressions matching failure messages logged
 by a Container Linux instance.
 
 If no files are specified as arguments, stdin is checked.
+
+The checks can be changed by src/config/kola-console-checks.yaml in the
+coreos-assembler working directory, as for kola run.
 `,
 
 		SilenceUsage: true,
@@ -50,6 +53,9 @@ func init() {
 }
 
 func runCheckConsole(cmd *cobra.Command, args []string) error {
+	if err := kola.LoadConsoleChecks(kolaPlatform); err != nil {
+		return err
+	}
 	if len(args) == 0 {
 		// default to stdin
 		args = append(args, "-")
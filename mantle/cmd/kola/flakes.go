@@ -0,0 +1,113 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+)
+
+var (
+	cmdFlakes = &cobra.Command{
+		Use:   "flakes",
+		Short: "Report flaky tests",
+		Long: `Report the tests which failed in previous runs, from the flake ledger
+kola run keeps in --flake-ledger.
+
+Tests which failed then passed when rerun are likely flaky; quarantine
+them in kola-denylist.yaml with e.g. 'quarantine: 5' to run them without
+failing the job until they pass 5 times in a row.
+`,
+		RunE: runFlakes,
+
+		SilenceUsage: true,
+	}
+
+	flakesJSON bool
+	flakesAll  bool
+)
+
+func init() {
+	root.AddCommand(cmdFlakes)
+	cmdFlakes.Flags().BoolVar(&flakesJSON, "json", false, "format output in JSON")
+	cmdFlakes.Flags().BoolVar(&flakesAll, "all", false, "include tests which never failed")
+}
+
+type flakeItem struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	kola.FlakeRecord
+}
+
+func runFlakes(cmd *cobra.Command, args []string) error {
+	path := kola.FlakeLedgerFile
+	if path == "" {
+		path = cosaCachePath("kola-flakes.json")
+	}
+	if path == "" {
+		return fmt.Errorf("no flake ledger; specify --flake-ledger or run from a cosa workdir")
+	}
+	ledger, err := kola.LoadFlakeLedger(path)
+	if err != nil {
+		return err
+	}
+
+	var items []flakeItem
+	for platform, records := range ledger.Platforms {
+		if kolaPlatform != "" && platform != kolaPlatform {
+			continue
+		}
+		for name, r := range records {
+			if r.Failures > 0 || flakesAll {
+				items = append(items, flakeItem{name, platform, *r})
+			}
+		}
+	}
+	// most failures first
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].Failures != items[j].Failures {
+			return items[i].Failures > items[j].Failures
+		}
+		if items[i].Name != items[j].Name {
+			return items[i].Name < items[j].Name
+		}
+		return items[i].Platform < items[j].Platform
+	})
+
+	if flakesJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(items)
+	}
+	if len(items) == 0 {
+		fmt.Println("No test failures recorded")
+		return nil
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "TEST\tPLATFORM\tRUNS\tFAILURES\tRERUN PASSES\tFAIL RATE\tPASS STREAK\tLAST RUN")
+	for _, item := range items {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%d\t%.0f%%\t%d\t%s\n", item.Name, item.Platform, item.Runs,
+			item.Failures, item.RerunPasses, 100*float64(item.Failures)/float64(item.Runs),
+			item.ConsecutivePasses, item.LastRun.Format("2006-01-02"))
+	}
+	return w.Flush()
+}
@@ -127,7 +127,7 @@ This can be useful for e.g. serving locally built OSTree repos to qemu.
 	runRerunFlag      bool
 	allowRerunSuccess string
 
-	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]$`)
+	wrapperMatch = regexp.MustCompile(`^(non-exclusive-test-bucket-[0-9]|fixture-[^/]+)$`)
 )
 
 func init() {
@@ -218,7 +218,7 @@ func runRerun(cmd *cobra.Command, args []string) error {
 		return err
 	}
 	for _, test := range data.Tests {
-		if nonexclusiveWrapperMatch.MatchString(test.Name) {
+		if wrapperMatch.MatchString(test.Name) {
 			// When the test hasn't started yet, we get the subtests
 			// of the test(nonExclusiveWrapper) for re-running
 			for _, subtest := range test.Subtests {
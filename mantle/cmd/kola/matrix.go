@@ -0,0 +1,82 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/kola"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+var (
+	cmdMatrix = &cobra.Command{
+		Use:   "matrix REPORT...",
+		Short: "Report which tests ran on which platforms",
+		Long: `Merge the report.json files of kola runs on different platforms,
+architectures and streams into a matrix of the registered tests by
+platform, architecture and firmware, to find coverage gaps.
+
+Each cell is passed, failed, skipped, denylisted, never-run, or n/a for
+tests which don't apply to the configuration.
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runMatrix,
+
+		SilenceUsage: true,
+	}
+
+	matrixFormat string
+)
+
+func init() {
+	root.AddCommand(cmdMatrix)
+	cmdMatrix.Flags().StringArrayVarP(&runExternals, "exttest", "E", nil, "Externally defined tests in directory")
+	cmdMatrix.Flags().StringVar(&matrixFormat, "format", "markdown", "output format: markdown, html or json")
+}
+
+func runMatrix(cmd *cobra.Command, args []string) error {
+	if err := registerExternals(); err != nil {
+		return err
+	}
+	tests := make(map[string]*register.Test)
+	for name, t := range register.Tests {
+		tests[name] = t
+	}
+	for name, t := range register.UpgradeTests {
+		tests[name] = t
+	}
+
+	matrix, err := kola.BuildMatrix(tests, args)
+	if err != nil {
+		return err
+	}
+	switch matrixFormat {
+	case "markdown":
+		return matrix.WriteMarkdown(os.Stdout)
+	case "html":
+		return matrix.WriteHTML(os.Stdout)
+	case "json":
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(matrix)
+	default:
+		return fmt.Errorf("unsupported format %q", matrixFormat)
+	}
+}
@@ -40,7 +40,7 @@ var (
 	kolaPlatform      string
 	kolaParallelArg   string
 	kolaArchitectures = []string{"amd64"}
-	kolaPlatforms     = []string{"aws", "azure", "do", "esx", "gcp", "openstack", "qemu", "qemu-iso"}
+	kolaPlatforms     = []string{"aws", "azure", "do", "esx", "gcp", "openstack", "qemu", "qemu-iso", "replay"}
 	kolaDistros       = []string{"fcos", "rhcos", "scos"}
 )
 
@@ -54,8 +54,12 @@ func init() {
 	sv(&outputDir, "output-dir", "", "Temporary output directory for test data and logs")
 	root.PersistentFlags().StringVarP(&kolaPlatform, "platform", "p", "", "VM platform: "+strings.Join(kolaPlatforms, ", "))
 	root.PersistentFlags().StringVarP(&kola.Options.Distribution, "distro", "b", "", "Distribution: "+strings.Join(kolaDistros, ", "))
-	root.PersistentFlags().StringVarP(&kolaParallelArg, "parallel", "j", "1", "number of tests to run in parallel, or \"auto\" to match CPU count")
+	root.PersistentFlags().StringVarP(&kolaParallelArg, "parallel", "j", "1", "number of tests to run in parallel, \"auto\" to match CPU count, or \"resources\" to start qemu tests as host memory, CPUs and disk space allow")
+	sv(&kola.ResourceBudget, "resource-budget", "", "Host resources for --parallel=resources instead of the detected ones, e.g. memory=48G,cpus=16,disk=200G")
 	sv(&kola.TAPFile, "tapfile", "", "file to write TAP results to")
+	sv(&kola.JUnitFile, "junit", "", "file to write JUnit XML results to")
+	sv(&kola.StatusAddr, "status-addr", "", "Serve the progress of the run over HTTP on this address, e.g. 'localhost:8080'")
+	bv(&kola.RecordSSH, "record-ssh", false, "Record the SSH commands of each test and their output, for --platform=replay")
 	root.PersistentFlags().BoolVarP(&kola.Options.UseWarnExitCode77, "on-warn-failure-exit-77", "", false, "Exit with code 77 if 'warn: true' tests fail")
 	sv(&kola.Options.BaseName, "basename", "kola", "Cluster name prefix")
 	ss("debug-systemd-unit", []string{}, "full-unit-name.service to enable SYSTEMD_LOG_LEVEL=debug on. Can be specified multiple times.")
@@ -64,7 +68,9 @@ func init() {
 	bv(&kola.NoNet, "no-net", false, "Don't run tests that require an Internet connection")
 	bv(&kola.ForceRunPlatformIndependent, "run-platform-independent", false, "Run tests that claim platform independence")
 	ssv(&kola.Tags, "tag", []string{}, "Test tag to run. Can be specified multiple times.")
-	sv(&kola.Sharding, "sharding", "", "Provide e.g. 'hash:m/n' where m and n are integers, 1 <= m <= n.  Only tests hashing to m will be run.")
+	sv(&kola.Sharding, "sharding", "", "Provide e.g. 'hash:m/n' where m and n are integers, 1 <= m <= n.  Only tests hashing to m will be run. With 'duration:m/n', shards are balanced by --duration-history, which must be given.")
+	sv(&kola.DurationHistory, "duration-history", "", "File recording test durations, to start the slowest tests first (default: cache/kola-durations.json in the cosa workdir)")
+	sv(&kola.FlakeLedgerFile, "flake-ledger", "", "File counting test results across runs, for quarantines and 'kola flakes' (default: cache/kola-flakes.json in the cosa workdir)")
 	bv(&kola.Options.SSHOnTestFailure, "ssh-on-test-failure", false, "SSH into a machine when tests fail")
 	sv(&kola.Options.Stream, "stream", "", "CoreOS stream ID (e.g. for Fedora CoreOS: stable, testing, next)")
 	sv(&kola.Options.CosaWorkdir, "workdir", "", "coreos-assembler working directory")
@@ -153,9 +159,15 @@ func init() {
 	bv(&kola.QEMUOptions.Nvme, "qemu-nvme", false, "Use NVMe for main disk")
 	bv(&kola.QEMUOptions.Swtpm, "qemu-swtpm", true, "Create temporary software TPM")
 	ssv(&kola.QEMUOptions.BindRO, "qemu-bind-ro", nil, "Inject a host directory; this does not automatically mount in the guest")
+	bv(&kola.QEMUOptions.CollectCrashDumps, "collect-crash-dumps", false, "Dump the memory of qemu machines on kernel panics, and save their /var/crash if kdump is configured")
+	bv(&kola.QEMUOptions.PrivateNetwork, "qemu-private-network", false, "Connect the qemu machines of each cluster to a bridge so they can reach each other at their private IPs (requires root)")
+	sv(&kola.QEMUOptions.UsermodeIPFamily, "qemu-usernet-family", "", "IP family of usermode networking: ipv4, ipv6, dual (default qemu's, IPv4 with IPv6 on the side)")
+	bv(&kola.QEMUOptions.Screenshots, "qemu-screenshots", false, "Save the screen of qemu machines as PNGs in their output directory whenever it changes")
+	sv(&kola.QEMUOptions.UsermodeIPv6Net, "qemu-usernet-ipv6-addr", "", "Guest IPv6 network of usermode networking (QEMU default is 'fec0::/64')")
 
 	sv(&kola.QEMUIsoOptions.IsoPath, "qemu-iso", "", "path to CoreOS ISO image")
 	bv(&kola.QEMUIsoOptions.AsDisk, "qemu-iso-as-disk", false, "attach ISO image as regular disk")
+	sv(&kola.ReplayOptions.Dir, "replay-dir", "", "Output directory of a run with --record-ssh, to replay with --platform=replay")
 	// s390x secex specific options
 	bv(&kola.QEMUOptions.SecureExecution, "qemu-secex", false, "Run IBM Secure Execution Image")
 	sv(&kola.QEMUOptions.SecureExecutionIgnitionPubKey, "qemu-secex-ignition-pubkey", "", "Path to Ignition GPG Public Key")
@@ -193,12 +205,14 @@ func syncOptionsImpl(useCosa bool) error {
 	}
 
 	// test parallelism
-	if kolaParallelArg == "auto" {
+	if kolaParallelArg == "auto" || kolaParallelArg == "resources" {
 		ncpu, err := system.GetProcessors()
 		if err != nil {
 			return fmt.Errorf("detecting CPU count: %w", err)
 		}
 		kola.TestParallelism = int(ncpu)
+		// on other platforms, tests are still limited by the CPU count
+		kola.ResourceScheduling = kolaParallelArg == "resources"
 	} else {
 		parallel, err := strconv.ParseInt(kolaParallelArg, 10, 32)
 		if err != nil {
@@ -207,6 +221,10 @@ func syncOptionsImpl(useCosa bool) error {
 		kola.TestParallelism = int(parallel)
 	}
 
+	if kola.ResourceBudget != "" && !kola.ResourceScheduling {
+		return fmt.Errorf("--resource-budget requires --parallel=resources")
+	}
+
 	// native 4k requires a UEFI bootloader
 	if kola.QEMUOptions.Native4k && kola.QEMUOptions.Firmware == "bios" {
 		return fmt.Errorf("native 4k requires uefi firmware")
@@ -224,6 +242,13 @@ func syncOptionsImpl(useCosa bool) error {
 		return err
 	}
 
+	if kola.QEMUOptions.UsermodeIPFamily != "" {
+		families := []string{platform.IPFamilyIPv4, platform.IPFamilyIPv6, platform.IPFamilyDualStack}
+		if err := validateOption("usermode IP family", kola.QEMUOptions.UsermodeIPFamily, families); err != nil {
+			return err
+		}
+	}
+
 	// Choose an appropriate AWS instance type for the target architecture
 	if kolaPlatform == "aws" && kola.AWSOptions.InstanceType == "" {
 		switch kola.Options.CosaBuildArch {
@@ -355,6 +380,18 @@ func syncOptionsImpl(useCosa bool) error {
 			return err
 		}
 	}
+
+	// shards on other hosts would default to other histories, and split
+	// the tests differently
+	if strings.HasPrefix(kola.Sharding, "duration:") && kola.DurationHistory == "" {
+		return fmt.Errorf("--sharding=duration:m/n requires an explicit --duration-history")
+	}
+	if kola.DurationHistory == "" && foundCosa {
+		kola.DurationHistory = cosaCachePath("kola-durations.json")
+	}
+	if kola.FlakeLedgerFile == "" && foundCosa {
+		kola.FlakeLedgerFile = cosaCachePath("kola-flakes.json")
+	}
 	// Currently the `--arch` option is defined in terms of coreos-assembler, but
 	// we also unconditionally use it for qemu if present.
 	kola.QEMUOptions.Arch = kola.Options.CosaBuildArch
@@ -489,3 +526,17 @@ func syncStreamOptions() error {
 
 	return nil
 }
+
+// cosaCachePath returns the path of a file in the cache directory of the
+// cosa workdir, or "" if there is no such directory.
+func cosaCachePath(name string) string {
+	workdir := kola.Options.CosaWorkdir
+	if workdir == "" {
+		workdir = "."
+	}
+	cacheDir := filepath.Join(workdir, "cache")
+	if _, err := os.Stat(cacheDir); err != nil {
+		return ""
+	}
+	return filepath.Join(cacheDir, name)
+}
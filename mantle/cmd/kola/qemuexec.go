@@ -371,6 +371,8 @@ func runQemuExec(cmd *cobra.Command, args []string) error {
 		}
 		builder.EnableUsermodeNetworking(h, usernetAddr)
 	}
+	builder.UsermodeIPFamily = kola.QEMUOptions.UsermodeIPFamily
+	builder.UsermodeIPv6Net = kola.QEMUOptions.UsermodeIPv6Net
 	if netboot != "" {
 		builder.SetNetbootP(netboot, netbootDir)
 	}
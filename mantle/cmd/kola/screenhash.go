@@ -0,0 +1,71 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+var (
+	cmdScreenHash = &cobra.Command{
+		Use:   "screenhash PNG...",
+		Short: "Print the hashes of screenshots",
+		Long: `Print the perceptual hashes of PNG screenshots, e.g. ones saved with
+--qemu-screenshots, to recognize their screens in tests with
+WaitForScreen().
+
+With --ref, also print how many bits each hash is away from a reference
+hash, to pick the maximum distance of a screen: mostly dark text screens
+differ in few bits.
+`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: runScreenHash,
+
+		SilenceUsage: true,
+	}
+
+	screenHashRef string
+)
+
+func init() {
+	root.AddCommand(cmdScreenHash)
+	cmdScreenHash.Flags().StringVar(&screenHashRef, "ref", "", "reference hash to compare to")
+}
+
+func runScreenHash(cmd *cobra.Command, args []string) error {
+	var ref platform.ScreenHash
+	if screenHashRef != "" {
+		var err error
+		if ref, err = platform.ParseScreenHash(screenHashRef); err != nil {
+			return err
+		}
+	}
+	for _, path := range args {
+		h, err := platform.ReadScreenHash(path)
+		if err != nil {
+			return err
+		}
+		if screenHashRef != "" {
+			fmt.Printf("%v  %2d  %s\n", h, h.Distance(ref), path)
+		} else {
+			fmt.Printf("%v  %s\n", h, path)
+		}
+	}
+	return nil
+}
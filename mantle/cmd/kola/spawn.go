@@ -15,6 +15,7 @@
 package main
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
 	"net"
@@ -57,8 +58,12 @@ var (
 	spawnSSHKeys        []string
 	spawnJSONInfoFd     int
 	spawnSSHCommand     string
+	spawnRollback       bool
 )
 
+// the snapshot of the machines which --rollback restores
+const spawnSnapshot = "kola-spawn"
+
 func init() {
 	cmdSpawn.Flags().IntVarP(&spawnNodeCount, "nodecount", "c", 1, "number of nodes to spawn")
 	cmdSpawn.Flags().StringVarP(&spawnUserData, "userdata", "u", "", "file containing userdata to pass to the instances")
@@ -73,6 +78,7 @@ func init() {
 	cmdSpawn.Flags().BoolVarP(&spawnSetSSHKeys, "keys", "k", false, "add SSH keys from --key options")
 	cmdSpawn.Flags().StringSliceVar(&spawnSSHKeys, "key", nil, "path to SSH public key (default: SSH agent + ~/.ssh/id_{rsa,dsa,ecdsa,ed25519}.pub)")
 	cmdSpawn.Flags().StringVarP(&spawnSSHCommand, "ssh-command", "x", "", "Command to execute instead of spawning a shell")
+	cmdSpawn.Flags().BoolVarP(&spawnRollback, "rollback", "", false, "snapshot qemu machines once started, and offer to roll them back each time the shell exits")
 	root.AddCommand(cmdSpawn)
 }
 
@@ -97,6 +103,12 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 	if spawnReconnect && !strings.HasPrefix(kolaPlatform, "qemu") {
 		return fmt.Errorf("Cannot use --reconnect on non-qemu platforms %v", kolaPlatform)
 	}
+	if spawnRollback && kolaPlatform != "qemu" {
+		return fmt.Errorf("Cannot use --rollback on non-qemu platforms %v", kolaPlatform)
+	}
+	if spawnRollback && spawnReconnect {
+		return fmt.Errorf("Cannot use --rollback with --reconnect")
+	}
 
 	var userdata *conf.UserData
 	if spawnUserData != "" {
@@ -222,6 +234,15 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 				return errors.Wrapf(err, "Setting shell prompt failed")
 			}
 		}
+		var stdin *bufio.Reader
+		if spawnRollback {
+			for _, m := range cluster.Machines() {
+				if err := m.(platform.QEMUMachine).Snapshot(spawnSnapshot); err != nil {
+					return errors.Wrapf(err, "Snapshotting machine %v failed", m.ID())
+				}
+			}
+			stdin = bufio.NewReader(os.Stdin)
+		}
 		for {
 			var bootID string
 			if spawnReconnect {
@@ -230,6 +251,26 @@ func runSpawn(cmd *cobra.Command, args []string) error {
 				}
 			}
 			err = platform.Manhole(someMach)
+			if spawnRollback {
+				// the exit status of the shell is the user's business, but
+				// a failing connection would fail again after rolling back
+				if _, ok := errors.Cause(err).(*ssh.ExitError); err != nil && !ok {
+					return errors.Wrapf(err, "Manhole failed")
+				}
+				fmt.Print("Press Enter to roll back and reconnect, or q and Enter to quit: ")
+				answer, rerr := stdin.ReadString('\n')
+				if rerr != nil || strings.TrimSpace(answer) == "q" {
+					fmt.Println()
+					return nil
+				}
+				fmt.Println("Rolling back...")
+				for _, m := range cluster.Machines() {
+					if err := m.(platform.QEMUMachine).Restore(spawnSnapshot); err != nil {
+						return errors.Wrapf(err, "Rolling back machine %v failed", m.ID())
+					}
+				}
+				continue
+			}
 			if !spawnReconnect {
 				return errors.Wrapf(err, "Manhole failed")
 			}
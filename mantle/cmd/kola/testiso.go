@@ -69,6 +69,11 @@ var (
 	isOffline        bool
 	isISOFromRAM     bool
 
+	// the screen iso-live-login waits for after logging in, see
+	// platform.HashScreen
+	liveLoginScreen     string
+	liveLoginScreenHash platform.ScreenHash
+
 	// These tests only run on RHCOS
 	tests_RHCOS_uefi = []string{
 		"iso-fips.uefi",
@@ -357,6 +362,7 @@ func init() {
 	cmdTestIso.Flags().BoolVarP(&instInsecure, "inst-insecure", "S", false, "Do not verify signature on metal image")
 	cmdTestIso.Flags().BoolVar(&console, "console", false, "Connect qemu console to terminal, turn off automatic initramfs failure checking")
 	cmdTestIso.Flags().StringSliceVar(&pxeKernelArgs, "pxe-kargs", nil, "Additional kernel arguments for PXE")
+	cmdTestIso.Flags().StringVar(&liveLoginScreen, "live-login-screen", "", "Hash of the login screen iso-live-login must show, from kola screenhash")
 
 	root.AddCommand(cmdTestIso)
 }
@@ -400,6 +406,13 @@ func newBaseQemuBuilder(outdir string) (*platform.QemuBuilder, error) {
 		return nil, err
 	}
 
+	builder.UsermodeIPFamily = kola.QEMUOptions.UsermodeIPFamily
+	builder.UsermodeIPv6Net = kola.QEMUOptions.UsermodeIPv6Net
+
+	if kola.QEMUOptions.Screenshots {
+		builder.ScreenshotDir = filepath.Join(outdir, "screenshots")
+	}
+
 	builder.InheritConsole = console
 	if !console {
 		builder.ConsoleFile = filepath.Join(outdir, "console.txt")
@@ -505,6 +518,11 @@ func runTestIso(cmd *cobra.Command, args []string) (err error) {
 	if kola.CosaBuild == nil {
 		return fmt.Errorf("Must provide --build")
 	}
+	if liveLoginScreen != "" {
+		if liveLoginScreenHash, err = platform.ParseScreenHash(liveLoginScreen); err != nil {
+			return err
+		}
+	}
 	tests := getAllTests(kola.CosaBuild)
 	if len(args) != 0 {
 		if tests, err = filterTests(tests, args); err != nil {
@@ -1022,6 +1040,9 @@ func testLiveLogin(ctx context.Context, outdir string) (time.Duration, error) {
 
 	// No network device to test https://github.com/coreos/fedora-coreos-config/pull/326
 	builder.Append("-net", "none")
+	if liveLoginScreen != "" {
+		builder.Framebuffer = true
+	}
 
 	mach, err := builder.Exec()
 	if err != nil {
@@ -1029,7 +1050,20 @@ func testLiveLogin(ctx context.Context, outdir string) (time.Duration, error) {
 	}
 	defer mach.Destroy()
 
-	return awaitCompletion(ctx, mach, outdir, completionChannel, nil, []string{"coreos-liveiso-success"})
+	duration, err := awaitCompletion(ctx, mach, outdir, completionChannel, nil, []string{"coreos-liveiso-success"})
+	if err != nil || liveLoginScreen == "" {
+		return duration, err
+	}
+	// the console may still be printing when the live ISO signals success
+	screenCtx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+	if err := mach.WaitForScreen(screenCtx, liveLoginScreenHash, 2); err != nil {
+		if derr := mach.Screendump(filepath.Join(outdir, "screen.png")); derr != nil {
+			plog.Errorf("Saving screen: %v", derr)
+		}
+		return duration, errors.Wrapf(err, "waiting for login screen")
+	}
+	return duration, nil
 }
 
 func testAsDisk(ctx context.Context, outdir string) (time.Duration, error) {
@@ -491,9 +491,29 @@ func (t *H) Parallel() {
 	// Add to the list of tests to be released by the parent.
 	t.parent.sub = append(t.parent.sub, t)
 
+	// Top-level tests start in the order they were run in.
+	var prev, next chan bool
+	if t.level == 1 {
+		prev, next = t.suite.takeTurn()
+	}
+
 	t.signal <- true   // Release calling test.
 	<-t.parent.barrier // Wait for the parent test to complete.
+	if prev != nil {
+		<-prev
+	}
 	t.suite.waitParallel()
+	if next != nil {
+		close(next)
+	}
+	t.start = time.Now()
+}
+
+// Untimed runs f, e.g. waiting for resources the test needs, without
+// counting the time it takes in the test duration.
+func (t *H) Untimed(f func()) {
+	t.duration += time.Since(t.start)
+	f()
 	t.start = time.Now()
 }
 
@@ -23,6 +23,7 @@ import (
 	"reflect"
 	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync/atomic"
 	"testing"
@@ -365,6 +366,37 @@ func TestOutputDir(t *testing.T) {
 	}
 }
 
+func TestUntimed(t *testing.T) {
+	htest := &HarnessTest{
+		run: func(h *H) {
+			h.Untimed(func() {
+				time.Sleep(500 * time.Millisecond)
+			})
+		},
+		timeout: DefaultTimeoutFlag,
+	}
+	opts := Options{
+		OutputDir: filepath.Join(t.TempDir(), "_test_temp"),
+		Verbose:   true,
+	}
+	suite := NewSuite(opts, Tests{
+		"Untimed": htest,
+	})
+
+	buf := &bytes.Buffer{}
+	if err := suite.runTests(buf, nil); err != nil {
+		t.Log("\n" + buf.String())
+		t.Fatal(err)
+	}
+	m := regexp.MustCompile(`PASS.*: Untimed \((\d+\.\d+)s\)`).FindStringSubmatch(buf.String())
+	if m == nil {
+		t.Fatalf("no duration in output:\n%s", buf.String())
+	}
+	if d, err := strconv.ParseFloat(m[1], 64); err != nil || d >= 0.25 {
+		t.Errorf("untimed wait counted in the duration: %ss", m[1])
+	}
+}
+
 func TestSubDirs(t *testing.T) {
 	suitedir := t.TempDir()
 
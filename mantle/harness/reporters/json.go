@@ -33,6 +33,12 @@ type jsonReporter struct {
 	// Context variables
 	Platform string `json:"platform"`
 	Version  string `json:"version"`
+	Arch     string `json:"arch,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+
+	// Denylisted are the tests which were not run because they are
+	// denylisted
+	Denylisted []string `json:"denylisted,omitempty"`
 
 	mutex sync.Mutex
 }
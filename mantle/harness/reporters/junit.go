@@ -0,0 +1,401 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+const (
+	// maximum number of trailing lines of console.txt and journal.txt
+	// embedded for a failed test
+	excerptLines = 200
+	// maximum size of such an excerpt
+	excerptBytes = 64 * 1024
+)
+
+// https://stackoverflow.com/a/14693789
+var ansiEscape = regexp.MustCompile(`\x1B(?:[@-Z\\-_]|\[[0-?]*[ -/]*[@-~])`)
+
+// junitReporter writes a JUnit XML report. Tests are reported as test
+// cases, except that tests with subtests become nested test suites.
+//
+// The same reporter can be given to several suites, e.g. a run and the
+// rerun of its failed tests; reports of a test after the first are
+// recorded as further attempts, and earlier failures are reported as
+// <flakyFailure> if the last attempt passed or <rerunFailure> otherwise,
+// as Maven surefire does.
+type junitReporter struct {
+	filename string
+	platform string
+	version  string
+	start    time.Time
+
+	tests map[string]*junitTest
+	// order the tests were first reported in
+	order []string
+
+	mutex sync.Mutex
+}
+
+type junitTest struct {
+	name     string
+	attempts []*junitAttempt
+}
+
+type junitAttempt struct {
+	result   testresult.TestResult
+	duration time.Duration
+	output   string
+	// outputDir is the output directory of the suite which ran the
+	// attempt, known once the suite's reports are written
+	outputDir string
+	console   string
+	journal   string
+}
+
+func NewJUnitReporter(filename, platform, version string) *junitReporter {
+	return &junitReporter{
+		filename: filename,
+		platform: platform,
+		version:  version,
+		start:    time.Now(),
+		tests:    make(map[string]*junitTest),
+	}
+}
+
+func (r *junitReporter) ReportTest(name string, subtests []string, result testresult.TestResult, duration time.Duration, b []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	t, ok := r.tests[name]
+	if !ok {
+		t = &junitTest{name: name}
+		r.tests[name] = t
+		r.order = append(r.order, name)
+	}
+	t.attempts = append(t.attempts, &junitAttempt{
+		result:   result,
+		duration: duration,
+		output:   ansiEscape.ReplaceAllString(string(b), ""),
+	})
+}
+
+func (r *junitReporter) SetResult(result testresult.TestResult) {}
+
+// Output writes the report to path. The attempts reported since the
+// previous call ran in the suite whose reports go to path, which is used
+// to find the console and journal of failed attempts.
+func (r *junitReporter) Output(path string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	outputDir := filepath.Dir(path)
+	for _, t := range r.tests {
+		for _, a := range t.attempts {
+			if a.outputDir != "" {
+				continue
+			}
+			a.outputDir = outputDir
+			if a.result == testresult.Fail {
+				if hostDir := findHostDir(outputDir, t.name); hostDir != "" {
+					a.console = ansiEscape.ReplaceAllString(readExcerpt(filepath.Join(hostDir, "console.txt")), "")
+					a.journal = readExcerpt(filepath.Join(hostDir, "journal.txt"))
+				}
+			}
+		}
+	}
+
+	f, err := os.Create(filepath.Join(path, r.filename))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(f)
+	enc.Indent("", "  ")
+	if err := enc.Encode(r.build()); err != nil {
+		return err
+	}
+	_, err = f.WriteString("\n")
+	return err
+}
+
+// findHostDir returns the directory of the machine a test most likely
+// failed on. A test can bring up several machines, each with its own
+// directory; the most recent one is more likely to have the error.
+// Subtests share the machines of their parents.
+func findHostDir(outputDir, name string) string {
+	for ; name != "." && name != ""; name = filepath.Dir(name) {
+		dir := filepath.Join(outputDir, name)
+		// e.g. testiso tests have no directory per machine
+		if _, err := os.Stat(filepath.Join(dir, "console.txt")); err == nil {
+			return dir
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			continue
+		}
+		var best string
+		var bestTime time.Time
+		for _, e := range entries {
+			if !e.IsDir() {
+				continue
+			}
+			p := filepath.Join(dir, e.Name())
+			if _, err := os.Stat(filepath.Join(p, "console.txt")); err != nil {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			if best == "" || info.ModTime().After(bestTime) {
+				best, bestTime = p, info.ModTime()
+			}
+		}
+		if best != "" {
+			return best
+		}
+	}
+	return ""
+}
+
+// readExcerpt returns the end of a file, or "" if it can't be read.
+func readExcerpt(path string) string {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	truncated := false
+	if len(b) > excerptBytes {
+		b = b[len(b)-excerptBytes:]
+		truncated = true
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if len(lines) > excerptLines {
+		lines = lines[len(lines)-excerptLines:]
+		truncated = true
+	}
+	s := strings.Join(lines, "")
+	if truncated {
+		s = fmt.Sprintf("[... last %d lines of %s ...]\n%s", len(lines), filepath.Base(path), s)
+	}
+	return s
+}
+
+// JUnit XML, as understood by Jenkins and Maven surefire
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     float64           `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name       string            `xml:"name,attr"`
+	Tests      int               `xml:"tests,attr"`
+	Failures   int               `xml:"failures,attr"`
+	Skipped    int               `xml:"skipped,attr"`
+	Time       float64           `xml:"time,attr"`
+	Timestamp  string            `xml:"timestamp,attr,omitempty"`
+	Properties []junitProperty   `xml:"properties>property,omitempty"`
+	Suites     []*junitTestSuite `xml:"testsuite"`
+	Cases      []*junitTestCase  `xml:"testcase"`
+}
+
+type junitProperty struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value,attr"`
+}
+
+type junitTestCase struct {
+	Name          string         `xml:"name,attr"`
+	Classname     string         `xml:"classname,attr"`
+	Time          float64        `xml:"time,attr"`
+	Skipped       *junitMessage  `xml:"skipped"`
+	Failure       *junitMessage  `xml:"failure"`
+	RerunFailures []junitAttempt `xml:"rerunFailure"`
+	FlakyFailures []junitAttempt `xml:"flakyFailure"`
+	SystemOut     string         `xml:"system-out,omitempty"`
+	SystemErr     string         `xml:"system-err,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+func (a junitAttempt) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	v := struct {
+		Message    string `xml:"message,attr"`
+		Type       string `xml:"type,attr"`
+		StackTrace string `xml:"stackTrace"`
+		SystemOut  string `xml:"system-out,omitempty"`
+		SystemErr  string `xml:"system-err,omitempty"`
+	}{
+		Message:    fmt.Sprintf("Test failed after %s", a.duration.Round(time.Millisecond)),
+		Type:       string(a.result),
+		StackTrace: a.output,
+		SystemOut:  a.console,
+		SystemErr:  a.journal,
+	}
+	return e.EncodeElement(v, start)
+}
+
+// junitNode is a test and the subtests reported under it
+type junitNode struct {
+	name     string
+	test     *junitTest
+	children []*junitNode
+}
+
+// build returns the report, with the tests in the order they were first
+// reported and their subtests nested under them.
+func (r *junitReporter) build() *junitTestSuites {
+	root := &junitNode{}
+	nodes := map[string]*junitNode{"": root}
+	var lookup func(name string) *junitNode
+	lookup = func(name string) *junitNode {
+		if n, ok := nodes[name]; ok {
+			return n
+		}
+		parent := ""
+		if i := strings.LastIndex(name, "/"); i >= 0 {
+			parent = name[:i]
+		}
+		n := &junitNode{name: name}
+		nodes[name] = n
+		p := lookup(parent)
+		p.children = append(p.children, n)
+		return n
+	}
+	// report parents before their subtests, which finish first
+	names := append([]string{}, r.order...)
+	sort.SliceStable(names, func(i, j int) bool {
+		return strings.Count(names[i], "/") < strings.Count(names[j], "/")
+	})
+	for _, name := range names {
+		lookup(name).test = r.tests[name]
+	}
+
+	classname := "kola"
+	if r.platform != "" {
+		classname = "kola." + r.platform
+	}
+	suite := r.buildSuite(root, classname, classname)
+	suite.Timestamp = r.start.UTC().Format(time.RFC3339)
+	for _, p := range []junitProperty{{"platform", r.platform}, {"version", r.version}} {
+		if p.Value != "" {
+			suite.Properties = append(suite.Properties, p)
+		}
+	}
+	return &junitTestSuites{
+		Name:     "kola",
+		Tests:    suite.Tests,
+		Failures: suite.Failures,
+		Skipped:  suite.Skipped,
+		Time:     suite.Time,
+		Suites:   []*junitTestSuite{suite},
+	}
+}
+
+func (r *junitReporter) buildSuite(n *junitNode, name, classname string) *junitTestSuite {
+	s := &junitTestSuite{Name: name}
+	add := func(tc *junitTestCase) {
+		s.Cases = append(s.Cases, tc)
+		s.Tests++
+		s.Time += tc.Time
+		if tc.Failure != nil {
+			s.Failures++
+		}
+		if tc.Skipped != nil {
+			s.Skipped++
+		}
+	}
+	for _, c := range n.children {
+		base := c.name[strings.LastIndex(c.name, "/")+1:]
+		if len(c.children) == 0 {
+			if c.test != nil {
+				add(buildCase(c.test, base, classname))
+			}
+			continue
+		}
+		sub := r.buildSuite(c, base, classname+"."+base)
+		// the test itself is only reported if it failed without a
+		// subtest failing, so that its failure isn't lost
+		if c.test != nil {
+			tc := buildCase(c.test, base, classname+"."+base)
+			if tc.Failure != nil && sub.Failures == 0 {
+				sub.Cases = append(sub.Cases, tc)
+				sub.Tests++
+				sub.Failures++
+			}
+			sub.Time = tc.Time
+		}
+		s.Suites = append(s.Suites, sub)
+		s.Tests += sub.Tests
+		s.Failures += sub.Failures
+		s.Skipped += sub.Skipped
+		s.Time += sub.Time
+	}
+	return s
+}
+
+func buildCase(t *junitTest, name, classname string) *junitTestCase {
+	last := t.attempts[len(t.attempts)-1]
+	tc := &junitTestCase{
+		Name:      name,
+		Classname: classname,
+		Time:      last.duration.Seconds(),
+	}
+	var failed []junitAttempt
+	for _, a := range t.attempts {
+		if a.result == testresult.Fail {
+			failed = append(failed, *a)
+		}
+	}
+	switch last.result {
+	case testresult.Fail:
+		first := failed[0]
+		tc.Failure = &junitMessage{Message: "Test failed", Text: first.output}
+		tc.SystemOut = first.console
+		tc.SystemErr = first.journal
+		tc.RerunFailures = failed[1:]
+	case testresult.Skip:
+		tc.Skipped = &junitMessage{Message: "Test skipped", Text: last.output}
+	default:
+		tc.FlakyFailures = failed
+		tc.SystemOut = last.output
+	}
+	return tc
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package reporters
+
+import (
+	"encoding/xml"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+)
+
+// newSuiteDir creates the output directory of a suite, with a machine
+// directory for test.
+func newSuiteDir(t *testing.T, test, console string) string {
+	dir := t.TempDir()
+	machine := filepath.Join(dir, test, "qemu-1234")
+	if err := os.MkdirAll(machine, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(machine, "console.txt"), []byte(console), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "reports"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func readJUnit(t *testing.T, path string) *junitTestSuites {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var r junitTestSuites
+	if err := xml.Unmarshal(b, &r); err != nil {
+		t.Fatalf("invalid JUnit XML: %v\n%s", err, b)
+	}
+	return &r
+}
+
+func TestJUnitReporter(t *testing.T) {
+	r := NewJUnitReporter("report.xml", "qemu", "41.20250101.0")
+
+	bucket := "non-exclusive-test-bucket-0"
+	dir := newSuiteDir(t, bucket, "\x1b[1mkernel panic\x1b[0m\n")
+	r.ReportTest("basic", nil, testresult.Pass, time.Second, []byte("ok"))
+	r.ReportTest(bucket+"/ext.config.a", nil, testresult.Pass, time.Second, nil)
+	r.ReportTest(bucket+"/ext.config.b", nil, testresult.Fail, time.Second, []byte("b failed"))
+	r.ReportTest(bucket, []string{bucket + "/ext.config.a", bucket + "/ext.config.b"}, testresult.Fail, 3*time.Second, nil)
+	r.ReportTest("rootfs.uuid", nil, testresult.Fail, time.Second, []byte("flaked"))
+	r.ReportTest("fips.enable", nil, testresult.Skip, 0, []byte("not on this arch"))
+	if err := r.Output(filepath.Join(dir, "reports")); err != nil {
+		t.Fatal(err)
+	}
+
+	first := readJUnit(t, filepath.Join(dir, "reports", "report.xml"))
+	if first.Tests != 5 || first.Failures != 2 || first.Skipped != 1 {
+		t.Errorf("unexpected totals: %+v", first)
+	}
+
+	// the rerun of the failed tests is reported by the same reporter
+	rerunDir := newSuiteDir(t, "rootfs.uuid", "")
+	r.ReportTest("rootfs.uuid", nil, testresult.Pass, time.Second, []byte("passed"))
+	r.ReportTest(bucket+"/ext.config.b", nil, testresult.Fail, time.Second, []byte("b failed again"))
+	if err := r.Output(filepath.Join(rerunDir, "reports")); err != nil {
+		t.Fatal(err)
+	}
+
+	report := readJUnit(t, filepath.Join(rerunDir, "reports", "report.xml"))
+	if report.Tests != 5 || report.Failures != 1 || report.Skipped != 1 {
+		t.Errorf("unexpected totals: %+v", report)
+	}
+	suite := report.Suites[0]
+	if suite.Name != "kola.qemu" || len(suite.Properties) != 2 {
+		t.Errorf("unexpected suite: %+v", suite)
+	}
+	cases := make(map[string]*junitTestCase)
+	for _, tc := range suite.Cases {
+		cases[tc.Name] = tc
+	}
+	if len(suite.Suites) != 1 || suite.Suites[0].Name != bucket {
+		t.Fatalf("expected the bucket as a nested suite, got %+v", suite.Suites)
+	}
+	for _, tc := range suite.Suites[0].Cases {
+		cases[tc.Name] = tc
+	}
+	if _, ok := cases[bucket]; ok {
+		t.Errorf("the bucket should not be a test case since a subtest failed")
+	}
+
+	b := cases["ext.config.b"]
+	if b == nil || b.Failure == nil || b.Failure.Text != "b failed" || len(b.RerunFailures) != 1 {
+		t.Errorf("unexpected ext.config.b: %+v", b)
+	} else if b.Classname != "kola.qemu."+bucket || b.SystemOut != "kernel panic\n" {
+		t.Errorf("expected the console of the bucket without escapes, got %+v", b)
+	}
+	if a := cases["ext.config.a"]; a == nil || a.Failure != nil {
+		t.Errorf("unexpected ext.config.a: %+v", a)
+	}
+	if uuid := cases["rootfs.uuid"]; uuid == nil || uuid.Failure != nil || len(uuid.FlakyFailures) != 1 {
+		t.Errorf("expected rootfs.uuid to be flaky, got %+v", uuid)
+	}
+	if fips := cases["fips.enable"]; fips == nil || fips.Skipped == nil {
+		t.Errorf("expected fips.enable to be skipped, got %+v", fips)
+	}
+
+	raw, err := os.ReadFile(filepath.Join(rerunDir, "reports", "report.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(raw), "<flakyFailure") || !strings.Contains(string(raw), "<stackTrace>flaked</stackTrace>") {
+		t.Errorf("expected a flakyFailure element:\n%s", raw)
+	}
+}
+
+func TestReadExcerpt(t *testing.T) {
+	p := filepath.Join(t.TempDir(), "journal.txt")
+	var b strings.Builder
+	for i := 0; i < excerptLines*2; i++ {
+		b.WriteString("line\n")
+	}
+	b.WriteString("last line\n")
+	if err := os.WriteFile(p, []byte(b.String()), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := readExcerpt(p)
+	if !strings.HasPrefix(s, "[... last") || !strings.HasSuffix(s, "last line\n") {
+		t.Errorf("unexpected excerpt %q", s)
+	}
+	if readExcerpt(filepath.Join(t.TempDir(), "missing")) != "" {
+		t.Errorf("a missing file should have an empty excerpt")
+	}
+}
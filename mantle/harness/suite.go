@@ -78,6 +78,10 @@ type Options struct {
 	// Sharding splits tests across runners
 	Sharding string
 
+	// Order lists tests to start first, in this order, e.g. the slowest
+	// ones. The other tests start in name order.
+	Order []string
+
 	Reporters reporters.Reporters
 }
 
@@ -149,6 +153,10 @@ type Suite struct {
 
 	// waiting is the number tests waiting to be run in parallel.
 	waiting int
+
+	// lastTurn is closed once the last top-level test which called
+	// Parallel has started, which lets the next one start.
+	lastTurn chan bool
 }
 
 func (c *Suite) waitParallel() {
@@ -163,6 +171,18 @@ func (c *Suite) waitParallel() {
 	<-c.startParallel
 }
 
+// takeTurn returns a channel closed once the top-level tests which called
+// Parallel before have started, and the channel to close once the caller
+// has started, so that top-level tests start in the order they run in.
+func (c *Suite) takeTurn() (prev, next chan bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	prev = c.lastTurn
+	next = make(chan bool)
+	c.lastTurn = next
+	return prev, next
+}
+
 func (c *Suite) release() {
 	c.mu.Lock()
 	if c.waiting == 0 {
@@ -291,7 +311,8 @@ func (s *Suite) runTests(out, tap io.Writer) error {
 		timeout: defaultTimeout,
 	}
 	tRunner(t, func(t *H) {
-		for name, htest := range s.tests {
+		for _, name := range s.order() {
+			htest := s.tests[name]
 			t.RunTimeout(name, htest.run, htest.timeout)
 		}
 		// Run catching the signal rather than the tRunner as a separate
@@ -316,6 +337,24 @@ func (s *Suite) runTests(out, tap io.Writer) error {
 	return nil
 }
 
+// order returns the names of the tests in the order to run them in.
+func (s *Suite) order() []string {
+	names := make([]string, 0, len(s.tests))
+	seen := make(map[string]bool)
+	for _, name := range s.opts.Order {
+		if _, ok := s.tests[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	for _, name := range s.tests.List() {
+		if !seen[name] {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
 // outputPath returns the file name under Options.OutputDir.
 func (s *Suite) outputPath(path string) string {
 	return filepath.Join(s.opts.OutputDir, path)
@@ -16,7 +16,11 @@
 package harness
 
 import (
+	"io"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 )
 
 func TestSuiteParallelism(t *testing.T) {
@@ -111,3 +115,30 @@ func TestSuiteParallelism(t *testing.T) {
 		}
 	}
 }
+
+func TestSuiteOrder(t *testing.T) {
+	var mu sync.Mutex
+	var started []string
+	var tests Tests
+	for _, name := range []string{"a", "b", "c", "d"} {
+		name := name
+		tests.Add(name, func(h *H) {
+			h.Parallel()
+			mu.Lock()
+			started = append(started, name)
+			mu.Unlock()
+			time.Sleep(10 * time.Millisecond)
+		}, DefaultTimeoutFlag)
+	}
+	suite := NewSuite(Options{
+		Parallel:  1,
+		Order:     []string{"c", "missing", "a"},
+		OutputDir: t.TempDir(),
+	}, tests)
+	if err := suite.runTests(io.Discard, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Join(started, " "); got != "c a b d" {
+		t.Errorf("unexpected start order: %s", got)
+	}
+}
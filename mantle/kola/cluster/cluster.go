@@ -46,6 +46,12 @@ type TestCluster struct {
 	hasFailure bool
 }
 
+// sshRecorder is implemented by clusters which can record the commands
+// run on their machines, see platform.RuntimeConfig.RecordSSH.
+type sshRecorder interface {
+	RecordSSH(m platform.Machine, cmd string, stdout, stderr []byte, err error)
+}
+
 // Run runs f as a subtest and reports whether f succeeded.
 func (t *TestCluster) Run(name string, f func(c TestCluster)) bool {
 	if t.FailFast && t.hasFailure {
@@ -140,6 +146,9 @@ func (t *TestCluster) SSH(m platform.Machine, cmd string) ([]byte, error) {
 	var err error
 	f := func() {
 		stdout, stderr, err = m.SSH(cmd)
+		if r, ok := t.Cluster.(sshRecorder); ok {
+			r.RecordSSH(m, cmd, stdout, stderr, err)
+		}
 	}
 
 	errMsg := fmt.Sprintf("ssh: %s", cmd)
@@ -0,0 +1,164 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// consoleCheck is a regexp which fails a test when it matches its
+// console or journal
+type consoleCheck struct {
+	desc              string
+	match             *regexp.Regexp
+	ignore            *regexp.Regexp // matches lines of known-benign noise
+	warnOnly          bool
+	allowRerunSuccess bool
+	skipFlag          *register.Flag
+}
+
+// ConsoleCheckObj is an entry of kola-console-checks.yaml. It adds a check,
+// or changes the check of the same description, e.g. one of the defaults.
+type ConsoleCheckObj struct {
+	Desc              string   `yaml:"desc"`
+	Match             string   `yaml:"match"`
+	Ignore            string   `yaml:"ignore"`
+	WarnOnly          *bool    `yaml:"warnOnly"`
+	AllowRerunSuccess *bool    `yaml:"allowRerunSuccess"`
+	Suppress          bool     `yaml:"suppress"`
+	Arches            []string `yaml:"arches"`
+	Platforms         []string `yaml:"platforms"`
+}
+
+// LoadConsoleChecks sets the checks in effect from the defaults and
+// src/config/kola-console-checks.yaml in the cosa workdir, if it exists.
+func LoadConsoleChecks(pltfrm string) error {
+	consoleChecks = defaultConsoleChecks
+	if Options.CosaWorkdir == "" {
+		return nil
+	}
+
+	path := filepath.Join(Options.CosaWorkdir, "src/config/kola-console-checks.yaml")
+	buf, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var objs []ConsoleCheckObj
+	if err := yaml.Unmarshal(buf, &objs); err != nil {
+		return fmt.Errorf("parsing %s: %w", path, err)
+	}
+	checks, err := applyConsoleChecks(defaultConsoleChecks, objs, pltfrm, Options.CosaBuildArch)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	consoleChecks = checks
+	return nil
+}
+
+// applyConsoleChecks returns checks changed by the entries which apply to
+// the platform and architecture.
+func applyConsoleChecks(checks []consoleCheck, objs []ConsoleCheckObj, pltfrm, arch string) ([]consoleCheck, error) {
+	ret := append([]consoleCheck{}, checks...)
+	for _, obj := range objs {
+		if obj.Desc == "" {
+			return nil, fmt.Errorf("console check without desc")
+		}
+		if len(obj.Arches) > 0 && !HasString(arch, obj.Arches) {
+			continue
+		}
+		if len(obj.Platforms) > 0 && !HasString(pltfrm, obj.Platforms) {
+			continue
+		}
+
+		i := 0
+		for ; i < len(ret); i++ {
+			if ret[i].desc == obj.Desc {
+				break
+			}
+		}
+		if obj.Suppress {
+			if i == len(ret) {
+				return nil, fmt.Errorf("cannot suppress unknown console check %q", obj.Desc)
+			}
+			plog.Debugf("Suppressing console check %q", obj.Desc)
+			ret = append(ret[:i], ret[i+1:]...)
+			continue
+		}
+		if i == len(ret) {
+			if obj.Match == "" {
+				return nil, fmt.Errorf("console check %q has no match", obj.Desc)
+			}
+			ret = append(ret, consoleCheck{desc: obj.Desc})
+		}
+		check := &ret[i]
+		if obj.Match != "" {
+			match, err := regexp.Compile(obj.Match)
+			if err != nil {
+				return nil, fmt.Errorf("console check %q: %w", obj.Desc, err)
+			}
+			check.match = match
+		}
+		if obj.Ignore != "" {
+			ignore, err := regexp.Compile(obj.Ignore)
+			if err != nil {
+				return nil, fmt.Errorf("console check %q: %w", obj.Desc, err)
+			}
+			check.ignore = ignore
+		}
+		if obj.WarnOnly != nil {
+			check.warnOnly = *obj.WarnOnly
+		}
+		if obj.AllowRerunSuccess != nil {
+			check.allowRerunSuccess = *obj.AllowRerunSuccess
+		}
+	}
+	return ret, nil
+}
+
+// find returns the first match of the check in output, with its
+// subexpressions, skipping the matches on lines to ignore.
+func (c *consoleCheck) find(output []byte) [][]byte {
+	if c.ignore == nil {
+		return c.match.FindSubmatch(output)
+	}
+	for _, loc := range c.match.FindAllSubmatchIndex(output, -1) {
+		start := bytes.LastIndexByte(output[:loc[0]], '\n') + 1
+		end := len(output)
+		if i := bytes.IndexByte(output[loc[1]:], '\n'); i >= 0 {
+			end = loc[1] + i
+		}
+		if c.ignore.Match(output[start:end]) {
+			continue
+		}
+		match := make([][]byte, len(loc)/2)
+		for i := range match {
+			if loc[2*i] >= 0 {
+				match[i] = output[loc[2*i]:loc[2*i+1]]
+			}
+		}
+		return match
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+const consoleChecksYaml = `
+- desc: acme driver crash
+  match: 'acme: fatal error (\w+)'
+  platforms:
+    - qemu
+- desc: kernel soft lockup
+  warnOnly: false
+  allowRerunSuccess: false
+- desc: core dump
+  suppress: true
+  arches:
+    - x86_64
+- desc: segfault
+  ignore: 'benign-helper\['
+`
+
+func TestLoadConsoleChecks(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workdir, "src/config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(workdir, "src/config/kola-console-checks.yaml"), []byte(consoleChecksYaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer func(workdir, arch string) {
+		Options.CosaWorkdir, Options.CosaBuildArch = workdir, arch
+		consoleChecks = defaultConsoleChecks
+	}(Options.CosaWorkdir, Options.CosaBuildArch)
+	Options.CosaWorkdir = workdir
+	Options.CosaBuildArch = "x86_64"
+
+	if err := LoadConsoleChecks("qemu"); err != nil {
+		t.Fatal(err)
+	}
+	test := &register.Test{Name: "basic"}
+	check := func(console string) (bool, string) {
+		warnOnly, badlines := CheckConsole([]byte(console), test)
+		return warnOnly, strings.Join(badlines, ", ")
+	}
+
+	if warnOnly, bad := check("acme: fatal error EIO\n"); warnOnly || bad != "acme driver crash (EIO)" {
+		t.Errorf("added check: got %v %q", warnOnly, bad)
+	}
+	if warnOnly, bad := check("watchdog: BUG: soft lockup - CPU#0 stuck\n"); warnOnly || bad != "kernel soft lockup" {
+		t.Errorf("overridden check: got %v %q", warnOnly, bad)
+	}
+	if _, bad := check("systemd-coredump[42]: Process 1 (foo) dumped core dump\n"); bad != "" {
+		t.Errorf("suppressed check: got %q", bad)
+	}
+	if _, bad := check("benign-helper[12]: SIGSEGV\n"); bad != "" {
+		t.Errorf("ignored line: got %q", bad)
+	}
+	if _, bad := check("benign-helper[12]: SIGSEGV\nfoo[13]: SIGSEGV\n"); bad != "segfault" {
+		t.Errorf("line after an ignored one: got %q", bad)
+	}
+	if _, bad := check("Kernel panic - not syncing: VFS\n"); bad != "kernel panic (VFS)" {
+		t.Errorf("default check: got %q", bad)
+	}
+
+	// the checks of other platforms and arches don't apply
+	Options.CosaBuildArch = "s390x"
+	if err := LoadConsoleChecks("aws"); err != nil {
+		t.Fatal(err)
+	}
+	if _, bad := check("acme: fatal error EIO\nfoo: core dump\n"); bad != "core dump" {
+		t.Errorf("scoped checks: got %q", bad)
+	}
+}
+
+func TestApplyConsoleChecksErrors(t *testing.T) {
+	for _, obj := range []ConsoleCheckObj{
+		{Match: "foo"},
+		{Desc: "new check"},
+		{Desc: "unknown", Suppress: true},
+		{Desc: "bad regexp", Match: "("},
+	} {
+		if _, err := applyConsoleChecks(defaultConsoleChecks, []ConsoleCheckObj{obj}, "qemu", "x86_64"); err == nil {
+			t.Errorf("%+v: expected an error", obj)
+		}
+	}
+}
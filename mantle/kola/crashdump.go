@@ -0,0 +1,62 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"path/filepath"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/util"
+)
+
+// collectCrashDumps saves /var/crash of the machines which booted again
+// after a kernel panic, e.g. into kdump, next to their console logs. The
+// memory dumps taken at the panic are already there.
+func collectCrashDumps(h *harness.H, c platform.Cluster) {
+	for _, m := range c.Machines() {
+		qm, ok := m.(platform.QEMUMachine)
+		if !ok {
+			continue
+		}
+		panicked, rebooted := qm.KernelPanicked()
+		if !panicked || !rebooted {
+			continue
+		}
+
+		// kdump saves the vmcore then reboots, which can take a while
+		var files []byte
+		err := util.Retry(30, 10*time.Second, func() error {
+			var err error
+			files, _, err = m.SSH("sudo ls -A /var/crash")
+			return err
+		})
+		if err != nil {
+			plog.Warningf("Collecting crash dumps of machine %s: %v", m.ID(), err)
+			continue
+		}
+		if len(files) == 0 {
+			plog.Infof("No crash dumps on machine %s; is kdump configured?", m.ID())
+			continue
+		}
+		dest := filepath.Join(h.OutputDir(), m.ID(), "var-crash.tar.gz")
+		if err := platform.CopyDirFromMachine(m, "/var/crash", dest); err != nil {
+			plog.Warningf("Collecting crash dumps of machine %s: %v", m.ID(), err)
+			continue
+		}
+		h.Logf("Saved the crash dumps of machine %s in %s", m.ID(), dest)
+	}
+}
@@ -0,0 +1,224 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/machine/qemu"
+)
+
+// How long a fixture machine may take to shut down before its disk is
+// snapshotted
+const fixturePoweroffTimeout = 2 * time.Minute
+
+// addFixtureTests replaces the tests which use a fixture by wrapper tests
+// which provision each fixture once and run its tests as subtests.
+func addFixtureTests(tests map[string]*register.Test, pltfrm string, flight platform.Flight) error {
+	byFixture := make(map[string][]*register.Test)
+	for name, test := range tests {
+		if test.Fixture != "" {
+			byFixture[test.Fixture] = append(byFixture[test.Fixture], test)
+			delete(tests, name)
+		}
+	}
+
+	for name, fixtureTests := range byFixture {
+		f, ok := register.Fixtures[name]
+		if !ok {
+			return fmt.Errorf("test %v uses unknown fixture %v", fixtureTests[0].Name, name)
+		}
+		sort.Slice(fixtureTests, func(i, j int) bool {
+			return fixtureTests[i].Name < fixtureTests[j].Name
+		})
+
+		if len(fixtureTests) == 1 || (f.Snapshot && pltfrm != "qemu") {
+			// Nothing to share, or no snapshots on this platform: the
+			// tests provision the fixture themselves.
+			for _, t := range fixtureTests {
+				tests[t.Name] = provisionFixtureTest(f, t)
+			}
+			continue
+		}
+		wrapper := makeFixtureTest(f, fixtureTests, flight)
+		tests[wrapper.Name] = &wrapper
+	}
+	return nil
+}
+
+// provisionFixtureTest returns a test which sets up the fixture on a
+// machine of its own and runs t on it.
+func provisionFixtureTest(f *register.Fixture, t *register.Test) *register.Test {
+	ft := *t
+	ft.UserData = f.UserData
+	ft.ClusterSize = 1
+	ft.MinMemory = f.MinMemory
+	ft.PrimaryDisk = f.PrimaryDisk
+	ft.AppendKernelArgs = f.AppendKernelArgs
+	if t.Timeout != harness.DefaultTimeoutFlag && f.Timeout != harness.DefaultTimeoutFlag {
+		ft.Timeout = t.Timeout + f.Timeout
+	}
+	ft.Run = func(c cluster.TestCluster) {
+		if f.Setup != nil {
+			f.Setup(c)
+		}
+		t.Run(c)
+	}
+	return &ft
+}
+
+// Create a parent test that sets up the fixture and runs the tests as
+// subtests, one after another on its machine or, for fixtures with
+// snapshots, each on a machine booted from a snapshot of it.
+func makeFixtureTest(f *register.Fixture, tests []*register.Test, flight platform.Flight) register.Test {
+	var subtests, tags []string
+	internetAccess := false
+	dependencyDirs := make(register.DepDirMap)
+	for _, t := range tests {
+		subtests = append(subtests, t.Name)
+		if !internetAccess && testRequiresInternet(t) {
+			tags = append(tags, NeedsInternetTag)
+			internetAccess = true
+		}
+		for k, v := range t.DependencyDir {
+			dependencyDirs[k] = v
+		}
+	}
+
+	wrapper := register.Test{
+		Name:          fmt.Sprintf("fixture-%s", f.Name),
+		Subtests:      subtests,
+		Tags:          tags,
+		Timeout:       f.Timeout,
+		DependencyDir: dependencyDirs,
+	}
+	if !f.Snapshot {
+		wrapper.UserData = f.UserData
+		wrapper.ClusterSize = 1
+		wrapper.MinMemory = f.MinMemory
+		wrapper.PrimaryDisk = f.PrimaryDisk
+		wrapper.AppendKernelArgs = f.AppendKernelArgs
+		wrapper.Run = func(tcluster cluster.TestCluster) {
+			if f.Setup != nil {
+				f.Setup(tcluster)
+			}
+			for _, t := range tests {
+				t := t
+				tcluster.H.RunTimeout(t.Name, func(h *harness.H) {
+					tcluster.H.NonExclusiveTestStarted()
+					testResults.add(h)
+					runFixtureSubtest(h, t, tcluster.Cluster)
+				}, t.Timeout)
+			}
+		}
+		return wrapper
+	}
+
+	// The machines are created by the test: the fixture's, whose disk is
+	// kept once it is set up, then one per test booted from a qcow2
+	// overlay of that disk.
+	wrapper.Run = func(tcluster cluster.TestCluster) {
+		qc, ok := tcluster.Cluster.(*qemu.Cluster)
+		if !ok {
+			tcluster.Fatalf("Fixture snapshots require the qemu platform")
+		}
+		// the disk is a hardlink to one in a qemu tempdir in /var/tmp
+		dir, err := os.MkdirTemp("/var/tmp", "kola-fixture")
+		if err != nil {
+			tcluster.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		disk := filepath.Join(dir, "fixture.qcow2")
+
+		m, err := qc.NewMachineWithQemuOptions(f.UserData, platform.QemuMachineOptions{
+			MachineOptions: platform.MachineOptions{
+				MinMemory:        f.MinMemory,
+				PrimaryDisk:      f.PrimaryDisk,
+				AppendKernelArgs: f.AppendKernelArgs,
+			},
+			SaveDisk: disk,
+		})
+		if err != nil {
+			tcluster.Fatalf("Creating fixture %s: %v", f.Name, err)
+		}
+		if f.Setup != nil {
+			f.Setup(tcluster)
+		}
+		if err := m.(platform.QEMUMachine).Poweroff(fixturePoweroffTimeout); err != nil {
+			tcluster.Fatalf("Shutting down fixture %s: %v", f.Name, err)
+		}
+		m.Destroy()
+
+		for _, t := range tests {
+			t := t
+			tcluster.H.RunTimeout(t.Name, func(h *harness.H) {
+				tcluster.H.NonExclusiveTestStarted()
+				testResults.add(h)
+
+				rconf := qc.RuntimeConf()
+				rconf.OutputDir = h.OutputDir()
+				rconf.EarlyRelease = nil
+				c, err := flight.NewCluster(&rconf)
+				if err != nil {
+					h.Fatalf("Cluster failed: %v", err)
+				}
+				defer func() {
+					if QEMUOptions.CollectCrashDumps {
+						collectCrashDumps(h, c)
+					}
+					c.Destroy()
+					if !testSkipBaseChecks(t) {
+						checkClusterConsoles(h, t, c)
+					}
+				}()
+				_, err = c.(*qemu.Cluster).NewMachineWithQemuOptions(nil, platform.QemuMachineOptions{
+					MachineOptions: platform.MachineOptions{
+						MinMemory:   f.MinMemory,
+						PrimaryDisk: f.PrimaryDisk,
+					},
+					OverrideBackingFile: disk,
+				})
+				if err != nil {
+					h.Fatalf("Booting snapshot of fixture %s: %v", f.Name, err)
+				}
+				runFixtureSubtest(h, t, c)
+			}, t.Timeout)
+		}
+	}
+	return wrapper
+}
+
+// runFixtureSubtest runs t on the machines of c.
+func runFixtureSubtest(h *harness.H, t *register.Test, c platform.Cluster) {
+	// A TestCluster with a reference to the subtest's harness, so that
+	// the timeout logic applies to the subtest
+	tcluster := cluster.TestCluster{
+		H:        h,
+		Cluster:  c,
+		FailFast: t.FailFast,
+	}
+	if IsWarningOnFailure(t.Name) || IsQuarantined(t.Name) {
+		h.WarningOnFailure()
+	}
+	t.Run(tcluster)
+}
@@ -0,0 +1,132 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+)
+
+func TestAddFixtureTests(t *testing.T) {
+	luks := &register.Fixture{
+		Name:     "luks",
+		UserData: conf.Ignition(`{"ignition": {"version": "3.0.0"}}`),
+		Timeout:  5 * time.Minute,
+	}
+	registry := &register.Fixture{
+		Name:     "registry",
+		Snapshot: true,
+	}
+	register.RegisterFixture(luks)
+	register.RegisterFixture(registry)
+	defer func() {
+		delete(register.Fixtures, "luks")
+		delete(register.Fixtures, "registry")
+	}()
+
+	run := func(c cluster.TestCluster) {}
+	newTests := func() map[string]*register.Test {
+		return map[string]*register.Test{
+			"basic":      {Name: "basic", Run: run},
+			"luks.b":     {Name: "luks.b", Run: run, Fixture: "luks", Timeout: time.Minute},
+			"luks.a":     {Name: "luks.a", Run: run, Fixture: "luks"},
+			"registry.a": {Name: "registry.a", Run: run, Fixture: "registry", Timeout: time.Minute},
+			"registry.b": {Name: "registry.b", Run: run, Fixture: "registry"},
+		}
+	}
+	names := func(tests map[string]*register.Test) []string {
+		var ret []string
+		for name := range tests {
+			ret = append(ret, name)
+		}
+		sort.Strings(ret)
+		return ret
+	}
+
+	// tests sharing a fixture run in a wrapper test
+	tests := newTests()
+	if err := addFixtureTests(tests, "qemu", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tests); !reflect.DeepEqual(got, []string{"basic", "fixture-luks", "fixture-registry"}) {
+		t.Fatalf("unexpected tests %v", got)
+	}
+	if w := tests["fixture-luks"]; !reflect.DeepEqual(w.Subtests, []string{"luks.a", "luks.b"}) || w.ClusterSize != 1 || w.UserData != luks.UserData {
+		t.Errorf("unexpected shared wrapper %+v", w)
+	}
+	// the machines of snapshots are created by the test
+	if w := tests["fixture-registry"]; w.ClusterSize != 0 {
+		t.Errorf("unexpected snapshot wrapper %+v", w)
+	}
+	if name, ok := GetRerunnableTestName("fixture-luks/luks.a"); !ok || name != "luks.a" {
+		t.Errorf("unexpected rerunnable name %q", name)
+	}
+	if _, ok := GetRerunnableTestName("fixture-luks"); ok {
+		t.Errorf("wrapper tests should not be rerun")
+	}
+
+	// there are no snapshots on other platforms
+	tests = newTests()
+	if err := addFixtureTests(tests, "aws", nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := names(tests); !reflect.DeepEqual(got, []string{"basic", "fixture-luks", "registry.a", "registry.b"}) {
+		t.Fatalf("unexpected tests %v", got)
+	}
+	if r := tests["registry.a"]; r.ClusterSize != 1 || r.Timeout != time.Minute {
+		t.Errorf("unexpected provisioned test %+v", r)
+	}
+
+	// a single test provisions the fixture itself
+	tests = map[string]*register.Test{"luks.b": newTests()["luks.b"]}
+	if err := addFixtureTests(tests, "qemu", nil); err != nil {
+		t.Fatal(err)
+	}
+	if b := tests["luks.b"]; b == nil || b.UserData != luks.UserData || b.Timeout != 6*time.Minute {
+		t.Errorf("unexpected provisioned test %+v", b)
+	}
+
+	tests = map[string]*register.Test{"foo": {Name: "foo", Fixture: "unknown"}}
+	if err := addFixtureTests(tests, "qemu", nil); err == nil {
+		t.Errorf("expected an error for an unknown fixture")
+	}
+}
+
+func TestRegisterFixtureConflicts(t *testing.T) {
+	for _, test := range []*register.Test{
+		{Name: "ok", Fixture: "luks", ClusterSize: 1, Timeout: time.Minute, FailFast: true},
+		{Name: "userdata", Fixture: "luks", UserData: conf.Ignition(`{"ignition": {"version": "3.0.0"}}`)},
+		{Name: "disks", Fixture: "luks", AdditionalDisks: []string{"5G"}},
+		{Name: "memory", Fixture: "luks", MinMemory: 4096},
+		{Name: "flags", Fixture: "luks", Flags: []register.Flag{register.NoSSHKeyInUserData}},
+		{Name: "external", Fixture: "luks", ExternalTest: "/usr/lib/tests/foo"},
+	} {
+		func() {
+			defer func() {
+				r := recover()
+				if (r == nil) != (test.Name == "ok") {
+					t.Errorf("%s: unexpected registration result %v", test.Name, r)
+				}
+			}()
+			register.Register(make(map[string]*register.Test), test)
+		}()
+	}
+}
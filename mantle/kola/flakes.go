@@ -0,0 +1,225 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// FlakeLedger counts the results of tests across runs, per platform.
+type FlakeLedger struct {
+	Platforms map[string]map[string]*FlakeRecord `json:"platforms"`
+}
+
+// FlakeRecord is the history of a test on a platform. A failure which
+// passed when rerun counts as both a failure and a rerun pass.
+type FlakeRecord struct {
+	Runs              int       `json:"runs"`
+	Passes            int       `json:"passes"`
+	Failures          int       `json:"failures"`
+	RerunPasses       int       `json:"rerunPasses"`
+	ConsecutivePasses int       `json:"consecutivePasses"`
+	LastRun           time.Time `json:"lastRun"`
+}
+
+// quarantine is a denylist entry for tests which run without failing
+// the job until they have passed a number of times in a row.
+type quarantine struct {
+	pattern string
+	passes  int
+}
+
+// LoadFlakeLedger reads a ledger file; a missing file is an empty ledger.
+func LoadFlakeLedger(path string) (*FlakeLedger, error) {
+	l := &FlakeLedger{Platforms: make(map[string]map[string]*FlakeRecord)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, l); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if l.Platforms == nil {
+		l.Platforms = make(map[string]map[string]*FlakeRecord)
+	}
+	return l, nil
+}
+
+func (l *FlakeLedger) save(path string) error {
+	return saveJSON(path, l)
+}
+
+// Get returns the record of a test on a platform, or nil.
+func (l *FlakeLedger) Get(platform, name string) *FlakeRecord {
+	return l.Platforms[platform][name]
+}
+
+// record adds the results of the run in outputDir, and of the rerun of
+// its failed tests if any.
+func (l *FlakeLedger) record(platform, outputDir string, now time.Time) error {
+	report, err := reporters.DeserialiseReport(filepath.Join(outputDir, "reports", "report.json"))
+	if err != nil {
+		return err
+	}
+	rerunPassed := make(map[string]bool)
+	rerun, err := reporters.DeserialiseReport(filepath.Join(outputDir, "rerun", "reports", "report.json"))
+	if err == nil {
+		for _, t := range rerun.Tests {
+			if name := GetBaseTestName(t.Name); name != "" && t.Result == testresult.Pass {
+				rerunPassed[name] = true
+			}
+		}
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+
+	records := l.Platforms[platform]
+	if records == nil {
+		records = make(map[string]*FlakeRecord)
+		l.Platforms[platform] = records
+	}
+	for _, t := range report.Tests {
+		name := GetBaseTestName(t.Name)
+		if name == "" || t.Result == testresult.Skip {
+			continue
+		}
+		r := records[name]
+		if r == nil {
+			r = &FlakeRecord{}
+			records[name] = r
+		}
+		r.Runs++
+		r.LastRun = now
+		if t.Result == testresult.Pass {
+			r.Passes++
+			r.ConsecutivePasses++
+			continue
+		}
+		// failed, or failed with a warning
+		r.Failures++
+		r.ConsecutivePasses = 0
+		if rerunPassed[name] {
+			r.RerunPasses++
+		}
+	}
+	return nil
+}
+
+// lockFlakeLedger takes an exclusive flock(2) next to the ledger at path,
+// so that runs sharing it don't lose each other's results, and returns
+// the function releasing it.
+func lockFlakeLedger(path string) (func(), error) {
+	lockPath := filepath.Join(filepath.Dir(path), "."+filepath.Base(path)+".lock")
+	f, err := os.OpenFile(lockPath, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("locking %s: %w", lockPath, err)
+	}
+	return func() {
+		_ = syscall.Flock(int(f.Fd()), syscall.LOCK_UN)
+		f.Close()
+	}, nil
+}
+
+// recordFlakes adds the results of a run to FlakeLedger, holding its lock
+// from reading to writing it.
+func recordFlakes(pltfrm, outputDir string) {
+	if FlakeLedgerFile == "" {
+		return
+	}
+	unlock, err := lockFlakeLedger(FlakeLedgerFile)
+	if err != nil {
+		plog.Warningf("Recording flakes: %v", err)
+		return
+	}
+	defer unlock()
+	l, err := LoadFlakeLedger(FlakeLedgerFile)
+	if err == nil {
+		err = l.record(pltfrm, outputDir, time.Now())
+	}
+	if err == nil {
+		err = l.save(FlakeLedgerFile)
+	}
+	if err != nil {
+		plog.Warningf("Recording flakes: %v", err)
+	}
+}
+
+// expireQuarantines finds the tests whose quarantine is over, since they
+// passed as many times in a row as their denylist entry asks.
+func expireQuarantines(tests map[string]*register.Test, pltfrm string) error {
+	expiredQuarantines = make(map[string]bool)
+	if len(quarantinedTests) == 0 || FlakeLedgerFile == "" {
+		return nil
+	}
+	l, err := LoadFlakeLedger(FlakeLedgerFile)
+	if err != nil {
+		return err
+	}
+	for name := range tests {
+		for _, q := range quarantinedTests {
+			found, err := filepath.Match(q.pattern, name)
+			if err != nil {
+				return err
+			}
+			if !found {
+				continue
+			}
+			if r := l.Get(pltfrm, name); r != nil && r.ConsecutivePasses >= q.passes {
+				fmt.Printf("✅ Quarantine of kola test \"%s\" expired after %d passes in a row; it can be removed from the denylist\n", name, r.ConsecutivePasses)
+				expiredQuarantines[name] = true
+			}
+		}
+	}
+	return nil
+}
+
+// IsQuarantined returns whether a failure of the test should not fail
+// the run.
+func IsQuarantined(testName string) bool {
+	if expiredQuarantines[testName] {
+		return false
+	}
+	for _, q := range quarantinedTests {
+		found, err := filepath.Match(q.pattern, testName)
+		if err != nil {
+			plog.Fatal(err)
+		}
+		if found {
+			return true
+		}
+	}
+	return false
+}
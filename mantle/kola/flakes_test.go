@@ -0,0 +1,192 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// writeRun writes the reports of a run in outputDir, and of its rerun if
+// rerun isn't nil.
+func writeRun(t *testing.T, outputDir string, run, rerun map[string]testresult.TestResult) {
+	t.Helper()
+	write := func(dir string, results map[string]testresult.TestResult) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		r := reporters.NewJSONReporter("report.json", "qemu", "")
+		for name, result := range results {
+			r.ReportTest(name, nil, result, time.Second, nil)
+		}
+		if err := r.Output(dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write(filepath.Join(outputDir, "reports"), run)
+	if rerun != nil {
+		write(filepath.Join(outputDir, "rerun", "reports"), rerun)
+	}
+}
+
+func TestFlakeLedger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kola-flakes.json")
+	l, err := LoadFlakeLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := t.TempDir()
+	writeRun(t, first, map[string]testresult.TestResult{
+		"basic":                             testresult.Pass,
+		"ostree.hotfix":                     testresult.Fail,
+		"non-exclusive-test-bucket-0":       testresult.Fail,
+		"non-exclusive-test-bucket-0/ext.a": testresult.Fail,
+		"fips.enable":                       testresult.Skip,
+	}, map[string]testresult.TestResult{
+		"ostree.hotfix":                     testresult.Pass,
+		"non-exclusive-test-bucket-0":       testresult.Fail,
+		"non-exclusive-test-bucket-0/ext.a": testresult.Fail,
+	})
+	now := time.Now()
+	if err := l.record("qemu", first, now); err != nil {
+		t.Fatal(err)
+	}
+	second := t.TempDir()
+	writeRun(t, second, map[string]testresult.TestResult{
+		"basic":         testresult.Pass,
+		"ostree.hotfix": testresult.Pass,
+		"ext.a":         testresult.Warn,
+	}, nil)
+	if err := l.record("qemu", second, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	l, err = LoadFlakeLedger(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(l.Platforms["qemu"]) != 3 {
+		t.Errorf("expected 3 tests, got %v", l.Platforms["qemu"])
+	}
+	if r := l.Get("qemu", "basic"); r == nil || r.Runs != 2 || r.Passes != 2 || r.ConsecutivePasses != 2 {
+		t.Errorf("unexpected basic: %+v", r)
+	}
+	if r := l.Get("qemu", "ostree.hotfix"); r == nil || r.Failures != 1 || r.RerunPasses != 1 || r.ConsecutivePasses != 1 {
+		t.Errorf("unexpected ostree.hotfix: %+v", r)
+	}
+	if r := l.Get("qemu", "ext.a"); r == nil || r.Failures != 2 || r.RerunPasses != 0 || r.ConsecutivePasses != 0 {
+		t.Errorf("unexpected ext.a: %+v", r)
+	}
+	if l.Get("qemu", "fips.enable") != nil || l.Get("aws", "basic") != nil {
+		t.Errorf("unexpected records")
+	}
+}
+
+func TestRecordFlakesConcurrently(t *testing.T) {
+	oldLedger := FlakeLedgerFile
+	defer func() { FlakeLedgerFile = oldLedger }()
+	FlakeLedgerFile = filepath.Join(t.TempDir(), "kola-flakes.json")
+
+	run := t.TempDir()
+	writeRun(t, run, map[string]testresult.TestResult{"basic": testresult.Pass}, nil)
+	// overlapping runs must not lose each other's results
+	const runs = 8
+	var wg sync.WaitGroup
+	for i := 0; i < runs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			recordFlakes("qemu", run)
+		}()
+	}
+	wg.Wait()
+
+	l, err := LoadFlakeLedger(FlakeLedgerFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := l.Get("qemu", "basic"); r == nil || r.Runs != runs {
+		t.Errorf("expected %d runs, got %+v", runs, r)
+	}
+}
+
+func TestQuarantine(t *testing.T) {
+	workdir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(workdir, "src/config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	denylist := `
+- pattern: ostree.*
+  tracker: https://example.com/issue/1
+  quarantine: 2
+- pattern: basic
+  quarantine: 2
+  platforms:
+    - aws
+`
+	if err := os.WriteFile(filepath.Join(workdir, "src/config/kola-denylist.yaml"), []byte(denylist), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ledger := filepath.Join(t.TempDir(), "kola-flakes.json")
+	l := &FlakeLedger{Platforms: map[string]map[string]*FlakeRecord{
+		"qemu": {
+			"ostree.hotfix":  {Runs: 3, Passes: 2, Failures: 1, ConsecutivePasses: 2},
+			"ostree.remount": {Runs: 3, Passes: 2, Failures: 1, ConsecutivePasses: 1},
+		},
+	}}
+	if err := l.save(ledger); err != nil {
+		t.Fatal(err)
+	}
+
+	defer func(workdir, stream, ledger string) {
+		Options.CosaWorkdir, DenylistStream, FlakeLedgerFile = workdir, stream, ledger
+		quarantinedTests, expiredQuarantines = nil, nil
+	}(Options.CosaWorkdir, DenylistStream, FlakeLedgerFile)
+	Options.CosaWorkdir = workdir
+	DenylistStream = "stable"
+	FlakeLedgerFile = ledger
+
+	if err := ParseDenyListYaml("qemu"); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]*register.Test{"ostree.hotfix": {}, "ostree.remount": {}, "basic": {}}
+	if err := expireQuarantines(tests, "qemu"); err != nil {
+		t.Fatal(err)
+	}
+	for name, expected := range map[string]bool{
+		"ostree.hotfix":  false, // passed twice in a row
+		"ostree.remount": true,
+		"ostree.new":     true,
+		"basic":          false, // only quarantined on aws
+	} {
+		if IsQuarantined(name) != expected {
+			t.Errorf("%s: expected quarantined=%v", name, expected)
+		}
+	}
+	if _, rerunnable := GetRerunnableTestName("ostree.remount"); rerunnable {
+		t.Errorf("quarantined tests should not be rerun")
+	}
+}
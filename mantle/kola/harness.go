@@ -23,6 +23,7 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -54,6 +55,7 @@ import (
 	"github.com/coreos/coreos-assembler/mantle/platform/machine/openstack"
 	"github.com/coreos/coreos-assembler/mantle/platform/machine/qemu"
 	"github.com/coreos/coreos-assembler/mantle/platform/machine/qemuiso"
+	"github.com/coreos/coreos-assembler/mantle/platform/machine/replay"
 	"github.com/coreos/coreos-assembler/mantle/system"
 	"github.com/coreos/coreos-assembler/mantle/util"
 )
@@ -115,12 +117,20 @@ var (
 	OpenStackOptions = openstackapi.Options{Options: &Options} // glue to set platform options from main
 	QEMUOptions      = qemu.Options{Options: &Options}         // glue to set platform options from main
 	QEMUIsoOptions   = qemuiso.Options{Options: &Options}      // glue to set platform options from main
+	ReplayOptions    = replay.Options{Options: &Options}       // glue to set platform options from main
 
 	CosaBuild *util.LocalBuild // this is a parsed cosa build
 
-	TestParallelism int    //glue var to set test parallelism from main
-	TAPFile         string // if not "", write TAP results here
-	NoNet           bool   // Disable tests requiring Internet
+	TestParallelism int //glue var to set test parallelism from main
+	// ResourceScheduling admits qemu tests by the host resources they
+	// need rather than by TestParallelism
+	ResourceScheduling bool
+	ResourceBudget     string // if not "", overrides detected host resources, e.g. "memory=48G,cpus=16"
+	TAPFile            string // if not "", write TAP results here
+	JUnitFile          string // if not "", write JUnit XML results here
+	StatusAddr         string // if not "", serve the progress of the run on this address
+	RecordSSH          bool   // record the SSH commands of tests, for the replay platform
+	NoNet              bool   // Disable tests requiring Internet
 	// ForceRunPlatformIndependent will cause tests that claim platform-independence to run
 	ForceRunPlatformIndependent bool
 
@@ -129,24 +139,30 @@ var (
 	DenylistedTests     []string // tests which are on the denylist
 	DenylistStream      string   //denylist-stream
 	WarnOnErrorTests    []string // denylisted tests we are going to run and warn in case of error
-	Tags                []string // tags to be ran
+	FlakeLedgerFile     string   // if not "", counts of test results across runs, for quarantines and `kola flakes`
 
-	// Sharding is a string of the form: hash:m/n where m and n are integers to run only tests which hash to m.
+	quarantinedTests   []quarantine    // denylisted tests we are going to run without failing the job
+	expiredQuarantines map[string]bool // quarantined tests which passed enough times in a row
+	Tags               []string        // tags to be ran
+
+	// Sharding is a string of the form: hash:m/n where m and n are integers to run only tests which hash to m,
+	// or duration:m/n to run the m-th of n shards balanced by DurationHistory,
+	// which sharded runs don't update.
 	Sharding string
+	// DurationHistory is a file recording how long tests take, to run the slowest first
+	DurationHistory string
 
 	extTestNum  = 1 // Assigns a unique number to each non-exclusive external test
 	testResults protectedTestResults
 
-	nonexclusivePrefixMatch  = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]/`)
-	nonexclusiveWrapperMatch = regexp.MustCompile(`^non-exclusive-test-bucket-[0-9]$`)
+	// Wrapper tests run other tests as subtests: buckets of non-exclusive
+	// tests, and tests sharing a fixture
+	wrapperPrefixMatch = regexp.MustCompile(`^(non-exclusive-test-bucket-[0-9]|fixture-[^/]+)/`)
+	wrapperMatch       = regexp.MustCompile(`^(non-exclusive-test-bucket-[0-9]|fixture-[^/]+)$`)
 
-	consoleChecks = []struct {
-		desc              string
-		match             *regexp.Regexp
-		warnOnly          bool
-		allowRerunSuccess bool
-		skipFlag          *register.Flag
-	}{
+	// defaultConsoleChecks can be changed by kola-console-checks.yaml,
+	// see LoadConsoleChecks()
+	defaultConsoleChecks = []consoleCheck{
 		{
 			desc:              "emergency shell",
 			match:             regexp.MustCompile("Press Enter for emergency shell|Starting Emergency Shell|You are in emergency mode"),
@@ -237,6 +253,9 @@ var (
 		},
 	}
 
+	// consoleChecks are the checks in effect
+	consoleChecks = defaultConsoleChecks
+
 	ErrWarnOnTestFail = errors.New("A test marked as warn:true failed.")
 )
 
@@ -305,6 +324,8 @@ func NewFlight(pltfrm string) (flight platform.Flight, err error) {
 		flight, err = qemu.NewFlight(&QEMUOptions)
 	case "qemu-iso":
 		flight, err = qemuiso.NewFlight(&QEMUIsoOptions)
+	case "replay":
+		flight, err = replay.NewFlight(&ReplayOptions)
 	default:
 		err = fmt.Errorf("invalid platform %q", pltfrm)
 	}
@@ -363,6 +384,9 @@ type DenyListObj struct {
 	SnoozeDate string   `yaml:"snooze"`
 	OsVersion  []string `yaml:"osversion"`
 	Warn       bool     `yaml:"warn"`
+	// Quarantine runs the test without failing the job until it
+	// passes this many times in a row.
+	Quarantine int `yaml:"quarantine"`
 }
 
 type ManifestData struct {
@@ -475,7 +499,10 @@ func ParseDenyListYaml(pltfrm string) error {
 			continue
 		}
 
-		if obj.SnoozeDate != "" {
+		if obj.Quarantine > 0 {
+			fmt.Printf("🚧 Quarantining kola test pattern \"%s\" until it passes %d times in a row\n", obj.Pattern, obj.Quarantine)
+			quarantinedTests = append(quarantinedTests, quarantine{pattern: obj.Pattern, passes: obj.Quarantine})
+		} else if obj.SnoozeDate != "" {
 			snoozeDate, err := time.Parse(snoozeFormat, obj.SnoozeDate)
 			if err != nil {
 				return err
@@ -507,6 +534,27 @@ func ParseDenyListYaml(pltfrm string) error {
 	return nil
 }
 
+// isAllowed returns whether item is in include, or include is empty, and
+// whether it is in exclude.
+func isAllowed(item string, include, exclude []string) (bool, bool) {
+	allowed, excluded := true, false
+	for _, i := range include {
+		if i == item {
+			allowed = true
+			break
+		} else {
+			allowed = false
+		}
+	}
+	for _, i := range exclude {
+		if i == item {
+			allowed = false
+			excluded = true
+		}
+	}
+	return allowed, excluded
+}
+
 func filterTests(tests map[string]*register.Test, patterns []string, pltfrm string) (map[string]*register.Test, error) {
 	r := make(map[string]*register.Test)
 
@@ -580,25 +628,6 @@ func filterTests(tests map[string]*register.Test, patterns []string, pltfrm stri
 			}
 		}
 
-		isAllowed := func(item string, include, exclude []string) (bool, bool) {
-			allowed, excluded := true, false
-			for _, i := range include {
-				if i == item {
-					allowed = true
-					break
-				} else {
-					allowed = false
-				}
-			}
-			for _, i := range exclude {
-				if i == item {
-					allowed = false
-					excluded = true
-				}
-			}
-			return allowed, excluded
-		}
-
 		// For now, we hardcode platform independent tests to run only on one platform.
 		// But in the future, we should optimize this so that an overall
 		// test planner/scheduler knows to run the test at most once or twice.
@@ -705,7 +734,10 @@ func filterDenylistedTests(tests map[string]*register.Test) (map[string]*registe
 // register tests in their init() function.  outputDir is where various test
 // logs and data will be written for analysis after the test run. If it already
 // exists it will be erased!
-func runProvidedTests(testsBank map[string]*register.Test, patterns []string, multiply int, rerun bool, rerunSuccessTags []string, pltfrm, outputDir string) error {
+//
+// junit, if not nil, is the JUnit reporter shared by the run and the rerun
+// of its failed tests, so that reruns are reported as further attempts.
+func runProvidedTests(testsBank map[string]*register.Test, patterns []string, multiply int, rerun bool, rerunSuccessTags []string, pltfrm, outputDir, sharding string, junit reporters.Reporter) error {
 	var versionStr string
 
 	// Avoid incurring cost of starting machine in getClusterSemver when
@@ -719,6 +751,12 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 	if err != nil {
 		plog.Fatal(err)
 	}
+	if err := expireQuarantines(testsBank, pltfrm); err != nil {
+		plog.Fatal(err)
+	}
+	if err := LoadConsoleChecks(pltfrm); err != nil {
+		plog.Fatal(err)
+	}
 
 	// Make sure all given patterns by the user match at least one test
 	for _, pattern := range patterns {
@@ -740,10 +778,18 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		plog.Fatalf("There are no matching tests to run on this architecture/platform: %s %s", Options.CosaBuildArch, pltfrm)
 	}
 
+	allowedTests := tests
 	tests, err = filterDenylistedTests(tests)
 	if err != nil {
 		plog.Fatal(err)
 	}
+	var denylisted []string
+	for name := range allowedTests {
+		if _, ok := tests[name]; !ok {
+			denylisted = append(denylisted, name)
+		}
+	}
+	sort.Strings(denylisted)
 
 	if len(tests) == 0 {
 		fmt.Printf("There are no tests to run because all tests are denylisted. Output in %v\n", outputDir)
@@ -804,6 +850,11 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		}
 	}
 
+	// Group the tests sharing a fixture
+	if err := addFixtureTests(tests, pltfrm, flight); err != nil {
+		plog.Fatal(err)
+	}
+
 	if multiply > 1 {
 		newTests := make(map[string]*register.Test)
 		for name, t := range tests {
@@ -819,34 +870,80 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 		tests = newTests
 	}
 
-	tests, err = shardTests(tests, Sharding)
+	var history *durationHistory
+	if DurationHistory != "" {
+		history, err = loadDurationHistory(DurationHistory)
+		if err != nil {
+			plog.Fatalf("Loading duration history: %v", err)
+		}
+	}
+
+	tests, err = shardTests(tests, sharding, history, pltfrm)
 	if err != nil {
 		plog.Fatalf("%v", err)
 	}
 
+	report := reporters.NewJSONReporter("report.json", pltfrm, versionStr)
+	report.Arch = Options.CosaBuildArch
+	if pltfrm == "qemu" {
+		report.Firmware = QEMUOptions.Firmware
+	}
+	report.Denylisted = denylisted
 	opts := harness.Options{
 		OutputDir: outputDir,
 		Parallel:  TestParallelism,
-		Sharding:  Sharding,
+		Sharding:  sharding,
 		Verbose:   true,
-		Reporters: reporters.Reporters{
-			reporters.NewJSONReporter("report.json", pltfrm, versionStr),
-		},
+		Reporters: reporters.Reporters{report},
+	}
+	if junit != nil {
+		opts.Reporters = append(opts.Reporters, junit)
+	}
+	if history != nil {
+		// start the slowest tests first so that they don't end up
+		// dominating the wall-clock time
+		opts.Order = longestFirst(history.expected(pltfrm, tests))
+	}
+
+	var sched *scheduler
+	if ResourceScheduling && pltfrm == "qemu" {
+		capacity, err := resourceBudget(ResourceBudget)
+		if err != nil {
+			plog.Fatalf("Resource scheduling: %v", err)
+		}
+		plog.Infof("Scheduling tests within %s", capacity)
+		sched = newScheduler(capacity)
+		// the scheduler decides when tests start
+		opts.Parallel = len(tests)
 	}
 
+	if StatusAddr != "" && statusSrv == nil {
+		statusSrv, err = startStatusServer(StatusAddr)
+		if err != nil {
+			plog.Fatalf("Status server: %v", err)
+		}
+	}
+	timeouts := make(map[string]time.Duration)
+	for _, test := range tests {
+		timeouts[test.Name] = (test.Timeout * time.Duration(100+(Options.ExtendTimeoutPercent))) / 100
+	}
+	statuses := statusSrv.addRun(outputDir, timeouts)
+
 	var htests harness.Tests
 	for _, test := range tests {
 		test := test // for the closure
+		status := statuses[test.Name]
 		run := func(h *harness.H) {
 			defer func() {
 				// Keep track of failed tests for a rerun
 				testResults.add(h)
+				status.done(h)
 			}()
 			// We launch a seperate cluster for each kola test
 			// At the end of the test, its cluster is destroyed
-			runTest(h, test, pltfrm, flight)
+			runTest(h, test, pltfrm, flight, sched, status)
 		}
-		htests.Add(test.Name, run, (test.Timeout*time.Duration(100+(Options.ExtendTimeoutPercent)))/100)
+		htests.Add(test.Name, run, timeouts[test.Name])
 	}
 
 	handleSuiteErrors := func(outputDir string, suiteErr error) error {
@@ -860,6 +957,16 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 			}
 		}
 
+		// After a rerun, this overwrites the report of the first run
+		// with one which also has the rerun attempts.
+		if JUnitFile != "" {
+			src := filepath.Join(outputDir, "reports", "report.xml")
+			err := system.CopyRegularFile(src, JUnitFile)
+			if suiteErr == nil && err != nil {
+				return err
+			}
+		}
+
 		if caughtTestError {
 			fmt.Printf("FAIL, output in %v\n", outputDir)
 		} else {
@@ -871,16 +978,28 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 
 	suite := harness.NewSuite(opts, htests)
 	runErr := suite.Run()
+	// a shard recording its durations would change the split of the
+	// shards running after it, so sharded runs only read the history
+	if history != nil && sharding == "" {
+		if err := history.record(pltfrm, filepath.Join(outputDir, "reports", "report.json")); err != nil {
+			plog.Warningf("Recording test durations: %v", err)
+		} else if err := history.save(DurationHistory); err != nil {
+			plog.Warningf("Saving test durations: %v", err)
+		}
+	}
 	runErr = handleSuiteErrors(outputDir, runErr)
 
 	detectedFailedWarnTrueTests := len(getWarnTrueFailedTests(testResults.getResults())) != 0
+	quarantinedFailedTests := getQuarantinedFailedTests(testResults.getResults())
 
 	testsToRerun := getRerunnable(testsBank, testResults.getResults())
 	numFailedTests := len(testsToRerun)
 	if len(testsToRerun) > 0 && rerun {
 		newOutputDir := filepath.Join(outputDir, "rerun")
 		fmt.Printf("\n\n======== Re-running failed tests (flake detection) ========\n\n")
-		reRunErr := runProvidedTests(testsToRerun, []string{"*"}, multiply, false, rerunSuccessTags, pltfrm, newOutputDir)
+		// the failed tests are all in this shard already, even if the
+		// non-exclusive ones are bucketed differently
+		reRunErr := runProvidedTests(testsToRerun, []string{"*"}, multiply, false, rerunSuccessTags, pltfrm, newOutputDir, "", junit)
 		if reRunErr == nil && allTestsAllowRerunSuccess(testsToRerun, rerunSuccessTags) {
 			runErr = nil       // reset to success since all tests allowed rerun success
 			numFailedTests = 0 // zero out the tally of failed tests
@@ -893,11 +1012,29 @@ func runProvidedTests(testsBank map[string]*register.Test, patterns []string, mu
 	// Return ErrWarnOnTestFail when ONLY tests with warn:true feature failed
 	if detectedFailedWarnTrueTests && numFailedTests == 0 {
 		return ErrWarnOnTestFail
+	} else if len(quarantinedFailedTests) > 0 && numFailedTests == 0 && runErr == harness.SuiteFailed {
+		// ONLY quarantined tests failed
+		fmt.Printf("Ignoring failures of quarantined tests: %s\n", strings.Join(quarantinedFailedTests, ", "))
+		return nil
 	} else {
 		return runErr
 	}
 }
 
+func getQuarantinedFailedTests(tests []*harness.H) []string {
+	var failed []string
+	for _, test := range tests {
+		if !test.Failed() {
+			continue
+		}
+		name := GetBaseTestName(test.Name())
+		if name != "" && IsQuarantined(name) {
+			failed = append(failed, name)
+		}
+	}
+	return failed
+}
+
 func getWarnTrueFailedTests(tests []*harness.H) []string {
 	var warnTrueFailedTests []string
 	for _, test := range tests {
@@ -945,15 +1082,15 @@ func allTestsAllowRerunSuccess(testsToRerun map[string]*register.Test, rerunSucc
 	return true
 }
 func GetBaseTestName(testName string) string {
-	// If this is a non-exclusive wrapper then just return the empty string
-	if nonexclusiveWrapperMatch.MatchString(testName) {
+	// If this is a wrapper test then just return the empty string
+	if wrapperMatch.MatchString(testName) {
 		return ""
 	}
 
 	// If the given test is a non-exclusive test with the prefix in
 	// the name we'll need to pull it apart. For example:
 	// non-exclusive-test-bucket-0/ext.config.files.license -> ext.config.files.license
-	substrings := nonexclusivePrefixMatch.Split(testName, 2)
+	substrings := wrapperPrefixMatch.Split(testName, 2)
 	return substrings[len(substrings)-1]
 }
 
@@ -969,8 +1106,8 @@ func GetRerunnableTestName(testName string) (string, bool) {
 		return "", false
 	}
 
-	// Tests with 'warn: true' are not rerunnable
-	if IsWarningOnFailure(name) {
+	// Tests with 'warn: true' or in quarantine are not rerunnable
+	if IsWarningOnFailure(name) || IsQuarantined(name) {
 		return "", false
 	}
 
@@ -997,10 +1134,11 @@ func getRerunnable(testsBank map[string]*register.Test, testResults []*harness.H
 	// First get the names of the tests that need to rerun
 	var testNamesToRerun []string
 	for _, h := range testResults {
-		// The current nonexclusive test wrapper would have all non-exclusive tests.
-		// We would add all those tests for rerunning if none of the non-exclusive
-		// subtests start due to some initial failure.
-		if nonexclusiveWrapperMatch.MatchString(h.Name()) && !h.GetNonExclusiveTestStarted() {
+		// The current wrapper test would have all non-exclusive tests, or all the
+		// tests sharing a fixture.
+		// We would add all those tests for rerunning if none of the subtests
+		// start due to some initial failure.
+		if wrapperMatch.MatchString(h.Name()) && !h.GetNonExclusiveTestStarted() {
 			if h.Failed() {
 				testNamesToRerun = append(testNamesToRerun, h.Subtests()...)
 			}
@@ -1024,11 +1162,25 @@ func getRerunnable(testsBank map[string]*register.Test, testResults []*harness.H
 }
 
 func RunTests(patterns []string, multiply int, rerun bool, rerunSuccessTags []string, pltfrm, outputDir string) error {
-	return runProvidedTests(register.Tests, patterns, multiply, rerun, rerunSuccessTags, pltfrm, outputDir)
+	defer recordFlakes(pltfrm, outputDir)
+	return runProvidedTests(register.Tests, patterns, multiply, rerun, rerunSuccessTags, pltfrm, outputDir, Sharding, newJUnitReporter(pltfrm))
 }
 
 func RunUpgradeTests(patterns []string, rerun bool, pltfrm, outputDir string) error {
-	return runProvidedTests(register.UpgradeTests, patterns, 0, rerun, nil, pltfrm, outputDir)
+	defer recordFlakes(pltfrm, outputDir)
+	return runProvidedTests(register.UpgradeTests, patterns, 0, rerun, nil, pltfrm, outputDir, Sharding, newJUnitReporter(pltfrm))
+}
+
+// newJUnitReporter returns a JUnit reporter if JUnitFile is set
+func newJUnitReporter(pltfrm string) reporters.Reporter {
+	if JUnitFile == "" {
+		return nil
+	}
+	var version string
+	if CosaBuild != nil {
+		version = CosaBuild.Meta.OstreeVersion
+	}
+	return reporters.NewJUnitReporter("report.xml", pltfrm, version)
 }
 
 // externalTestMeta is parsed from kola.json in external tests
@@ -1599,16 +1751,18 @@ mainloop:
 	return buckets
 }
 
-// shardTests filters tests to a particular shard - i.e. a group of tests
-// whose name hashes to the same value.
-func shardTests(tests map[string]*register.Test, sharding string) (map[string]*register.Test, error) {
+// shardTests filters tests to a particular shard. With hash:m/n, a shard
+// is a group of tests whose name hashes to the same value; with
+// duration:m/n, shards are balanced by the durations in history.
+func shardTests(tests map[string]*register.Test, sharding string, history *durationHistory, pltfrm string) (map[string]*register.Test, error) {
 	if sharding == "" {
 		return tests, nil
 	}
-	if !strings.HasPrefix(sharding, "hash:") {
+	mode, spec, ok := strings.Cut(sharding, ":")
+	if !ok || (mode != "hash" && mode != "duration") {
 		return nil, fmt.Errorf("invalid sharding syntax: %s", sharding)
 	}
-	parts := strings.SplitN(sharding[len("hash:"):], "/", 2)
+	parts := strings.SplitN(spec, "/", 2)
 	if len(parts) != 2 {
 		return nil, fmt.Errorf("invalid sharding syntax: %s", sharding)
 	}
@@ -1623,10 +1777,23 @@ func shardTests(tests map[string]*register.Test, sharding string) (map[string]*r
 	if mv > nv || nv < 1 || mv < 1 {
 		return nil, fmt.Errorf("invalid sharding in '%s'", sharding)
 	}
-	m := uint(mv)
-	n := uint(nv)
 
 	ret := make(map[string]*register.Test)
+	if mode == "duration" {
+		if history == nil {
+			return nil, fmt.Errorf("sharding by duration requires a duration history")
+		}
+		shard := durationShards(history.expected(pltfrm, tests), nv)[mv-1]
+		for name, test := range tests {
+			if shard[name] {
+				ret[name] = test
+			}
+		}
+		return ret, nil
+	}
+
+	m := uint(mv)
+	n := uint(nv)
 	for name, test := range tests {
 		h := fnv.New64()
 		h.Write([]byte(name))
@@ -1708,7 +1875,7 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 						// Collect the journal logs after execution is finished
 						defer collectLogsExternalTest(h, t, newTC)
 					}
-					if IsWarningOnFailure(t.Name) {
+					if IsWarningOnFailure(t.Name) || IsQuarantined(t.Name) {
 						newTC.H.WarningOnFailure()
 					}
 
@@ -1737,10 +1904,31 @@ func makeNonExclusiveTest(bucket int, tests []*register.Test, flight platform.Fl
 // runTest is a harness for running a single test.
 // outputDir is where various test logs and data will be written for
 // analysis after the test run. It should already exist.
-func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flight) {
+// If sched is not nil, the test waits for the host resources it needs.
+func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flight, sched *scheduler, status *testStatus) {
 	h.Parallel()
 	h.SetSubtests(t.Subtests)
 
+	earlyRelease := h.Release
+	if sched != nil {
+		need, err := testResources(t, &QEMUOptions, Options.CosaBuildArch)
+		if err != nil {
+			h.Fatalf("Estimating resources: %v", err)
+		}
+		// the duration of the test, for the history and the reports,
+		// starts once it is admitted
+		var release func()
+		h.Untimed(func() {
+			release = sched.acquire(t.Name, need)
+		})
+		defer release()
+		earlyRelease = func() {
+			release()
+			h.Release()
+		}
+	}
+	status.running(h)
+
 	rconf := &platform.RuntimeConfig{
 		AllowFailedUnits:   testSkipBaseChecks(t),
 		InternetAccess:     testRequiresInternet(t),
@@ -1750,7 +1938,8 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		OutputDir:          h.OutputDir(),
 		SSHOnTestFailure:   Options.SSHOnTestFailure,
 		WarningsAction:     conf.FailWarnings,
-		EarlyRelease:       h.Release,
+		EarlyRelease:       earlyRelease,
+		RecordSSH:          RecordSSH,
 	}
 	if t.HasFlag(register.AllowConfigWarnings) {
 		rconf.WarningsAction = conf.IgnoreWarnings
@@ -1761,9 +1950,19 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 	if err != nil {
 		h.Fatalf("Cluster failed: %v", err)
 	}
+	status.setCluster(c)
 	defer func() {
 		h.StopExecTimer()
+		if QEMUOptions.CollectCrashDumps {
+			collectCrashDumps(h, c)
+		}
+		status.setCluster(nil)
 		c.Destroy()
+		if r, ok := c.(replayChecker); ok {
+			if err := r.CheckReplayed(); err != nil {
+				h.Errorf("Replay: %v", err)
+			}
+		}
 		if h.TimedOut() {
 			// We'll allow tests that time out to succeed on rerun.
 			markTestForRerunSuccess(t, "Test timed out.")
@@ -1772,25 +1971,7 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 			plog.Debugf("Skipping base checks for %s", t.Name)
 			return
 		}
-		handleConsoleChecks := func(logtype, id, output string) {
-			warnOnly, badlines := CheckConsole([]byte(output), t)
-			if SkipConsoleWarnings {
-				warnOnly = true
-			}
-			for _, badline := range badlines {
-				if warnOnly {
-					plog.Warningf("Found %s on machine %s %s", badline, id, logtype)
-				} else {
-					h.Errorf("Found %s on machine %s %s", badline, id, logtype)
-				}
-			}
-		}
-		for id, output := range c.ConsoleOutput() {
-			handleConsoleChecks("console", id, output)
-		}
-		for id, output := range c.JournalOutput() {
-			handleConsoleChecks("journal", id, output)
-		}
+		checkClusterConsoles(h, t, c)
 	}()
 
 	if t.ClusterSize > 0 {
@@ -1846,7 +2027,7 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 		FailFast:    t.FailFast,
 	}
 
-	if IsWarningOnFailure(t.Name) {
+	if IsWarningOnFailure(t.Name) || IsQuarantined(t.Name) {
 		tcluster.H.WarningOnFailure()
 	}
 
@@ -1942,6 +2123,36 @@ func runTest(h *harness.H, t *register.Test, pltfrm string, flight platform.Flig
 	t.Run(tcluster)
 }
 
+// replayChecker is implemented by the clusters of the replay platform,
+// whose tests fail when they skip commands of the recording.
+type replayChecker interface {
+	CheckReplayed() error
+}
+
+// checkClusterConsoles fails the test if the console checks match the
+// console or journal of a machine of the cluster.
+func checkClusterConsoles(h *harness.H, t *register.Test, c platform.Cluster) {
+	handleConsoleChecks := func(logtype, id, output string) {
+		warnOnly, badlines := CheckConsole([]byte(output), t)
+		if SkipConsoleWarnings {
+			warnOnly = true
+		}
+		for _, badline := range badlines {
+			if warnOnly {
+				plog.Warningf("Found %s on machine %s %s", badline, id, logtype)
+			} else {
+				h.Errorf("Found %s on machine %s %s", badline, id, logtype)
+			}
+		}
+	}
+	for id, output := range c.ConsoleOutput() {
+		handleConsoleChecks("console", id, output)
+	}
+	for id, output := range c.JournalOutput() {
+		handleConsoleChecks("journal", id, output)
+	}
+}
+
 // ScpKolet searches for a kolet binary and copies it to the machines.
 // Write initially to a .partial file in the same directory and then
 // rename since systemd.path units may be watching and we don't want
@@ -1997,7 +2208,7 @@ func CheckConsole(output []byte, t *register.Test) (bool, []string) {
 		if check.skipFlag != nil && t != nil && t.HasFlag(*check.skipFlag) {
 			continue
 		}
-		match := check.match.FindSubmatch(output)
+		match := check.find(output)
 		if match != nil {
 			badline := check.desc
 			if len(match) > 1 {
@@ -0,0 +1,183 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// durationHistory has the duration of tests in previous runs, per
+// platform, in seconds. Each new duration is averaged with the recorded
+// one to smooth out noise.
+type durationHistory struct {
+	Platforms map[string]map[string]float64 `json:"platforms"`
+}
+
+// loadDurationHistory reads a history file; a missing file is an empty
+// history.
+func loadDurationHistory(path string) (*durationHistory, error) {
+	h := &durationHistory{Platforms: make(map[string]map[string]float64)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return h, nil
+	} else if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, h); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	if h.Platforms == nil {
+		h.Platforms = make(map[string]map[string]float64)
+	}
+	return h, nil
+}
+
+// save atomically replaces the history file at path.
+func (h *durationHistory) save(path string) error {
+	return saveJSON(path, h)
+}
+
+// saveJSON atomically replaces path with v in JSON, so that concurrent
+// runs don't leave it half-written.
+func saveJSON(path string, v interface{}) error {
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(append(b, '\n')); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// record adds the durations of the tests which passed in a report.json.
+// Non-exclusive tests are recorded under their own name, since the
+// buckets they run in change from one run to the next.
+func (h *durationHistory) record(platform, reportPath string) error {
+	report, err := reporters.DeserialiseReport(reportPath)
+	if err != nil {
+		return err
+	}
+	durations := h.Platforms[platform]
+	if durations == nil {
+		durations = make(map[string]float64)
+		h.Platforms[platform] = durations
+	}
+	for _, t := range report.Tests {
+		if t.Result != testresult.Pass || wrapperMatch.MatchString(t.Name) {
+			continue
+		}
+		name := wrapperPrefixMatch.ReplaceAllString(t.Name, "")
+		d := t.Duration.Seconds()
+		if prev, ok := durations[name]; ok {
+			d = (prev + d) / 2
+		}
+		durations[name] = d
+	}
+	return nil
+}
+
+// expected returns the expected duration of each test. The duration of a
+// wrapper test, e.g. a bucket of non-exclusive tests, is the sum of its
+// subtests. Tests with no history are expected to take the average time,
+// or a minute if there is no history at all.
+func (h *durationHistory) expected(platform string, tests map[string]*register.Test) map[string]time.Duration {
+	durations := h.Platforms[platform]
+	var total float64
+	for _, d := range durations {
+		total += d
+	}
+	average := time.Minute.Seconds()
+	if len(durations) > 0 {
+		average = total / float64(len(durations))
+	}
+	lookup := func(name string) float64 {
+		if d, ok := durations[name]; ok {
+			return d
+		}
+		return average
+	}
+
+	ret := make(map[string]time.Duration)
+	for name, t := range tests {
+		var d float64
+		if wrapperMatch.MatchString(name) {
+			for _, sub := range t.Subtests {
+				d += lookup(wrapperPrefixMatch.ReplaceAllString(sub, ""))
+			}
+		} else {
+			d = lookup(name)
+		}
+		ret[name] = time.Duration(d * float64(time.Second))
+	}
+	return ret
+}
+
+// longestFirst returns the names of tests from the longest expected to
+// the shortest, by name for equal durations.
+func longestFirst(expected map[string]time.Duration) []string {
+	names := make([]string, 0, len(expected))
+	for name := range expected {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		if expected[names[i]] != expected[names[j]] {
+			return expected[names[i]] > expected[names[j]]
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// durationShards splits tests into n shards of about the same expected
+// duration, by giving each test, longest first, to the shard with the
+// least work so far. The result only depends on the expected durations,
+// so all shards agree on it given the same history.
+func durationShards(expected map[string]time.Duration, n int) []map[string]bool {
+	shards := make([]map[string]bool, n)
+	totals := make([]time.Duration, n)
+	for i := range shards {
+		shards[i] = make(map[string]bool)
+	}
+	for _, name := range longestFirst(expected) {
+		min := 0
+		for i := 1; i < n; i++ {
+			if totals[i] < totals[min] {
+				min = i
+			}
+		}
+		shards[min][name] = true
+		totals[min] += expected[name]
+	}
+	return shards
+}
@@ -0,0 +1,149 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// writeReport writes a report.json like a kola run does.
+func writeReport(t *testing.T, dir string, results map[string]time.Duration) string {
+	t.Helper()
+	r := reporters.NewJSONReporter("report.json", "qemu", "41.20250101.0")
+	for name, d := range results {
+		result := testresult.Pass
+		if d == 0 {
+			result = testresult.Fail
+		}
+		r.ReportTest(name, nil, result, d, nil)
+	}
+	if err := r.Output(dir); err != nil {
+		t.Fatal(err)
+	}
+	return filepath.Join(dir, "report.json")
+}
+
+func TestDurationHistory(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "kola-durations.json")
+	h, err := loadDurationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := writeReport(t, t.TempDir(), map[string]time.Duration{
+		"basic":                               time.Minute,
+		"rpmostree.upgrade":                   8 * time.Minute,
+		"non-exclusive-test-bucket-0":         3 * time.Minute,
+		"non-exclusive-test-bucket-0/ext.a":   20 * time.Second,
+		"non-exclusive-test-bucket-0/ext.b":   40 * time.Second,
+		"ostree.hotfix":                       0, // failed
+		"non-exclusive-test-bucket-1/ext.new": 0, // failed
+	})
+	if err := h.record("qemu", report); err != nil {
+		t.Fatal(err)
+	}
+	report = writeReport(t, t.TempDir(), map[string]time.Duration{"basic": 3 * time.Minute})
+	if err := h.record("qemu", report); err != nil {
+		t.Fatal(err)
+	}
+	if err := h.save(path); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err = loadDurationHistory(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	durations := h.Platforms["qemu"]
+	if len(durations) != 4 || durations["basic"] != 120 || durations["ext.b"] != 40 {
+		t.Errorf("unexpected history: %v", durations)
+	}
+
+	expected := h.expected("qemu", map[string]*register.Test{
+		"basic":                       {},
+		"rpmostree.upgrade":           {},
+		"ostree.hotfix":               {},
+		"non-exclusive-test-bucket-1": {Subtests: []string{"non-exclusive-test-bucket-1/ext.a", "non-exclusive-test-bucket-1/ext.b"}},
+	})
+	// the average of the four recorded tests
+	if expected["ostree.hotfix"] != 165*time.Second {
+		t.Errorf("unexpected duration for a test without history: %s", expected["ostree.hotfix"])
+	}
+	if expected["non-exclusive-test-bucket-1"] != time.Minute {
+		t.Errorf("unexpected duration for a bucket: %s", expected["non-exclusive-test-bucket-1"])
+	}
+	order := strings.Join(longestFirst(expected), " ")
+	if order != "rpmostree.upgrade ostree.hotfix basic non-exclusive-test-bucket-1" {
+		t.Errorf("unexpected order: %s", order)
+	}
+
+	// without history, tests are spread evenly
+	expected = h.expected("aws", map[string]*register.Test{"a": {}, "b": {}, "c": {}, "d": {}})
+	for _, shard := range durationShards(expected, 2) {
+		if len(shard) != 2 {
+			t.Errorf("unbalanced shards: %v", shard)
+		}
+	}
+}
+
+func TestShardTests(t *testing.T) {
+	h := &durationHistory{Platforms: map[string]map[string]float64{
+		"qemu": {"a": 600, "b": 300, "c": 200, "d": 100, "e": 100},
+	}}
+	tests := make(map[string]*register.Test)
+	for name := range h.Platforms["qemu"] {
+		tests[name] = &register.Test{Name: name}
+	}
+
+	seen := make(map[string]bool)
+	for i, want := range []string{"a e", "b c d"} {
+		shard, err := shardTests(tests, fmt.Sprintf("duration:%d/2", i+1), h, "qemu")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var names []string
+		for _, name := range []string{"a", "b", "c", "d", "e"} {
+			if _, ok := shard[name]; ok {
+				names = append(names, name)
+				seen[name] = true
+			}
+		}
+		if strings.Join(names, " ") != want {
+			t.Errorf("shard %d: expected %s, got %v", i+1, want, names)
+		}
+	}
+	if len(seen) != len(tests) {
+		t.Errorf("tests missing from shards: %v", seen)
+	}
+
+	for _, sharding := range []string{"duration:0/2", "duration:3/2", "size:1/2", "duration:1"} {
+		if _, err := shardTests(tests, sharding, h, "qemu"); err == nil {
+			t.Errorf("%s: expected an error", sharding)
+		}
+	}
+	if _, err := shardTests(tests, "duration:1/2", nil, "qemu"); err == nil {
+		t.Errorf("expected an error without history")
+	}
+	if ret, err := shardTests(tests, "hash:1/1", nil, "qemu"); err != nil || len(ret) != len(tests) {
+		t.Errorf("hash sharding: %v %v", ret, err)
+	}
+}
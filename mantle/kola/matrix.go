@@ -0,0 +1,237 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+// States of the cells of a coverage matrix, besides the test results
+const (
+	stateDenylisted    = "denylisted"
+	stateNeverRun      = "never-run"
+	stateNotApplicable = "n/a"
+)
+
+// When a column has several runs of a test, the cell shows the first of
+// these states it reached: a test is covered if it passed in one of them.
+var matrixStateRank = map[string]int{
+	statePassed:     0,
+	stateFailed:     1,
+	stateSkipped:    2,
+	stateDenylisted: 3,
+}
+
+// MatrixColumn is a configuration that kola runs tests in.
+type MatrixColumn struct {
+	Platform string `json:"platform"`
+	Arch     string `json:"arch,omitempty"`
+	Firmware string `json:"firmware,omitempty"`
+}
+
+func (c MatrixColumn) String() string {
+	parts := []string{c.Platform}
+	for _, p := range []string{c.Arch, c.Firmware} {
+		if p != "" {
+			parts = append(parts, p)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+// Matrix is the coverage of tests by kola runs, with a row per test and
+// a column per configuration.
+type Matrix struct {
+	Columns []MatrixColumn `json:"columns"`
+	Rows    []MatrixRow    `json:"rows"`
+}
+
+// MatrixRow has the state of a test in each column of a Matrix.
+type MatrixRow struct {
+	Test  string   `json:"test"`
+	Cells []string `json:"cells"`
+}
+
+// BuildMatrix merges the report.json files of kola runs, which may be on
+// different platforms, architectures and streams, with the registered
+// tests. Tests which were not run in a column are "never-run", or "n/a"
+// if they don't apply to its platform, architecture or firmware.
+func BuildMatrix(tests map[string]*register.Test, reportPaths []string) (*Matrix, error) {
+	results := make(map[MatrixColumn]map[string]string)
+	names := make(map[string]bool)
+	for name := range tests {
+		names[name] = true
+	}
+	for _, path := range reportPaths {
+		report, err := reporters.DeserialiseReport(path)
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading %s", path)
+		}
+		col := MatrixColumn{
+			Platform: report.Platform,
+			Arch:     report.Arch,
+			Firmware: report.Firmware,
+		}
+		states := results[col]
+		if states == nil {
+			states = make(map[string]string)
+			results[col] = states
+		}
+		set := func(name, state string) {
+			names[name] = true
+			if old, ok := states[name]; !ok || matrixStateRank[state] < matrixStateRank[old] {
+				states[name] = state
+			}
+		}
+		for _, t := range report.Tests {
+			// the wrappers of tests and the subtests of native functions
+			// aren't rows of their own
+			name := GetBaseTestName(t.Name)
+			if name == "" || strings.Contains(name, "/") {
+				continue
+			}
+			switch t.Result {
+			case testresult.Pass:
+				set(name, statePassed)
+			case testresult.Skip:
+				set(name, stateSkipped)
+			default:
+				set(name, stateFailed)
+			}
+		}
+		for _, name := range report.Denylisted {
+			set(name, stateDenylisted)
+		}
+	}
+
+	m := &Matrix{}
+	for col := range results {
+		m.Columns = append(m.Columns, col)
+	}
+	sort.Slice(m.Columns, func(i, j int) bool {
+		a, b := m.Columns[i], m.Columns[j]
+		if a.Platform != b.Platform {
+			return a.Platform < b.Platform
+		}
+		if a.Arch != b.Arch {
+			return a.Arch < b.Arch
+		}
+		return a.Firmware < b.Firmware
+	})
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		row := MatrixRow{Test: name}
+		for _, col := range m.Columns {
+			state, ok := results[col][name]
+			if !ok {
+				state = stateNeverRun
+				if t := tests[name]; t != nil && !testAppliesTo(t, col) {
+					state = stateNotApplicable
+				}
+			}
+			row.Cells = append(row.Cells, state)
+		}
+		m.Rows = append(m.Rows, row)
+	}
+	return m, nil
+}
+
+// testAppliesTo returns whether kola would run the test in the column, as
+// filterTests decides for a run.
+func testAppliesTo(t *register.Test, col MatrixColumn) bool {
+	platforms := t.Platforms
+	if HasString(PlatformIndependentTag, t.Tags) {
+		platforms = []string{defaultPlatformIndependentPlatform}
+	}
+	if allowed, excluded := isAllowed(col.Platform, platforms, t.ExcludePlatforms); !allowed || excluded {
+		return false
+	}
+	if col.Arch != "" {
+		if allowed, _ := isAllowed(col.Arch, t.Architectures, t.ExcludeArchitectures); !allowed {
+			return false
+		}
+	}
+	if col.Platform == "qemu" && col.Firmware != "" {
+		if allowed, excluded := isAllowed(col.Firmware, t.Firmwares, t.ExcludeFirmwares); !allowed || excluded {
+			return false
+		}
+	}
+	return true
+}
+
+// WriteMarkdown writes the matrix as a Markdown table.
+func (m *Matrix) WriteMarkdown(w io.Writer) error {
+	header := []string{"Test"}
+	rule := []string{"---"}
+	for _, col := range m.Columns {
+		header = append(header, col.String())
+		rule = append(rule, "---")
+	}
+	if _, err := fmt.Fprintf(w, "| %s |\n| %s |\n", strings.Join(header, " | "), strings.Join(rule, " | ")); err != nil {
+		return err
+	}
+	for _, row := range m.Rows {
+		if _, err := fmt.Fprintf(w, "| %s | %s |\n", row.Test, strings.Join(row.Cells, " | ")); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var matrixTemplate = template.Must(template.New("matrix").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kola test matrix</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px; text-align: left; border: 1px solid #ccc; }
+.passed { background: #dfd; }
+.failed { background: #fdd; }
+.skipped, .denylisted { background: #ffd; }
+.never-run { background: #fcc; font-weight: bold; }
+.n\/a { color: #888; }
+</style>
+</head>
+<body>
+<table>
+<tr><th>Test</th>{{range .Columns}}<th>{{.}}</th>{{end}}</tr>
+{{range .Rows}}
+<tr><td>{{.Test}}</td>{{range .Cells}}<td class="{{.}}">{{.}}</td>{{end}}</tr>
+{{end}}
+</table>
+</body>
+</html>
+`))
+
+// WriteHTML writes the matrix as an HTML page.
+func (m *Matrix) WriteHTML(w io.Writer) error {
+	return matrixTemplate.Execute(w, m)
+}
@@ -0,0 +1,103 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"bytes"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/coreos/coreos-assembler/mantle/harness/reporters"
+	"github.com/coreos/coreos-assembler/mantle/harness/testresult"
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+)
+
+func TestBuildMatrix(t *testing.T) {
+	tests := map[string]*register.Test{
+		"basic":        {Name: "basic"},
+		"ostree.sync":  {Name: "ostree.sync"},
+		"qemu.only":    {Name: "qemu.only", Platforms: []string{"qemu"}},
+		"not.aarch64":  {Name: "not.aarch64", ExcludeArchitectures: []string{"aarch64"}},
+		"uefi.only":    {Name: "uefi.only", Firmwares: []string{"uefi"}},
+		"indep.thing":  {Name: "indep.thing", Tags: []string{PlatformIndependentTag}},
+		"denied.thing": {Name: "denied.thing"},
+	}
+
+	var paths []string
+	report := func(platform, arch, firmware string, results map[string]testresult.TestResult, denylisted ...string) {
+		dir := t.TempDir()
+		r := reporters.NewJSONReporter("report.json", platform, "41.20250101.0")
+		r.Arch = arch
+		r.Firmware = firmware
+		r.Denylisted = denylisted
+		for name, result := range results {
+			r.ReportTest(name, nil, result, 0, nil)
+		}
+		if err := r.Output(dir); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, filepath.Join(dir, "report.json"))
+	}
+	report("qemu", "x86_64", "uefi", map[string]testresult.TestResult{
+		"basic":                             testresult.Pass,
+		"basic/TestFoo":                     testresult.Pass,
+		"ostree.sync":                       testresult.Fail,
+		"non-exclusive-test-bucket-0":       testresult.Pass,
+		"non-exclusive-test-bucket-0/ext.a": testresult.Skip,
+	}, "denied.thing")
+	// the rerun of the failed test
+	report("qemu", "x86_64", "uefi", map[string]testresult.TestResult{
+		"ostree.sync": testresult.Pass,
+	})
+	report("aws", "aarch64", "", map[string]testresult.TestResult{
+		"basic": testresult.Fail,
+	})
+
+	m, err := BuildMatrix(tests, paths)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedColumns := []MatrixColumn{
+		{Platform: "aws", Arch: "aarch64"},
+		{Platform: "qemu", Arch: "x86_64", Firmware: "uefi"},
+	}
+	if !reflect.DeepEqual(m.Columns, expectedColumns) {
+		t.Errorf("got columns %v, expected %v", m.Columns, expectedColumns)
+	}
+	expectedRows := []MatrixRow{
+		{"basic", []string{stateFailed, statePassed}},
+		{"denied.thing", []string{stateNeverRun, stateDenylisted}},
+		{"ext.a", []string{stateNeverRun, stateSkipped}},
+		{"indep.thing", []string{stateNotApplicable, stateNeverRun}},
+		{"not.aarch64", []string{stateNotApplicable, stateNeverRun}},
+		{"ostree.sync", []string{stateNeverRun, statePassed}},
+		{"qemu.only", []string{stateNotApplicable, stateNeverRun}},
+		{"uefi.only", []string{stateNeverRun, stateNeverRun}},
+	}
+	if !reflect.DeepEqual(m.Rows, expectedRows) {
+		t.Errorf("got rows %v, expected %v", m.Rows, expectedRows)
+	}
+
+	var buf bytes.Buffer
+	if err := m.WriteMarkdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	expected := "| Test | aws/aarch64 | qemu/x86_64/uefi |\n| --- | --- | --- |\n| basic | failed | passed |\n"
+	if got := buf.String(); !strings.HasPrefix(got, expected) {
+		t.Errorf("unexpected markdown:\n%s", got)
+	}
+}
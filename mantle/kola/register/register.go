@@ -16,6 +16,7 @@ package register
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
@@ -121,6 +122,36 @@ type Test struct {
 	// If provided, this test will be run on the target instance type.
 	// This overrides the instance type set with `kola run`
 	InstanceType string
+
+	// If provided, this test runs on the machine of the registered Fixture
+	// of this name instead of a machine of its own. The machine is the
+	// fixture's, so the test cannot set the fields describing one, such as
+	// UserData, Flags, MinMemory or AdditionalDisks, nor NativeFuncs.
+	Fixture string
+}
+
+// Fixture is a machine that several tests can share, e.g. a node with a
+// LUKS root or with a local registry, so that it is provisioned once
+// rather than for each of them.
+type Fixture struct {
+	Name     string // should be unique
+	UserData *conf.UserData
+	// Setup, if not nil, runs once on the machine before the tests.
+	Setup func(cluster.TestCluster)
+	// Timeout of provisioning the machine and Setup
+	Timeout time.Duration
+
+	// Machine options, as for Test
+	MinMemory        int
+	PrimaryDisk      string
+	AppendKernelArgs string
+
+	// If true, each test gets a machine of its own booted from a snapshot
+	// of the fixture's disk, so it can change it freely. Snapshots are
+	// qcow2 overlays on QEMU; on other platforms, each test provisions
+	// the fixture itself. If false, the tests run one after another on
+	// the same machine and must leave it as they found it.
+	Snapshot bool
 }
 
 // Registered tests that run as part of `kola run` live here. Mapping of names
@@ -131,6 +162,9 @@ var Tests = map[string]*Test{}
 // names to tests.
 var UpgradeTests = map[string]*Test{}
 
+// Registered fixtures, by name.
+var Fixtures = map[string]*Fixture{}
+
 // Register is usually called via init() functions and is how kola test
 // harnesses knows which tests it can choose from. Panics if existing name is
 // registered
@@ -138,6 +172,9 @@ func Register(m map[string]*Test, t *Test) {
 	if len(t.Conflicts) > 0 && !t.NonExclusive {
 		panic("exclusive test cannot have non-empty conflicts entry")
 	}
+	if fields := fixtureConflicts(t); len(fields) > 0 {
+		panic(fmt.Sprintf("test %v uses a fixture and cannot set %v", t.Name, strings.Join(fields, ", ")))
+	}
 	_, ok := m[t.Name]
 	if ok {
 		panic(fmt.Sprintf("test %v already registered", t.Name))
@@ -145,6 +182,51 @@ func Register(m map[string]*Test, t *Test) {
 	m[t.Name] = t
 }
 
+// fixtureConflicts returns the fields set by a test using a fixture which
+// would be ignored, since its machine is the fixture's.
+func fixtureConflicts(t *Test) []string {
+	if t.Fixture == "" {
+		return nil
+	}
+	fields := []struct {
+		name string
+		set  bool
+	}{
+		{"UserData", t.UserData != nil},
+		{"NativeFuncs", len(t.NativeFuncs) > 0},
+		{"ClusterSize", t.ClusterSize > 1},
+		{"Flags", len(t.Flags) > 0},
+		{"MultiPathDisk", t.MultiPathDisk},
+		{"AdditionalDisks", len(t.AdditionalDisks) > 0},
+		{"PrimaryDisk", t.PrimaryDisk != ""},
+		{"InjectContainer", t.InjectContainer},
+		{"MinMemory", t.MinMemory != 0},
+		{"MinDiskSize", t.MinDiskSize != 0},
+		{"AdditionalNics", t.AdditionalNics != 0},
+		{"AppendKernelArgs", t.AppendKernelArgs != ""},
+		{"AppendFirstbootKernelArgs", t.AppendFirstbootKernelArgs != ""},
+		{"ExternalTest", t.ExternalTest != ""},
+		{"NonExclusive", t.NonExclusive},
+		{"InstanceType", t.InstanceType != ""},
+	}
+	var ret []string
+	for _, f := range fields {
+		if f.set {
+			ret = append(ret, f.name)
+		}
+	}
+	return ret
+}
+
+// RegisterFixture registers a fixture that tests can refer to by name.
+// Panics if a fixture of this name is already registered.
+func RegisterFixture(f *Fixture) {
+	if _, ok := Fixtures[f.Name]; ok {
+		panic(fmt.Sprintf("fixture %v already registered", f.Name))
+	}
+	Fixtures[f.Name] = f
+}
+
 func RegisterTest(t *Test) {
 	Register(Tests, t)
 }
@@ -0,0 +1,327 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform/machine/qemu"
+	"github.com/coreos/coreos-assembler/mantle/system"
+	"github.com/coreos/coreos-assembler/mantle/util"
+)
+
+const (
+	// memory used by qemu itself and its helpers (swtpm, virtiofsd) on
+	// top of the guest memory
+	qemuOverheadMiB = 256
+	// memory kept for kola and the rest of the host
+	hostReserveMiB = 1024
+	// disk space assumed for the overlay of a primary disk of unknown
+	// size; overlays only grow as the guest writes
+	defaultPrimaryDiskGiB = 2
+	// directory the qemu platform creates its disks in
+	qemuDiskDir = "/var/tmp"
+	// how long a queued test can be overtaken by tests which fit in the
+	// free capacity, before it holds them back until it can run
+	starvationTimeout = 5 * time.Minute
+)
+
+// Resources is an amount of host resources.
+type Resources struct {
+	MemoryMiB int64
+	CPUs      int64
+	DiskGiB   int64
+}
+
+func (r Resources) add(o Resources) Resources {
+	return Resources{r.MemoryMiB + o.MemoryMiB, r.CPUs + o.CPUs, r.DiskGiB + o.DiskGiB}
+}
+
+func (r Resources) sub(o Resources) Resources {
+	return Resources{r.MemoryMiB - o.MemoryMiB, r.CPUs - o.CPUs, r.DiskGiB - o.DiskGiB}
+}
+
+// fits returns whether r is no larger than o in any dimension.
+func (r Resources) fits(o Resources) bool {
+	return r.MemoryMiB <= o.MemoryMiB && r.CPUs <= o.CPUs && r.DiskGiB <= o.DiskGiB
+}
+
+func (r Resources) String() string {
+	return fmt.Sprintf("%dMiB memory, %d vCPUs, %dGiB disk", r.MemoryMiB, r.CPUs, r.DiskGiB)
+}
+
+// testResources estimates the host resources the machines of a test use
+// on the qemu platform, following the defaults of the platform.
+func testResources(t *register.Test, opts *qemu.Options, arch string) (Resources, error) {
+	machines := int64(t.ClusterSize)
+	// tests with no cluster usually bring up a machine themselves
+	if machines < 1 {
+		machines = 1
+	}
+
+	var memory int64
+	if opts.Memory != "" {
+		m, err := strconv.ParseInt(opts.Memory, 10, 32)
+		if err != nil {
+			return Resources{}, fmt.Errorf("parsing memory option: %w", err)
+		}
+		memory = m
+	} else if t.MinMemory != 0 {
+		memory = int64(t.MinMemory)
+	} else if opts.SecureExecution {
+		memory = 4096
+	} else {
+		memory = 1024
+		switch arch {
+		case "aarch64", "s390x", "ppc64le":
+			memory = 2048
+		}
+	}
+
+	disk := int64(defaultPrimaryDiskGiB)
+	if t.PrimaryDisk != "" {
+		size, _, err := util.ParseDiskSpec(t.PrimaryDisk, true)
+		if err != nil {
+			return Resources{}, fmt.Errorf("parsing primary disk spec '%s': %w", t.PrimaryDisk, err)
+		}
+		if size > 0 {
+			disk = size
+		}
+	}
+	if t.MinDiskSize > 0 {
+		disk = int64(t.MinDiskSize)
+	} else if opts.DiskSize != "" {
+		// qemu-img resize syntax, where +SIZE grows the image
+		size, err := parseSize(strings.TrimPrefix(opts.DiskSize, "+"))
+		if err != nil {
+			return Resources{}, fmt.Errorf("parsing disk size option: %w", err)
+		}
+		if strings.HasPrefix(opts.DiskSize, "+") {
+			disk += size / 1024
+		} else {
+			disk = size / 1024
+		}
+	}
+	for _, spec := range t.AdditionalDisks {
+		size, _, err := util.ParseDiskSpec(spec, false)
+		if err != nil {
+			return Resources{}, fmt.Errorf("parsing additional disk spec '%s': %w", spec, err)
+		}
+		disk += size
+	}
+
+	return Resources{
+		MemoryMiB: machines * (memory + qemuOverheadMiB),
+		CPUs:      machines,
+		DiskGiB:   machines * disk,
+	}, nil
+}
+
+// parseSize parses a size with an M, G or T suffix into MiB.
+func parseSize(s string) (int64, error) {
+	units := map[string]int64{"M": 1, "G": 1024, "T": 1024 * 1024}
+	if len(s) < 2 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := units[strings.ToUpper(s[len(s)-1:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: expected an M, G or T suffix", s)
+	}
+	n, err := strconv.ParseInt(s[:len(s)-1], 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return n * unit, nil
+}
+
+// resourceBudget returns the host resources tests can use, as detected
+// on the host and overridden by budget, e.g. "memory=48G,cpus=16,disk=200G".
+func resourceBudget(budget string) (Resources, error) {
+	overrides := make(map[string]string)
+	if budget != "" {
+		for _, kv := range strings.Split(budget, ",") {
+			split := strings.SplitN(kv, "=", 2)
+			if len(split) != 2 {
+				return Resources{}, fmt.Errorf("invalid resource budget %q: expected KEY=VALUE", kv)
+			}
+			switch split[0] {
+			case "memory", "cpus", "disk":
+				overrides[split[0]] = split[1]
+			default:
+				return Resources{}, fmt.Errorf("unknown resource %q; expected memory, cpus or disk", split[0])
+			}
+		}
+	}
+
+	var r Resources
+	if v, ok := overrides["memory"]; ok {
+		m, err := parseSize(v)
+		if err != nil {
+			return Resources{}, err
+		}
+		r.MemoryMiB = m
+	} else {
+		available, err := system.GetAvailableMemory()
+		if err != nil {
+			return Resources{}, fmt.Errorf("detecting available memory: %w", err)
+		}
+		r.MemoryMiB = int64(available/(1024*1024)) - hostReserveMiB
+	}
+	if v, ok := overrides["cpus"]; ok {
+		n, err := strconv.ParseInt(v, 10, 32)
+		if err != nil || n < 1 {
+			return Resources{}, fmt.Errorf("invalid CPU count %q", v)
+		}
+		r.CPUs = n
+	} else {
+		n, err := system.GetProcessors()
+		if err != nil {
+			return Resources{}, fmt.Errorf("detecting CPU count: %w", err)
+		}
+		r.CPUs = int64(n)
+	}
+	if v, ok := overrides["disk"]; ok {
+		m, err := parseSize(v)
+		if err != nil {
+			return Resources{}, err
+		}
+		r.DiskGiB = m / 1024
+	} else {
+		var st syscall.Statfs_t
+		if err := syscall.Statfs(qemuDiskDir, &st); err != nil {
+			return Resources{}, fmt.Errorf("detecting free space in %s: %w", qemuDiskDir, err)
+		}
+		r.DiskGiB = int64(st.Bavail) * st.Bsize / (1024 * 1024 * 1024)
+	}
+	return r, nil
+}
+
+// scheduler admits tests as long as the resources they need are free.
+// Tests are admitted in any order that fits, except that a test queued
+// for longer than starvationTimeout holds back the tests queued after
+// it. A test needing more than the whole capacity runs alone.
+type scheduler struct {
+	capacity Resources
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	used    Resources
+	running int
+	queue   []*schedulerWaiter
+}
+
+type schedulerWaiter struct {
+	need  Resources
+	since time.Time
+}
+
+func newScheduler(capacity Resources) *scheduler {
+	s := &scheduler{capacity: capacity}
+	s.cond = sync.NewCond(&s.mutex)
+	return s
+}
+
+// acquire blocks until test name can use need and returns a function
+// releasing the resources, which can be called several times.
+func (s *scheduler) acquire(name string, need Resources) func() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if !need.fits(s.capacity) {
+		plog.Warningf("%s needs %s, more than the %s available; running it alone", name, need, s.capacity)
+	}
+	w := &schedulerWaiter{need: need, since: time.Now()}
+	s.queue = append(s.queue, w)
+	queued := false
+	for !s.admissible(w) {
+		if !queued {
+			plog.Infof("Queueing %s: %s", name, s.reason(w))
+			queued = true
+		}
+		s.cond.Wait()
+	}
+	for i, o := range s.queue {
+		if o == w {
+			s.queue = append(s.queue[:i], s.queue[i+1:]...)
+			break
+		}
+	}
+	if queued {
+		plog.Infof("Starting %s after waiting %s", name, time.Since(w.since).Round(time.Second))
+	}
+	s.used = s.used.add(need)
+	s.running++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			s.mutex.Lock()
+			s.used = s.used.sub(need)
+			s.running--
+			s.mutex.Unlock()
+			s.cond.Broadcast()
+		})
+	}
+}
+
+// admissible returns whether w can start now. Must be called with the
+// mutex held.
+func (s *scheduler) admissible(w *schedulerWaiter) bool {
+	if s.starvedBy(w) != nil {
+		return false
+	}
+	if s.running == 0 {
+		return true
+	}
+	return s.used.add(w.need).fits(s.capacity)
+}
+
+// starvedBy returns the waiter queued before w for too long, if any.
+func (s *scheduler) starvedBy(w *schedulerWaiter) *schedulerWaiter {
+	for _, o := range s.queue {
+		if o == w {
+			return nil
+		}
+		if time.Since(o.since) > starvationTimeout {
+			return o
+		}
+	}
+	return nil
+}
+
+// reason describes why w can't start now.
+func (s *scheduler) reason(w *schedulerWaiter) string {
+	if o := s.starvedBy(w); o != nil {
+		return fmt.Sprintf("a test needing %s has been waiting for %s", o.need, time.Since(o.since).Round(time.Second))
+	}
+	free := s.capacity.sub(s.used)
+	var short []string
+	if w.need.MemoryMiB > free.MemoryMiB {
+		short = append(short, fmt.Sprintf("needs %dMiB memory, %dMiB free", w.need.MemoryMiB, free.MemoryMiB))
+	}
+	if w.need.CPUs > free.CPUs {
+		short = append(short, fmt.Sprintf("needs %d vCPUs, %d free", w.need.CPUs, free.CPUs))
+	}
+	if w.need.DiskGiB > free.DiskGiB {
+		short = append(short, fmt.Sprintf("needs %dGiB disk, %dGiB free", w.need.DiskGiB, free.DiskGiB))
+	}
+	return fmt.Sprintf("%s (%d tests running)", strings.Join(short, "; "), s.running)
+}
@@ -0,0 +1,134 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/register"
+	"github.com/coreos/coreos-assembler/mantle/platform/machine/qemu"
+)
+
+func TestTestResources(t *testing.T) {
+	for _, tc := range []struct {
+		test     register.Test
+		opts     qemu.Options
+		arch     string
+		expected Resources
+	}{
+		{register.Test{ClusterSize: 1}, qemu.Options{}, "x86_64", Resources{1024 + qemuOverheadMiB, 1, defaultPrimaryDiskGiB}},
+		{register.Test{ClusterSize: 0}, qemu.Options{}, "aarch64", Resources{2048 + qemuOverheadMiB, 1, defaultPrimaryDiskGiB}},
+		{register.Test{ClusterSize: 3, MinMemory: 4096}, qemu.Options{}, "x86_64", Resources{3 * (4096 + qemuOverheadMiB), 3, 3 * defaultPrimaryDiskGiB}},
+		{register.Test{ClusterSize: 1, MinMemory: 4096}, qemu.Options{Memory: "8192"}, "x86_64", Resources{8192 + qemuOverheadMiB, 1, defaultPrimaryDiskGiB}},
+		{register.Test{ClusterSize: 1, PrimaryDisk: "20G:mpath", AdditionalDisks: []string{"5G", "1G:serial=foo"}}, qemu.Options{}, "x86_64", Resources{1024 + qemuOverheadMiB, 1, 26}},
+		{register.Test{ClusterSize: 1, MinDiskSize: 16}, qemu.Options{DiskSize: "+10G"}, "x86_64", Resources{1024 + qemuOverheadMiB, 1, 16}},
+		{register.Test{ClusterSize: 1}, qemu.Options{DiskSize: "+10G"}, "x86_64", Resources{1024 + qemuOverheadMiB, 1, defaultPrimaryDiskGiB + 10}},
+	} {
+		r, err := testResources(&tc.test, &tc.opts, tc.arch)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if r != tc.expected {
+			t.Errorf("%+v: expected %s, got %s", tc.test, tc.expected, r)
+		}
+	}
+}
+
+func TestResourceBudget(t *testing.T) {
+	r, err := resourceBudget("memory=48G,cpus=16,disk=200G")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r != (Resources{48 * 1024, 16, 200}) {
+		t.Errorf("unexpected budget %s", r)
+	}
+	for _, budget := range []string{"memory=48", "cpus=0", "gpus=1", "disk"} {
+		if _, err := resourceBudget(budget); err == nil {
+			t.Errorf("%s: expected an error", budget)
+		}
+	}
+}
+
+// started returns whether acquire has returned for the given channel
+// within a short time.
+func started(c chan func()) (func(), bool) {
+	select {
+	case release := <-c:
+		return release, true
+	case <-time.After(100 * time.Millisecond):
+		return nil, false
+	}
+}
+
+func TestScheduler(t *testing.T) {
+	s := newScheduler(Resources{MemoryMiB: 4096, CPUs: 4, DiskGiB: 100})
+	acquire := func(name string, need Resources) chan func() {
+		c := make(chan func(), 1)
+		go func() {
+			c <- s.acquire(name, need)
+		}()
+		return c
+	}
+
+	small := Resources{MemoryMiB: 1024, CPUs: 1, DiskGiB: 10}
+	release1, ok := started(acquire("small1", small))
+	if !ok {
+		t.Fatal("small1 should start")
+	}
+	release2, ok := started(acquire("small2", small))
+	if !ok {
+		t.Fatal("small2 should start")
+	}
+
+	// not enough memory until two small tests are done
+	bigc := acquire("big", Resources{MemoryMiB: 3072, CPUs: 1, DiskGiB: 10})
+	if _, ok := started(bigc); ok {
+		t.Fatal("big should be queued")
+	}
+	// smaller tests can still start meanwhile
+	release3, ok := started(acquire("small3", small))
+	if !ok {
+		t.Fatal("small3 should start")
+	}
+	release1()
+	release1() // releasing twice is harmless
+	if _, ok := started(bigc); ok {
+		t.Fatal("big should still be queued")
+	}
+	release2()
+	releaseBig, ok := started(bigc)
+	if !ok {
+		t.Fatal("big should start once enough memory is free")
+	}
+	release3()
+	releaseBig()
+
+	// a test larger than the host runs alone
+	release1, _ = started(acquire("small1", small))
+	hugec := acquire("huge", Resources{MemoryMiB: 8192, CPUs: 1, DiskGiB: 10})
+	if _, ok := started(hugec); ok {
+		t.Fatal("huge should wait for the other tests")
+	}
+	release1()
+	releaseHuge, ok := started(hugec)
+	if !ok {
+		t.Fatal("huge should start alone")
+	}
+	if _, ok := started(acquire("small2", small)); ok {
+		t.Fatal("nothing should start next to huge")
+	}
+	releaseHuge()
+}
@@ -0,0 +1,320 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"html/template"
+	"net"
+	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/harness"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// Test states shown on the status page
+const (
+	stateQueued  = "queued"
+	stateRunning = "running"
+	statePassed  = "passed"
+	stateFailed  = "failed"
+	stateSkipped = "skipped"
+)
+
+// statusServer serves the progress of kola runs: a run and the rerun of
+// its failed tests, each with its own output directory.
+type statusServer struct {
+	mutex sync.Mutex
+	root  string // output directory served under /output/
+	runs  []*runStatus
+}
+
+type runStatus struct {
+	outputDir string
+	start     time.Time
+	tests     map[string]*testStatus
+}
+
+// testStatus tracks a test of a run. Its methods can be called on nil,
+// when there is no status server.
+type testStatus struct {
+	server    *statusServer
+	name      string
+	timeout   time.Duration
+	state     string
+	start     time.Time
+	end       time.Time
+	outputDir string
+	cluster   platform.Cluster
+}
+
+// JSON of the status endpoint
+type statusJSON struct {
+	Runs []runJSON `json:"runs"`
+}
+
+type runJSON struct {
+	OutputDir string         `json:"outputDir"`
+	Start     time.Time      `json:"start"`
+	Elapsed   float64        `json:"elapsed"`
+	Counts    map[string]int `json:"counts"`
+	Tests     []testJSON     `json:"tests"`
+}
+
+type testJSON struct {
+	Name     string        `json:"name"`
+	State    string        `json:"state"`
+	Elapsed  float64       `json:"elapsed,omitempty"`
+	Timeout  float64       `json:"timeout,omitempty"`
+	Machines []machineJSON `json:"machines,omitempty"`
+}
+
+type machineJSON struct {
+	ID      string `json:"id"`
+	Console string `json:"console"`
+	Journal string `json:"journal"`
+}
+
+// statusSrv is the status server of this process, if --status-addr is set
+var statusSrv *statusServer
+
+// startStatusServer serves the status page on addr until kola exits.
+func startStatusServer(addr string) (*statusServer, error) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	s := &statusServer{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", s.serveHTML)
+	mux.HandleFunc("/status.json", s.serveJSON)
+	mux.HandleFunc("/output/", s.serveOutput)
+	go func() {
+		if err := http.Serve(l, mux); err != nil {
+			plog.Errorf("Status server: %v", err)
+		}
+	}()
+	plog.Noticef("Serving test status on http://%s/", l.Addr())
+	return s, nil
+}
+
+// addRun starts tracking the tests of a run, all queued at first, and
+// returns them by name. The server can be nil.
+func (s *statusServer) addRun(outputDir string, timeouts map[string]time.Duration) map[string]*testStatus {
+	ret := make(map[string]*testStatus)
+	if s == nil {
+		return ret
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	r := &runStatus{
+		outputDir: outputDir,
+		start:     time.Now(),
+		tests:     ret,
+	}
+	if s.root == "" {
+		s.root = outputDir
+	}
+	for name, timeout := range timeouts {
+		ret[name] = &testStatus{
+			server:  s,
+			name:    name,
+			timeout: timeout,
+			state:   stateQueued,
+		}
+	}
+	s.runs = append(s.runs, r)
+	return ret
+}
+
+// running marks the test as started, with its output in h.OutputDir().
+func (t *testStatus) running(h *harness.H) {
+	if t == nil {
+		return
+	}
+	t.server.mutex.Lock()
+	defer t.server.mutex.Unlock()
+	t.state = stateRunning
+	t.start = time.Now()
+	t.outputDir = h.OutputDir()
+}
+
+// setCluster records the cluster whose machines the test owns.
+func (t *testStatus) setCluster(c platform.Cluster) {
+	if t == nil {
+		return
+	}
+	t.server.mutex.Lock()
+	defer t.server.mutex.Unlock()
+	t.cluster = c
+}
+
+// done records the result of the test.
+func (t *testStatus) done(h *harness.H) {
+	if t == nil {
+		return
+	}
+	t.server.mutex.Lock()
+	defer t.server.mutex.Unlock()
+	switch {
+	case h.Failed():
+		t.state = stateFailed
+	case h.Skipped():
+		t.state = stateSkipped
+	default:
+		t.state = statePassed
+	}
+	if t.start.IsZero() {
+		t.start = time.Now()
+	}
+	t.end = time.Now()
+	t.cluster = nil
+}
+
+func (s *statusServer) status() statusJSON {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	now := time.Now()
+	var ret statusJSON
+	for _, r := range s.runs {
+		run := runJSON{
+			OutputDir: r.outputDir,
+			Start:     r.start,
+			Elapsed:   now.Sub(r.start).Seconds(),
+			Counts:    make(map[string]int),
+		}
+		for _, t := range r.tests {
+			run.Counts[t.state]++
+			test := testJSON{
+				Name:    t.name,
+				State:   t.state,
+				Timeout: t.timeout.Seconds(),
+			}
+			if t.state == stateRunning {
+				test.Elapsed = now.Sub(t.start).Seconds()
+			} else if !t.end.IsZero() {
+				test.Elapsed = t.end.Sub(t.start).Seconds()
+			}
+			if t.cluster != nil {
+				rel, err := filepath.Rel(s.root, t.outputDir)
+				if err != nil {
+					rel = t.name
+				}
+				for _, m := range t.cluster.Machines() {
+					dir := path.Join("/output", filepath.ToSlash(rel), m.ID())
+					test.Machines = append(test.Machines, machineJSON{
+						ID:      m.ID(),
+						Console: dir + "/console.txt",
+						Journal: dir + "/journal.txt",
+					})
+				}
+			}
+			run.Tests = append(run.Tests, test)
+		}
+		// running tests first, then queued ones, then finished ones
+		order := map[string]int{stateRunning: 0, stateQueued: 1, stateFailed: 2, statePassed: 3, stateSkipped: 3}
+		sort.Slice(run.Tests, func(i, j int) bool {
+			a, b := run.Tests[i], run.Tests[j]
+			if order[a.State] != order[b.State] {
+				return order[a.State] < order[b.State]
+			}
+			return a.Name < b.Name
+		})
+		ret.Runs = append(ret.Runs, run)
+	}
+	return ret
+}
+
+func (s *statusServer) serveJSON(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(s.status()); err != nil {
+		plog.Warningf("Status server: %v", err)
+	}
+}
+
+// serveOutput serves the files of the output directory of the first run,
+// which has the ones of the rerun in its rerun/ subdirectory.
+func (s *statusServer) serveOutput(w http.ResponseWriter, r *http.Request) {
+	s.mutex.Lock()
+	root := s.root
+	s.mutex.Unlock()
+	if root == "" {
+		http.NotFound(w, r)
+		return
+	}
+	http.StripPrefix("/output", http.FileServer(http.Dir(root))).ServeHTTP(w, r)
+}
+
+var statusTemplate = template.Must(template.New("status").Funcs(template.FuncMap{
+	"duration": func(secs float64) string {
+		if secs == 0 {
+			return "-"
+		}
+		return (time.Duration(secs) * time.Second).String()
+	},
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<meta http-equiv="refresh" content="5">
+<title>kola</title>
+<style>
+body { font-family: sans-serif; }
+table { border-collapse: collapse; }
+td, th { padding: 2px 12px; text-align: left; }
+.running { background: #def; }
+.failed { background: #fdd; }
+.passed { color: #080; }
+.skipped { color: #888; }
+</style>
+</head>
+<body>
+{{range .Runs}}
+<h2>{{.OutputDir}}</h2>
+<p>Elapsed {{duration .Elapsed}}:
+{{index .Counts "running"}} running, {{index .Counts "queued"}} queued,
+{{index .Counts "passed"}} passed, {{index .Counts "failed"}} failed,
+{{index .Counts "skipped"}} skipped
+(<a href="/status.json">JSON</a>)</p>
+<table>
+<tr><th>Test</th><th>State</th><th>Elapsed</th><th>Timeout</th><th>Machines</th></tr>
+{{range .Tests}}
+<tr class="{{.State}}"><td>{{.Name}}</td><td>{{.State}}</td><td>{{duration .Elapsed}}</td><td>{{duration .Timeout}}</td>
+<td>{{range .Machines}}{{.ID}} (<a href="{{.Console}}">console</a>, <a href="{{.Journal}}">journal</a>) {{end}}</td></tr>
+{{end}}
+</table>
+{{end}}
+</body>
+</html>
+`))
+
+func (s *statusServer) serveHTML(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := statusTemplate.Execute(w, s.status()); err != nil {
+		plog.Warningf("Status server: %v", err)
+	}
+}
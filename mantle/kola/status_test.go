@@ -0,0 +1,93 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package kola
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestStatusServer(t *testing.T) {
+	outputDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outputDir, "test.tap"), []byte("ok 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	s := &statusServer{}
+	tests := s.addRun(outputDir, map[string]time.Duration{
+		"basic":         10 * time.Minute,
+		"ostree.hotfix": 5 * time.Minute,
+		"fips.enable":   time.Minute,
+	})
+	now := time.Now()
+	tests["basic"].state = stateRunning
+	tests["basic"].start = now.Add(-time.Minute)
+	tests["fips.enable"].state = statePassed
+	tests["fips.enable"].start = now.Add(-30 * time.Second)
+	tests["fips.enable"].end = now
+
+	rec := httptest.NewRecorder()
+	s.serveJSON(rec, httptest.NewRequest("GET", "/status.json", nil))
+	var status statusJSON
+	if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Runs) != 1 {
+		t.Fatalf("expected 1 run, got %d", len(status.Runs))
+	}
+	run := status.Runs[0]
+	if run.Counts[stateRunning] != 1 || run.Counts[stateQueued] != 1 || run.Counts[statePassed] != 1 {
+		t.Errorf("unexpected counts %v", run.Counts)
+	}
+	var names []string
+	for _, test := range run.Tests {
+		names = append(names, test.Name)
+	}
+	if strings.Join(names, " ") != "basic ostree.hotfix fips.enable" {
+		t.Errorf("unexpected order %v", names)
+	}
+	if basic := run.Tests[0]; basic.Elapsed < 60 || basic.Timeout != 600 {
+		t.Errorf("unexpected basic %+v", basic)
+	}
+	if fips := run.Tests[2]; fips.Elapsed != 30 {
+		t.Errorf("unexpected fips.enable %+v", fips)
+	}
+
+	rec = httptest.NewRecorder()
+	s.serveHTML(rec, httptest.NewRequest("GET", "/", nil))
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), "ostree.hotfix") {
+		t.Errorf("unexpected page %d: %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	s.serveOutput(rec, httptest.NewRequest("GET", "/output/test.tap", nil))
+	if rec.Body.String() != "ok 1\n" {
+		t.Errorf("unexpected output file %d: %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestStatusWithoutServer(t *testing.T) {
+	var s *statusServer
+	tests := s.addRun("out", map[string]time.Duration{"basic": time.Minute})
+	// these are no-ops
+	tests["basic"].running(nil)
+	tests["basic"].setCluster(nil)
+	tests["basic"].done(nil)
+}
@@ -31,11 +31,18 @@ import (
 
 // init runs when the package is imported and takes care of registering tests
 func init() {
+	// The podman tests share a booted node, each on a snapshot of it since
+	// they leave containers, images and networks behind.
+	register.RegisterFixture(&register.Fixture{
+		Name:     "podman",
+		Snapshot: true,
+	})
 	register.RegisterTest(&register.Test{
 		Run:         podmanBaseTest,
 		ClusterSize: 1,
 		Name:        `podman.base`,
 		Description: "Verify podman info and running with various options work.",
+		Fixture:     "podman",
 	})
 	// These remaining tests use networking, and hence don't work reliably on RHCOS
 	// right now due to due to https://bugzilla.redhat.com/show_bug.cgi?id=1757572
@@ -47,6 +54,7 @@ func init() {
 		Tags:        []string{kola.NeedsInternetTag}, // For pulling nginx
 		Distros:     []string{"fcos"},
 		FailFast:    true,
+		Fixture:     "podman",
 	})
 	register.RegisterTest(&register.Test{
 		Run:         podmanNetworksReliably,
@@ -57,6 +65,7 @@ func init() {
 		Tags:    []string{kola.NeedsInternetTag},
 		Distros: []string{"fcos"},
 		Timeout: 20 * time.Minute,
+		Fixture: "podman",
 	})
 	// https://github.com/coreos/mantle/pull/1080
 	// register.RegisterTest(&register.Test{
@@ -0,0 +1,250 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//	http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+package util
+
+import (
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+
+	"github.com/coreos/coreos-assembler/mantle/kola/cluster"
+	"github.com/coreos/coreos-assembler/mantle/network/ntp"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// Fault is a failure which InjectFaults injects into a QEMU machine, e.g.
+//
+//	heal := util.InjectFaults(c, m, util.DiskThrottle("disk-2", 1<<20, 0), util.LinkDown("eth1"))
+//	defer heal()
+type Fault struct {
+	// Name describes the fault in the test log
+	Name   string
+	inject func(m platform.QEMUMachine) error
+	// nil if the fault can't be undone
+	heal func(m platform.QEMUMachine) error
+}
+
+// InjectFaults injects faults into m in order, and returns a function
+// which heals them in reverse order, skipping those which can't be
+// healed. The test fails if a fault can't be injected or healed.
+func InjectFaults(c cluster.TestCluster, m platform.Machine, faults ...Fault) (heal func()) {
+	qm, ok := m.(platform.QEMUMachine)
+	if !ok {
+		c.Fatalf("Fault injection requires a QEMU machine")
+	}
+	var injected []Fault
+	for _, f := range faults {
+		c.Logf("Injecting fault into %s: %s", m.ID(), f.Name)
+		if err := f.inject(qm); err != nil {
+			c.Fatalf("Injecting fault %s: %v", f.Name, err)
+		}
+		injected = append(injected, f)
+	}
+	return func() {
+		for i := len(injected) - 1; i >= 0; i-- {
+			f := injected[i]
+			if f.heal == nil {
+				continue
+			}
+			c.Logf("Healing fault of %s: %s", m.ID(), f.Name)
+			if err := f.heal(qm); err != nil {
+				c.Fatalf("Healing fault %s: %v", f.Name, err)
+			}
+		}
+	}
+}
+
+// DiskUnplug hot-unplugs the disk with the given drive id, "disk-1" for the
+// primary disk and "disk-2" and on for additional disks. It can't be healed.
+func DiskUnplug(drive string) Fault {
+	return Fault{
+		Name: fmt.Sprintf("unplug %s", drive),
+		inject: func(m platform.QEMUMachine) error {
+			return m.UnplugDisk(drive)
+		},
+	}
+}
+
+// DiskThrottle limits the disk with the given drive id to bps bytes and
+// iops operations per second, 0 meaning unlimited.
+func DiskThrottle(drive string, bps, iops int64) Fault {
+	return Fault{
+		Name: fmt.Sprintf("throttle %s to %d bps and %d iops", drive, bps, iops),
+		inject: func(m platform.QEMUMachine) error {
+			return m.ThrottleDisk(drive, bps, iops)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			return m.ThrottleDisk(drive, 0, 0)
+		},
+	}
+}
+
+// LinkDown takes down the link of the NIC with the given netdev id, e.g.
+// "eth1" for the first additional NIC. Taking down "eth0" cuts kola's SSH
+// connection to the machine.
+func LinkDown(netdev string) Fault {
+	return Fault{
+		Name: fmt.Sprintf("link down on %s", netdev),
+		inject: func(m platform.QEMUMachine) error {
+			return m.SetLinkUp(netdev, false)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			return m.SetLinkUp(netdev, true)
+		},
+	}
+}
+
+// PacketLoss drops percent of the packets going in and out of the given
+// interface of the guest, e.g. "eth1". QEMU can't drop packets, so this is
+// done with nftables in the guest, over SSH.
+func PacketLoss(iface string, percent int) Fault {
+	table := fmt.Sprintf("kola-loss-%s", iface)
+	return Fault{
+		Name: fmt.Sprintf("%d%% packet loss on %s", percent, iface),
+		inject: func(m platform.QEMUMachine) error {
+			cmd := fmt.Sprintf(`sudo nft -f - <<EOF
+table inet %[1]s {
+	chain input {
+		type filter hook input priority 0;
+		iifname "%[2]s" numgen random mod 100 < %[3]d drop
+	}
+	chain output {
+		type filter hook output priority 0;
+		oifname "%[2]s" numgen random mod 100 < %[3]d drop
+	}
+}
+EOF`, table, iface, percent)
+			return sshFault(m, cmd)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			return sshFault(m, fmt.Sprintf("sudo nft delete table inet %s", table))
+		},
+	}
+}
+
+// ClockJump moves the time served by the NTP server of the private network
+// by offset. It requires --qemu-private-network, and affects all the
+// machines of the cluster. Otherwise the guest would keep following the
+// public NTP servers it reaches through the usermode NIC, so chronyd is
+// restarted with the private NTP server as its only source; healing puts
+// its configuration back. Since chronyd steps the clock only in its first
+// updates after starting and slews afterwards, the guest follows quickly.
+func ClockJump(offset time.Duration) Fault {
+	return Fault{
+		Name: fmt.Sprintf("clock jump by %v", offset),
+		inject: func(m platform.QEMUMachine) error {
+			s, err := ntpServer(m)
+			if err != nil {
+				return err
+			}
+			s.SetTime(time.Now().Add(offset))
+			return usePrivateNTP(m)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			s, err := ntpServer(m)
+			if err != nil {
+				return err
+			}
+			s.SetTime(time.Time{})
+			return restoreNTP(m)
+		},
+	}
+}
+
+// LeapSecond announces a leap second at second, which must be midnight UTC
+// on the first day of a month, from the NTP server of the private network.
+// Like ClockJump, it requires --qemu-private-network and makes chronyd use
+// only the private NTP server until healed.
+func LeapSecond(second time.Time, direction ntp.LeapIndicator) Fault {
+	return Fault{
+		Name: fmt.Sprintf("%v leap second at %v", direction, second),
+		inject: func(m platform.QEMUMachine) error {
+			s, err := ntpServer(m)
+			if err != nil {
+				return err
+			}
+			s.SetLeapSecond(second, direction)
+			return usePrivateNTP(m)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			s, err := ntpServer(m)
+			if err != nil {
+				return err
+			}
+			s.SetLeapSecond(time.Time{}, ntp.LEAP_NONE)
+			return restoreNTP(m)
+		},
+	}
+}
+
+// MemoryPressure inflates the balloon so that the guest is left with mib
+// MiB of memory. The machine must be created with the Balloon option.
+func MemoryPressure(mib int) Fault {
+	return Fault{
+		Name: fmt.Sprintf("memory pressure down to %d MiB", mib),
+		inject: func(m platform.QEMUMachine) error {
+			return m.SetBalloon(mib)
+		},
+		heal: func(m platform.QEMUMachine) error {
+			// QEMU caps the target at the memory of the machine
+			return m.SetBalloon(math.MaxInt32)
+		},
+	}
+}
+
+func ntpServer(m platform.QEMUMachine) (*ntp.Server, error) {
+	s := m.NTPServer()
+	if s == nil {
+		return nil, errors.New("clock faults require --qemu-private-network")
+	}
+	return s, nil
+}
+
+// chronyConfBackup keeps the chrony configuration of the guest while
+// usePrivateNTP is in effect. Faults sharing it set it up only once.
+const chronyConfBackup = "/etc/chrony.conf.kola-orig"
+
+// usePrivateNTP restarts chronyd in the guest with the NTP server of the
+// private network as its only source, dropping the pools, servers and
+// DHCP-provided sources of the original configuration.
+func usePrivateNTP(m platform.QEMUMachine) error {
+	cmd := fmt.Sprintf(`set -e
+if ! sudo test -e %[1]s; then
+	sudo cp -p /etc/chrony.conf %[1]s
+	sudo sed -i -E '/^(pool|server|peer|sourcedir)[[:space:]]/d' /etc/chrony.conf
+	echo 'server %[2]s iburst prefer' | sudo tee -a /etc/chrony.conf >/dev/null
+	sudo systemctl restart chronyd
+fi`, chronyConfBackup, m.NTPServerIP())
+	return sshFault(m, cmd)
+}
+
+// restoreNTP undoes usePrivateNTP, if it is still in effect.
+func restoreNTP(m platform.QEMUMachine) error {
+	cmd := fmt.Sprintf(`set -e
+if sudo test -e %[1]s; then
+	sudo mv %[1]s /etc/chrony.conf
+	sudo systemctl restart chronyd
+fi`, chronyConfBackup)
+	return sshFault(m, cmd)
+}
+
+func sshFault(m platform.QEMUMachine, cmd string) error {
+	_, stderr, err := m.SSH(cmd)
+	if err != nil {
+		return errors.Wrapf(err, "running %q: %s", cmd, stderr)
+	}
+	return nil
+}
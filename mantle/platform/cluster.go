@@ -21,6 +21,7 @@ import (
 	"net"
 	"os"
 	"path/filepath"
+	"sort"
 	"sync"
 
 	"github.com/pkg/errors"
@@ -37,6 +38,8 @@ type BaseCluster struct {
 	machserial uint
 	machmap    map[string]Machine
 	consolemap map[string]string
+	machindex  map[string]int // order in which the machines were added or requested, see orderMachines
+	recording  SSHRecording
 
 	bf    *BaseFlight
 	name  string
@@ -54,6 +57,7 @@ func NewBaseCluster(bf *BaseFlight, rconf *RuntimeConfig) (*BaseCluster, error)
 		bf:         bf,
 		machmap:    make(map[string]Machine),
 		consolemap: make(map[string]string),
+		machindex:  make(map[string]int),
 		name:       fmt.Sprintf("%s-%s", bf.baseopts.BaseName, uuid.New()),
 		rconf:      rconf,
 	}
@@ -115,6 +119,8 @@ func (bc *BaseCluster) SSH(m Machine, cmd string) ([]byte, []byte, error) {
 	return outBytes, errBytes, err
 }
 
+// Machines returns the machines of the cluster in the order of their
+// index, see MachineIndex.
 func (bc *BaseCluster) Machines() []Machine {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
@@ -122,9 +128,45 @@ func (bc *BaseCluster) Machines() []Machine {
 	for _, m := range bc.machmap {
 		machs = append(machs, m)
 	}
+	sort.Slice(machs, func(i, j int) bool {
+		return bc.machindex[machs[i].ID()] < bc.machindex[machs[j].ID()]
+	})
 	return machs
 }
 
+// MachineIndex returns the index of a machine in the cluster: the order
+// it was requested in for machines created together by NewMachines, and
+// the order it was added in otherwise. Unlike the order machines come up
+// in, it is the same across runs, so recordings can refer to it.
+func (bc *BaseCluster) MachineIndex(m Machine) int {
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	return bc.machindex[m.ID()]
+}
+
+// orderMachines renumbers machines created together, which were added in
+// the order they came up in, so that their indexes follow the order they
+// were requested in. Commands already recorded on them are updated.
+func (bc *BaseCluster) orderMachines(machs []Machine) {
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	indexes := make([]int, 0, len(machs))
+	for _, m := range machs {
+		indexes = append(indexes, bc.machindex[m.ID()])
+	}
+	sort.Ints(indexes)
+	renumbered := make(map[int]int, len(machs))
+	for i, m := range machs {
+		renumbered[bc.machindex[m.ID()]] = indexes[i]
+		bc.machindex[m.ID()] = indexes[i]
+	}
+	for i, c := range bc.recording.Commands {
+		if n, ok := renumbered[c.Machine]; ok {
+			bc.recording.Commands[i].Machine = n
+		}
+	}
+}
+
 func (bc *BaseCluster) appendSSH(m Machine) error {
 	sshConfig, err := os.OpenFile(filepath.Join(bc.rconf.OutputDir, "ssh-config"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
@@ -161,6 +203,9 @@ func (bc *BaseCluster) AddMach(m Machine) {
 	bc.machlock.Lock()
 	defer bc.machlock.Unlock()
 	bc.machmap[m.ID()] = m
+	if _, ok := bc.machindex[m.ID()]; !ok {
+		bc.machindex[m.ID()] = len(bc.machindex)
+	}
 
 	if err := bc.appendSSH(m); err != nil {
 		panic(err)
@@ -288,6 +333,11 @@ func (bc *BaseCluster) Destroy() {
 		bc.numMachines--
 		m.Destroy()
 	}
+	if bc.rconf.RecordSSH {
+		if err := bc.writeSSHRecording(); err != nil {
+			plog.Errorf("Writing SSH recording: %v", err)
+		}
+	}
 }
 
 func (bc *BaseCluster) Distribution() string {
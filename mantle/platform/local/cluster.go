@@ -15,9 +15,6 @@
 package local
 
 import (
-	"fmt"
-
-	"github.com/vishvananda/netlink"
 	"github.com/vishvananda/netns"
 
 	"github.com/coreos/coreos-assembler/mantle/lang/destructor"
@@ -37,36 +34,8 @@ func (lc *LocalCluster) NewCommand(name string, arg ...string) exec.Cmd {
 	return cmd
 }
 
-func (lc *LocalCluster) NewTap(bridge string) (tap *TunTap, err error) {
-	nsExit, err := ns.Enter(lc.flight.nshandle)
-	if err != nil {
-		return nil, err
-	}
-	defer func() {
-		err = nsExit()
-	}()
-
-	tap, err = AddLinkTap("")
-	if err != nil {
-		return nil, fmt.Errorf("tap failed: %v", err)
-	}
-
-	err = netlink.LinkSetUp(tap)
-	if err != nil {
-		return nil, fmt.Errorf("tap up failed: %v", err)
-	}
-
-	br, err := netlink.LinkByName(bridge)
-	if err != nil {
-		return nil, fmt.Errorf("bridge failed: %v", err)
-	}
-
-	err = netlink.LinkSetMaster(tap, br.(*netlink.Bridge))
-	if err != nil {
-		return nil, fmt.Errorf("set master failed: %v", err)
-	}
-
-	return tap, nil
+func (lc *LocalCluster) NewTap(bridge string) (*TunTap, error) {
+	return lc.flight.Network.NewTap(bridge)
 }
 
 func (lc *LocalCluster) GetNsHandle() netns.NsHandle {
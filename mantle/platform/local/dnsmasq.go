@@ -17,6 +17,7 @@ package local
 import (
 	"fmt"
 	"net"
+	"sync"
 	"text/template"
 
 	"github.com/coreos/pkg/capnslog"
@@ -28,6 +29,7 @@ import (
 
 type Interface struct {
 	HardwareAddr net.HardwareAddr
+	Hostname     string // resolvable in the domain of the segment
 	DHCPv4       []net.IPNet
 	DHCPv6       []net.IPNet
 	//SLAAC net.IPAddr
@@ -38,11 +40,13 @@ type Segment struct {
 	BridgeIf   *Interface
 	Interfaces []*Interface
 	nextIf     int
+	free       []*Interface // interfaces given back with PutInterface
 }
 
 type Dnsmasq struct {
 	Segments []*Segment
 	dnsmasq  *exec.ExecCmd
+	mutex    sync.Mutex
 }
 
 const (
@@ -86,7 +90,7 @@ dhcp-range={{.IP}},ra-names,slaac
 {{end}}
 
 {{range .Interfaces}}
-dhcp-host={{.HardwareAddr}}{{template "ips" .DHCPv4}}{{template "ips" .DHCPv6}}
+dhcp-host={{.HardwareAddr}}{{template "ips" .DHCPv4}}{{template "ips" .DHCPv6}},{{.Hostname}}
 {{end}}
 {{end}}
 
@@ -99,6 +103,7 @@ var plog = capnslog.NewPackageLogger("github.com/coreos/coreos-assembler/mantle"
 func newInterface(s byte, i uint16) *Interface {
 	return &Interface{
 		HardwareAddr: net.HardwareAddr{0x02, s, 0, 0, byte(i / 256), byte(i % 256)},
+		Hostname:     fmt.Sprintf("node%d", i),
 		DHCPv4: []net.IPNet{{
 			IP:   net.IP{10, s, byte(i / 256), byte(i % 256)},
 			Mask: net.CIDRMask(16, 32)}},
@@ -208,8 +213,15 @@ func NewDnsmasq() (*Dnsmasq, error) {
 }
 
 func (dm *Dnsmasq) GetInterface(bridge string) (in *Interface) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
 	for _, seg := range dm.Segments {
 		if bridge == seg.BridgeName {
+			if len(seg.free) > 0 {
+				in = seg.free[len(seg.free)-1]
+				seg.free = seg.free[:len(seg.free)-1]
+				return
+			}
 			if seg.nextIf >= len(seg.Interfaces) {
 				panic("Not enough interfaces!")
 			}
@@ -221,6 +233,20 @@ func (dm *Dnsmasq) GetInterface(bridge string) (in *Interface) {
 	panic("Not a valid bridge!")
 }
 
+// PutInterface gives back an interface of GetInterface once its machine
+// is gone, for the next machines on the segment.
+func (dm *Dnsmasq) PutInterface(bridge string, in *Interface) {
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+	for _, seg := range dm.Segments {
+		if bridge == seg.BridgeName {
+			seg.free = append(seg.free, in)
+			return
+		}
+	}
+	panic("Not a valid bridge!")
+}
+
 func (dm *Dnsmasq) Destroy() {
 	if err := dm.dnsmasq.Kill(); err != nil {
 		plog.Errorf("Error killing dnsmasq: %v", err)
@@ -16,13 +16,9 @@
 package local
 
 import (
-	"github.com/vishvananda/netns"
-
 	"github.com/coreos/coreos-assembler/mantle/lang/destructor"
 	"github.com/coreos/coreos-assembler/mantle/network"
-	"github.com/coreos/coreos-assembler/mantle/network/ntp"
 	"github.com/coreos/coreos-assembler/mantle/platform"
-	"github.com/coreos/coreos-assembler/mantle/system/ns"
 )
 
 const (
@@ -32,57 +28,30 @@ const (
 type LocalFlight struct {
 	destructor.MultiDestructor
 	*platform.BaseFlight
-	Dnsmasq    *Dnsmasq
-	NTPServer  *ntp.Server
-	nshandle   netns.NsHandle
+	*Network
 	listenPort int32
 }
 
 func NewLocalFlight(opts *platform.Options, platformName platform.Name) (*LocalFlight, error) {
-	nshandle, err := ns.Create()
+	lnet, err := NewNetwork()
 	if err != nil {
 		return nil, err
 	}
 
-	nsdialer := network.NewNsDialer(nshandle)
+	nsdialer := network.NewNsDialer(lnet.nshandle)
 	bf, err := platform.NewBaseFlightWithDialer(opts, platformName, nsdialer)
 	if err != nil {
-		nshandle.Close()
+		lnet.Destroy()
 		return nil, err
 	}
 
 	lf := &LocalFlight{
 		BaseFlight: bf,
-		nshandle:   nshandle,
+		Network:    lnet,
 		listenPort: listenPortBase,
 	}
 	lf.AddDestructor(lf.BaseFlight)
-	lf.AddCloser(&lf.nshandle)
-
-	// dnsmasq and etcd must be launched in the new namespace
-	nsExit, err := ns.Enter(lf.nshandle)
-	if err != nil {
-		lf.Destroy()
-		return nil, err
-	}
-	defer func() {
-		_ = nsExit()
-	}()
-
-	lf.Dnsmasq, err = NewDnsmasq()
-	if err != nil {
-		lf.Destroy()
-		return nil, err
-	}
-	lf.AddDestructor(lf.Dnsmasq)
-
-	lf.NTPServer, err = ntp.NewServer(":123")
-	if err != nil {
-		lf.Destroy()
-		return nil, err
-	}
-	lf.AddCloser(lf.NTPServer)
-	go lf.NTPServer.Serve()
+	lf.AddDestructor(lf.Network)
 
 	return lf, nil
 }
@@ -0,0 +1,119 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package local
+
+import (
+	"fmt"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+
+	"github.com/coreos/coreos-assembler/mantle/lang/destructor"
+	"github.com/coreos/coreos-assembler/mantle/network/ntp"
+	"github.com/coreos/coreos-assembler/mantle/system/ns"
+)
+
+// Network is a network namespace with the bridges of a Dnsmasq and an NTP
+// server, which machines join through tap devices. Unlike a LocalFlight,
+// it has no SSH agent or clusters of its own, so other platforms can give
+// one to each of their clusters.
+type Network struct {
+	destructor.MultiDestructor
+	Dnsmasq   *Dnsmasq
+	NTPServer *ntp.Server
+	nshandle  netns.NsHandle
+}
+
+// NewNetwork creates a network namespace and starts dnsmasq and the NTP
+// server in it. This requires root.
+func NewNetwork() (*Network, error) {
+	nshandle, err := ns.Create()
+	if err != nil {
+		return nil, err
+	}
+	n := &Network{
+		nshandle: nshandle,
+	}
+	n.AddCloser(&n.nshandle)
+
+	// dnsmasq and the NTP server must be launched in the new namespace
+	nsExit, err := ns.Enter(n.nshandle)
+	if err != nil {
+		n.Destroy()
+		return nil, err
+	}
+	defer func() {
+		_ = nsExit()
+	}()
+
+	n.Dnsmasq, err = NewDnsmasq()
+	if err != nil {
+		n.Destroy()
+		return nil, err
+	}
+	n.AddDestructor(n.Dnsmasq)
+
+	n.NTPServer, err = ntp.NewServer(":123")
+	if err != nil {
+		n.Destroy()
+		return nil, err
+	}
+	n.AddCloser(n.NTPServer)
+	go n.NTPServer.Serve()
+
+	return n, nil
+}
+
+// NewTap creates a tap device attached to a bridge of the network.
+func (n *Network) NewTap(bridge string) (tap *TunTap, err error) {
+	nsExit, err := ns.Enter(n.nshandle)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		err = nsExit()
+	}()
+
+	tap, err = AddLinkTap("")
+	if err != nil {
+		return nil, fmt.Errorf("tap failed: %v", err)
+	}
+
+	err = netlink.LinkSetUp(tap)
+	if err != nil {
+		return nil, fmt.Errorf("tap up failed: %v", err)
+	}
+
+	br, err := netlink.LinkByName(bridge)
+	if err != nil {
+		return nil, fmt.Errorf("bridge failed: %v", err)
+	}
+
+	err = netlink.LinkSetMaster(tap, br.(*netlink.Bridge))
+	if err != nil {
+		return nil, fmt.Errorf("set master failed: %v", err)
+	}
+
+	return tap, nil
+}
+
+// NsHandle returns the network namespace.
+func (n *Network) NsHandle() netns.NsHandle {
+	return n.nshandle
+}
+
+func (n *Network) Destroy() {
+	n.MultiDestructor.Destroy()
+}
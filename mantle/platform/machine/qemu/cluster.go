@@ -29,6 +29,7 @@ import (
 
 	"github.com/coreos/coreos-assembler/mantle/platform"
 	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+	"github.com/coreos/coreos-assembler/mantle/platform/local"
 	"github.com/coreos/coreos-assembler/mantle/util"
 )
 
@@ -39,6 +40,9 @@ import (
 type Cluster struct {
 	*platform.BaseCluster
 	flight *flight
+	// the network namespace with the bridge of the private network, if
+	// enabled; each cluster has its own so clusters can't reach each other
+	privnet *local.Network
 
 	mu          sync.Mutex
 	tearingDown bool
@@ -181,6 +185,7 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	if options.OverrideBackingFile != "" {
 		primaryDisk.BackingFile = options.OverrideBackingFile
 	}
+	primaryDisk.LinkFile = options.SaveDisk
 
 	if err = builder.AddBootDisk(&primaryDisk); err != nil {
 		return nil, err
@@ -197,6 +202,8 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		}
 		builder.EnableUsermodeNetworking(h, "")
 	}
+	builder.UsermodeIPFamily = qc.flight.opts.UsermodeIPFamily
+	builder.UsermodeIPv6Net = qc.flight.opts.UsermodeIPv6Net
 	if options.AdditionalNics > 0 {
 		builder.AddAdditionalNics(options.AdditionalNics)
 	}
@@ -212,9 +219,21 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	if options.Firmware != "" {
 		builder.Firmware = options.Firmware
 	}
+	builder.Balloon = options.Balloon
+	builder.Framebuffer = options.Framebuffer
+	if qc.flight.opts.Screenshots {
+		builder.ScreenshotDir = filepath.Join(dir, "screenshots")
+	}
+
+	if qc.privnet != nil {
+		if err := qc.addPrivateNic(builder, qm); err != nil {
+			return nil, err
+		}
+	}
 
 	inst, err := builder.Exec()
 	if err != nil {
+		qm.releasePrivateNic()
 		return nil, err
 	}
 	qm.inst = inst
@@ -231,6 +250,11 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 		return nil, err
 	}
 
+	if qc.flight.opts.CollectCrashDumps {
+		qm.done = make(chan struct{})
+		go qm.watchConsole(dir)
+	}
+
 	// Run StartMachine, which blocks on the machine being booted up enough
 	// for SSH access, but only if the caller didn't tell us not to.
 	if !options.SkipStartMachine {
@@ -256,8 +280,26 @@ func (qc *Cluster) NewMachineWithQemuOptions(userdata *conf.UserData, options pl
 	return qm, nil
 }
 
+// addPrivateNic connects the machine to the bridge of the private network
+// of the cluster, where dnsmasq gives it a fixed address and hostname.
+func (qc *Cluster) addPrivateNic(builder *platform.QemuBuilder, qm *machine) error {
+	dm := qc.privnet.Dnsmasq
+	bridge := dm.Segments[0].BridgeName
+	tap, err := qc.privnet.NewTap(bridge)
+	if err != nil {
+		return errors.Wrapf(err, "creating tap device")
+	}
+	qm.tap = tap
+	qm.privateIf = dm.GetInterface(bridge)
+	builder.AddTapNic(tap.File, qm.privateIf.HardwareAddr)
+	return nil
+}
+
 func (qc *Cluster) Destroy() {
 	qc.tearingDown = true
 	qc.BaseCluster.Destroy()
+	if qc.privnet != nil {
+		qc.privnet.Destroy()
+	}
 	qc.flight.DelCluster(qc)
 }
@@ -0,0 +1,71 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+var (
+	kernelPanicMatch = regexp.MustCompile(`Kernel panic - not syncing`)
+	kernelBootMatch  = regexp.MustCompile(`Linux version \d`)
+)
+
+// watchConsole dumps the guest memory in dir the first time a kernel panic
+// appears on the console, then watches for a kernel booting after it, e.g.
+// kdump's, until the machine is destroyed.
+func (m *machine) watchConsole(dir string) {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	var panicEnd int
+	for {
+		select {
+		case <-m.done:
+			return
+		case <-ticker.C:
+		}
+		buf, err := os.ReadFile(m.consolePath)
+		if err != nil {
+			continue
+		}
+
+		m.crashLock.Lock()
+		if !m.panicked {
+			if loc := kernelPanicMatch.FindIndex(buf); loc != nil {
+				m.panicked = true
+				panicEnd = loc[1]
+				path := filepath.Join(dir, "memory.kdump")
+				plog.Warningf("Kernel panic on machine %s, dumping its memory to %s", m.id, path)
+				if err := m.inst.DumpGuestMemory(path); err != nil {
+					plog.Errorf("Dumping memory of machine %s: %v", m.id, err)
+				}
+			}
+		} else if kernelBootMatch.Match(buf[panicEnd:]) {
+			m.rebooted = true
+			m.crashLock.Unlock()
+			return
+		}
+		m.crashLock.Unlock()
+	}
+}
+
+func (m *machine) KernelPanicked() (panicked, rebooted bool) {
+	m.crashLock.Lock()
+	defer m.crashLock.Unlock()
+	return m.panicked, m.rebooted
+}
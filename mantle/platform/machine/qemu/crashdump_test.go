@@ -0,0 +1,66 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package qemu
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+func TestWatchConsole(t *testing.T) {
+	dir := t.TempDir()
+	m := &machine{
+		id:          "test",
+		inst:        &platform.QemuInstance{}, // without QMP, the dump fails
+		consolePath: filepath.Join(dir, "console.txt"),
+		done:        make(chan struct{}),
+	}
+	appendConsole := func(s string) {
+		f, err := os.OpenFile(m.consolePath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	waitFor := func(panicked, rebooted bool) {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			if p, r := m.KernelPanicked(); p == panicked && r == rebooted {
+				return
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		p, r := m.KernelPanicked()
+		t.Fatalf("expected panicked=%v rebooted=%v, got %v %v", panicked, rebooted, p, r)
+	}
+
+	appendConsole("[    0.000000] Linux version 6.12.0\n")
+	go m.watchConsole(dir)
+	defer close(m.done)
+	time.Sleep(1500 * time.Millisecond)
+	waitFor(false, false)
+
+	appendConsole("[   42.000000] Kernel panic - not syncing: sysrq triggered crash\n")
+	waitFor(true, false)
+	appendConsole("[    0.000000] Linux version 6.12.0\n")
+	waitFor(true, true)
+}
@@ -16,9 +16,11 @@ package qemu
 
 import (
 	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
 
 	"github.com/coreos/coreos-assembler/mantle/platform"
 	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+	"github.com/coreos/coreos-assembler/mantle/platform/local"
 )
 
 const (
@@ -58,6 +60,22 @@ type Options struct {
 	// Option to create IBM cex based luks encryption
 	Cex bool
 
+	// Dump the guest memory when a kernel panic appears on the console
+	CollectCrashDumps bool
+
+	// Connect the machines of each cluster to a bridge of its own, with
+	// DHCP and DNS, so that they can reach each other at their PrivateIP()
+	PrivateNetwork bool
+
+	// IP family and IPv6 network of usermode networking, see
+	// platform.QemuBuilder.UsermodeIPFamily
+	UsermodeIPFamily string
+	UsermodeIPv6Net  string
+
+	// Save the screen of the machines to their output directory as it
+	// changes
+	Screenshots bool
+
 	*platform.Options
 }
 
@@ -102,6 +120,13 @@ func (qf *flight) NewCluster(rconf *platform.RuntimeConfig) (platform.Cluster, e
 		BaseCluster: bc,
 		flight:      qf,
 	}
+	if qf.opts.PrivateNetwork {
+		qc.privnet, err = local.NewNetwork()
+		if err != nil {
+			bc.Destroy()
+			return nil, errors.Wrapf(err, "setting up private network (requires root)")
+		}
+	}
 
 	qf.AddCluster(qc)
 
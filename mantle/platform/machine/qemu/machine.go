@@ -18,11 +18,14 @@ import (
 	"context"
 	"errors"
 	"os"
+	"sync"
 	"time"
 
 	"golang.org/x/crypto/ssh"
 
+	"github.com/coreos/coreos-assembler/mantle/network/ntp"
 	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/local"
 )
 
 type machine struct {
@@ -33,6 +36,18 @@ type machine struct {
 	consolePath string
 	console     string
 	ip          string
+
+	// set on the private network, see Options.PrivateNetwork
+	tap       *local.TunTap
+	privateIf *local.Interface
+
+	// set when crash dumps are collected, see watchConsole()
+	done      chan struct{}
+	crashLock sync.Mutex
+	panicked  bool
+	rebooted  bool
+
+	destroyOnce sync.Once
 }
 
 func (m *machine) ID() string {
@@ -44,6 +59,9 @@ func (m *machine) IP() string {
 }
 
 func (m *machine) PrivateIP() string {
+	if m.privateIf != nil {
+		return m.privateIf.DHCPv4[0].IP.String()
+	}
 	return m.ip
 }
 
@@ -91,10 +109,23 @@ func (m *machine) WaitForSoftReboot(timeout time.Duration, oldSoftRebootsCount s
 	return platform.WaitForMachineSoftReboot(m, m.journal, timeout, oldSoftRebootsCount)
 }
 
+// Destroy may be called more than once, e.g. by a test and then by its
+// cluster; only the first call has an effect.
 func (m *machine) Destroy() {
+	m.destroyOnce.Do(m.destroy)
+}
+
+func (m *machine) destroy() {
+	if m.done != nil {
+		close(m.done)
+	}
+	// wait for a memory dump in progress
+	m.crashLock.Lock()
 	m.inst.Destroy()
+	m.crashLock.Unlock()
 
 	m.journal.Destroy()
+	m.releasePrivateNic()
 
 	if buf, err := os.ReadFile(m.consolePath); err == nil {
 		m.console = string(buf)
@@ -105,6 +136,71 @@ func (m *machine) Destroy() {
 	m.qc.DelMach(m)
 }
 
+func (m *machine) Snapshot(name string) error {
+	return m.inst.Snapshot(name)
+}
+
+func (m *machine) Restore(name string) error {
+	if err := m.inst.Restore(name); err != nil {
+		return err
+	}
+	// the connection of the journal is gone with the guest's state
+	return platform.StartMachineAfterReboot(m, m.journal, "")
+}
+
+func (m *machine) UnplugDisk(drive string) error {
+	return m.inst.UnplugDisk(drive)
+}
+
+func (m *machine) ThrottleDisk(drive string, bps, iops int64) error {
+	return m.inst.ThrottleDisk(drive, bps, iops)
+}
+
+func (m *machine) SetLinkUp(netdev string, up bool) error {
+	return m.inst.SetLinkUp(netdev, up)
+}
+
+func (m *machine) SetBalloon(mib int) error {
+	return m.inst.SetBalloon(mib)
+}
+
+func (m *machine) NTPServer() *ntp.Server {
+	if m.qc.privnet == nil {
+		return nil
+	}
+	return m.qc.privnet.NTPServer
+}
+
+func (m *machine) NTPServerIP() string {
+	if m.qc.privnet == nil {
+		return ""
+	}
+	return m.qc.privnet.Dnsmasq.Segments[0].BridgeIf.DHCPv4[0].IP.String()
+}
+
+func (m *machine) Screendump(path string) error {
+	return m.inst.Screendump(path)
+}
+
+func (m *machine) WaitForScreen(ctx context.Context, want platform.ScreenHash, maxDistance int) error {
+	return m.inst.WaitForScreen(ctx, want, maxDistance)
+}
+
+// releasePrivateNic removes the tap device of the machine on the private
+// network, and gives its address back.
+func (m *machine) releasePrivateNic() {
+	if m.tap == nil {
+		return
+	}
+	if err := m.tap.Close(); err != nil {
+		plog.Errorf("Error closing tap device of instance %v: %v", m.ID(), err)
+	}
+	dm := m.qc.privnet.Dnsmasq
+	dm.PutInterface(dm.Segments[0].BridgeName, m.privateIf)
+	m.tap = nil
+	m.privateIf = nil
+}
+
 func (m *machine) ConsoleOutput() string {
 	return m.console
 }
@@ -128,3 +224,19 @@ func (m *machine) RemovePrimaryBlockDevice() error {
 func (m *machine) RemoveBlockDeviceForMultipath(device string) error {
 	return m.inst.RemoveBlockDeviceForMultipath(device)
 }
+
+func (m *machine) Poweroff(timeout time.Duration) error {
+	// the connection usually drops before the command returns
+	m.SSH("sudo systemctl --no-block poweroff") //nolint // Ignore Errors
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- m.inst.Wait()
+	}()
+	select {
+	case err := <-exited:
+		return err
+	case <-time.After(timeout):
+		return errors.New("timed out waiting for qemu to exit")
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/pborman/uuid"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+)
+
+type cluster struct {
+	*platform.BaseCluster
+	flight *flight
+
+	mu        sync.Mutex
+	recording *platform.SSHRecording
+	replayed  []bool // the recorded commands which were run
+}
+
+func (rc *cluster) NewMachine(userdata *conf.UserData) (platform.Machine, error) {
+	return rc.NewMachineWithOptions(userdata, platform.MachineOptions{})
+}
+
+func (rc *cluster) NewMachineWithOptions(userdata *conf.UserData, options platform.MachineOptions) (platform.Machine, error) {
+	if options.InstanceType != "" {
+		return nil, errors.New("platform replay does not support changing instance types")
+	}
+	if _, err := rc.RenderUserData(userdata, map[string]string{}); err != nil {
+		return nil, err
+	}
+
+	rm := &machine{
+		cluster: rc,
+		id:      uuid.New(),
+	}
+	rc.AddMach(rm)
+
+	return rm, nil
+}
+
+// lookup returns the first command recorded on machine index which was
+// not run yet, or nil.
+func (rc *cluster) lookup(index int, cmd string) *platform.RecordedCommand {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	for i := range rc.recording.Commands {
+		c := &rc.recording.Commands[i]
+		if !rc.replayed[i] && c.Machine == index && c.Cmd == cmd {
+			rc.replayed[i] = true
+			return c
+		}
+	}
+	return nil
+}
+
+// CheckReplayed returns an error listing the recorded commands which were
+// not run, e.g. since the test no longer runs them.
+func (rc *cluster) CheckReplayed() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+	var missed []string
+	for i, c := range rc.recording.Commands {
+		if !rc.replayed[i] {
+			missed = append(missed, fmt.Sprintf("machine %d: %q", c.Machine, c.Cmd))
+		}
+	}
+	if len(missed) > 0 {
+		return fmt.Errorf("recorded commands were not run: %s", strings.Join(missed, ", "))
+	}
+	return nil
+}
+
+func (rc *cluster) Destroy() {
+	rc.BaseCluster.Destroy()
+	rc.flight.DelCluster(rc)
+}
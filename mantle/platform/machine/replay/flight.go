@@ -0,0 +1,96 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"path/filepath"
+
+	"github.com/coreos/pkg/capnslog"
+	"github.com/pkg/errors"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+	"github.com/coreos/coreos-assembler/mantle/platform/conf"
+)
+
+const (
+	Platform platform.Name = "replay"
+)
+
+// Options contains replay-specific options for the flight.
+type Options struct {
+	// Dir is the output directory of a kola run with recorded SSH
+	// commands, which has a directory for each test
+	Dir string
+
+	*platform.Options
+}
+
+type flight struct {
+	*platform.BaseFlight
+	opts *Options
+}
+
+var (
+	plog = capnslog.NewPackageLogger("github.com/coreos/coreos-assembler/mantle", "platform/machine/replay")
+)
+
+// NewFlight returns a flight whose machines answer the commands of a
+// test with the output recorded for them, without booting anything.
+func NewFlight(opts *Options) (platform.Flight, error) {
+	if opts.Dir == "" {
+		return nil, errors.New("platform replay requires a directory of recordings")
+	}
+	bf, err := platform.NewBaseFlight(opts.Options, Platform)
+	if err != nil {
+		return nil, err
+	}
+
+	rf := &flight{
+		BaseFlight: bf,
+		opts:       opts,
+	}
+
+	return rf, nil
+}
+
+// NewCluster creates a cluster replaying the recording of the test whose
+// output directory has the same name as the one of rconf.
+func (rf *flight) NewCluster(rconf *platform.RuntimeConfig) (platform.Cluster, error) {
+	path := filepath.Join(rf.opts.Dir, filepath.Base(rconf.OutputDir), platform.SSHRecordingFile)
+	recording, err := platform.ReadSSHRecording(path)
+	if err != nil {
+		return nil, errors.Wrapf(err, "reading recording")
+	}
+
+	bc, err := platform.NewBaseCluster(rf.BaseFlight, rconf)
+	if err != nil {
+		return nil, err
+	}
+
+	rc := &cluster{
+		BaseCluster: bc,
+		flight:      rf,
+		recording:   recording,
+		replayed:    make([]bool, len(recording.Commands)),
+	}
+
+	rf.AddCluster(rc)
+
+	return rc, nil
+}
+
+func (rf *flight) ConfigTooLarge(ud conf.UserData) bool {
+	return false
+}
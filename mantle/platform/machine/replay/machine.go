@@ -0,0 +1,137 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/coreos-assembler/mantle/network/mockssh"
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+// machine answers commands from the recording of its cluster, matched by
+// the index of the machine in the cluster. Commands which weren't
+// recorded fail with exit status 127, except file transfers of the
+// harness, which send their content on stdin and succeed without output.
+type machine struct {
+	cluster *cluster
+	id      string
+}
+
+func (rm *machine) ID() string {
+	return rm.id
+}
+
+func (rm *machine) IP() string {
+	return "replay-" + rm.id
+}
+
+func (rm *machine) PrivateIP() string {
+	return rm.IP()
+}
+
+func (rm *machine) RuntimeConf() platform.RuntimeConfig {
+	return rm.cluster.RuntimeConf()
+}
+
+func (rm *machine) SSHClient() (*ssh.Client, error) {
+	return mockssh.NewMockClient(rm.handle), nil
+}
+
+func (rm *machine) PasswordSSHClient(user string, password string) (*ssh.Client, error) {
+	return rm.SSHClient()
+}
+
+func (rm *machine) SSH(cmd string) ([]byte, []byte, error) {
+	var stdout bytes.Buffer
+	var stderr bytes.Buffer
+	client, err := rm.SSHClient()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer session.Close()
+
+	session.Stdout = &stdout
+	session.Stderr = &stderr
+	err = session.Run(cmd)
+	return bytes.TrimSpace(stdout.Bytes()), bytes.TrimSpace(stderr.Bytes()), err
+}
+
+// handle replays the command of the session.
+func (rm *machine) handle(session *mockssh.Session) {
+	index := rm.cluster.MachineIndex(rm)
+	// the client waits for stdin to be consumed, e.g. by file transfers
+	n, err := io.Copy(io.Discard, session.Stdin)
+	if err != nil {
+		plog.Warningf("machine %d: reading stdin: %v", index, err)
+	}
+	c := rm.cluster.lookup(index, session.Exec)
+	if c == nil && n > 0 {
+		plog.Debugf("machine %d: file transfer was not recorded: %q", index, session.Exec)
+		_ = session.Exit(0)
+		return
+	}
+	if c == nil {
+		_, _ = fmt.Fprintf(session.Stderr, "replay: command was not recorded on machine %d: %s", index, session.Exec)
+		_ = session.Exit(127)
+		return
+	}
+	_, _ = io.WriteString(session.Stdout, c.Stdout)
+	_, _ = io.WriteString(session.Stderr, c.Stderr)
+	_ = session.Exit(c.ExitStatus)
+}
+
+func (rm *machine) IgnitionError() error {
+	return nil
+}
+
+func (rm *machine) Start() error {
+	return nil
+}
+
+func (rm *machine) Reboot() error {
+	return nil
+}
+
+func (rm *machine) WaitForReboot(timeout time.Duration, oldBootId string) error {
+	return nil
+}
+
+func (rm *machine) WaitForSoftReboot(timeout time.Duration, oldSoftRebootsCount string) error {
+	return nil
+}
+
+func (rm *machine) Destroy() {
+	rm.cluster.DelMach(rm)
+}
+
+func (rm *machine) ConsoleOutput() string {
+	return ""
+}
+
+func (rm *machine) JournalOutput() string {
+	return ""
+}
@@ -0,0 +1,201 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package replay
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+
+	"github.com/coreos/coreos-assembler/mantle/platform"
+)
+
+func TestReplay(t *testing.T) {
+	recorded := t.TempDir()
+	outputDir := filepath.Join(t.TempDir(), "basic")
+	if err := os.Mkdir(outputDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+
+	// record the commands of a test, with a replay cluster of an empty
+	// recording
+	flight, err := NewFlight(&Options{Dir: filepath.Dir(outputDir), Options: &platform.Options{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flight.Destroy()
+	if err := os.WriteFile(filepath.Join(outputDir, platform.SSHRecordingFile), []byte(`{"commands": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	c, err := flight.NewCluster(&platform.RuntimeConfig{OutputDir: outputDir, RecordSSH: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	m0, err := c.NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, err := c.NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rc := c.(*cluster)
+	rc.RecordSSH(m1, "hostname", []byte("m1"), nil, nil)
+	rc.RecordSSH(m0, "hostname", []byte("m0"), nil, nil)
+	rc.RecordSSH(m0, "false", nil, []byte("oops"), errors.New("connection lost"))
+	c.Destroy()
+	recording, err := platform.ReadSSHRecording(filepath.Join(outputDir, platform.SSHRecordingFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(recording.Commands) != 3 || recording.Commands[0].Machine != 1 || recording.Commands[1].Machine != 0 {
+		t.Fatalf("unexpected recording %+v", recording.Commands)
+	}
+
+	// replay them
+	buf, err := os.ReadFile(filepath.Join(outputDir, platform.SSHRecordingFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(recorded, "basic"), 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(recorded, "basic", platform.SSHRecordingFile), buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	flight, err = NewFlight(&Options{Dir: recorded, Options: &platform.Options{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flight.Destroy()
+	c, err = flight.NewCluster(&platform.RuntimeConfig{OutputDir: outputDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Destroy()
+	m0, err = c.NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m1, err = c.NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		m      platform.Machine
+		cmd    string
+		stdout string
+		stderr string
+		status int
+	}{
+		{m0, "hostname", "m0", "", 0},
+		{m1, "hostname", "m1", "", 0},
+		{m0, "false", "", "oops", 255},
+		// not recorded
+		{m1, "false", "", "replay: command was not recorded on machine 1: false", 127},
+	} {
+		stdout, stderr, err := tt.m.SSH(tt.cmd)
+		status := 0
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			status = exitErr.ExitStatus()
+		} else if err != nil {
+			t.Fatalf("%s: %v", tt.cmd, err)
+		}
+		if string(stdout) != tt.stdout || string(stderr) != tt.stderr || status != tt.status {
+			t.Errorf("%s: got %q, %q, %d, expected %q, %q, %d", tt.cmd, stdout, stderr, status, tt.stdout, tt.stderr, tt.status)
+		}
+	}
+
+	// file transfers of the harness aren't recorded, but succeed
+	client, err := m0.SSHClient()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	session, err := client.NewSession()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer session.Close()
+	session.Stdin = strings.NewReader("kolet")
+	if err := session.Run("install -m 0755 /dev/stdin kolet"); err != nil {
+		t.Errorf("file transfer: %v", err)
+	}
+
+	if err := c.(*cluster).CheckReplayed(); err != nil {
+		t.Errorf("all recorded commands were run: %v", err)
+	}
+
+	// a test which no longer runs some of the recorded commands fails
+	c2, err := flight.NewCluster(&platform.RuntimeConfig{OutputDir: outputDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c2.Destroy()
+	m0, err = c2.NewMachine(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = c2.NewMachine(nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := m0.SSH("hostname"); err != nil {
+		t.Fatal(err)
+	}
+	err = c2.(*cluster).CheckReplayed()
+	if err == nil || !strings.Contains(err.Error(), `machine 1: "hostname"`) || !strings.Contains(err.Error(), `machine 0: "false"`) || strings.Contains(err.Error(), `machine 0: "hostname"`) {
+		t.Errorf("expected the commands which were not run, got %v", err)
+	}
+}
+
+func TestNewMachinesOrder(t *testing.T) {
+	outputDir := filepath.Join(t.TempDir(), "basic")
+	if err := os.Mkdir(outputDir, 0777); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outputDir, platform.SSHRecordingFile), []byte(`{"commands": []}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	flight, err := NewFlight(&Options{Dir: filepath.Dir(outputDir), Options: &platform.Options{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer flight.Destroy()
+	c, err := flight.NewCluster(&platform.RuntimeConfig{OutputDir: outputDir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Destroy()
+
+	// the machines come up in any order, but are indexed in the order
+	// they were requested
+	machs, err := platform.NewMachines(c, nil, 8, platform.MachineOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, m := range c.Machines() {
+		if m != machs[i] {
+			t.Errorf("machine %d: not listed in the order requested", i)
+		}
+		if index := c.(*cluster).MachineIndex(m); index != i {
+			t.Errorf("machine %d: got index %d", i, index)
+		}
+	}
+}
@@ -20,6 +20,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"io"
+	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
@@ -220,6 +221,10 @@ type RuntimeConfig struct {
 
 	// whether a Manhole into a machine should be created on detected failure
 	SSHOnTestFailure bool
+
+	// RecordSSH is true if the commands run by the test with
+	// TestCluster.SSH should be written to SSHRecordingFile
+	RecordSSH bool
 }
 
 // Wrap a StdoutPipe as a io.ReadCloser
@@ -376,48 +381,84 @@ func CopyDirToMachine(inputdir string, m Machine, destdir string) error {
 	return nil
 }
 
+// CopyDirFromMachine writes the contents of the remote srcdir to the local
+// file dest as a gzip-compressed tarball.
+func CopyDirFromMachine(m Machine, srcdir, dest string) error {
+	client, err := m.SSHClient()
+	if err != nil {
+		return errors.Wrapf(err, "failed creating SSH client")
+	}
+
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return errors.Wrapf(err, "failed creating SSH session")
+	}
+
+	defer session.Close()
+
+	f, err := os.Create(dest)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	stderr := bytes.NewBuffer(nil)
+	session.Stdout = f
+	session.Stderr = stderr
+	if err := session.Run(fmt.Sprintf("sudo tar -cz -C %s -f - .", shellquote.Join(srcdir))); err != nil {
+		return errors.Wrapf(err, "executing remote tar: %q", stderr)
+	}
+	return f.Close()
+}
+
 // NewMachines spawns n instances in cluster c, with
-// each instance passed the same userdata.
+// each instance passed the same userdata. The machines are returned, and
+// listed by c.Machines(), in the order they were requested.
 func NewMachines(c Cluster, userdata *conf.UserData, n int, options MachineOptions) ([]Machine, error) {
 	var wg sync.WaitGroup
 
-	mchan := make(chan Machine, n)
+	machs := make([]Machine, n)
 	errchan := make(chan error, n)
 
 	for i := 0; i < n; i++ {
 		wg.Add(1)
-		go func() {
+		go func(i int) {
 			defer wg.Done()
 			m, err := c.NewMachineWithOptions(userdata, options)
 			if err != nil {
 				errchan <- err
 			}
-			if m != nil {
-				mchan <- m
-			}
-		}()
+			machs[i] = m
+		}(i)
 	}
 
 	wg.Wait()
-	close(mchan)
 	close(errchan)
 
-	machs := []Machine{}
-
-	for m := range mchan {
-		machs = append(machs, m)
-	}
-
 	if firsterr, ok := <-errchan; ok {
 		for _, m := range machs {
-			m.Destroy()
+			if m != nil {
+				m.Destroy()
+			}
 		}
 		return nil, firsterr
 	}
 
+	// the machines were added as they came up; index them in the order
+	// they were requested instead, which doesn't vary between runs
+	if o, ok := c.(machineOrderer); ok {
+		o.orderMachines(machs)
+	}
 	return machs, nil
 }
 
+// machineOrderer is implemented by clusters embedding a BaseCluster.
+type machineOrderer interface {
+	orderMachines([]Machine)
+}
+
 // checkSystemdUnitFailures ensures that no system unit is in a failed state.
 func checkSystemdUnitFailures(output string) error {
 	if len(output) > 0 {
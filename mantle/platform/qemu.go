@@ -44,6 +44,7 @@ import (
 	"syscall"
 	"time"
 
+	"github.com/coreos/coreos-assembler/mantle/network/ntp"
 	"github.com/coreos/coreos-assembler/mantle/platform/conf"
 	"github.com/coreos/coreos-assembler/mantle/util"
 	coreosarch "github.com/coreos/stream-metadata-go/arch"
@@ -66,6 +67,13 @@ type HostForwardPort struct {
 	GuestPort int
 }
 
+// IP families of usermode networking, see QemuBuilder.UsermodeIPFamily.
+const (
+	IPFamilyIPv4      = "ipv4"
+	IPFamilyIPv6      = "ipv6"
+	IPFamilyDualStack = "dual"
+)
+
 // QemuMachineOptions is specialized MachineOption struct for QEMU.
 type QemuMachineOptions struct {
 	MachineOptions
@@ -75,6 +83,15 @@ type QemuMachineOptions struct {
 	Firmware            string
 	Nvme                bool
 	Cex                 bool
+	// If not empty, the primary disk is kept at this path after the
+	// machine is destroyed, e.g. to boot other machines from it with
+	// OverrideBackingFile. It must be on the same filesystem as /var/tmp.
+	SaveDisk string
+	// Add a balloon device, to put the guest under memory pressure with
+	// SetBalloon.
+	Balloon bool
+	// Add a display, to check what is on the screen with WaitForScreen.
+	Framebuffer bool
 }
 
 // QEMUMachine represents a qemu instance.
@@ -87,6 +104,43 @@ type QEMUMachine interface {
 	RemovePrimaryBlockDevice() error
 	// RemoveBlockDeviceForMultipath removes the specified device on multipath.
 	RemoveBlockDeviceForMultipath(device string) error
+	// Poweroff shuts the machine down cleanly and waits for qemu to exit,
+	// so that its disks are consistent.
+	Poweroff(timeout time.Duration) error
+	// KernelPanicked reports whether a kernel panic appeared on the console
+	// and whether a kernel, e.g. kdump's, booted after it. Panics are only
+	// watched for when crash dumps are collected.
+	KernelPanicked() (panicked, rebooted bool)
+	// Snapshot saves the running machine, its memory and disks, as the
+	// snapshot name.
+	Snapshot(name string) error
+	// Restore rolls the machine back to the snapshot name and waits for
+	// it to be reachable again.
+	Restore(name string) error
+	// UnplugDisk hot-unplugs the disk with the given drive id, e.g.
+	// "disk-1" for the primary disk, without telling the guest beforehand.
+	UnplugDisk(drive string) error
+	// ThrottleDisk limits the I/O of the disk with the given drive id to
+	// bps bytes and iops operations per second; 0 means unlimited.
+	ThrottleDisk(drive string, bps, iops int64) error
+	// SetLinkUp sets the link state of the NIC with the given netdev id,
+	// e.g. "eth0" for the usermode NIC.
+	SetLinkUp(netdev string, up bool) error
+	// SetBalloon inflates or deflates the balloon so that the guest is
+	// left with mib MiB of memory. It requires the Balloon option.
+	SetBalloon(mib int) error
+	// NTPServer returns the NTP server of the private network, whose time
+	// the machines follow, or nil without a private network.
+	NTPServer() *ntp.Server
+	// NTPServerIP returns the address the guest reaches NTPServer at, or
+	// "" without a private network.
+	NTPServerIP() string
+	// Screendump saves the screen of the machine to path as a PNG. It
+	// requires the Framebuffer option or screenshots.
+	Screendump(path string) error
+	// WaitForScreen waits until the screen looks like want, e.g. a GRUB
+	// menu, i.e. its hash is at most maxDistance bits away.
+	WaitForScreen(ctx context.Context, want ScreenHash, maxDistance int) error
 }
 
 // Disk holds the details of a virtual disk.
@@ -102,6 +156,8 @@ type Disk struct {
 	NbdDisk           bool     // if true, the disks should be presented over nbd:unix socket
 	MultiPathDisk     bool     // if true, present multiple paths
 	Wwn               uint64   // Optional World wide name for the SCSI disk. If not set or set to 0, a random one will be generated. Used only with "channel=scsi". Must be an integer
+	LinkFile          string   // if not empty, hardlink the disk image there so that it outlives the instance
+	BadSectors        []uint64 // 512 byte sectors on which I/O fails with EIO, through blkdebug. Not supported with MultiPathDisk
 
 	attachEndPoint string   // qemuPath to attach to
 	dstFileName    string   // the prepared file
@@ -115,6 +171,7 @@ func ParseDisk(spec string, allowNoSize bool) (*Disk, error) {
 	serialOpt := []string{}
 	multipathed := false
 	var wwn uint64
+	var badSectors []uint64
 
 	size, diskmap, err := util.ParseDiskSpec(spec, allowNoSize)
 	if err != nil {
@@ -140,6 +197,12 @@ func ParseDisk(spec string, allowNoSize bool) (*Disk, error) {
 			if err != nil {
 				return nil, fmt.Errorf("invalid value %s for wwn. Must be an integer", value)
 			}
+		case "badsector":
+			sector, err := strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value %s for badsector. Must be an integer", value)
+			}
+			badSectors = append(badSectors, sector)
 		default:
 			return nil, fmt.Errorf("invalid key %q", key)
 		}
@@ -157,6 +220,7 @@ func ParseDisk(spec string, allowNoSize bool) (*Disk, error) {
 		LogicalSectorSize: logicalSectorSize,
 		MultiPathDisk:     multipathed,
 		Wwn:               wwn,
+		BadSectors:        badSectors,
 	}, nil
 }
 
@@ -175,11 +239,17 @@ type QemuInstance struct {
 	// Helpers are child processes such as nbd or virtiofsd that should be lifecycle bound to qemu
 	helpers            []exec.Cmd
 	hostForwardedPorts []HostForwardPort
+	// the host address of the forwarded ports, 127.0.0.1 or ::1
+	hostForwardAddr string
 
 	journalPipe *os.File
 
 	qmpSocket     *qmp.SocketMonitor
 	qmpSocketPath string
+
+	// set while screenshots are taken, see QemuBuilder.ScreenshotDir
+	screensDone    chan struct{}
+	screensStopped chan struct{}
 }
 
 // Signaled returns whether QEMU process was signaled.
@@ -202,7 +272,7 @@ func (inst *QemuInstance) Kill() error {
 func (inst *QemuInstance) SSHAddress() (string, error) {
 	for _, fwdPorts := range inst.hostForwardedPorts {
 		if fwdPorts.Service == "ssh" {
-			return fmt.Sprintf("127.0.0.1:%d", fwdPorts.HostPort), nil
+			return net.JoinHostPort(inst.hostForwardAddr, strconv.Itoa(fwdPorts.HostPort)), nil
 		}
 	}
 	return "", fmt.Errorf("didn't find an address")
@@ -294,6 +364,11 @@ func (inst *QemuInstance) WaitAll(ctx context.Context) error {
 
 // Destroy kills the instance and associated sidecar processes.
 func (inst *QemuInstance) Destroy() {
+	if inst.screensDone != nil {
+		close(inst.screensDone)
+		<-inst.screensStopped
+		inst.screensDone = nil
+	}
 	if inst.qmpSocket != nil {
 		inst.qmpSocket.Disconnect() //nolint // Ignore Errors
 		inst.qmpSocket = nil
@@ -447,6 +522,30 @@ func (inst *QemuInstance) RemovePrimaryBlockDevice() (err2 error) {
 	return nil
 }
 
+// DumpGuestMemory writes the memory of the guest to path, compressed in the
+// kdump format, e.g. to debug a kernel panic. The guest is paused meanwhile.
+func (inst *QemuInstance) DumpGuestMemory(path string) error {
+	return inst.dumpGuestMemory(path)
+}
+
+// Snapshot saves the state of the instance, its memory and disks, as the
+// snapshot name in its qcow2 disks, replacing an older one of that name.
+// The guest is paused meanwhile.
+func (inst *QemuInstance) Snapshot(name string) error {
+	// snapshot-save refuses to overwrite a tag, and snapshot-delete skips
+	// the disks which don't have it
+	if err := inst.snapshotJob("snapshot-delete", name); err != nil {
+		return err
+	}
+	return inst.snapshotJob("snapshot-save", name)
+}
+
+// Restore rolls the instance back to the snapshot name. Network
+// connections to the guest from after the snapshot are lost.
+func (inst *QemuInstance) Restore(name string) error {
+	return inst.snapshotJob("snapshot-load", name)
+}
+
 // RemoveBlockDeviceForMultipath remove the specified device on multipath.
 func (inst *QemuInstance) RemoveBlockDeviceForMultipath(device string) error {
 	blkdevs, err := inst.listBlkDevices()
@@ -526,17 +625,38 @@ type QemuBuilder struct {
 	ignitionSet      bool
 	ignitionRendered bool
 
-	UsermodeNetworking        bool
-	usermodeNetworkingAddr    string
+	UsermodeNetworking     bool
+	usermodeNetworkingAddr string
+	// UsermodeIPFamily is IPFamilyIPv4, IPFamilyIPv6 or IPFamilyDualStack
+	// for the usermode NICs; QEMU's default, IPv4 with IPv6 on the side
+	// but ports forwarded over IPv4 only, if empty.
+	UsermodeIPFamily string
+	// UsermodeIPv6Net is the IPv6 network of the usermode NICs, e.g.
+	// "fd00:10:0:2::/64", whose ::2 address is the host; QEMU's default is
+	// fec0::/64.
+	UsermodeIPv6Net           string
 	RestrictNetworking        bool
 	requestedHostForwardPorts []HostForwardPort
 	additionalNics            int
+	tapNics                   []tapNic
 	netbootP                  string
 	netbootDir                string
 
 	finalized bool
 	diskID    uint
 	disks     []*Disk
+	// Balloon adds a balloon device, see QemuInstance.SetBalloon
+	Balloon bool
+
+	// Framebuffer adds a display device, whose screen QemuInstance.Screendump
+	// captures although nothing shows it.
+	Framebuffer bool
+	// ScreenshotDir, if set, is where the screen is saved as a PNG every
+	// ScreenshotInterval, 5 seconds by default, when it changed. It
+	// implies Framebuffer.
+	ScreenshotDir      string
+	ScreenshotInterval time.Duration
+
 	// virtioSerialID is incremented for each device
 	virtioSerialID uint
 	// hostMounts is an array of directories mounted (via 9p or virtiofs) from the host
@@ -665,8 +785,82 @@ func (builder *QemuBuilder) AddAdditionalNics(additionalNics int) {
 	builder.additionalNics = additionalNics
 }
 
+// tapNic is a NIC backed by an open tap device
+type tapNic struct {
+	tap *os.File
+	mac net.HardwareAddr
+}
+
+// AddTapNic adds a NIC with the given MAC address, backed by the tap
+// device tap, e.g. one on a bridge shared with other machines. The caller
+// keeps ownership of tap.
+func (builder *QemuBuilder) AddTapNic(tap *os.File, mac net.HardwareAddr) {
+	builder.tapNics = append(builder.tapNics, tapNic{tap: tap, mac: mac})
+}
+
+// usermodeIPOpts returns the -netdev user options for the IP families of
+// usermode networking, and the host addresses to forward ports on.
+func (builder *QemuBuilder) usermodeIPOpts() (string, []string, error) {
+	var opts string
+	fwdAddrs := []string{"127.0.0.1"}
+	switch builder.UsermodeIPFamily {
+	case "":
+	case IPFamilyIPv4:
+		opts = ",ipv4=on,ipv6=off"
+	case IPFamilyIPv6:
+		// QEMU only serves TFTP and its net= network over IPv4
+		if builder.netbootP != "" {
+			return "", nil, errors.New("netboot requires IPv4 usermode networking")
+		}
+		if builder.usermodeNetworkingAddr != "" {
+			return "", nil, fmt.Errorf("usermode network %s requires IPv4 usermode networking", builder.usermodeNetworkingAddr)
+		}
+		opts = ",ipv4=off,ipv6=on"
+		fwdAddrs = []string{"::1"}
+	case IPFamilyDualStack:
+		opts = ",ipv4=on,ipv6=on"
+		fwdAddrs = append(fwdAddrs, "::1")
+	default:
+		return "", nil, fmt.Errorf("unknown usermode IP family %q", builder.UsermodeIPFamily)
+	}
+	if builder.UsermodeIPv6Net != "" {
+		if builder.UsermodeIPFamily == IPFamilyIPv4 {
+			return "", nil, errors.New("an IPv6 network requires IPv6 usermode networking")
+		}
+		host, err := usermodeIPv6Host(builder.UsermodeIPv6Net)
+		if err != nil {
+			return "", nil, err
+		}
+		opts += fmt.Sprintf(",ipv6-net=%s,ipv6-host=%s", builder.UsermodeIPv6Net, host)
+	}
+	return opts, fwdAddrs, nil
+}
+
+// usermodeIPv6Host returns the address of the host in the usermode IPv6
+// network ipv6Net, its ::2 like QEMU's default.
+func usermodeIPv6Host(ipv6Net string) (net.IP, error) {
+	ip, ipnet, err := net.ParseCIDR(ipv6Net)
+	if err != nil {
+		return nil, errors.Wrapf(err, "parsing usermode IPv6 network")
+	}
+	if ip.To4() != nil {
+		return nil, fmt.Errorf("usermode IPv6 network %s is not IPv6", ipv6Net)
+	}
+	if ones, _ := ipnet.Mask.Size(); ones > 126 {
+		return nil, fmt.Errorf("usermode IPv6 network %s is too small", ipv6Net)
+	}
+	host := make(net.IP, net.IPv6len)
+	copy(host, ipnet.IP)
+	host[net.IPv6len-1] |= 2
+	return host, nil
+}
+
 func (builder *QemuBuilder) setupNetworking() error {
-	netdev := "user,id=eth0"
+	ipOpts, fwdAddrs, err := builder.usermodeIPOpts()
+	if err != nil {
+		return err
+	}
+	netdev := "user,id=eth0" + ipOpts
 	for i := range builder.requestedHostForwardPorts {
 		address := fmt.Sprintf(":%d", builder.requestedHostForwardPorts[i].HostPort)
 		// Possible race condition between getting the port here and using it
@@ -677,9 +871,14 @@ func (builder *QemuBuilder) setupNetworking() error {
 		}
 		l.Close()
 		builder.requestedHostForwardPorts[i].HostPort = l.Addr().(*net.TCPAddr).Port
-		netdev += fmt.Sprintf(",hostfwd=tcp:127.0.0.1:%d-:%d",
-			builder.requestedHostForwardPorts[i].HostPort,
-			builder.requestedHostForwardPorts[i].GuestPort)
+		for _, addr := range fwdAddrs {
+			if strings.Contains(addr, ":") {
+				addr = "[" + addr + "]"
+			}
+			netdev += fmt.Sprintf(",hostfwd=tcp:%s:%d-:%d", addr,
+				builder.requestedHostForwardPorts[i].HostPort,
+				builder.requestedHostForwardPorts[i].GuestPort)
+		}
 	}
 
 	if builder.Hostname != "" {
@@ -722,6 +921,10 @@ func (builder *QemuBuilder) setupNetworking() error {
 }
 
 func (builder *QemuBuilder) setupAdditionalNetworking() error {
+	ipOpts, _, err := builder.usermodeIPOpts()
+	if err != nil {
+		return err
+	}
 	macCounter := 0
 	netOffset := 30
 	for i := 1; i <= builder.additionalNics; i++ {
@@ -729,7 +932,10 @@ func (builder *QemuBuilder) setupAdditionalNetworking() error {
 		netSuffix := fmt.Sprintf("%d", netOffset+i)
 		macSuffix := fmt.Sprintf("%02x", macCounter)
 
-		netdev := fmt.Sprintf("user,id=eth%s,dhcpstart=10.0.2.%s", idSuffix, netSuffix)
+		netdev := fmt.Sprintf("user,id=eth%s%s", idSuffix, ipOpts)
+		if builder.UsermodeIPFamily != IPFamilyIPv6 {
+			netdev += fmt.Sprintf(",dhcpstart=10.0.2.%s", netSuffix)
+		}
 		device := virtio(builder.architecture, "net", fmt.Sprintf("netdev=eth%s,mac=52:55:00:d1:56:%s", idSuffix, macSuffix))
 		// On s390x, devices use the CCW bus instead of PCI, which may cause them to appear in a different order.
 		// By default, the CSSID is 0xFE and the SSID is 0x0. For additional NICs, set the SSID to 0x1
@@ -1142,6 +1348,11 @@ func (disk *Disk) prepare(builder *QemuBuilder) error {
 	if err := qemuImg.Run(); err != nil {
 		return err
 	}
+	if disk.LinkFile != "" {
+		if err := os.Link(disk.dstFileName, disk.LinkFile); err != nil {
+			return errors.Wrapf(err, "keeping disk")
+		}
+	}
 
 	fdSet := builder.AddFd(tmpf)
 	disk.attachEndPoint = fdSet
@@ -1236,6 +1447,17 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 		defaultDiskOpts += "," + strings.Join(disk.DriveOpts, ",")
 	}
 
+	file := "file=" + disk.attachEndPoint
+	if len(disk.BadSectors) > 0 {
+		if disk.MultiPathDisk {
+			return errors.New("bad sectors are not supported on multipath disks")
+		}
+		var err error
+		if file, err = disk.blkdebugFile(builder); err != nil {
+			return err
+		}
+	}
+
 	if disk.MultiPathDisk || channel == "scsi" {
 		// Fake a NVME or SCSI device with a fake WWN.
 		// The WWN needs to be a unique uint64 number
@@ -1278,8 +1500,8 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 			builder.Append("-device",
 				fmt.Sprintf("scsi-hd,bus=%s.0,drive=%s,wwn=%d%s",
 					scsiID, id, wwn, opts))
-			builder.Append("-drive", fmt.Sprintf("if=none,id=%s,file=%s,media=disk,%s",
-				id, disk.attachEndPoint, defaultDiskOpts))
+			builder.Append("-drive", fmt.Sprintf("if=none,id=%s,%s,media=disk,%s",
+				id, file, defaultDiskOpts))
 		}
 
 	} else {
@@ -1300,8 +1522,8 @@ func (builder *QemuBuilder) addDiskImpl(disk *Disk, primary bool) error {
 		}
 
 		// Default to cache=unsafe
-		builder.Append("-drive", fmt.Sprintf("if=none,id=%s,file=%s,%s",
-			id, disk.attachEndPoint, defaultDiskOpts))
+		builder.Append("-drive", fmt.Sprintf("if=none,id=%s,%s,%s",
+			id, file, defaultDiskOpts))
 	}
 	return nil
 }
@@ -1799,6 +2021,9 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 
 	// We never want a popup window
 	argv = append(argv, "-nographic")
+	if builder.Framebuffer || builder.ScreenshotDir != "" {
+		argv = append(argv, "-device", framebufferDevice(builder.architecture))
+	}
 
 	// We want to customize everything from scratch, so avoid defaults
 	argv = append(argv, "-nodefaults")
@@ -1857,6 +2082,10 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 			return nil, err
 		}
 		inst.hostForwardedPorts = builder.requestedHostForwardPorts
+		inst.hostForwardAddr = "127.0.0.1"
+		if builder.UsermodeIPFamily == IPFamilyIPv6 {
+			inst.hostForwardAddr = "::1"
+		}
 	}
 
 	// Handle Additional NICs networking
@@ -1866,6 +2095,10 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		}
 	}
 
+	if builder.Balloon {
+		builder.Append("-device", virtio(builder.architecture, "balloon", "id=balloon0"))
+	}
+
 	// Handle Software TPM
 	if builder.Swtpm && builder.supportsSwtpm() {
 		err = builder.ensureTempdir()
@@ -1988,6 +2221,13 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		argv = append(argv, "-add-fd", fmt.Sprintf("fd=%d,set=%d", fdnum, fdset))
 		fdnum++
 	}
+	// tap devices are passed after the fdsets, and used by fd directly
+	for i, nic := range builder.tapNics {
+		id := fmt.Sprintf("tap%d", i)
+		argv = append(argv, "-netdev", fmt.Sprintf("tap,id=%s,fd=%d", id, fdnum),
+			"-device", virtio(builder.architecture, "net", fmt.Sprintf("netdev=%s,mac=%s", id, nic.mac)))
+		fdnum++
+	}
 
 	if builder.ConsoleFile != "" {
 		builder.Append("-display", "none", "-chardev", "file,id=log,path="+builder.ConsoleFile, "-serial", "chardev:log")
@@ -2011,6 +2251,9 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 	}
 
 	cmd.ExtraFiles = append(cmd.ExtraFiles, builder.fds...)
+	for _, nic := range builder.tapNics {
+		cmd.ExtraFiles = append(cmd.ExtraFiles, nic.tap)
+	}
 
 	if builder.InheritConsole {
 		cmd.Stdin = os.Stdin
@@ -2047,6 +2290,19 @@ func (builder *QemuBuilder) Exec() (*QemuInstance, error) {
 		return nil, fmt.Errorf("failed to connect over qmp to qemu instance")
 	}
 
+	if builder.ScreenshotDir != "" {
+		if err := os.MkdirAll(builder.ScreenshotDir, 0755); err != nil {
+			return nil, err
+		}
+		interval := builder.ScreenshotInterval
+		if interval == 0 {
+			interval = defaultScreenshotInterval
+		}
+		inst.screensDone = make(chan struct{})
+		inst.screensStopped = make(chan struct{})
+		go inst.captureScreens(builder.ScreenshotDir, interval)
+	}
+
 	// Hacky code to test https://github.com/openshift/os/pull/1346
 	if timeout, ok := os.LookupEnv("COSA_TEST_CDROM_UNPLUG"); ok {
 		val, err := time.ParseDuration(timeout)
@@ -0,0 +1,98 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// blkdebugFile writes a blkdebug config failing I/O on the bad sectors of
+// the disk, and returns the -drive options which open the disk through it.
+// blkdebug sits above the format driver, so sectors are those the guest
+// sees.
+func (disk *Disk) blkdebugFile(builder *QemuBuilder) (string, error) {
+	f, err := builder.TempFile("blkdebug")
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	if _, err := f.WriteString(renderBlkdebugConfig(disk.BadSectors)); err != nil {
+		return "", errors.Wrapf(err, "writing blkdebug config")
+	}
+	format := "qcow2"
+	if disk.NbdDisk {
+		// qemu-nbd serves the guest's view of the qcow2 image
+		format = "raw"
+	}
+	return fmt.Sprintf("driver=blkdebug,config=%s,image.driver=%s,image.file.filename=%s",
+		f.Name(), format, disk.attachEndPoint), nil
+}
+
+// renderBlkdebugConfig returns a blkdebug config which fails reads and
+// writes touching any of sectors with EIO.
+func renderBlkdebugConfig(sectors []uint64) string {
+	var buf strings.Builder
+	for _, sector := range sectors {
+		for _, event := range []string{"read_aio", "write_aio"} {
+			fmt.Fprintf(&buf, "[inject-error]\nevent = \"%s\"\nerrno = \"%d\"\nsector = \"%d\"\n\n", event, 5, sector)
+		}
+	}
+	return buf.String()
+}
+
+// UnplugDisk hot-unplugs the disk with the given drive id, "disk-1" for the
+// primary disk and "disk-2" and on for additional disks, like pulling it out
+// of a running server: the guest isn't asked first.
+func (inst *QemuInstance) UnplugDisk(drive string) error {
+	blkdevs, err := inst.listBlkDevices()
+	if err != nil {
+		return errors.Wrapf(err, "Could not list block devices through qmp")
+	}
+	for _, dev := range blkdevs.Return {
+		if dev.Device != drive {
+			continue
+		}
+		// virtio disks are the parent of their backend
+		device := strings.TrimSuffix(dev.DevicePath, "/virtio-backend")
+		return inst.deleteBlockDevice(device)
+	}
+	return fmt.Errorf("Target device %q not found in block device list", drive)
+}
+
+// ThrottleDisk limits the I/O of the disk with the given drive id to bps
+// bytes and iops operations per second, reads and writes together. 0 means
+// unlimited, so ThrottleDisk(drive, 0, 0) lifts the limits.
+func (inst *QemuInstance) ThrottleDisk(drive string, bps, iops int64) error {
+	return inst.setIOThrottle(drive, bps, iops)
+}
+
+// SetLinkUp sets the link state of the NIC with the given netdev id, i.e.
+// "eth0" for the usermode NIC, "eth1" and on for additional NICs, and
+// "tap0" for the NIC on the private network. The guest sees a link down
+// like an unplugged cable.
+func (inst *QemuInstance) SetLinkUp(netdev string, up bool) error {
+	return inst.setLink(netdev, up)
+}
+
+// SetBalloon inflates or deflates the balloon so that the guest is left
+// with mib MiB of memory, which requires QemuBuilder.Balloon. The guest
+// gives the memory back on its own, so this blocks only until QEMU asked
+// it to.
+func (inst *QemuInstance) SetBalloon(mib int) error {
+	return inst.setBalloon(int64(mib) * 1024 * 1024)
+}
@@ -0,0 +1,146 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestUsermodeIPv6Host(t *testing.T) {
+	tests := []struct {
+		net  string
+		host string // "" if net is rejected
+	}{
+		{"fec0::/64", "fec0::2"},
+		{"fd00:1:2:3::/64", "fd00:1:2:3::2"},
+		{"fd00::1234/64", "fd00::2"},
+		{"fd00::/126", "fd00::2"},
+		{"fd00::/127", ""},
+		{"fd00::/128", ""},
+		{"10.0.2.0/24", ""},
+		{"fec0::", ""},
+		{"bogus", ""},
+	}
+	for _, tt := range tests {
+		host, err := usermodeIPv6Host(tt.net)
+		if tt.host == "" {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %s", tt.net, host)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.net, err)
+		} else if host.String() != tt.host {
+			t.Errorf("%s: expected %s, got %s", tt.net, tt.host, host)
+		}
+	}
+}
+
+func TestUsermodeIPOpts(t *testing.T) {
+	tests := []struct {
+		name     string
+		builder  QemuBuilder
+		opts     string
+		fwdAddrs []string
+		fail     bool
+	}{
+		{
+			name:     "default",
+			fwdAddrs: []string{"127.0.0.1"},
+		},
+		{
+			name:     "ipv4",
+			builder:  QemuBuilder{UsermodeIPFamily: IPFamilyIPv4},
+			opts:     ",ipv4=on,ipv6=off",
+			fwdAddrs: []string{"127.0.0.1"},
+		},
+		{
+			name:     "ipv6",
+			builder:  QemuBuilder{UsermodeIPFamily: IPFamilyIPv6},
+			opts:     ",ipv4=off,ipv6=on",
+			fwdAddrs: []string{"::1"},
+		},
+		{
+			name:     "dual",
+			builder:  QemuBuilder{UsermodeIPFamily: IPFamilyDualStack},
+			opts:     ",ipv4=on,ipv6=on",
+			fwdAddrs: []string{"127.0.0.1", "::1"},
+		},
+		{
+			name:     "ipv6 network",
+			builder:  QemuBuilder{UsermodeIPFamily: IPFamilyIPv6, UsermodeIPv6Net: "fd00::/64"},
+			opts:     ",ipv4=off,ipv6=on,ipv6-net=fd00::/64,ipv6-host=fd00::2",
+			fwdAddrs: []string{"::1"},
+		},
+		{
+			name:     "ipv6 network by default",
+			builder:  QemuBuilder{UsermodeIPv6Net: "fd00::/64"},
+			opts:     ",ipv6-net=fd00::/64,ipv6-host=fd00::2",
+			fwdAddrs: []string{"127.0.0.1"},
+		},
+		{
+			name:     "dual with ipv4 network",
+			builder:  QemuBuilder{UsermodeIPFamily: IPFamilyDualStack, usermodeNetworkingAddr: "10.0.3.0/24"},
+			opts:     ",ipv4=on,ipv6=on",
+			fwdAddrs: []string{"127.0.0.1", "::1"},
+		},
+		{
+			name:    "ipv4 with ipv6 network",
+			builder: QemuBuilder{UsermodeIPFamily: IPFamilyIPv4, UsermodeIPv6Net: "fd00::/64"},
+			fail:    true,
+		},
+		{
+			name:    "ipv6 with ipv4 network",
+			builder: QemuBuilder{UsermodeIPFamily: IPFamilyIPv6, usermodeNetworkingAddr: "10.0.3.0/24"},
+			fail:    true,
+		},
+		{
+			name:    "ipv6 netboot",
+			builder: QemuBuilder{UsermodeIPFamily: IPFamilyIPv6, netbootP: "pxelinux.0"},
+			fail:    true,
+		},
+		{
+			name:    "bad ipv6 network",
+			builder: QemuBuilder{UsermodeIPFamily: IPFamilyIPv6, UsermodeIPv6Net: "10.0.2.0/24"},
+			fail:    true,
+		},
+		{
+			name:    "unknown family",
+			builder: QemuBuilder{UsermodeIPFamily: "ipx"},
+			fail:    true,
+		},
+	}
+	for _, tt := range tests {
+		opts, fwdAddrs, err := tt.builder.usermodeIPOpts()
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%s: expected an error, got %q", tt.name, opts)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if opts != tt.opts {
+			t.Errorf("%s: expected options %q, got %q", tt.name, tt.opts, opts)
+		}
+		if !reflect.DeepEqual(fwdAddrs, tt.fwdAddrs) {
+			t.Errorf("%s: expected forwarding addresses %v, got %v", tt.name, tt.fwdAddrs, fwdAddrs)
+		}
+	}
+}
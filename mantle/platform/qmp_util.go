@@ -19,6 +19,8 @@ package platform
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -39,10 +41,21 @@ type QOMBlkDev struct {
 		Inserted   struct {
 			BackingFileDepth int    `json:"backing_file_depth"`
 			NodeName         string `json:"node-name"`
+			Drv              string `json:"drv"`
+			ReadOnly         bool   `json:"ro"`
 		} `json:"inserted"`
 	} `json:"return"`
 }
 
+// QOMJobs is the list of background jobs, such as snapshots.
+type QOMJobs struct {
+	Return []struct {
+		ID     string `json:"id"`
+		Status string `json:"status"`
+		Error  string `json:"error"`
+	} `json:"return"`
+}
+
 // runQmpCommand executes a qemu command over the QMP socket.
 func (inst *QemuInstance) runQmpCommand(cmd string) ([]byte, error) {
 	if inst.qmpSocket == nil {
@@ -99,3 +112,158 @@ func (inst *QemuInstance) deleteBlockDevice(device string) error {
 	}
 	return nil
 }
+
+// dumpGuestMemory uses the qmp socket to write the memory of the guest to
+// path, in the compressed kdump format which crash(8) reads.
+func (inst *QemuInstance) dumpGuestMemory(path string) error {
+	cmd := fmt.Sprintf(`{ "execute": "dump-guest-memory", "arguments": { "paging": false, "protocol": "file:%s", "format": "kdump-zlib" } }`, path)
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return errors.Wrapf(err, "Dumping guest memory to %s", path)
+	}
+	return nil
+}
+
+// runQmpJob executes a qemu command which starts the job id, and waits for
+// the job to conclude. A job still running after timeout is cancelled.
+func (inst *QemuInstance) runQmpJob(cmd, id string, timeout time.Duration) error {
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		out, err := inst.runQmpCommand(`{ "execute": "query-jobs" }`)
+		if err != nil {
+			return errors.Wrapf(err, "Running QMP query-jobs command")
+		}
+		var jobs QOMJobs
+		if err = json.Unmarshal(out, &jobs); err != nil {
+			return errors.Wrapf(err, "De-serializing QMP query-jobs output")
+		}
+		found := false
+		for _, job := range jobs.Return {
+			if job.ID != id {
+				continue
+			}
+			found = true
+			if job.Status != "concluded" {
+				break
+			}
+			dismiss := fmt.Sprintf(`{ "execute": "job-dismiss", "arguments": { "id": "%s" } }`, id)
+			if _, err := inst.runQmpCommand(dismiss); err != nil {
+				return errors.Wrapf(err, "Dismissing job %s", id)
+			}
+			if job.Error != "" {
+				return errors.New(job.Error)
+			}
+			return nil
+		}
+		if !found {
+			return fmt.Errorf("job %s disappeared", id)
+		}
+		if time.Now().After(deadline) {
+			cancel := fmt.Sprintf(`{ "execute": "job-cancel", "arguments": { "id": "%s" } }`, id)
+			if _, err := inst.runQmpCommand(cancel); err != nil {
+				plog.Warningf("Cancelling job %s: %v", id, err)
+			}
+			return fmt.Errorf("job %s did not conclude within %v", id, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// snapshotNodes returns the block nodes which are saved in snapshots: the
+// writable disks, which must all be qcow2. The firmware variables are
+// left out, as they are raw.
+func (inst *QemuInstance) snapshotNodes() ([]string, error) {
+	blkdevs, err := inst.listBlkDevices()
+	if err != nil {
+		return nil, err
+	}
+	var nodes []string
+	for _, dev := range blkdevs.Return {
+		if dev.Inserted.NodeName == "" || dev.Inserted.ReadOnly || strings.HasPrefix(dev.Device, "pflash") {
+			continue
+		}
+		if dev.Inserted.Drv != "qcow2" {
+			return nil, fmt.Errorf("disk %s is %s, only qcow2 disks support snapshots", dev.Inserted.NodeName, dev.Inserted.Drv)
+		}
+		nodes = append(nodes, dev.Inserted.NodeName)
+	}
+	if len(nodes) == 0 {
+		return nil, errors.New("no disk to save the snapshot to")
+	}
+	return nodes, nil
+}
+
+// snapshotJobTimeout is how long saving, loading or deleting a snapshot,
+// memory included, may take.
+const snapshotJobTimeout = 10 * time.Minute
+
+// snapshotJob uses the qmp socket to run snapshot-save, snapshot-load or
+// snapshot-delete for the snapshot tag, with the memory in the first disk.
+func (inst *QemuInstance) snapshotJob(command, tag string) error {
+	nodes, err := inst.snapshotNodes()
+	if err != nil {
+		return err
+	}
+	id := fmt.Sprintf("%s-%d", command, time.Now().UnixNano())
+	args := map[string]interface{}{
+		"job-id":  id,
+		"tag":     tag,
+		"devices": nodes,
+	}
+	if command != "snapshot-delete" {
+		args["vmstate"] = nodes[0]
+	}
+	cmd, err := json.Marshal(map[string]interface{}{
+		"execute":   command,
+		"arguments": args,
+	})
+	if err != nil {
+		return err
+	}
+	if err := inst.runQmpJob(string(cmd), id, snapshotJobTimeout); err != nil {
+		return errors.Wrapf(err, "Running QMP %s command for snapshot %s", command, tag)
+	}
+	return nil
+}
+
+// setIOThrottle uses the qmp socket to limit the I/O of a drive, 0 meaning
+// unlimited.
+func (inst *QemuInstance) setIOThrottle(drive string, bps, iops int64) error {
+	cmd := fmt.Sprintf(`{ "execute": "block_set_io_throttle", "arguments": { "device":"%s", "bps":%d, "bps_rd":0, "bps_wr":0, "iops":%d, "iops_rd":0, "iops_wr":0 } }`,
+		drive, bps, iops)
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return errors.Wrapf(err, "Throttling drive %s", drive)
+	}
+	return nil
+}
+
+// setLink uses the qmp socket to bring the link of a NIC up or down.
+func (inst *QemuInstance) setLink(netdev string, up bool) error {
+	cmd := fmt.Sprintf(`{ "execute": "set_link", "arguments": { "name":"%s", "up":%t } }`, netdev, up)
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return errors.Wrapf(err, "Setting link of %s up=%t", netdev, up)
+	}
+	return nil
+}
+
+// setBalloon uses the qmp socket to set the target memory of the guest, in
+// bytes, through the balloon device.
+func (inst *QemuInstance) setBalloon(bytes int64) error {
+	cmd := fmt.Sprintf(`{ "execute": "balloon", "arguments": { "value":%d } }`, bytes)
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return errors.Wrapf(err, "Setting balloon target to %d bytes", bytes)
+	}
+	return nil
+}
+
+// screendump uses the qmp socket to save the screen of the guest to path,
+// as a PNG.
+func (inst *QemuInstance) screendump(path string) error {
+	cmd := fmt.Sprintf(`{ "execute": "screendump", "arguments": { "filename":"%s", "format":"png" } }`, path)
+	if _, err := inst.runQmpCommand(cmd); err != nil {
+		return errors.Wrapf(err, "Saving screen to %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,195 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// defaultScreenshotInterval is how often the screen is captured if
+// QemuBuilder.ScreenshotInterval is unset.
+const defaultScreenshotInterval = 5 * time.Second
+
+// ScreenHash is a perceptual hash of a screen, which stays close for
+// screens that look alike, e.g. the same GRUB menu with another entry
+// highlighted or timeout, so tests can recognize screens without OCR.
+type ScreenHash uint64
+
+func (h ScreenHash) String() string {
+	return fmt.Sprintf("%016x", uint64(h))
+}
+
+// Distance returns how many bits of the hashes differ. Mostly dark text
+// screens, like GRUB menus and login prompts, differ in few bits, so they
+// should be compared with a small maximum distance, e.g. 2.
+func (h ScreenHash) Distance(o ScreenHash) int {
+	return bits.OnesCount64(uint64(h ^ o))
+}
+
+// ParseScreenHash parses a hash as printed by ScreenHash.String, e.g. by
+// `kola screenhash`.
+func ParseScreenHash(s string) (ScreenHash, error) {
+	h, err := strconv.ParseUint(s, 16, 64)
+	if err != nil {
+		return 0, errors.Wrapf(err, "parsing screen hash %q", s)
+	}
+	return ScreenHash(h), nil
+}
+
+// HashScreen returns the difference hash of img: it is shrunk to 9x8 gray
+// cells, and each bit tells whether a cell is darker than the cell to its
+// right.
+func HashScreen(img image.Image) ScreenHash {
+	b := img.Bounds()
+	var cells [8][9]float64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 9; x++ {
+			r := image.Rect(b.Min.X+x*b.Dx()/9, b.Min.Y+y*b.Dy()/8,
+				b.Min.X+(x+1)*b.Dx()/9, b.Min.Y+(y+1)*b.Dy()/8)
+			cells[y][x] = luminance(img, r)
+		}
+	}
+	var h ScreenHash
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			h <<= 1
+			if cells[y][x] < cells[y][x+1] {
+				h |= 1
+			}
+		}
+	}
+	return h
+}
+
+// luminance returns the average gray level of the pixels of img in r.
+func luminance(img image.Image, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 0
+	}
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			sum += float64(color.GrayModel.Convert(img.At(x, y)).(color.Gray).Y)
+		}
+	}
+	return sum / float64(r.Dx()*r.Dy())
+}
+
+// ReadScreenHash returns the hash of the PNG screenshot at path.
+func ReadScreenHash(path string) (ScreenHash, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	img, err := png.Decode(f)
+	if err != nil {
+		return 0, errors.Wrapf(err, "decoding %s", path)
+	}
+	return HashScreen(img), nil
+}
+
+// framebufferDevice returns the display device to capture the screen of
+// the architecture from, the one its firmware draws on.
+func framebufferDevice(arch string) string {
+	switch arch {
+	case "aarch64":
+		return "ramfb"
+	case "s390x":
+		return "virtio-gpu-ccw"
+	default:
+		return "VGA"
+	}
+}
+
+// Screendump saves the screen of the instance to path as a PNG. The
+// instance needs a display, see QemuBuilder.Framebuffer.
+func (inst *QemuInstance) Screendump(path string) error {
+	return inst.screendump(path)
+}
+
+// WaitForScreen takes screendumps until one looks like want, i.e. is at
+// most maxDistance bits away from it, or ctx is done.
+func (inst *QemuInstance) WaitForScreen(ctx context.Context, want ScreenHash, maxDistance int) error {
+	f, err := os.CreateTemp(inst.tempdir, "screen-*.png")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	defer os.Remove(f.Name())
+
+	for {
+		if err := inst.Screendump(f.Name()); err != nil {
+			return err
+		}
+		h, err := ReadScreenHash(f.Name())
+		if err != nil {
+			return err
+		}
+		if h.Distance(want) <= maxDistance {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Wrapf(ctx.Err(), "waiting for screen %v, last was %v", want, h)
+		case <-time.After(time.Second):
+		}
+	}
+}
+
+// captureScreens saves the screen to dir every interval, when it changed,
+// until the instance is destroyed.
+func (inst *QemuInstance) captureScreens(dir string, interval time.Duration) {
+	defer close(inst.screensStopped)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	start := time.Now()
+	var last ScreenHash
+	n := 0
+	for {
+		select {
+		case <-inst.screensDone:
+			return
+		case <-ticker.C:
+		}
+		path := filepath.Join(dir, fmt.Sprintf("screen-%03d-%04ds.png", n, int(time.Since(start).Seconds())))
+		if err := inst.Screendump(path); err != nil {
+			plog.Debugf("Taking screenshot: %v", err)
+			continue
+		}
+		h, err := ReadScreenHash(path)
+		if err != nil {
+			plog.Debugf("Hashing screenshot: %v", err)
+			continue
+		}
+		if n > 0 && h == last {
+			os.Remove(path) //nolint // Ignore Errors
+			continue
+		}
+		last = h
+		n++
+	}
+}
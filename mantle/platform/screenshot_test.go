@@ -0,0 +1,191 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"testing"
+)
+
+// textScreen draws a menu-like screen: light rows of "text" on a dark
+// background, with the row highlighted, if any, drawn as a light bar.
+func textScreen(rows []string, highlighted int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	fill(img, img.Bounds(), color.RGBA{0, 0, 0, 255})
+	for i, row := range rows {
+		y := 40 + i*24
+		if i == highlighted {
+			fill(img, image.Rect(20, y-4, 620, y+20), color.RGBA{200, 200, 200, 255})
+		}
+		for j, c := range row {
+			if c == ' ' {
+				continue
+			}
+			fill(img, image.Rect(30+j*9, y, 37+j*9, y+16), color.RGBA{100, 160, 100, 255})
+		}
+	}
+	return img
+}
+
+// gradientScreen goes from black to white, left to right, or right to
+// left if reversed.
+func gradientScreen(reversed bool) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 640, 480))
+	for x := 0; x < 640; x++ {
+		v := uint8(x * 255 / 639)
+		if reversed {
+			v = 255 - v
+		}
+		fill(img, image.Rect(x, 0, x+1, 480), color.RGBA{v, v, v, 255})
+	}
+	return img
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			img.SetRGBA(x, y, c)
+		}
+	}
+}
+
+func TestHashScreen(t *testing.T) {
+	menu := []string{
+		"Fedora CoreOS 42.20250101.3.0 (Live)",
+		"Fedora CoreOS 42.20250101.3.0 (Live) (rescue)",
+		"UEFI Firmware Settings",
+	}
+	menuDrawn := textScreen(menu, 0)
+	offset := image.NewRGBA(menuDrawn.Bounds().Add(image.Pt(100, 50)))
+	draw.Draw(offset, offset.Bounds(), menuDrawn, image.Point{}, draw.Src)
+	tests := []struct {
+		name        string
+		a, b        image.Image
+		minDistance int
+		maxDistance int
+	}{
+		{
+			name: "identical",
+			a:    menuDrawn,
+			b:    textScreen(menu, 0),
+		},
+		{
+			name:        "near: same menu with a countdown",
+			a:           menuDrawn,
+			b:           textScreen(append(menu[:3:3], "", "The selected entry will be started in 4s"), 0),
+			maxDistance: 2,
+		},
+		{
+			name:        "far: another screen",
+			a:           menuDrawn,
+			b:           textScreen([]string{"", "", "", "", "", "", "", "", "", "", "localhost login:"}, -1),
+			minDistance: 3,
+			maxDistance: 64,
+		},
+		{
+			name:        "far: opposite gradients",
+			a:           gradientScreen(false),
+			b:           gradientScreen(true),
+			minDistance: 64,
+			maxDistance: 64,
+		},
+		{
+			name: "offset bounds",
+			a:    menuDrawn,
+			b:    offset,
+		},
+	}
+	for _, tt := range tests {
+		d := HashScreen(tt.a).Distance(HashScreen(tt.b))
+		if d < tt.minDistance || d > tt.maxDistance {
+			t.Errorf("%s: distance %d not in [%d, %d]", tt.name, d, tt.minDistance, tt.maxDistance)
+		}
+	}
+
+	if h := HashScreen(gradientScreen(false)); h != ^ScreenHash(0) {
+		t.Errorf("black to white gradient: expected all bits set, got %v", h)
+	}
+	if h := HashScreen(image.NewRGBA(image.Rect(0, 0, 640, 480))); h != 0 {
+		t.Errorf("blank screen: expected no bits set, got %v", h)
+	}
+	if h := HashScreen(image.NewRGBA(image.Rect(0, 0, 4, 4))); h != 0 {
+		t.Errorf("screen smaller than the hash: expected no bits set, got %v", h)
+	}
+}
+
+func TestScreenHashDistance(t *testing.T) {
+	tests := []struct {
+		a, b     ScreenHash
+		distance int
+	}{
+		{0, 0, 0},
+		{0x1234, 0x1234, 0},
+		{0, 1, 1},
+		{0x8000000000000000, 0, 1},
+		{0xff, 0x0f, 4},
+		{0, ^ScreenHash(0), 64},
+	}
+	for _, tt := range tests {
+		if d := tt.a.Distance(tt.b); d != tt.distance {
+			t.Errorf("%v to %v: expected %d, got %d", tt.a, tt.b, tt.distance, d)
+		}
+		if d := tt.b.Distance(tt.a); d != tt.distance {
+			t.Errorf("%v to %v: expected %d, got %d", tt.b, tt.a, tt.distance, d)
+		}
+	}
+}
+
+func TestParseScreenHash(t *testing.T) {
+	tests := []struct {
+		s    string
+		hash ScreenHash
+		fail bool
+	}{
+		{s: "0000000000000000", hash: 0},
+		{s: "00000000000000ff", hash: 0xff},
+		{s: "ffffffffffffffff", hash: ^ScreenHash(0)},
+		{s: "FFFFFFFFFFFFFFFF", hash: ^ScreenHash(0)},
+		{s: "1a2b", hash: 0x1a2b},
+		{s: "", fail: true},
+		{s: "0x1a2b", fail: true},
+		{s: "-1", fail: true},
+		{s: "1ffffffffffffffff", fail: true},
+		{s: "screen", fail: true},
+		{s: " 1a2b", fail: true},
+	}
+	for _, tt := range tests {
+		h, err := ParseScreenHash(tt.s)
+		if tt.fail {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", tt.s, h)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: %v", tt.s, err)
+		} else if h != tt.hash {
+			t.Errorf("%q: expected %v, got %v", tt.s, tt.hash, h)
+		}
+	}
+
+	for _, h := range []ScreenHash{0, 1, 0xdeadbeef, ^ScreenHash(0)} {
+		parsed, err := ParseScreenHash(h.String())
+		if err != nil || parsed != h {
+			t.Errorf("%v: round trip gave %v, %v", h, parsed, err)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package platform
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/ssh"
+)
+
+// SSHRecordingFile is the file in the output directory of a cluster which
+// has its recorded SSH commands, see RuntimeConfig.RecordSSH.
+const SSHRecordingFile = "ssh-recording.json"
+
+// SSHRecording is the SSH commands run on the machines of a cluster, in
+// the order they were run.
+type SSHRecording struct {
+	Commands []RecordedCommand `json:"commands"`
+}
+
+// RecordedCommand is a command run on a machine and its result.
+type RecordedCommand struct {
+	Machine    int    `json:"machine"` // index of the machine, see BaseCluster.MachineIndex
+	Cmd        string `json:"cmd"`
+	Stdout     string `json:"stdout"`
+	Stderr     string `json:"stderr"`
+	ExitStatus int    `json:"exitStatus"`
+}
+
+// ReadSSHRecording reads the recording written to path by a cluster.
+func ReadSSHRecording(path string) (*SSHRecording, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var r SSHRecording
+	if err := json.Unmarshal(buf, &r); err != nil {
+		return nil, errors.Wrapf(err, "parsing %s", path)
+	}
+	return &r, nil
+}
+
+// RecordSSH adds a command run on m to the recording of the cluster, if
+// RuntimeConfig.RecordSSH is set. Errors other than a non-zero exit
+// status are recorded as exit status 255, like ssh(1) does.
+func (bc *BaseCluster) RecordSSH(m Machine, cmd string, stdout, stderr []byte, err error) {
+	if !bc.rconf.RecordSSH {
+		return
+	}
+	status := 0
+	if err != nil {
+		status = 255
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			status = exitErr.ExitStatus()
+		}
+	}
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	bc.recording.Commands = append(bc.recording.Commands, RecordedCommand{
+		Machine:    bc.machindex[m.ID()],
+		Cmd:        cmd,
+		Stdout:     string(stdout),
+		Stderr:     string(stderr),
+		ExitStatus: status,
+	})
+}
+
+// writeSSHRecording writes the recording of the cluster to its output
+// directory.
+func (bc *BaseCluster) writeSSHRecording() error {
+	bc.machlock.Lock()
+	defer bc.machlock.Unlock()
+	buf, err := json.MarshalIndent(&bc.recording, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(bc.rconf.OutputDir, SSHRecordingFile), buf, 0644)
+}
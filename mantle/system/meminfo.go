@@ -0,0 +1,120 @@
+// Copyright 2025 Red Hat
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package system
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// GetAvailableMemory returns the number of bytes of memory which can be
+// allocated without swapping, taking into account the memory limit of
+// our cgroup.
+func GetAvailableMemory() (uint64, error) {
+	f, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, fmt.Errorf("reading meminfo: %w", err)
+	}
+	defer f.Close()
+
+	var available uint64
+	found := false
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || fields[0] != "MemAvailable:" {
+			continue
+		}
+		kb, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid MemAvailable: %w", err)
+		}
+		available = kb * 1024
+		found = true
+		break
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, fmt.Errorf("reading meminfo: %w", err)
+	}
+	if !found {
+		return 0, fmt.Errorf("no MemAvailable in meminfo")
+	}
+
+	limit, err := getMemoryLimit()
+	if err != nil {
+		return 0, err
+	}
+	if limit < available {
+		return limit, nil
+	}
+	return available, nil
+}
+
+// getMemoryLimit returns the memory our cgroup can still allocate, or
+// math.MaxUint64 if it isn't limited.
+func getMemoryLimit() (uint64, error) {
+	// cgroups v2
+	max, err := readCgroupValue("/sys/fs/cgroup/memory.max")
+	if err == nil {
+		current, err := readCgroupValue("/sys/fs/cgroup/memory.current")
+		if err != nil {
+			return 0, err
+		}
+		if current > max {
+			return 0, nil
+		}
+		return max - current, nil
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	// cgroups v1; unlimited is a huge number rather than "max"
+	limit, err := readCgroupValue("/sys/fs/cgroup/memory/memory.limit_in_bytes")
+	if os.IsNotExist(err) {
+		return math.MaxUint64, nil
+	} else if err != nil {
+		return 0, err
+	}
+	usage, err := readCgroupValue("/sys/fs/cgroup/memory/memory.usage_in_bytes")
+	if err != nil {
+		return 0, err
+	}
+	if usage > limit {
+		return 0, nil
+	}
+	return limit - usage, nil
+}
+
+// readCgroupValue reads a file holding a number of bytes or "max". Errors
+// reading the file are returned unwrapped so os.IsNotExist() works.
+func readCgroupValue(path string) (uint64, error) {
+	buf, err := os.ReadFile(path)
+	if err != nil {
+		return 0, err
+	}
+	s := strings.TrimSpace(string(buf))
+	if s == "max" {
+		return math.MaxUint64, nil
+	}
+	v, err := strconv.ParseUint(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value in %s: %w", path, err)
+	}
+	return v, nil
+}
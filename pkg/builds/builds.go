@@ -3,9 +3,11 @@ package builds
 import (
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
 
 	"github.com/pkg/errors"
 )
@@ -13,25 +15,64 @@ import (
 const (
 	// CosaBuildsJSON is the COSA build.json file name
 	CosaBuildsJSON = "builds.json"
+
+	// BuildsSchemaVersion is the builds.json schema version written
+	// for new build lists
+	BuildsSchemaVersion = "1.0.0"
 )
 
 var (
 	// ErrNoBuildsFound is thrown when a build is missing
 	ErrNoBuildsFound = errors.New("no COSA builds found")
+
+	// ErrBuildNotFound is thrown when a build ID is not in builds.json
+	ErrBuildNotFound = errors.New("build not found in builds.json")
+
+	// ErrBuildsFailsValidation is thrown on reading an invalid builds.json
+	ErrBuildsFailsValidation = errors.New("builds.json failed schema validation")
 )
 
-// build represents the build struct in a buildJSON
-type build struct {
-	ID     string   `json:"id"`
-	Arches []string `json:"arches"`
+// BuildEntry represents a single build in a builds.json
+type BuildEntry struct {
+	ID            string         `json:"id"`
+	Arches        []string       `json:"arches"`
+	PolicyCleanup *PolicyCleanup `json:"policy-cleanup,omitempty"`
+}
+
+// PolicyCleanup records the cleanup actions `cosa coreos-prune` has
+// completed for a build.
+type PolicyCleanup struct {
+	CloudUploads bool     `json:"cloud-uploads,omitempty"`
+	Containers   bool     `json:"containers,omitempty"`
+	Images       bool     `json:"images,omitempty"`
+	ImagesKept   []string `json:"images-kept,omitempty"`
+}
+
+// Tag represents a named pointer to a build in a builds.json
+type Tag struct {
+	Name        string `json:"name"`
+	Created     string `json:"created"`
+	Target      string `json:"target"`
+	Description string `json:"description,omitempty"`
 }
 
-// BuildsJSON represents the JSON that records the builds
-// TODO: this should be generated by a schema
+// BuildsJSON represents the JSON that records the builds. It is
+// validated against src/builds-v1.json on read. Older lists without a
+// schema version are accepted and get the current one when rewritten.
 type BuildsJSON struct {
-	SchemaVersion string  `json:"schema-version"`
-	Builds        []build `json:"builds"`
-	TimeStamp     string  `json:"timestamp"`
+	SchemaVersion   string       `json:"schema-version"`
+	Builds          []BuildEntry `json:"builds"`
+	TombstoneBuilds []BuildEntry `json:"tombstone-builds,omitempty"`
+	Tags            []Tag        `json:"tags,omitempty"`
+	TimeStamp       string       `json:"timestamp,omitempty"`
+}
+
+// NewBuildsJSON returns an empty build list using the current schema.
+func NewBuildsJSON() *BuildsJSON {
+	return &BuildsJSON{
+		SchemaVersion: BuildsSchemaVersion,
+		Builds:        []BuildEntry{},
+	}
 }
 
 func GetBuilds(dir string) (*BuildsJSON, error) {
@@ -40,18 +81,158 @@ func GetBuilds(dir string) (*BuildsJSON, error) {
 	if err != nil {
 		return nil, ErrNoBuildsFound
 	}
+	defer f.Close()
 	d := []byte{}
 	bufD := bytes.NewBuffer(d)
 	if _, err := io.Copy(bufD, f); err != nil {
 		return nil, err
 	}
+	return parseBuildsJSON(path, bufD.Bytes())
+}
+
+// parseBuildsJSON validates and decodes the builds.json read from source.
+func parseBuildsJSON(source string, data []byte) (*BuildsJSON, error) {
+	if errs := validateJSON(BuildsSchemaJSON, data); len(errs) != 0 {
+		return nil, errors.Wrapf(ErrBuildsFailsValidation, "%s: %v", source, errs)
+	}
 	b := &BuildsJSON{}
-	if err := json.Unmarshal(bufD.Bytes(), b); err != nil {
+	if err := json.Unmarshal(data, b); err != nil {
 		return nil, err
 	}
 	return b, nil
 }
 
+// WriteBuilds atomically rewrites dir/builds.json. The new content is
+// written to a temporary file in the same directory and renamed over
+// the old one, so readers never observe a partially written file.
+func (b *BuildsJSON) WriteBuilds(dir string) error {
+	out, err := b.marshal()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(dir, "."+CosaBuildsJSON+".tmp-")
+	if err != nil {
+		return errors.Wrapf(err, "failed to create temporary file in %s", dir)
+	}
+	tmpName := f.Name()
+	defer os.Remove(tmpName) //nolint
+
+	if _, err := f.Write(out); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Chmod(0644); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmpName, filepath.Join(dir, CosaBuildsJSON))
+}
+
+// marshal renders the build list as written to builds.json.
+func (b *BuildsJSON) marshal() ([]byte, error) {
+	if b.SchemaVersion == "" {
+		b.SchemaVersion = BuildsSchemaVersion
+	}
+	if b.Builds == nil {
+		b.Builds = []BuildEntry{}
+	}
+	out, err := json.MarshalIndent(b, "", "    ")
+	if err != nil {
+		return nil, err
+	}
+	return append(out, '\n'), nil
+}
+
+// BumpTimestamp sets the timestamp of the build list to now.
+func (b *BuildsJSON) BumpTimestamp() {
+	b.TimeStamp = time.Now().UTC().Format(time.RFC3339)
+}
+
+// Has reports whether the build ID is recorded in the build list.
+func (b *BuildsJSON) Has(buildID string) bool {
+	return b.indexOf(buildID) >= 0
+}
+
+// Get returns the build list entry for a build ID.
+func (b *BuildsJSON) Get(buildID string) (*BuildEntry, bool) {
+	i := b.indexOf(buildID)
+	if i < 0 {
+		return nil, false
+	}
+	return &b.Builds[i], true
+}
+
+// Latest returns the newest build in the build list, regardless of arch.
+func (b *BuildsJSON) Latest() (string, bool) {
+	if len(b.Builds) == 0 {
+		return "", false
+	}
+	return b.Builds[0].ID, true
+}
+
+// InsertBuild records a build for an arch. New builds are prepended since
+// builds.json is ordered newest first; inserting an arch for an existing
+// build extends that build's arches.
+func (b *BuildsJSON) InsertBuild(buildID, arch string) error {
+	if e, ok := b.Get(buildID); ok {
+		for _, a := range e.Arches {
+			if a == arch {
+				return fmt.Errorf("build %s for %s already exists", buildID, arch)
+			}
+		}
+		e.Arches = append(e.Arches, arch)
+		return nil
+	}
+	b.Builds = append([]BuildEntry{{ID: buildID, Arches: []string{arch}}}, b.Builds...)
+	return nil
+}
+
+// RemoveBuild drops a build from the build list along with any tags
+// pointing to it.
+func (b *BuildsJSON) RemoveBuild(buildID string) error {
+	i := b.indexOf(buildID)
+	if i < 0 {
+		return errors.Wrapf(ErrBuildNotFound, "build %s", buildID)
+	}
+	b.Builds = append(b.Builds[:i], b.Builds[i+1:]...)
+	tags := b.Tags[:0]
+	for _, t := range b.Tags {
+		if t.Target != buildID {
+			tags = append(tags, t)
+		}
+	}
+	b.Tags = tags
+	return nil
+}
+
+// TagsFor returns the names of the tags pointing at a build.
+func (b *BuildsJSON) TagsFor(buildID string) []string {
+	var ret []string
+	for _, t := range b.Tags {
+		if t.Target == buildID {
+			ret = append(ret, t.Name)
+		}
+	}
+	return ret
+}
+
+func (b *BuildsJSON) indexOf(buildID string) int {
+	for i, e := range b.Builds {
+		if e.ID == buildID {
+			return i
+		}
+	}
+	return -1
+}
+
 // getLatest returns the latest build for the arch.
 func (b *BuildsJSON) getLatest(arch string) (string, bool) {
 	for _, b := range b.Builds {
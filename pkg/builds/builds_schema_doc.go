@@ -0,0 +1,134 @@
+// Generated by ./generate-schema.sh
+// Source hash: 6887e731d12f1fac2cbaf0b8ad18f8ec71a7e9e9602b4dcd3fad58cab9c543ac
+// DO NOT EDIT
+
+package builds
+
+var generatedBuildsSchemaJSON = `{
+  "definitions": {
+    "build": {
+      "type": "object",
+      "required": [
+        "id",
+        "arches"
+      ],
+      "properties": {
+        "id": {
+          "$id": "#/build/id",
+          "type": "string",
+          "title": "Build ID",
+          "minLength": 1
+        },
+        "arches": {
+          "$id": "#/build/arches",
+          "type": "array",
+          "title": "Architectures",
+          "minItems": 1,
+          "uniqueItems": true,
+          "items": {
+            "type": "string"
+          }
+        },
+        "policy-cleanup": {
+          "$id": "#/build/policy-cleanup",
+          "type": "object",
+          "title": "Completed cleanup actions from cosa coreos-prune",
+          "properties": {
+            "cloud-uploads": {
+              "type": "boolean"
+            },
+            "containers": {
+              "type": "boolean"
+            },
+            "images": {
+              "type": "boolean"
+            },
+            "images-kept": {
+              "type": "array",
+              "items": {
+                "type": "string"
+              }
+            }
+          }
+        }
+      }
+    },
+    "tag": {
+      "type": "object",
+      "required": [
+        "name",
+        "created",
+        "target"
+      ],
+      "properties": {
+        "name": {
+          "$id": "#/tag/name",
+          "type": "string",
+          "title": "Tag name",
+          "minLength": 1
+        },
+        "created": {
+          "$id": "#/tag/created",
+          "type": "string",
+          "title": "Creation timestamp"
+        },
+        "target": {
+          "$id": "#/tag/target",
+          "type": "string",
+          "title": "Target build ID",
+          "minLength": 1
+        },
+        "description": {
+          "$id": "#/tag/description",
+          "type": "string",
+          "title": "Description"
+        }
+      }
+    }
+  },
+  "$schema": "http://json-schema.org/draft-07/schema#",
+  "$id": "http://github.com/coreos/coreos-assembler/blob/main/src/builds-v1.json",
+  "type": "object",
+  "title": "CoreOS Assembler v1 builds.json schema",
+  "required": [
+    "builds"
+  ],
+  "properties": {
+    "schema-version": {
+      "$id": "#/properties/schema-version",
+      "type": "string",
+      "title": "Schema version",
+      "pattern": "^1\\.[0-9]+\\.[0-9]+$"
+    },
+    "timestamp": {
+      "$id": "#/properties/timestamp",
+      "type": "string",
+      "title": "Last modification timestamp"
+    },
+    "builds": {
+      "$id": "#/properties/builds",
+      "type": "array",
+      "title": "Builds, newest first",
+      "items": {
+        "$ref": "#/definitions/build"
+      }
+    },
+    "tombstone-builds": {
+      "$id": "#/properties/tombstone-builds",
+      "type": "array",
+      "title": "Builds removed by cosa coreos-prune",
+      "items": {
+        "$ref": "#/definitions/build"
+      }
+    },
+    "tags": {
+      "$id": "#/properties/tags",
+      "type": "array",
+      "title": "Tags",
+      "items": {
+        "$ref": "#/definitions/tag"
+      }
+    }
+  }
+}
+`
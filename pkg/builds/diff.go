@@ -0,0 +1,579 @@
+package builds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+const (
+	// CosaCommitMetaJSON is the ostree commit metadata stored next to meta.json
+	CosaCommitMetaJSON = "commitmeta.json"
+)
+
+// Change kinds used in a BuildDiff
+const (
+	ChangeAdded      = "added"
+	ChangeRemoved    = "removed"
+	ChangeModified   = "modified"
+	ChangeUpgraded   = "upgraded"
+	ChangeDowngraded = "downgraded"
+)
+
+// Package is an RPM from the rpmdb package list of an ostree commit.
+type Package struct {
+	Name    string `json:"name"`
+	Epoch   string `json:"epoch"`
+	Version string `json:"version"`
+	Release string `json:"release"`
+	Arch    string `json:"arch"`
+}
+
+// EVR returns the epoch:version-release of the package, omitting a zero epoch.
+func (p Package) EVR() string {
+	if p.Epoch == "" || p.Epoch == "0" {
+		return fmt.Sprintf("%s-%s", p.Version, p.Release)
+	}
+	return fmt.Sprintf("%s:%s-%s", p.Epoch, p.Version, p.Release)
+}
+
+// CommitMeta is the subset of commitmeta.json used for diffing builds.
+type CommitMeta struct {
+	Packages   []Package
+	Advisories []string
+}
+
+// ReadCommitMeta parses the package list and advisory IDs from a
+// commitmeta.json.
+func ReadCommitMeta(path string) (*CommitMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseCommitMeta(f)
+}
+
+func parseCommitMeta(r io.Reader) (*CommitMeta, error) {
+	var raw struct {
+		PkgList    [][]interface{} `json:"rpmostree.rpmdb.pkglist"`
+		Advisories [][]interface{} `json:"rpmostree.advisories"`
+	}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return nil, fmt.Errorf("failed to parse commitmeta: %w", err)
+	}
+	cm := &CommitMeta{}
+	for _, p := range raw.PkgList {
+		if len(p) != 5 {
+			return nil, fmt.Errorf("invalid package entry in commitmeta: %v", p)
+		}
+		s := make([]string, 5)
+		for i, v := range p {
+			s[i] = fmt.Sprint(v)
+		}
+		cm.Packages = append(cm.Packages, Package{s[0], s[1], s[2], s[3], s[4]})
+	}
+	for _, a := range raw.Advisories {
+		if len(a) == 0 {
+			continue
+		}
+		cm.Advisories = append(cm.Advisories, fmt.Sprint(a[0]))
+	}
+	return cm, nil
+}
+
+// DiffTarget is one side of a build diff. Only Build is required; the
+// package, advisory and kernel argument sections of a diff are filled in
+// only when both sides provide the corresponding data.
+type DiffTarget struct {
+	Build      *Build
+	CommitMeta *CommitMeta
+	// KernelArgs are the extra kernel arguments from the image config,
+	// or nil if unknown.
+	KernelArgs []string
+}
+
+// ValueChange records a named value which differs between two builds. An
+// empty From or To means the value is absent on that side.
+type ValueChange struct {
+	Key  string `json:"key"`
+	From string `json:"from,omitempty"`
+	To   string `json:"to,omitempty"`
+}
+
+// PackageChange records a package which differs between two builds.
+type PackageChange struct {
+	Name   string   `json:"name"`
+	Change string   `json:"change"`
+	From   *Package `json:"from,omitempty"`
+	To     *Package `json:"to,omitempty"`
+}
+
+// ArtifactChange records an artifact which differs between two builds.
+type ArtifactChange struct {
+	Name       string `json:"name"`
+	Change     string `json:"change"`
+	FromPath   string `json:"from-path,omitempty"`
+	ToPath     string `json:"to-path,omitempty"`
+	FromSize   int64  `json:"from-size,omitempty"`
+	ToSize     int64  `json:"to-size,omitempty"`
+	FromSha256 string `json:"from-sha256,omitempty"`
+	ToSha256   string `json:"to-sha256,omitempty"`
+}
+
+// SetDiff lists the members added to and removed from a set.
+type SetDiff struct {
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Empty reports whether the sets were identical.
+func (s *SetDiff) Empty() bool {
+	return s == nil || (len(s.Added) == 0 && len(s.Removed) == 0)
+}
+
+// BuildDiff is a structured comparison of two builds. All lists are
+// sorted so that the same pair of builds always produces the same diff.
+type BuildDiff struct {
+	From       string           `json:"from"`
+	To         string           `json:"to"`
+	Metadata   []ValueChange    `json:"metadata,omitempty"`
+	Packages   []PackageChange  `json:"packages,omitempty"`
+	Advisories *SetDiff         `json:"advisories,omitempty"`
+	KernelArgs *SetDiff         `json:"kernel-args,omitempty"`
+	Artifacts  []ArtifactChange `json:"artifacts,omitempty"`
+	Cloud      []ValueChange    `json:"cloud,omitempty"`
+}
+
+// Diff compares the meta.json of two builds: ostree and source metadata,
+// artifacts and cloud upload records.
+func Diff(a, b *Build) *BuildDiff {
+	return DiffTargets(&DiffTarget{Build: a}, &DiffTarget{Build: b})
+}
+
+// DiffTargets compares two builds, including their package sets and
+// advisories if both commitmeta are available and their kernel arguments
+// if both are known.
+func DiffTargets(a, b *DiffTarget) *BuildDiff {
+	d := &BuildDiff{
+		From:      a.Build.BuildID,
+		To:        b.Build.BuildID,
+		Metadata:  diffMaps(metadataRecords(a.Build), metadataRecords(b.Build)),
+		Artifacts: diffArtifacts(a.Build, b.Build),
+		Cloud:     diffMaps(cloudRecords(a.Build), cloudRecords(b.Build)),
+	}
+	if a.CommitMeta != nil && b.CommitMeta != nil {
+		d.Packages = diffPackages(a.CommitMeta.Packages, b.CommitMeta.Packages)
+		if s := diffSets(a.CommitMeta.Advisories, b.CommitMeta.Advisories); !s.Empty() {
+			d.Advisories = s
+		}
+	}
+	if a.KernelArgs != nil && b.KernelArgs != nil {
+		if s := diffSets(a.KernelArgs, b.KernelArgs); !s.Empty() {
+			d.KernelArgs = s
+		}
+	}
+	return d
+}
+
+func metadataRecords(b *Build) map[string]string {
+	m := map[string]string{
+		"name":                                   b.Name,
+		"ostree-commit":                          b.OstreeCommit,
+		"ostree-version":                         b.OstreeVersion,
+		"ostree-timestamp":                       b.OstreeTimestamp,
+		"ref":                                    b.BuildRef,
+		"coreos-assembler.basearch":              b.Architecture,
+		"coreos-assembler.config-gitrev":         b.ConfigGitRev,
+		"coreos-assembler.config-variant":        b.ConfigVariant,
+		"coreos-assembler.image-config-checksum": b.CosaImageChecksum,
+		"coreos-assembler.image-input-checksum":  b.ImageInputChecksum,
+		"rpm-ostree-inputhash":                   b.InputHashOfTheRpmOstree,
+	}
+	if b.ContainerConfigGit != nil {
+		m["coreos-assembler.container-config-git"] = gitRecord(b.ContainerConfigGit)
+	}
+	if b.CosaContainerImageGit != nil {
+		m["coreos-assembler.container-image-git"] = gitRecord(b.CosaContainerImageGit)
+	}
+	return m
+}
+
+func gitRecord(g *Git) string {
+	s := fmt.Sprintf("%s@%s", g.Origin, g.Commit)
+	if g.Dirty == "true" {
+		s += " (dirty)"
+	}
+	return s
+}
+
+func cloudRecords(b *Build) map[string]string {
+	m := make(map[string]string)
+	for _, ami := range b.Amis {
+		m["amis/"+ami.Region] = ami.Hvm
+	}
+	for _, ami := range b.AwsWinLi {
+		m["aws-winli/"+ami.Region] = ami.Hvm
+	}
+	for _, img := range b.AlibabaAliyunUploads {
+		m["aliyun/"+img.Region] = img.ImageID
+	}
+	if b.Gcp != nil {
+		m["gcp"] = fmt.Sprintf("%s/%s", b.Gcp.ImageProject, b.Gcp.ImageName)
+	}
+	if b.Azure != nil {
+		m["azure"] = b.Azure.URL
+	}
+	for _, c := range b.IbmCloud {
+		m["ibmcloud/"+c.Region] = c.URL
+	}
+	for _, c := range b.PowerVirtualServer {
+		m["powervs/"+c.Region] = c.URL
+	}
+	if b.S3 != nil {
+		m["s3"] = fmt.Sprintf("%s/%s", b.S3.Bucket, b.S3.Key)
+	}
+	if b.Koji != nil && b.Koji.KojiBuildID != 0 {
+		m["koji"] = fmt.Sprintf("%d", int64(b.Koji.KojiBuildID))
+	}
+	for key, img := range map[string]*PrimaryImage{
+		"base-oscontainer":     b.BaseOsContainer,
+		"extensions-container": b.ExtensionsContainer,
+		"kubevirt":             b.KubevirtContainer,
+		"oscontainer":          b.Oscontainer,
+	} {
+		if img != nil {
+			m[key] = fmt.Sprintf("%s@%s", img.Image, img.Digest)
+		}
+	}
+	if b.ReleasePayload != nil {
+		m["release-payload"] = fmt.Sprintf("%s@%s", b.ReleasePayload.Image, b.ReleasePayload.Digest)
+	}
+	return m
+}
+
+// diffMaps returns the sorted changes between two maps, ignoring keys
+// with empty values on both sides.
+func diffMaps(from, to map[string]string) []ValueChange {
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	var ret []ValueChange
+	for _, k := range sortedKeys(keys) {
+		if from[k] != to[k] {
+			ret = append(ret, ValueChange{Key: k, From: from[k], To: to[k]})
+		}
+	}
+	return ret
+}
+
+func diffArtifacts(a, b *Build) []ArtifactChange {
+	from := artifactsOf(a)
+	to := artifactsOf(b)
+	keys := make(map[string]bool)
+	for k := range from {
+		keys[k] = true
+	}
+	for k := range to {
+		keys[k] = true
+	}
+	var ret []ArtifactChange
+	for _, k := range sortedKeys(keys) {
+		f, fok := from[k]
+		t, tok := to[k]
+		c := ArtifactChange{Name: k}
+		switch {
+		case fok && !tok:
+			c.Change = ChangeRemoved
+		case !fok && tok:
+			c.Change = ChangeAdded
+		case f.Sha256 != t.Sha256 || f.SizeInBytes != t.SizeInBytes:
+			c.Change = ChangeModified
+		default:
+			continue
+		}
+		if fok {
+			c.FromPath, c.FromSize, c.FromSha256 = f.Path, int64(f.SizeInBytes), f.Sha256
+		}
+		if tok {
+			c.ToPath, c.ToSize, c.ToSha256 = t.Path, int64(t.SizeInBytes), t.Sha256
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+// artifactsOf returns the artifacts of a build which have a path.
+func artifactsOf(b *Build) map[string]*Artifact {
+	ret := make(map[string]*Artifact)
+	if b.BuildArtifacts == nil {
+		return ret
+	}
+	for k, v := range b.artifacts() {
+		if v != nil && v.Path != "" {
+			ret[k] = v
+		}
+	}
+	return ret
+}
+
+func diffPackages(from, to []Package) []PackageChange {
+	f := make(map[string]Package)
+	for _, p := range from {
+		f[p.Name] = p
+	}
+	t := make(map[string]Package)
+	for _, p := range to {
+		t[p.Name] = p
+	}
+	names := make(map[string]bool)
+	for n := range f {
+		names[n] = true
+	}
+	for n := range t {
+		names[n] = true
+	}
+	var ret []PackageChange
+	for _, n := range sortedKeys(names) {
+		fp, fok := f[n]
+		tp, tok := t[n]
+		c := PackageChange{Name: n}
+		switch {
+		case fok && !tok:
+			c.Change = ChangeRemoved
+			c.From = &fp
+		case !fok && tok:
+			c.Change = ChangeAdded
+			c.To = &tp
+		default:
+			rc := RpmVerCmp(fp.Epoch, tp.Epoch)
+			if rc == 0 {
+				rc = RpmVerCmp(fp.Version, tp.Version)
+			}
+			if rc == 0 {
+				rc = RpmVerCmp(fp.Release, tp.Release)
+			}
+			switch {
+			case rc < 0:
+				c.Change = ChangeUpgraded
+			case rc > 0:
+				c.Change = ChangeDowngraded
+			default:
+				continue
+			}
+			c.From, c.To = &fp, &tp
+		}
+		ret = append(ret, c)
+	}
+	return ret
+}
+
+func diffSets(from, to []string) *SetDiff {
+	f := make(map[string]bool)
+	for _, s := range from {
+		f[s] = true
+	}
+	t := make(map[string]bool)
+	for _, s := range to {
+		t[s] = true
+	}
+	d := &SetDiff{}
+	for _, s := range sortedKeys(t) {
+		if !f[s] {
+			d.Added = append(d.Added, s)
+		}
+	}
+	for _, s := range sortedKeys(f) {
+		if !t[s] {
+			d.Removed = append(d.Removed, s)
+		}
+	}
+	return d
+}
+
+func sortedKeys(m map[string]bool) []string {
+	ret := make([]string, 0, len(m))
+	for k := range m {
+		ret = append(ret, k)
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+// Markdown renders the diff for pasting into release notes.
+func (d *BuildDiff) Markdown() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "## Changes from %s to %s\n", d.From, d.To)
+
+	section := func(title string) {
+		fmt.Fprintf(&sb, "\n### %s\n\n", title)
+	}
+	orNone := func(s string) string {
+		if s == "" {
+			return "_none_"
+		}
+		return "`" + s + "`"
+	}
+
+	if len(d.Metadata) > 0 {
+		section("Metadata")
+		sb.WriteString("| Key | From | To |\n|---|---|---|\n")
+		for _, c := range d.Metadata {
+			fmt.Fprintf(&sb, "| %s | %s | %s |\n", c.Key, orNone(c.From), orNone(c.To))
+		}
+	}
+	if len(d.Packages) > 0 {
+		section("Packages")
+		for _, kind := range []string{ChangeUpgraded, ChangeDowngraded, ChangeAdded, ChangeRemoved} {
+			var lines []string
+			for _, p := range d.Packages {
+				if p.Change != kind {
+					continue
+				}
+				switch kind {
+				case ChangeAdded:
+					lines = append(lines, fmt.Sprintf("- %s %s", p.Name, p.To.EVR()))
+				case ChangeRemoved:
+					lines = append(lines, fmt.Sprintf("- %s %s", p.Name, p.From.EVR()))
+				default:
+					lines = append(lines, fmt.Sprintf("- %s %s → %s", p.Name, p.From.EVR(), p.To.EVR()))
+				}
+			}
+			if len(lines) > 0 {
+				fmt.Fprintf(&sb, "%s%s:\n\n%s\n\n", strings.ToUpper(kind[:1]), kind[1:], strings.Join(lines, "\n"))
+			}
+		}
+	}
+	writeSet := func(title string, s *SetDiff) {
+		if s.Empty() {
+			return
+		}
+		section(title)
+		for _, a := range s.Added {
+			fmt.Fprintf(&sb, "- added `%s`\n", a)
+		}
+		for _, r := range s.Removed {
+			fmt.Fprintf(&sb, "- removed `%s`\n", r)
+		}
+	}
+	writeSet("Advisories", d.Advisories)
+	writeSet("Kernel arguments", d.KernelArgs)
+	if len(d.Artifacts) > 0 {
+		section("Artifacts")
+		sb.WriteString("| Artifact | Change | From size | To size |\n|---|---|---|---|\n")
+		for _, a := range d.Artifacts {
+			fmt.Fprintf(&sb, "| %s | %s | %d | %d |\n", a.Name, a.Change, a.FromSize, a.ToSize)
+		}
+	}
+	if len(d.Cloud) > 0 {
+		section("Cloud uploads")
+		sb.WriteString("| Record | From | To |\n|---|---|---|\n")
+		for _, c := range d.Cloud {
+			fmt.Fprintf(&sb, "| %s | %s | %s |\n", c.Key, orNone(c.From), orNone(c.To))
+		}
+	}
+	return sb.String()
+}
+
+// RpmVerCmp compares two RPM version or release strings using the rpmvercmp
+// algorithm. It returns -1 if a is older than b, 1 if a is newer and 0 if
+// they are equal.
+func RpmVerCmp(a, b string) int {
+	if a == b {
+		return 0
+	}
+	isAlnum := func(c byte) bool {
+		return isDigit(c) || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+	}
+	for len(a) > 0 || len(b) > 0 {
+		for len(a) > 0 && !isAlnum(a[0]) && a[0] != '~' && a[0] != '^' {
+			a = a[1:]
+		}
+		for len(b) > 0 && !isAlnum(b[0]) && b[0] != '~' && b[0] != '^' {
+			b = b[1:]
+		}
+
+		// tilde sorts before everything, even the end of the string
+		if strings.HasPrefix(a, "~") || strings.HasPrefix(b, "~") {
+			if !strings.HasPrefix(a, "~") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "~") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		// caret sorts after the end of the string, but before anything else
+		if strings.HasPrefix(a, "^") || strings.HasPrefix(b, "^") {
+			if len(a) == 0 {
+				return -1
+			}
+			if len(b) == 0 {
+				return 1
+			}
+			if !strings.HasPrefix(a, "^") {
+				return 1
+			}
+			if !strings.HasPrefix(b, "^") {
+				return -1
+			}
+			a, b = a[1:], b[1:]
+			continue
+		}
+		if len(a) == 0 || len(b) == 0 {
+			break
+		}
+
+		numeric := isDigit(a[0])
+		span := func(s string) (string, string) {
+			i := 0
+			for i < len(s) && ((numeric && isDigit(s[i])) || (!numeric && isAlnum(s[i]) && !isDigit(s[i]))) {
+				i++
+			}
+			return s[:i], s[i:]
+		}
+		var sa, sb string
+		sa, a = span(a)
+		sb, b = span(b)
+		if sb == "" {
+			// numeric segments are always newer than alpha ones
+			if numeric {
+				return 1
+			}
+			return -1
+		}
+		if numeric {
+			sa = strings.TrimLeft(sa, "0")
+			sb = strings.TrimLeft(sb, "0")
+			if len(sa) != len(sb) {
+				if len(sa) > len(sb) {
+					return 1
+				}
+				return -1
+			}
+		}
+		if c := strings.Compare(sa, sb); c != 0 {
+			return c
+		}
+	}
+	switch {
+	case len(a) == 0 && len(b) == 0:
+		return 0
+	case len(a) == 0:
+		return -1
+	default:
+		return 1
+	}
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
@@ -0,0 +1,166 @@
+package builds
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+func TestRpmVerCmp(t *testing.T) {
+	cases := []struct {
+		a, b string
+		want int
+	}{
+		{"1.0", "1.0", 0},
+		{"1.0", "2.0", -1},
+		{"2.0", "1.0", 1},
+		{"1.10", "1.9", 1},
+		{"1.0a", "1.0", 1},
+		{"1.0", "1.0~rc1", 1},
+		{"1.0~rc1", "1.0~rc2", -1},
+		{"1.0^git1", "1.0", 1},
+		{"1.0^git1", "1.0.1", -1},
+		{"a", "1", -1},
+		{"1.001", "1.1", 0},
+		{"5.fc40", "5.fc41", -1},
+	}
+	for _, c := range cases {
+		if got := RpmVerCmp(c.a, c.b); got != c.want {
+			t.Errorf("RpmVerCmp(%q, %q) = %d, expected %d", c.a, c.b, got, c.want)
+		}
+	}
+}
+
+const testCommitMetaFrom = `{
+  "rpmostree.rpmdb.pkglist": [
+    ["bash", "0", "5.2.26", "1.fc40", "x86_64"],
+    ["kernel", "0", "6.8.5", "301.fc40", "x86_64"],
+    ["nano", "0", "7.2", "6.fc40", "x86_64"],
+    ["podman", "5", "5.0.1", "1.fc40", "x86_64"]
+  ],
+  "rpmostree.advisories": [["FEDORA-2024-1", 1, 0, [], {}]]
+}`
+
+const testCommitMetaTo = `{
+  "rpmostree.rpmdb.pkglist": [
+    ["bash", "0", "5.2.26", "1.fc40", "x86_64"],
+    ["kernel", "0", "6.8.7", "300.fc40", "x86_64"],
+    ["podman", "5", "5.0.0", "1.fc40", "x86_64"],
+    ["vim-minimal", "2", "9.1.264", "1.fc40", "x86_64"]
+  ],
+  "rpmostree.advisories": [["FEDORA-2024-1", 1, 0, [], {}], ["FEDORA-2024-2", 2, 0, [], {}]]
+}`
+
+func TestDiffTargets(t *testing.T) {
+	fromCM, err := parseCommitMeta(strings.NewReader(testCommitMetaFrom))
+	if err != nil {
+		t.Fatal(err)
+	}
+	toCM, err := parseCommitMeta(strings.NewReader(testCommitMetaTo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := &Build{
+		BuildID:       "40.1",
+		OstreeCommit:  "aaaa",
+		OstreeVersion: "40.1",
+		Amis:          []Amis{{Region: "us-east-1", Hvm: "ami-1"}},
+		BuildArtifacts: &BuildArtifacts{
+			Ostree: Artifact{Path: "ostree.ociarchive", Sha256: "1", SizeInBytes: 10},
+			Qemu:   &Artifact{Path: "qemu.qcow2", Sha256: "2", SizeInBytes: 20},
+			Metal:  &Artifact{Path: "metal.raw", Sha256: "3", SizeInBytes: 30},
+		},
+	}
+	b := &Build{
+		BuildID:       "40.2",
+		OstreeCommit:  "bbbb",
+		OstreeVersion: "40.2",
+		Amis:          []Amis{{Region: "us-east-1", Hvm: "ami-2"}, {Region: "us-west-1", Hvm: "ami-3"}},
+		BuildArtifacts: &BuildArtifacts{
+			Ostree: Artifact{Path: "ostree.ociarchive", Sha256: "1", SizeInBytes: 10},
+			Qemu:   &Artifact{Path: "qemu.qcow2", Sha256: "4", SizeInBytes: 25},
+			Aws:    &Artifact{Path: "aws.vmdk", Sha256: "5", SizeInBytes: 40},
+		},
+	}
+
+	d := DiffTargets(
+		&DiffTarget{Build: a, CommitMeta: fromCM, KernelArgs: []string{"console=ttyS0"}},
+		&DiffTarget{Build: b, CommitMeta: toCM, KernelArgs: []string{"console=ttyS0", "mitigations=auto"}},
+	)
+
+	pkgs := make(map[string]string)
+	for _, p := range d.Packages {
+		pkgs[p.Name] = p.Change
+	}
+	expectedPkgs := map[string]string{
+		"kernel":      ChangeUpgraded,
+		"nano":        ChangeRemoved,
+		"podman":      ChangeDowngraded,
+		"vim-minimal": ChangeAdded,
+	}
+	if len(pkgs) != len(expectedPkgs) {
+		t.Errorf("unexpected package changes: %v", pkgs)
+	}
+	for n, c := range expectedPkgs {
+		if pkgs[n] != c {
+			t.Errorf("expected %s to be %s, got %q", n, c, pkgs[n])
+		}
+	}
+
+	arts := make(map[string]string)
+	for _, a := range d.Artifacts {
+		arts[a.Name] = a.Change
+	}
+	expectedArts := map[string]string{
+		"aws":   ChangeAdded,
+		"metal": ChangeRemoved,
+		"qemu":  ChangeModified,
+	}
+	if len(arts) != len(expectedArts) {
+		t.Errorf("unexpected artifact changes: %v", arts)
+	}
+	for n, c := range expectedArts {
+		if arts[n] != c {
+			t.Errorf("expected artifact %s to be %s, got %q", n, c, arts[n])
+		}
+	}
+
+	if d.Advisories == nil || len(d.Advisories.Added) != 1 || d.Advisories.Added[0] != "FEDORA-2024-2" {
+		t.Errorf("unexpected advisories diff: %+v", d.Advisories)
+	}
+	if d.KernelArgs == nil || len(d.KernelArgs.Added) != 1 || d.KernelArgs.Added[0] != "mitigations=auto" {
+		t.Errorf("unexpected kernel args diff: %+v", d.KernelArgs)
+	}
+	if len(d.Cloud) != 2 || d.Cloud[0].Key != "amis/us-east-1" || d.Cloud[1].From != "" {
+		t.Errorf("unexpected cloud diff: %+v", d.Cloud)
+	}
+
+	// The output must be stable across runs
+	first, _ := json.Marshal(d)
+	for i := 0; i < 5; i++ {
+		again, _ := json.Marshal(DiffTargets(
+			&DiffTarget{Build: a, CommitMeta: fromCM, KernelArgs: []string{"console=ttyS0"}},
+			&DiffTarget{Build: b, CommitMeta: toCM, KernelArgs: []string{"console=ttyS0", "mitigations=auto"}},
+		))
+		if string(first) != string(again) {
+			t.Fatalf("diff is not stable:\n%s\n%s", first, again)
+		}
+	}
+
+	md := d.Markdown()
+	for _, s := range []string{"## Changes from 40.1 to 40.2", "kernel 6.8.5-301.fc40 → 6.8.7-300.fc40", "| qemu | modified | 20 | 25 |"} {
+		if !strings.Contains(md, s) {
+			t.Errorf("markdown is missing %q:\n%s", s, md)
+		}
+	}
+}
+
+func TestDiffWithoutCommitMeta(t *testing.T) {
+	a := &Build{BuildID: "a", OstreeCommit: "x"}
+	b := &Build{BuildID: "b", OstreeCommit: "x"}
+	d := Diff(a, b)
+	if len(d.Metadata) != 0 || d.Packages != nil || d.Advisories != nil {
+		t.Errorf("identical builds should have an empty diff: %+v", d)
+	}
+}
@@ -0,0 +1,151 @@
+package builds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// buildsLockFile is the advisory lock serializing writers of
+	// builds.json. The Python writers take it too, with Builds.lock() in
+	// src/cosalib/builds.py; it is not the ".builds.json.lock" of
+	// flufl.lock, whose protocol can't be shared.
+	buildsLockFile = ".builds.json.flock"
+)
+
+// BuildsLock is an exclusive advisory lock on the builds.json of a
+// directory.
+type BuildsLock struct {
+	f *os.File
+}
+
+// LockBuilds takes an exclusive flock(2) on the builds.json of dir,
+// blocking until any other holder releases it.
+func LockBuilds(dir string) (*BuildsLock, error) {
+	path := filepath.Join(dir, buildsLockFile)
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open lock %s", path)
+	}
+	for {
+		err = syscall.Flock(int(f.Fd()), syscall.LOCK_EX)
+		if err != syscall.EINTR {
+			break
+		}
+	}
+	if err != nil {
+		f.Close()
+		return nil, errors.Wrapf(err, "failed to lock %s", path)
+	}
+	return &BuildsLock{f: f}, nil
+}
+
+// Unlock releases the lock.
+func (l *BuildsLock) Unlock() error {
+	if l.f == nil {
+		return nil
+	}
+	err := syscall.Flock(int(l.f.Fd()), syscall.LOCK_UN)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	l.f = nil
+	return err
+}
+
+// UpdateBuilds runs fn on the builds.json of dir while holding the builds
+// lock. The file is re-read after taking the lock, so fn always sees the
+// latest state, and is atomically rewritten with a fresh timestamp if fn
+// succeeds. A missing builds.json is treated as an empty build list.
+func UpdateBuilds(dir string, fn func(*BuildsJSON) error) error {
+	lock, err := LockBuilds(dir)
+	if err != nil {
+		return err
+	}
+	defer lock.Unlock() //nolint
+
+	bj, err := GetBuilds(dir)
+	if err == ErrNoBuildsFound {
+		if _, serr := os.Stat(filepath.Join(dir, CosaBuildsJSON)); os.IsNotExist(serr) {
+			bj, err = NewBuildsJSON(), nil
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := fn(bj); err != nil {
+		return err
+	}
+	bj.BumpTimestamp()
+	return bj.WriteBuilds(dir)
+}
+
+// Insert records a build for an arch in the builds.json of dir.
+func Insert(dir, buildID, arch string) error {
+	return UpdateBuilds(dir, func(bj *BuildsJSON) error {
+		return bj.InsertBuild(buildID, arch)
+	})
+}
+
+// Remove drops a build and its tags from the builds.json of dir.
+func Remove(dir, buildID string) error {
+	return UpdateBuilds(dir, func(bj *BuildsJSON) error {
+		return bj.RemoveBuild(buildID)
+	})
+}
+
+// TagBuild points a tag at a build in the builds.json of dir, creating the tag
+// if needed. Moving an existing tag to a different build requires force.
+func TagBuild(dir, name, buildID, description string, force bool) error {
+	return UpdateBuilds(dir, func(bj *BuildsJSON) error {
+		return bj.SetTag(name, buildID, description, force)
+	})
+}
+
+// UntagBuild deletes a tag from the builds.json of dir.
+func UntagBuild(dir, name string) error {
+	return UpdateBuilds(dir, func(bj *BuildsJSON) error {
+		for i, t := range bj.Tags {
+			if t.Name == name {
+				bj.Tags = append(bj.Tags[:i], bj.Tags[i+1:]...)
+				return nil
+			}
+		}
+		return fmt.Errorf("tag %s does not exist", name)
+	})
+}
+
+// SetTag points a tag at a build in the build list, creating the tag if
+// needed. Moving an existing tag to a different build requires force.
+func (b *BuildsJSON) SetTag(name, buildID, description string, force bool) error {
+	if !b.Has(buildID) {
+		return errors.Wrapf(ErrBuildNotFound, "build %s", buildID)
+	}
+	created := time.Now().UTC().Format(time.RFC3339)
+	for i, t := range b.Tags {
+		if t.Name != name {
+			continue
+		}
+		if t.Target != buildID && !force {
+			return fmt.Errorf("tag %s already points to %s; use force to move it", name, t.Target)
+		}
+		b.Tags[i].Target = buildID
+		b.Tags[i].Created = created
+		if description != "" {
+			b.Tags[i].Description = description
+		}
+		return nil
+	}
+	b.Tags = append(b.Tags, Tag{
+		Name:        name,
+		Created:     created,
+		Target:      buildID,
+		Description: description,
+	})
+	return nil
+}
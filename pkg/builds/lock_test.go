@@ -0,0 +1,112 @@
+package builds
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestConcurrentInsert(t *testing.T) {
+	dir := t.TempDir()
+	const writers = 16
+
+	var wg sync.WaitGroup
+	errs := make(chan error, writers)
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs <- Insert(dir, fmt.Sprintf("build-%d", i), "x86_64")
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatalf("Insert failed: %v", err)
+		}
+	}
+
+	bj, err := GetBuilds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bj.Builds) != writers {
+		t.Errorf("expected %d builds, found %d; concurrent writers clobbered each other", writers, len(bj.Builds))
+	}
+}
+
+func TestTagAndRemove(t *testing.T) {
+	dir := t.TempDir()
+	for _, id := range []string{"a", "b"} {
+		if err := Insert(dir, id, "x86_64"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := TagBuild(dir, "stable", "c", "", false); !errors.Is(err, ErrBuildNotFound) {
+		t.Errorf("tagging an unknown build should fail, got %v", err)
+	}
+	if err := TagBuild(dir, "stable", "a", "first", false); err != nil {
+		t.Fatal(err)
+	}
+	if err := TagBuild(dir, "stable", "b", "", false); err == nil {
+		t.Errorf("moving a tag without force should fail")
+	}
+	if err := TagBuild(dir, "stable", "b", "", true); err != nil {
+		t.Fatal(err)
+	}
+	bj, err := GetBuilds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(bj.Tags) != 1 || bj.Tags[0].Target != "b" || bj.Tags[0].Description != "first" {
+		t.Errorf("unexpected tags: %+v", bj.Tags)
+	}
+
+	if err := Remove(dir, "b"); err != nil {
+		t.Fatal(err)
+	}
+	bj, err = GetBuilds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bj.Has("b") || len(bj.Tags) != 0 {
+		t.Errorf("build b and its tag should be gone: %+v", bj)
+	}
+	if err := UntagBuild(dir, "stable"); err == nil {
+		t.Errorf("removing a missing tag should fail")
+	}
+}
+
+func TestBuildsSchemaValidation(t *testing.T) {
+	cases := []struct {
+		desc  string
+		data  string
+		valid bool
+	}{
+		{"valid", testData, true},
+		{"policy cleanup", `{"schema-version": "1.0.0", "builds": [{"id": "a", "arches": ["x86_64"], "policy-cleanup": {"cloud-uploads": true, "images-kept": ["qemu"]}}], "tombstone-builds": []}`, true},
+		{"missing version", `{"builds": [{"id": "a", "arches": ["x86_64"]}]}`, true},
+		{"missing arches", `{"schema-version": "1.0.0", "builds": [{"id": "a"}]}`, false},
+		{"unsupported version", `{"schema-version": "2.0.0", "builds": []}`, false},
+		{"bad tag", `{"schema-version": "1.0.0", "builds": [], "tags": [{"name": "x"}]}`, false},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, CosaBuildsJSON), []byte(c.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := GetBuilds(dir)
+			if c.valid && err != nil {
+				t.Errorf("expected builds.json to be valid: %v", err)
+			}
+			if !c.valid && !errors.Is(err, ErrBuildsFailsValidation) {
+				t.Errorf("expected a validation error, got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package builds
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+// PrunePolicy describes which builds survive a prune. A build is kept if
+// any of the enabled policies selects it; with every policy disabled
+// nothing is pruned.
+type PrunePolicy struct {
+	// KeepLastN keeps the newest N untagged builds. Zero disables the policy.
+	KeepLastN int
+	// KeepNewerThan keeps builds younger than the duration. Zero disables
+	// the policy.
+	KeepNewerThan time.Duration
+	// KeepTagged keeps every build that has a tag pointing to it. Tagged
+	// builds do not count against KeepLastN.
+	KeepTagged bool
+}
+
+// PruneCandidate is a build considered by a prune.
+type PruneCandidate struct {
+	ID        string    `json:"id"`
+	Arches    []string  `json:"arches"`
+	Tags      []string  `json:"tags,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+	Size      int64     `json:"size"`
+	Reason    string    `json:"reason"`
+}
+
+// PrunePlan is the result of applying a PrunePolicy to a build list.
+type PrunePlan struct {
+	Keep   []PruneCandidate `json:"keep"`
+	Remove []PruneCandidate `json:"remove"`
+}
+
+// Bytes returns the number of bytes freed by removing the planned builds.
+func (p *PrunePlan) Bytes() int64 {
+	var total int64
+	for _, c := range p.Remove {
+		total += c.Size
+	}
+	return total
+}
+
+// PlanPrune works out which builds in the build list of dir should be
+// removed under the policy. Builds are considered in builds.json order,
+// which is newest first. Nothing on disk is modified.
+func PlanPrune(dir string, bj *BuildsJSON, policy PrunePolicy, now time.Time) (*PrunePlan, error) {
+	disabled := policy.KeepLastN <= 0 && policy.KeepNewerThan <= 0
+	n := policy.KeepLastN
+	plan := &PrunePlan{}
+	for _, e := range bj.Builds {
+		c := PruneCandidate{
+			ID:     e.ID,
+			Arches: e.Arches,
+			Tags:   bj.TagsFor(e.ID),
+		}
+		c.Timestamp = buildTimestamp(dir, e)
+		size, err := DiskUsage(filepath.Join(dir, e.ID))
+		if err != nil {
+			return nil, err
+		}
+		c.Size = size
+
+		switch {
+		case disabled:
+			c.Reason = "no prune policy enabled"
+		case policy.KeepTagged && len(c.Tags) > 0:
+			c.Reason = fmt.Sprintf("tagged (%s)", strings.Join(c.Tags, ", "))
+		case n > 0:
+			c.Reason = fmt.Sprintf("within last %d builds", policy.KeepLastN)
+			n--
+		case policy.KeepNewerThan > 0 && c.Timestamp.IsZero():
+			// never prune by age what we can't date
+			c.Reason = "unknown age"
+		case policy.KeepNewerThan > 0 && now.Sub(c.Timestamp) < policy.KeepNewerThan:
+			c.Reason = fmt.Sprintf("newer than %s", policy.KeepNewerThan)
+		}
+		if c.Reason != "" {
+			plan.Keep = append(plan.Keep, c)
+			continue
+		}
+		c.Reason = "not selected by any keep policy"
+		plan.Remove = append(plan.Remove, c)
+	}
+	return plan, nil
+}
+
+// Apply removes the planned builds from the builds.json of dir under the
+// builds lock, repoints the latest symlink once builds.json is written and
+// finally deletes the build directories. builds.json is written first so
+// that an interrupted prune never leaves entries pointing at deleted
+// directories.
+func (p *PrunePlan) Apply(dir string) error {
+	if len(p.Remove) == 0 {
+		return nil
+	}
+	var updated *BuildsJSON
+	err := UpdateBuilds(dir, func(bj *BuildsJSON) error {
+		updated = bj
+		for _, c := range p.Remove {
+			// the build may have been removed concurrently
+			if bj.Has(c.ID) {
+				if err := bj.RemoveBuild(c.ID); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.Wrapf(err, "failed to update %s", CosaBuildsJSON)
+	}
+	if err := UpdateLatestLink(dir, updated); err != nil {
+		return errors.Wrap(err, "failed to update latest link")
+	}
+
+	var failed []string
+	for _, c := range p.Remove {
+		log.WithField("build", c.ID).Info("Pruning build")
+		if err := os.RemoveAll(filepath.Join(dir, c.ID)); err != nil {
+			log.WithError(err).WithField("build", c.ID).Error("failed to remove build")
+			failed = append(failed, c.ID)
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to remove builds: %v", failed)
+	}
+	return nil
+}
+
+// UpdateLatestLink points dir/latest at the newest build in the build list,
+// or removes it when the list is empty.
+func UpdateLatestLink(dir string, bj *BuildsJSON) error {
+	link := filepath.Join(dir, "latest")
+	latest, ok := bj.Latest()
+	if !ok {
+		if err := os.Remove(link); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	tmp := link + ".tmp"
+	_ = os.Remove(tmp)
+	if err := os.Symlink(latest, tmp); err != nil {
+		return err
+	}
+	return os.Rename(tmp, link)
+}
+
+// DiskUsage returns the apparent size in bytes of everything under path.
+// A missing path has a size of zero.
+func DiskUsage(path string) (int64, error) {
+	var total int64
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			total += info.Size()
+		}
+		return nil
+	})
+	return total, err
+}
+
+// buildTimestamp returns the newest build timestamp across the arches of a
+// build that are present locally, or the zero time if none are.
+func buildTimestamp(dir string, e BuildEntry) time.Time {
+	var ts time.Time
+	for _, arch := range e.Arches {
+		b, err := ParseBuild(filepath.Join(dir, e.ID, arch, CosaMetaJSON))
+		if err != nil {
+			continue
+		}
+		// Older builds only have ostree-timestamp
+		s := b.BuildTimeStamp
+		if s == "" {
+			s = b.OstreeTimestamp
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			continue
+		}
+		if t.After(ts) {
+			ts = t
+		}
+	}
+	return ts
+}
@@ -0,0 +1,191 @@
+package builds
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestBuild creates a minimal build directory with a meta.json and a
+// payload of the given size.
+func writeTestBuild(t *testing.T, dir, id, arch string, ts time.Time, size int) {
+	t.Helper()
+	p := filepath.Join(dir, id, arch)
+	if err := os.MkdirAll(p, 0755); err != nil {
+		t.Fatal(err)
+	}
+	meta := fmt.Sprintf(`{"buildid": %q, "name": "fedora-coreos", "ostree-commit": "abc", "ostree-timestamp": %q, "ostree-version": %q}`,
+		id, ts.Format(time.RFC3339), id)
+	if err := os.WriteFile(filepath.Join(p, CosaMetaJSON), []byte(meta), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(p, "payload"), make([]byte, size), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestBuildsDir(t *testing.T, now time.Time) (string, *BuildsJSON) {
+	t.Helper()
+	tmpd := t.TempDir()
+	bj := NewBuildsJSON()
+	// oldest first so that InsertBuild leaves the newest at the front
+	for i := 0; i < 5; i++ {
+		id := fmt.Sprintf("40.2024010%d.dev.0", i)
+		writeTestBuild(t, tmpd, id, "x86_64", now.Add(-time.Duration(5-i)*24*time.Hour), 100*(i+1))
+		if err := bj.InsertBuild(id, "x86_64"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	bj.Tags = []Tag{{Name: "stable", Target: "40.20240100.dev.0", Created: now.Format(time.RFC3339)}}
+	if err := bj.WriteBuilds(tmpd); err != nil {
+		t.Fatal(err)
+	}
+	return tmpd, bj
+}
+
+func removedIDs(p *PrunePlan) []string {
+	var ret []string
+	for _, c := range p.Remove {
+		ret = append(ret, c.ID)
+	}
+	return ret
+}
+
+func TestPlanPrune(t *testing.T) {
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	cases := []struct {
+		desc   string
+		policy PrunePolicy
+		remove []string
+	}{
+		{
+			desc:   "disabled",
+			policy: PrunePolicy{KeepTagged: true},
+		},
+		{
+			desc:   "keep last 2 and tagged",
+			policy: PrunePolicy{KeepLastN: 2, KeepTagged: true},
+			remove: []string{"40.20240102.dev.0", "40.20240101.dev.0"},
+		},
+		{
+			desc:   "keep last 2 ignoring tags",
+			policy: PrunePolicy{KeepLastN: 2},
+			remove: []string{"40.20240102.dev.0", "40.20240101.dev.0", "40.20240100.dev.0"},
+		},
+		{
+			desc:   "keep by age",
+			policy: PrunePolicy{KeepNewerThan: 60 * time.Hour},
+			remove: []string{"40.20240102.dev.0", "40.20240101.dev.0", "40.20240100.dev.0"},
+		},
+		{
+			desc:   "keep last 1 or by age",
+			policy: PrunePolicy{KeepLastN: 1, KeepNewerThan: 84 * time.Hour, KeepTagged: true},
+			remove: []string{"40.20240101.dev.0"},
+		},
+	}
+	for _, c := range cases {
+		t.Run(c.desc, func(t *testing.T) {
+			dir, bj := newTestBuildsDir(t, now)
+			plan, err := PlanPrune(dir, bj, c.policy, now)
+			if err != nil {
+				t.Fatalf("PlanPrune: %v", err)
+			}
+			got := removedIDs(plan)
+			if fmt.Sprint(got) != fmt.Sprint(c.remove) {
+				t.Errorf("expected to remove %v, got %v", c.remove, got)
+			}
+			if len(plan.Keep)+len(plan.Remove) != 5 {
+				t.Errorf("plan does not cover every build")
+			}
+		})
+	}
+}
+
+func TestPlanPruneUnknownAge(t *testing.T) {
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	dir, bj := newTestBuildsDir(t, now)
+	// the oldest build loses its timestamp
+	meta := filepath.Join(dir, "40.20240100.dev.0", "x86_64", CosaMetaJSON)
+	if err := os.WriteFile(meta, []byte(`{"buildid": "40.20240100.dev.0"}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	plan, err := PlanPrune(dir, bj, PrunePolicy{KeepNewerThan: 60 * time.Hour}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"40.20240102.dev.0", "40.20240101.dev.0"}
+	if got := removedIDs(plan); fmt.Sprint(got) != fmt.Sprint(expected) {
+		t.Errorf("expected to remove %v, got %v", expected, got)
+	}
+	for _, c := range plan.Keep {
+		if c.ID == "40.20240100.dev.0" && c.Reason != "unknown age" {
+			t.Errorf("unexpected reason for build without timestamp: %q", c.Reason)
+		}
+	}
+}
+
+func TestPrunePlanApply(t *testing.T) {
+	now := time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)
+	dir, bj := newTestBuildsDir(t, now)
+	plan, err := PlanPrune(dir, bj, PrunePolicy{KeepLastN: 1}, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 100+200+300+400 bytes of payload plus the meta.json files
+	if plan.Bytes() < 1000 {
+		t.Errorf("expected at least 1000 bytes to be freed, got %d", plan.Bytes())
+	}
+	if err := plan.Apply(dir); err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+
+	onDisk, err := GetBuilds(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(onDisk.Builds) != 1 || onDisk.Builds[0].ID != "40.20240104.dev.0" {
+		t.Errorf("unexpected builds after prune: %v", onDisk.Builds)
+	}
+	if len(onDisk.Tags) != 0 {
+		t.Errorf("tags pointing to pruned builds should be dropped: %v", onDisk.Tags)
+	}
+	for _, c := range plan.Remove {
+		if _, err := os.Stat(filepath.Join(dir, c.ID)); !os.IsNotExist(err) {
+			t.Errorf("build dir %s should have been removed", c.ID)
+		}
+	}
+	target, err := os.Readlink(filepath.Join(dir, "latest"))
+	if err != nil || target != "40.20240104.dev.0" {
+		t.Errorf("latest should point to the remaining build, got %q (%v)", target, err)
+	}
+}
+
+func TestBuildsJSONMutations(t *testing.T) {
+	bj := NewBuildsJSON()
+	if err := bj.InsertBuild("a", "x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bj.InsertBuild("b", "x86_64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bj.InsertBuild("a", "aarch64"); err != nil {
+		t.Fatal(err)
+	}
+	if err := bj.InsertBuild("a", "aarch64"); err == nil {
+		t.Errorf("inserting a duplicate arch should fail")
+	}
+	if latest, _ := bj.Latest(); latest != "b" {
+		t.Errorf("expected latest build b, got %s", latest)
+	}
+	if e, ok := bj.Get("a"); !ok || len(e.Arches) != 2 {
+		t.Errorf("expected build a to have two arches: %v", e)
+	}
+	if err := bj.RemoveBuild("c"); err == nil {
+		t.Errorf("removing an unknown build should fail")
+	}
+	if err := bj.RemoveBuild("b"); err != nil || bj.Has("b") {
+		t.Errorf("failed to remove build b: %v", err)
+	}
+}
@@ -15,6 +15,9 @@ import (
 var (
 	// SchemaJSON Schema document. Default the generated Schema.
 	SchemaJSON = generatedSchemaJSON
+
+	// BuildsSchemaJSON is the builds.json Schema document.
+	BuildsSchemaJSON = generatedBuildsSchemaJSON
 )
 
 func init() {
@@ -55,16 +58,33 @@ func (build *Build) Validate() []error {
 	if err != nil {
 		return append(e, err)
 	}
+	return validateJSON(SchemaJSON, data)
+}
+
+// Validate checks the build list against the builds.json schema.
+func (b *BuildsJSON) Validate() []error {
+	data, err := json.Marshal(b)
+	if err != nil {
+		return []error{err}
+	}
+	return validateJSON(BuildsSchemaJSON, data)
+}
+
+func validateJSON(schemaJSON string, data []byte) []error {
+	var e []error
 	if len(data) == 0 {
 		return append(e,
 			errors.New("build data is empty"),
 		)
 	}
 
-	result, _ := schema.Validate(
-		schema.NewStringLoader(SchemaJSON),
-		schema.NewStringLoader(string(data)),
+	result, err := schema.Validate(
+		schema.NewStringLoader(schemaJSON),
+		schema.NewBytesLoader(data),
 	)
+	if err != nil {
+		return append(e, err)
+	}
 
 	if result.Valid() {
 		return nil
@@ -152,8 +152,8 @@ func TestMergeMeta(t *testing.T) {
 	// Create a fake build dir
 	fakeBuildID := "999.1"
 	bjson, _ := json.Marshal(BuildsJSON{
-		SchemaVersion: "0.1.0",
-		Builds: []build{
+		SchemaVersion: "1.0.0",
+		Builds: []BuildEntry{
 			{
 				ID:     fakeBuildID,
 				Arches: []string{BuilderArch()},
@@ -0,0 +1,324 @@
+package builds
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrObjectNotFound is thrown when a key does not exist in a BuildStore
+	ErrObjectNotFound = errors.New("object not found in build store")
+
+	// ErrChecksumMismatch is thrown when a transferred artifact does not
+	// match the sha256 recorded in meta.json
+	ErrChecksumMismatch = errors.New("artifact checksum mismatch")
+)
+
+const (
+	// partialSuffix is appended to artifacts while they are being fetched,
+	// so that an interrupted transfer can be resumed.
+	partialSuffix = ".partial"
+
+	// sha256Suffix names the sidecar recording the sha256 of a pushed
+	// artifact, in sha256sum(1) format, for stores that can't hash their
+	// objects in place.
+	sha256Suffix = ".sha256"
+)
+
+// BuildStore is a location holding a cosa builds directory: a local
+// directory, a plain HTTP server or an S3-compatible bucket. Keys are
+// slash-separated paths relative to the builds directory, such as
+// "builds.json" or "<buildid>/<arch>/meta.json".
+type BuildStore interface {
+	// Stat returns the size of the object at key, or an error wrapping
+	// ErrObjectNotFound.
+	Stat(ctx context.Context, key string) (int64, error)
+
+	// Open returns the content of the object at key, starting at offset.
+	Open(ctx context.Context, key string, offset int64) (io.ReadCloser, error)
+
+	// Put replaces the object at key with size bytes read from r.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+
+	// List returns the sorted keys of the objects under the directory
+	// prefix, recursively. An empty prefix lists the whole store, and a
+	// missing prefix lists nothing.
+	List(ctx context.Context, prefix string) ([]string, error)
+
+	// String returns the location of the store for logging.
+	String() string
+}
+
+// checksummer is implemented by stores able to hash an object in place,
+// which then need no sha256 sidecar.
+type checksummer interface {
+	Sha256(ctx context.Context, key string) (string, error)
+}
+
+// OpenBuildStore returns the BuildStore for a location. Locations may be
+// "s3://bucket/prefix", "http(s)://host/path" or a local directory. When
+// s3Endpoint is set it is used instead of AWS, e.g. for MinIO.
+func OpenBuildStore(ctx context.Context, location, s3Endpoint string) (BuildStore, error) {
+	switch {
+	case strings.HasPrefix(location, "s3://"):
+		bucket, prefix, _ := strings.Cut(strings.TrimPrefix(location, "s3://"), "/")
+		if bucket == "" {
+			return nil, fmt.Errorf("no bucket in %q", location)
+		}
+		return OpenS3Store(ctx, bucket, prefix, s3Endpoint)
+	case strings.HasPrefix(location, "http://"), strings.HasPrefix(location, "https://"):
+		return NewHTTPStore(location, nil), nil
+	default:
+		return NewLocalStore(location), nil
+	}
+}
+
+// buildKey returns the key of a file inside a build directory.
+func buildKey(buildID, arch, name string) string {
+	return path.Join(buildID, arch, name)
+}
+
+// FetchBuilds reads and validates the builds.json of a store.
+func FetchBuilds(ctx context.Context, s BuildStore) (*BuildsJSON, error) {
+	data, err := readObject(ctx, s, CosaBuildsJSON)
+	if err != nil {
+		return nil, err
+	}
+	return parseBuildsJSON(fmt.Sprintf("%s/%s", s, CosaBuildsJSON), data)
+}
+
+// PushBuilds writes the builds.json of a store. Unlike UpdateBuilds this
+// takes no lock, so there must be a single writer for a remote store.
+func PushBuilds(ctx context.Context, s BuildStore, bj *BuildsJSON) error {
+	out, err := bj.marshal()
+	if err != nil {
+		return err
+	}
+	return s.Put(ctx, CosaBuildsJSON, bytes.NewReader(out), int64(len(out)))
+}
+
+// FetchMeta reads the meta.json of a build from a store.
+func FetchMeta(ctx context.Context, s BuildStore, buildID, arch string) (*Build, error) {
+	data, err := readObject(ctx, s, buildKey(buildID, arch, CosaMetaJSON))
+	if err != nil {
+		return nil, err
+	}
+	return buildParser(bytes.NewReader(data))
+}
+
+// PushMeta writes the meta.json of a build to a store.
+func PushMeta(ctx context.Context, s BuildStore, buildID, arch string, b *Build) error {
+	out, err := json.MarshalIndent(b, "", "    ")
+	if err != nil {
+		return err
+	}
+	key := buildKey(buildID, arch, CosaMetaJSON)
+	return s.Put(ctx, key, bytes.NewReader(out), int64(len(out)))
+}
+
+func readObject(ctx context.Context, s BuildStore, key string) ([]byte, error) {
+	r, err := s.Open(ctx, key, 0)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+// FetchArtifact downloads an artifact of a build into dir and verifies it
+// against its recorded sha256. An artifact already present and intact is
+// not fetched again, and a partial download left by an interrupted fetch
+// is resumed rather than restarted.
+func FetchArtifact(ctx context.Context, s BuildStore, buildID, arch string, a *Artifact, dir string) error {
+	dest := filepath.Join(dir, a.Path)
+	if sum, _, err := sha256File(dest); err == nil && sum == a.Sha256 {
+		log.WithField("artifact", a.Path).Debug("Artifact already fetched")
+		return nil
+	}
+
+	key := buildKey(buildID, arch, a.Path)
+	size, err := s.Stat(ctx, key)
+	if err != nil {
+		return err
+	}
+
+	partial := dest + partialSuffix
+	f, err := os.OpenFile(partial, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	offset, err := f.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if offset > size {
+		if err := f.Truncate(0); err != nil {
+			return err
+		}
+		if offset, err = f.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+	}
+	if offset < size {
+		if offset > 0 {
+			log.WithFields(log.Fields{"artifact": a.Path, "offset": offset}).Info("Resuming fetch")
+		}
+		r, err := s.Open(ctx, key, offset)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(f, r)
+		r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "failed to fetch %s", key)
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	sum, _, err := sha256File(partial)
+	if err != nil {
+		return err
+	}
+	if sum != a.Sha256 {
+		// a corrupt partial file must not be resumed from
+		_ = os.Remove(partial)
+		return errors.Wrapf(ErrChecksumMismatch, "%s: expected %s, got %s", key, a.Sha256, sum)
+	}
+	return os.Rename(partial, dest)
+}
+
+// PushArtifact uploads an artifact of a build from dir after verifying it
+// against its recorded sha256. An artifact already present in the store is
+// skipped only if its remote checksum matches, so an interrupted push can
+// be re-run without trusting a truncated or stale object of the same size.
+// Stores that can't hash objects in place get a sha256 sidecar next to the
+// artifact, written after it.
+func PushArtifact(ctx context.Context, s BuildStore, buildID, arch string, a *Artifact, dir string) error {
+	src := filepath.Join(dir, a.Path)
+	sum, size, err := sha256File(src)
+	if err != nil {
+		return err
+	}
+	if sum != a.Sha256 {
+		return errors.Wrapf(ErrChecksumMismatch, "%s: expected %s, got %s", src, a.Sha256, sum)
+	}
+
+	key := buildKey(buildID, arch, a.Path)
+	remoteSize, err := s.Stat(ctx, key)
+	if err != nil && !errors.Is(err, ErrObjectNotFound) {
+		return err
+	}
+	if err == nil && remoteSize == size {
+		remoteSum, err := remoteSha256(ctx, s, key)
+		if err != nil {
+			return err
+		}
+		if remoteSum == sum {
+			log.WithField("artifact", a.Path).Debug("Artifact already pushed")
+			return nil
+		}
+		log.WithField("artifact", a.Path).Info("Remote artifact does not match, pushing again")
+	}
+
+	f, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := s.Put(ctx, key, f, size); err != nil {
+		return err
+	}
+	if _, ok := s.(checksummer); ok {
+		return nil
+	}
+	sidecar := fmt.Sprintf("%s  %s\n", sum, path.Base(a.Path))
+	return s.Put(ctx, key+sha256Suffix, strings.NewReader(sidecar), int64(len(sidecar)))
+}
+
+// remoteSha256 returns the sha256 of the object at key in a store, or ""
+// if the store has no record of it.
+func remoteSha256(ctx context.Context, s BuildStore, key string) (string, error) {
+	if c, ok := s.(checksummer); ok {
+		return c.Sha256(ctx, key)
+	}
+	data, err := readObject(ctx, s, key+sha256Suffix)
+	if errors.Is(err, ErrObjectNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if f := strings.Fields(string(data)); len(f) > 0 {
+		return f[0], nil
+	}
+	return "", nil
+}
+
+// MirrorBuild copies a build from one store to another, staging artifacts
+// in workdir, and records it in the destination builds.json. The meta.json
+// is pushed only once every artifact has been, so a build is never visible
+// in the destination without its artifacts.
+func MirrorBuild(ctx context.Context, src, dst BuildStore, buildID, arch, workdir string) error {
+	b, err := FetchMeta(ctx, src, buildID, arch)
+	if err != nil {
+		return errors.Wrapf(err, "failed to fetch meta.json of %s/%s", buildID, arch)
+	}
+	if b.BuildArtifacts == nil {
+		b.BuildArtifacts = new(BuildArtifacts)
+	}
+	stage := filepath.Join(workdir, buildID, arch)
+	if err := os.MkdirAll(stage, 0755); err != nil {
+		return err
+	}
+
+	for name, a := range b.artifacts() {
+		if a == nil || a.Path == "" {
+			continue
+		}
+		l := log.WithFields(log.Fields{"build": buildID, "arch": arch, "artifact": name})
+		l.Info("Fetching artifact")
+		if err := FetchArtifact(ctx, src, buildID, arch, a, stage); err != nil {
+			return err
+		}
+		l.Info("Pushing artifact")
+		if err := PushArtifact(ctx, dst, buildID, arch, a, stage); err != nil {
+			return err
+		}
+	}
+	if err := PushMeta(ctx, dst, buildID, arch, b); err != nil {
+		return err
+	}
+
+	bj, err := FetchBuilds(ctx, dst)
+	if errors.Is(err, ErrObjectNotFound) {
+		bj, err = NewBuildsJSON(), nil
+	}
+	if err != nil {
+		return err
+	}
+	if e, ok := bj.Get(buildID); ok {
+		for _, a := range e.Arches {
+			if a == arch {
+				return nil
+			}
+		}
+	}
+	if err := bj.InsertBuild(buildID, arch); err != nil {
+		return err
+	}
+	bj.BumpTimestamp()
+	return PushBuilds(ctx, dst, bj)
+}
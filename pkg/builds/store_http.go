@@ -0,0 +1,160 @@
+package builds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// HTTPStore is a BuildStore backed by a plain HTTP server. Objects are
+// fetched with GET (using Range requests to resume) and stored with PUT,
+// so pushing requires a server accepting uploads, e.g. WebDAV. Listing
+// crawls the directory indexes generated by the server, as with nginx's
+// autoindex or Apache's mod_autoindex.
+type HTTPStore struct {
+	BaseURL string
+	Client  *http.Client
+}
+
+// NewHTTPStore returns a BuildStore for a builds directory served at
+// baseURL. If client is nil, http.DefaultClient is used.
+func NewHTTPStore(baseURL string, client *http.Client) *HTTPStore {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &HTTPStore{
+		BaseURL: strings.TrimSuffix(baseURL, "/"),
+		Client:  client,
+	}
+}
+
+func (h *HTTPStore) url(key string) string {
+	return h.BaseURL + "/" + strings.TrimPrefix(key, "/")
+}
+
+func (h *HTTPStore) do(ctx context.Context, method, key string, body io.Reader, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, h.url(key), body)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode == http.StatusNotFound {
+		res.Body.Close()
+		return nil, errors.Wrapf(ErrObjectNotFound, "%s", h.url(key))
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		res.Body.Close()
+		return nil, fmt.Errorf("%s %s: %s", method, h.url(key), res.Status)
+	}
+	return res, nil
+}
+
+// Stat implements BuildStore.
+func (h *HTTPStore) Stat(ctx context.Context, key string) (int64, error) {
+	res, err := h.do(ctx, http.MethodHead, key, nil, nil)
+	if err != nil {
+		return 0, err
+	}
+	res.Body.Close()
+	if res.ContentLength < 0 {
+		return 0, fmt.Errorf("HEAD %s: no content length", h.url(key))
+	}
+	return res.ContentLength, nil
+}
+
+// Open implements BuildStore.
+func (h *HTTPStore) Open(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	header := http.Header{}
+	if offset > 0 {
+		header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+	res, err := h.do(ctx, http.MethodGet, key, nil, header)
+	if err != nil {
+		return nil, err
+	}
+	// servers without Range support send the whole object
+	if offset > 0 && res.StatusCode != http.StatusPartialContent {
+		if _, err := io.CopyN(io.Discard, res.Body, offset); err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+	}
+	return res.Body, nil
+}
+
+// Put implements BuildStore.
+func (h *HTTPStore) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, h.url(key), r)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	res, err := h.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", h.url(key), res.Status)
+	}
+	return nil
+}
+
+// hrefRe matches the links of a generated directory index.
+var hrefRe = regexp.MustCompile(`href="([^"?#]+)"`)
+
+// List implements BuildStore.
+func (h *HTTPStore) List(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	dirs := []string{strings.Trim(prefix, "/")}
+	for len(dirs) > 0 {
+		dir := dirs[0]
+		dirs = dirs[1:]
+		res, err := h.do(ctx, http.MethodGet, dir+"/", nil, nil)
+		if errors.Is(err, ErrObjectNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		body, err := io.ReadAll(res.Body)
+		res.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range hrefRe.FindAllStringSubmatch(string(body), -1) {
+			name, err := url.PathUnescape(m[1])
+			// skip parent, sort and absolute links
+			if err != nil || strings.HasPrefix(name, ".") || strings.HasPrefix(name, "/") || strings.Contains(name, "://") {
+				continue
+			}
+			key := path.Join(dir, name)
+			if strings.HasSuffix(name, "/") {
+				dirs = append(dirs, key)
+			} else {
+				keys = append(keys, key)
+			}
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// String implements BuildStore.
+func (h *HTTPStore) String() string {
+	return h.BaseURL
+}
@@ -0,0 +1,132 @@
+package builds
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// LocalStore is a BuildStore backed by a local builds directory.
+type LocalStore struct {
+	Dir string
+}
+
+// NewLocalStore returns a BuildStore for a local builds directory.
+func NewLocalStore(dir string) *LocalStore {
+	return &LocalStore{Dir: dir}
+}
+
+func (l *LocalStore) path(key string) string {
+	return filepath.Join(l.Dir, filepath.FromSlash(key))
+}
+
+// Stat implements BuildStore.
+func (l *LocalStore) Stat(_ context.Context, key string) (int64, error) {
+	fi, err := os.Stat(l.path(key))
+	if os.IsNotExist(err) {
+		return 0, errors.Wrapf(ErrObjectNotFound, "%s", l.path(key))
+	}
+	if err != nil {
+		return 0, err
+	}
+	return fi.Size(), nil
+}
+
+// Open implements BuildStore.
+func (l *LocalStore) Open(_ context.Context, key string, offset int64) (io.ReadCloser, error) {
+	f, err := os.Open(l.path(key))
+	if os.IsNotExist(err) {
+		return nil, errors.Wrapf(ErrObjectNotFound, "%s", l.path(key))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
+
+// Put implements BuildStore. The object is written to a temporary file
+// and renamed into place, so readers never observe a partial object.
+func (l *LocalStore) Put(_ context.Context, key string, r io.Reader, size int64) error {
+	dest := l.path(key)
+	if err := os.MkdirAll(filepath.Dir(dest), 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(dest), "."+filepath.Base(dest)+".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name()) //nolint
+
+	n, err := io.Copy(f, r)
+	if err == nil && n != size {
+		err = errors.Errorf("%s: wrote %d bytes, expected %d", key, n, size)
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), dest)
+}
+
+// List implements BuildStore. Hidden files, such as the builds.json lock
+// and temporary files of an ongoing Put, are not listed.
+func (l *LocalStore) List(_ context.Context, prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.WalkDir(l.path(prefix), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if strings.HasPrefix(d.Name(), ".") && p != l.path(prefix) {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(l.Dir, p)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, filepath.ToSlash(rel))
+		return nil
+	})
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Sha256 hashes the object at key in place.
+func (l *LocalStore) Sha256(_ context.Context, key string) (string, error) {
+	sum, _, err := sha256File(l.path(key))
+	if os.IsNotExist(err) {
+		return "", errors.Wrapf(ErrObjectNotFound, "%s", l.path(key))
+	}
+	return sum, err
+}
+
+// String implements BuildStore.
+func (l *LocalStore) String() string {
+	return l.Dir
+}
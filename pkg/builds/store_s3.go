@@ -0,0 +1,145 @@
+package builds
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// S3Store is a BuildStore backed by a prefix in an S3-compatible bucket.
+type S3Store struct {
+	Client *s3.Client
+	Bucket string
+	Prefix string
+}
+
+// NewS3Store returns a BuildStore for the builds directory stored under
+// prefix in bucket.
+func NewS3Store(client *s3.Client, bucket, prefix string) *S3Store {
+	return &S3Store{
+		Client: client,
+		Bucket: bucket,
+		Prefix: strings.Trim(prefix, "/"),
+	}
+}
+
+// OpenS3Store returns an S3Store using the default AWS credential chain.
+// When endpoint is set, requests go to that S3-compatible service using
+// path-style addressing instead of AWS.
+func OpenS3Store(ctx context.Context, bucket, prefix, endpoint string) (*S3Store, error) {
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to load AWS configuration")
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(endpoint)
+			o.UsePathStyle = true
+		}
+	})
+	return NewS3Store(client, bucket, prefix), nil
+}
+
+func (s *S3Store) key(key string) string {
+	return path.Join(s.Prefix, key)
+}
+
+func s3IsNotFound(err error) bool {
+	var ae smithy.APIError
+	if errors.As(err, &ae) {
+		return ae.ErrorCode() == "NoSuchKey" || ae.ErrorCode() == "NotFound"
+	}
+	return false
+}
+
+func (s *S3Store) wrapErr(err error, key string) error {
+	if s3IsNotFound(err) {
+		return errors.Wrapf(ErrObjectNotFound, "s3://%s/%s", s.Bucket, s.key(key))
+	}
+	return errors.Wrapf(err, "s3://%s/%s", s.Bucket, s.key(key))
+}
+
+// Stat implements BuildStore.
+func (s *S3Store) Stat(ctx context.Context, key string) (int64, error) {
+	out, err := s.Client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	})
+	if err != nil {
+		return 0, s.wrapErr(err, key)
+	}
+	return aws.ToInt64(out.ContentLength), nil
+}
+
+// Open implements BuildStore.
+func (s *S3Store) Open(ctx context.Context, key string, offset int64) (io.ReadCloser, error) {
+	in := &s3.GetObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+	}
+	if offset > 0 {
+		in.Range = aws.String(fmt.Sprintf("bytes=%d-", offset))
+	}
+	out, err := s.Client.GetObject(ctx, in)
+	if err != nil {
+		return nil, s.wrapErr(err, key)
+	}
+	return out.Body, nil
+}
+
+// Put implements BuildStore. Large objects are sent as a multipart upload.
+func (s *S3Store) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	uploader := manager.NewUploader(s.Client)
+	_, err := uploader.Upload(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.Bucket),
+		Key:    aws.String(s.key(key)),
+		Body:   io.LimitReader(r, size),
+	})
+	if err != nil {
+		return s.wrapErr(err, key)
+	}
+	return nil
+}
+
+// List implements BuildStore.
+func (s *S3Store) List(ctx context.Context, prefix string) ([]string, error) {
+	root := s.key(strings.Trim(prefix, "/"))
+	if root != "" {
+		root += "/"
+	}
+	base := s.Prefix
+	if base != "" {
+		base += "/"
+	}
+	var keys []string
+	p := s3.NewListObjectsV2Paginator(s.Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.Bucket),
+		Prefix: aws.String(root),
+	})
+	for p.HasMorePages() {
+		page, err := p.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrapf(err, "listing s3://%s/%s", s.Bucket, root)
+		}
+		for _, o := range page.Contents {
+			keys = append(keys, strings.TrimPrefix(aws.ToString(o.Key), base))
+		}
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// String implements BuildStore.
+func (s *S3Store) String() string {
+	return fmt.Sprintf("s3://%s/%s", s.Bucket, s.Prefix)
+}
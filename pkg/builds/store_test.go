@@ -0,0 +1,322 @@
+package builds
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// objectServer is a minimal stand-in for MinIO: it serves path-style
+// /<bucket>/<key> objects with HEAD, ranged GET and PUT, ListObjectsV2
+// and autoindex-style HTML directory listings, which is all both the HTTP
+// and S3 stores need.
+type objectServer struct {
+	*httptest.Server
+	mu      sync.Mutex
+	objects map[string][]byte
+	ranges  []string
+}
+
+func newObjectServer(t *testing.T) *objectServer {
+	o := &objectServer{objects: make(map[string][]byte)}
+	o.Server = httptest.NewServer(http.HandlerFunc(o.serve))
+	t.Cleanup(o.Close)
+	return o
+}
+
+func (o *objectServer) serve(w http.ResponseWriter, r *http.Request) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	key := strings.TrimPrefix(r.URL.Path, "/")
+	switch r.Method {
+	case http.MethodPut:
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		o.objects[key] = data
+		w.Header().Set("ETag", fmt.Sprintf("%q", sha256Hex(data)[:32]))
+	case http.MethodGet, http.MethodHead:
+		if r.URL.Query().Get("list-type") == "2" {
+			o.listBucket(w, key, r.URL.Query().Get("prefix"))
+			return
+		}
+		if strings.HasSuffix(key, "/") {
+			o.listIndex(w, key)
+			return
+		}
+		data, ok := o.objects[key]
+		if !ok {
+			w.Header().Set("Content-Type", "application/xml")
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `<?xml version="1.0" encoding="UTF-8"?><Error><Code>NoSuchKey</Code><Message>not found</Message></Error>`)
+			return
+		}
+		if rg := r.Header.Get("Range"); rg != "" {
+			o.ranges = append(o.ranges, rg)
+		}
+		http.ServeContent(w, r, "", time.Time{}, bytes.NewReader(data))
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+func (o *objectServer) listBucket(w http.ResponseWriter, bucket, prefix string) {
+	var keys []string
+	for k := range o.objects {
+		if strings.HasPrefix(k, bucket+"/"+prefix) {
+			keys = append(keys, strings.TrimPrefix(k, bucket+"/"))
+		}
+	}
+	sort.Strings(keys)
+	w.Header().Set("Content-Type", "application/xml")
+	fmt.Fprintf(w, `<?xml version="1.0" encoding="UTF-8"?><ListBucketResult><Name>%s</Name><Prefix>%s</Prefix><KeyCount>%d</KeyCount><IsTruncated>false</IsTruncated>`, bucket, prefix, len(keys))
+	for _, k := range keys {
+		fmt.Fprintf(w, "<Contents><Key>%s</Key><Size>%d</Size></Contents>", k, len(o.objects[bucket+"/"+k]))
+	}
+	fmt.Fprint(w, "</ListBucketResult>")
+}
+
+func (o *objectServer) listIndex(w http.ResponseWriter, dir string) {
+	entries := make(map[string]bool)
+	for k := range o.objects {
+		if rest, ok := strings.CutPrefix(k, dir); ok {
+			if i := strings.Index(rest, "/"); i >= 0 {
+				rest = rest[:i+1]
+			}
+			entries[rest] = true
+		}
+	}
+	if len(entries) == 0 {
+		http.NotFound(w, nil)
+		return
+	}
+	fmt.Fprint(w, `<html><body><a href="../">../</a><a href="?C=N;O=D">Name</a>`)
+	for e := range entries {
+		fmt.Fprintf(w, `<a href="%s">%s</a>`, e, e)
+	}
+	fmt.Fprint(w, "</body></html>")
+}
+
+func (o *objectServer) s3Client() *s3.Client {
+	return s3.New(s3.Options{
+		Region:                     "us-east-1",
+		BaseEndpoint:               aws.String(o.URL),
+		UsePathStyle:               true,
+		Credentials:                credentials.NewStaticCredentialsProvider("minio", "minio123", ""),
+		RequestChecksumCalculation: aws.RequestChecksumCalculationWhenRequired,
+		ResponseChecksumValidation: aws.ResponseChecksumValidationWhenRequired,
+	})
+}
+
+// writeStoreBuild creates a local builds directory with one build.
+func writeStoreBuild(t *testing.T, dir string, payload []byte) *Build {
+	t.Helper()
+	b := &Build{
+		BuildID:      "40.20240101.dev.0",
+		Name:         "fedora-coreos",
+		Architecture: "x86_64",
+		BuildArtifacts: &BuildArtifacts{
+			Qemu: &Artifact{
+				Path:        "test-qemu.qcow2",
+				Sha256:      sha256Hex(payload),
+				SizeInBytes: float64(len(payload)),
+			},
+		},
+	}
+	bdir := filepath.Join(dir, b.BuildID, b.Architecture)
+	if err := os.MkdirAll(bdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(bdir, "test-qemu.qcow2"), payload, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.WriteMeta(filepath.Join(bdir, CosaMetaJSON), false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Insert(dir, b.BuildID, b.Architecture); err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestBuildStores(t *testing.T) {
+	ctx := context.Background()
+	payload := bytes.Repeat([]byte("qcow2"), 4096)
+
+	srv := newObjectServer(t)
+	stores := map[string]BuildStore{
+		"local": NewLocalStore(t.TempDir()),
+		"http":  NewHTTPStore(srv.URL+"/http/builds", nil),
+		"s3":    NewS3Store(srv.s3Client(), "bucket", "prod/builds"),
+	}
+	for name, store := range stores {
+		t.Run(name, func(t *testing.T) {
+			srcDir := t.TempDir()
+			b := writeStoreBuild(t, srcDir, payload)
+			src := NewLocalStore(srcDir)
+
+			if _, err := FetchBuilds(ctx, store); !errors.Is(err, ErrObjectNotFound) {
+				t.Fatalf("expected an empty store, got %v", err)
+			}
+			if err := MirrorBuild(ctx, src, store, b.BuildID, "x86_64", t.TempDir()); err != nil {
+				t.Fatalf("mirroring to %s: %v", store, err)
+			}
+			bj, err := FetchBuilds(ctx, store)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bj.Has(b.BuildID) {
+				t.Errorf("builds.json of %s is missing %s", store, b.BuildID)
+			}
+			keys, err := store.List(ctx, b.BuildID)
+			if err != nil {
+				t.Fatalf("listing %s: %v", store, err)
+			}
+			expected := []string{
+				b.BuildID + "/x86_64/meta.json",
+				b.BuildID + "/x86_64/test-qemu.qcow2",
+			}
+			if name != "local" {
+				expected = append(expected, b.BuildID+"/x86_64/test-qemu.qcow2"+sha256Suffix)
+			}
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("unexpected keys in %s: %v", store, keys)
+			}
+			if keys, _ := store.List(ctx, "41.20250101.0"); len(keys) != 0 {
+				t.Errorf("listing a missing build should be empty, got %v", keys)
+			}
+
+			// and back again, resuming from a partial download
+			destDir := t.TempDir()
+			stage := filepath.Join(destDir, b.BuildID, "x86_64")
+			if err := os.MkdirAll(stage, 0755); err != nil {
+				t.Fatal(err)
+			}
+			partial := filepath.Join(stage, "test-qemu.qcow2"+partialSuffix)
+			if err := os.WriteFile(partial, payload[:1000], 0644); err != nil {
+				t.Fatal(err)
+			}
+			if err := MirrorBuild(ctx, store, NewLocalStore(destDir), b.BuildID, "x86_64", destDir); err != nil {
+				t.Fatalf("mirroring from %s: %v", store, err)
+			}
+			got, err := os.ReadFile(filepath.Join(stage, "test-qemu.qcow2"))
+			if err != nil || !bytes.Equal(got, payload) {
+				t.Errorf("artifact did not round-trip through %s: %v", store, err)
+			}
+			if _, err := os.Stat(partial); !os.IsNotExist(err) {
+				t.Errorf("partial download was left behind")
+			}
+			m, _, err := ReadBuild(destDir, b.BuildID, "x86_64")
+			if err != nil || m.BuildArtifacts.Qemu.Sha256 != b.BuildArtifacts.Qemu.Sha256 {
+				t.Errorf("meta.json did not round-trip through %s: %v", store, err)
+			}
+		})
+	}
+
+	srv.mu.Lock()
+	defer srv.mu.Unlock()
+	if len(srv.ranges) != 2 {
+		t.Errorf("expected the http and s3 fetches to resume with a Range request, got %v", srv.ranges)
+	}
+}
+
+func TestFetchArtifactChecksum(t *testing.T) {
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	payload := []byte("not what meta.json says")
+	b := writeStoreBuild(t, srcDir, payload)
+	a := *b.BuildArtifacts.Qemu
+	a.Sha256 = sha256Hex([]byte("something else"))
+
+	dir := t.TempDir()
+	err := FetchArtifact(ctx, NewLocalStore(srcDir), b.BuildID, "x86_64", &a, dir)
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, got %v", err)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("corrupt download should be discarded, found %d files", len(entries))
+	}
+
+	err = PushArtifact(ctx, NewLocalStore(t.TempDir()), b.BuildID, "x86_64", &a, filepath.Join(srcDir, b.BuildID, "x86_64"))
+	if !errors.Is(err, ErrChecksumMismatch) {
+		t.Errorf("pushing a corrupt artifact should fail, got %v", err)
+	}
+}
+
+func TestPushArtifactVerifiesRemote(t *testing.T) {
+	ctx := context.Background()
+	srcDir := t.TempDir()
+	payload := bytes.Repeat([]byte("qcow2"), 4096)
+	b := writeStoreBuild(t, srcDir, payload)
+	stage := filepath.Join(srcDir, b.BuildID, "x86_64")
+	key := buildKey(b.BuildID, "x86_64", "test-qemu.qcow2")
+	corrupt := bytes.Repeat([]byte("xxxxx"), 4096)
+
+	srv := newObjectServer(t)
+	store := NewHTTPStore(srv.URL+"/builds", nil)
+	if err := PushArtifact(ctx, store, b.BuildID, "x86_64", b.BuildArtifacts.Qemu, stage); err != nil {
+		t.Fatal(err)
+	}
+	// a stale remote object of the right size from another push
+	srv.mu.Lock()
+	srv.objects["builds/"+key] = corrupt
+	srv.objects["builds/"+key+sha256Suffix] = []byte(sha256Hex(corrupt) + "  test-qemu.qcow2\n")
+	srv.mu.Unlock()
+	if err := PushArtifact(ctx, store, b.BuildID, "x86_64", b.BuildArtifacts.Qemu, stage); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	if !bytes.Equal(srv.objects["builds/"+key], payload) {
+		t.Errorf("stale remote artifact was not pushed again")
+	}
+	// without a sidecar the remote object can't be trusted either
+	srv.objects["builds/"+key] = corrupt
+	delete(srv.objects, "builds/"+key+sha256Suffix)
+	srv.mu.Unlock()
+	if err := PushArtifact(ctx, store, b.BuildID, "x86_64", b.BuildArtifacts.Qemu, stage); err != nil {
+		t.Fatal(err)
+	}
+	srv.mu.Lock()
+	if !bytes.Equal(srv.objects["builds/"+key], payload) {
+		t.Errorf("remote artifact without a checksum was not pushed again")
+	}
+	srv.mu.Unlock()
+
+	// local stores are hashed in place
+	dstDir := t.TempDir()
+	local := NewLocalStore(dstDir)
+	if err := PushArtifact(ctx, local, b.BuildID, "x86_64", b.BuildArtifacts.Qemu, stage); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(local.path(key), corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := PushArtifact(ctx, local, b.BuildID, "x86_64", b.BuildArtifacts.Qemu, stage); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := os.ReadFile(local.path(key)); !bytes.Equal(got, payload) {
+		t.Errorf("corrupt local artifact was not pushed again")
+	}
+	if _, err := os.Stat(local.path(key + sha256Suffix)); !os.IsNotExist(err) {
+		t.Errorf("local stores should not get a sha256 sidecar")
+	}
+}
@@ -0,0 +1,268 @@
+package builds
+
+import (
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Artifact verification states
+const (
+	VerifyOK      = "ok"
+	VerifyMissing = "missing"
+	VerifyCorrupt = "corrupt"
+	VerifyError   = "error"
+)
+
+// looseBuildFiles are files cosa writes into a build directory that are
+// not tracked as artifacts in meta.json.
+var looseBuildFiles = []string{
+	"commitmeta.json",
+	"ostree-commit-object",
+	"coreos-assembler-config-git.json",
+	"coreos-assembler-config.tar.gz",
+}
+
+// ArtifactVerification is the result of checking a single artifact.
+type ArtifactVerification struct {
+	Name   string   `json:"name"`
+	Path   string   `json:"path"`
+	Status string   `json:"status"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// VerifyReport is the result of checking all artifacts of a build.
+type VerifyReport struct {
+	BuildID      string                 `json:"buildid"`
+	Arch         string                 `json:"arch"`
+	Artifacts    []ArtifactVerification `json:"artifacts"`
+	Unreferenced []string               `json:"unreferenced,omitempty"`
+}
+
+// Missing returns the artifacts which are referenced but not on disk.
+func (r *VerifyReport) Missing() []ArtifactVerification {
+	return r.withStatus(VerifyMissing)
+}
+
+// Corrupt returns the artifacts whose size or digest does not match.
+func (r *VerifyReport) Corrupt() []ArtifactVerification {
+	return r.withStatus(VerifyCorrupt)
+}
+
+// OK reports whether every artifact was verified successfully.
+func (r *VerifyReport) OK() bool {
+	for _, a := range r.Artifacts {
+		if a.Status != VerifyOK {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *VerifyReport) withStatus(status string) []ArtifactVerification {
+	var ret []ArtifactVerification
+	for _, a := range r.Artifacts {
+		if a.Status == status {
+			ret = append(ret, a)
+		}
+	}
+	return ret
+}
+
+// VerifyArtifacts checks the size and sha256 of every artifact of the build
+// found in dir, and for compressed artifacts also the uncompressed size and
+// sha256. Artifacts are hashed in parallel using up to jobs workers; if jobs
+// is less than one the number of CPUs is used. Files in dir which are not
+// referenced by meta.json are reported as unreferenced.
+func (build *Build) VerifyArtifacts(dir string, jobs int) (*VerifyReport, error) {
+	if jobs < 1 {
+		jobs = runtime.NumCPU()
+	}
+	report := &VerifyReport{
+		BuildID: build.BuildID,
+		Arch:    build.Architecture,
+	}
+
+	if build.BuildArtifacts == nil {
+		build.BuildArtifacts = new(BuildArtifacts)
+	}
+	referenced := make(map[string]bool)
+	var names []string
+	artifacts := build.artifacts()
+	for name, a := range artifacts {
+		if a == nil || a.Path == "" {
+			continue
+		}
+		referenced[a.Path] = true
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	report.Artifacts = make([]ArtifactVerification, len(names))
+	work := make(chan int)
+	var wg sync.WaitGroup
+	for i := 0; i < jobs; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range work {
+				name := names[idx]
+				report.Artifacts[idx] = verifyArtifact(dir, name, artifacts[name])
+			}
+		}()
+	}
+	for i := range names {
+		work <- i
+	}
+	close(work)
+	wg.Wait()
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		n := e.Name()
+		if e.IsDir() || referenced[n] || IsMetaJSON(n) || isLooseBuildFile(n) {
+			continue
+		}
+		report.Unreferenced = append(report.Unreferenced, n)
+	}
+	return report, nil
+}
+
+func isLooseBuildFile(name string) bool {
+	if strings.HasPrefix(name, "manifest-lock.generated.") {
+		return true
+	}
+	for _, n := range looseBuildFiles {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+func verifyArtifact(dir, name string, a *Artifact) ArtifactVerification {
+	v := ArtifactVerification{
+		Name:   name,
+		Path:   a.Path,
+		Status: VerifyOK,
+	}
+	fail := func(status, format string, args ...interface{}) {
+		// an error verifying an artifact is less specific than it
+		// being corrupt, so never downgrade the status
+		if v.Status != VerifyCorrupt {
+			v.Status = status
+		}
+		v.Errors = append(v.Errors, fmt.Sprintf(format, args...))
+	}
+
+	p := filepath.Join(dir, a.Path)
+	fi, err := os.Stat(p)
+	if err != nil {
+		if os.IsNotExist(err) {
+			v.Status = VerifyMissing
+		} else {
+			fail(VerifyError, "%v", err)
+		}
+		return v
+	}
+	if a.SizeInBytes != 0 && int64(a.SizeInBytes) != fi.Size() {
+		fail(VerifyCorrupt, "size mismatch: expected %d, found %d", int64(a.SizeInBytes), fi.Size())
+	}
+	sum, _, err := sha256File(p)
+	if err != nil {
+		fail(VerifyError, "hashing: %v", err)
+		return v
+	}
+	if a.Sha256 != "" && sum != a.Sha256 {
+		fail(VerifyCorrupt, "sha256 mismatch: expected %s, found %s", a.Sha256, sum)
+	}
+
+	if a.UncompressedSha256 == "" && a.UncompressedSize == 0 {
+		return v
+	}
+	usum, usize, err := sha256Decompressed(p)
+	if err != nil {
+		fail(VerifyError, "decompressing: %v", err)
+		return v
+	}
+	if a.UncompressedSize != 0 && int64(a.UncompressedSize) != usize {
+		fail(VerifyCorrupt, "uncompressed size mismatch: expected %d, found %d", a.UncompressedSize, usize)
+	}
+	if a.UncompressedSha256 != "" && usum != a.UncompressedSha256 {
+		fail(VerifyCorrupt, "uncompressed sha256 mismatch: expected %s, found %s", a.UncompressedSha256, usum)
+	}
+	return v
+}
+
+// sha256File returns the hex sha256 and size of a file.
+func sha256File(path string) (string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", 0, err
+	}
+	defer f.Close()
+	return sha256Reader(f)
+}
+
+func sha256Reader(r io.Reader) (string, int64, error) {
+	h := sha256.New()
+	n, err := io.Copy(h, r)
+	if err != nil {
+		return "", n, err
+	}
+	return hex.EncodeToString(h.Sum(nil)), n, nil
+}
+
+// sha256Decompressed returns the hex sha256 and size of the decompressed
+// content of a compressed artifact. gzip is handled natively; xz and zstd
+// are piped through the system tools, as cmd-compress does.
+func sha256Decompressed(path string) (string, int64, error) {
+	var tool string
+	switch filepath.Ext(path) {
+	case ".gz":
+		f, err := os.Open(path)
+		if err != nil {
+			return "", 0, err
+		}
+		defer f.Close()
+		gz, err := gzip.NewReader(f)
+		if err != nil {
+			return "", 0, err
+		}
+		defer gz.Close()
+		return sha256Reader(gz)
+	case ".xz":
+		tool = "xz"
+	case ".zst":
+		tool = "zstd"
+	default:
+		return "", 0, fmt.Errorf("unknown compression format for %s", filepath.Base(path))
+	}
+
+	cmd := exec.Command(tool, "-dc", path)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.StdoutPipe()
+	if err != nil {
+		return "", 0, err
+	}
+	if err := cmd.Start(); err != nil {
+		return "", 0, err
+	}
+	sum, n, herr := sha256Reader(out)
+	if err := cmd.Wait(); err != nil {
+		return "", n, fmt.Errorf("%s -dc: %w", tool, err)
+	}
+	return sum, n, herr
+}
@@ -0,0 +1,94 @@
+package builds
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func sha256Hex(b []byte) string {
+	s := sha256.Sum256(b)
+	return hex.EncodeToString(s[:])
+}
+
+func TestVerifyArtifacts(t *testing.T) {
+	dir := t.TempDir()
+
+	qemu := bytes.Repeat([]byte("qcow2"), 1024)
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	if _, err := w.Write(qemu); err != nil {
+		t.Fatal(err)
+	}
+	w.Close()
+	if err := os.WriteFile(filepath.Join(dir, "test-qemu.qcow2.gz"), gz.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// metal is truncated on disk
+	metal := []byte("a raw disk image")
+	if err := os.WriteFile(filepath.Join(dir, "test-metal.raw"), metal[:4], 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range []string{"meta.json", "commitmeta.json", "stray.iso"} {
+		if err := os.WriteFile(filepath.Join(dir, f), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	b := &Build{
+		BuildID:      "test",
+		Architecture: "x86_64",
+		BuildArtifacts: &BuildArtifacts{
+			Qemu: &Artifact{
+				Path:               "test-qemu.qcow2.gz",
+				Sha256:             sha256Hex(gz.Bytes()),
+				SizeInBytes:        float64(gz.Len()),
+				UncompressedSha256: sha256Hex(qemu),
+				UncompressedSize:   len(qemu),
+			},
+			Metal: &Artifact{
+				Path:        "test-metal.raw",
+				Sha256:      sha256Hex(metal),
+				SizeInBytes: float64(len(metal)),
+			},
+			Aws: &Artifact{
+				Path:   "test-aws.vmdk",
+				Sha256: sha256Hex(nil),
+			},
+		},
+	}
+
+	report, err := b.VerifyArtifacts(dir, 2)
+	if err != nil {
+		t.Fatalf("failed to verify build: %v", err)
+	}
+	if report.OK() {
+		t.Errorf("report should not be OK")
+	}
+
+	status := make(map[string]string)
+	for _, a := range report.Artifacts {
+		status[a.Name] = a.Status
+	}
+	expected := map[string]string{
+		"qemu":  VerifyOK,
+		"metal": VerifyCorrupt,
+		"aws":   VerifyMissing,
+	}
+	for name, want := range expected {
+		if status[name] != want {
+			t.Errorf("expected %s to be %s, got %s", name, want, status[name])
+		}
+	}
+	if len(report.Artifacts) != len(expected) {
+		t.Errorf("expected %d artifacts in the report, got %d", len(expected), len(report.Artifacts))
+	}
+	if len(report.Unreferenced) != 1 || report.Unreferenced[0] != "stray.iso" {
+		t.Errorf("expected stray.iso to be unreferenced, got %v", report.Unreferenced)
+	}
+}
@@ -51,4 +51,19 @@ var generatedSchemaJSON = \`$(< ${schema_json})
 \`
 EOM
 
+builds_schema_json="builds-${schema_version}.json"
+builds_digest=$(sha256sum "${builds_schema_json}" | awk '{print $1}')
+echo "Generating COSA builds.json Schema ${schema_version}"
+
+cat > "${tdir}/builds_schema_doc.go" <<EOM
+// Generated by ${0}
+// Source hash: ${builds_digest}
+// DO NOT EDIT
+
+package builds
+
+var generatedBuildsSchemaJSON = \`$(< ${builds_schema_json})
+\`
+EOM
+
 cp -av ${tdir}/*go ${mydir}/../pkg/builds/
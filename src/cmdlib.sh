@@ -1017,6 +1017,21 @@ sha256sum_str() {
     sha256sum | cut -f 1 -d ' '
 }
 
+# Write an artifact event for the given file to the event stream in
+# COSA_EVENTS_FD; see the "Machine-readable events" section of docs/cosa.md.
+# Does nothing when the stream is not enabled.
+emit_artifact_event() {
+    local path=$1; shift
+    if [ -z "${COSA_EVENTS_FD:-}" ]; then
+        return
+    fi
+    jq -nc --arg time "$(date -u +%Y-%m-%dT%H:%M:%S.%NZ)" \
+        --arg command "$(basename "$0")" --argjson pid $$ \
+        --arg path "$(realpath "${path}")" --argjson size "$(stat -c '%s' "${path}")" \
+        --arg sha256 "$(sha256sum_str < "${path}")" \
+        '{type: "artifact", $time, $command, $pid, $path, $size, $sha256}' >&"${COSA_EVENTS_FD}"
+}
+
 get_latest_build() {
     if [ -L "${workdir:-$(pwd)}/builds/latest" ]; then
         readlink "${workdir:-$(pwd)}/builds/latest"
@@ -1075,8 +1090,9 @@ import sys
 sys.path.insert(0, '${DIR}')
 from cosalib.builds import Builds
 builds = Builds('${workdir:-$(pwd)}')
-builds.insert_build('${buildid}', basearch='${arch:-}')
-builds.bump_timestamp()
+with builds.lock():
+    builds.insert_build('${buildid}', basearch='${arch:-}')
+    builds.bump_timestamp()
 print('Build ${buildid} was inserted ${arch:+for $arch}')")
 }
 
@@ -2,6 +2,7 @@
 Builds interacts with builds.json
 """
 
+import contextlib
 import json
 import os
 import gi
@@ -11,6 +12,7 @@ gi.require_version('OSTree', '1.0')
 from gi.repository import Gio, OSTree
 
 from cosalib.cmdlib import (
+    flock,
     get_basearch,
     get_timestamp,
     import_ostree_commit,
@@ -23,6 +25,9 @@ Build = collections.namedtuple('Build', ['id', 'timestamp', 'basearches'])
 BUILDFILES = {
     # The list of builds.
     'list': 'builds/builds.json',
+    # The lock serializing writers of the list; the Go writers in
+    # pkg/builds take it too
+    'lock': 'builds/.builds.json.flock',
     # This copy of builds.json tracks what we last downloaded from the source
     'sourcedata': 'tmp/builds-source.json',
     # This tracks the URL passed to buildfetch
@@ -50,6 +55,17 @@ class Builds:  # pragma: nocover
         if int(parts[0]) != 1:
             raise SystemExit(f"Unsupported build metadata version {ver}")
 
+    @contextlib.contextmanager
+    def lock(self):
+        """
+        Hold the lock of the list and reload it, so that changes made
+        meanwhile by other writers aren't overwritten by flush().
+        """
+        with flock(self._path(BUILDFILES['lock'])):
+            if os.path.isfile(self._fn):
+                self._data = load_json(self._fn, require_exclusive=False)
+            yield
+
     def _path(self, path):
         if not self._workdir:
             return path
@@ -2,6 +2,8 @@
 """
 Houses helper code for python based coreos-assembler commands.
 """
+import contextlib
+import fcntl
 import glob
 import hashlib
 import json
@@ -156,6 +158,22 @@ def write_json(path, data, lock_path=None, merge_func=None):
         shutil.move(f.name, path)
 
 
+@contextlib.contextmanager
+def flock(path):
+    """
+    Hold an exclusive flock(2) on path, creating it if needed.
+
+    :param path: The full path to the lock file
+    :type: path: str
+    """
+    with open(path, 'a') as f:
+        fcntl.flock(f, fcntl.LOCK_EX)
+        try:
+            yield
+        finally:
+            fcntl.flock(f, fcntl.LOCK_UN)
+
+
 def load_json(path, require_exclusive=True, lock_path=None):
     """
     Shortcut for loading json from a file path.
@@ -200,6 +218,33 @@ def sha256sum_file(path):
     return h.hexdigest()
 
 
+def emit_artifact_event(path, sha256=None, size=None):
+    """
+    Writes an artifact event to the event stream in COSA_EVENTS_FD, if
+    enabled. See the "Machine-readable events" section of docs/cosa.md.
+
+    :param path: The path to the artifact
+    :type path: str
+    :param sha256: The sha256 sum of the artifact, if already known
+    :type sha256: str
+    :param size: The size of the artifact, if already known
+    :type size: int
+    """
+    fd = os.environ.get('COSA_EVENTS_FD')
+    if not fd:
+        return
+    event = {
+        'type': 'artifact',
+        'time': datetime.datetime.now(datetime.timezone.utc).isoformat(),
+        'command': os.path.basename(sys.argv[0]),
+        'pid': os.getpid(),
+        'path': os.path.abspath(path),
+        'size': size if size is not None else os.stat(path).st_size,
+        'sha256': sha256 or sha256sum_file(path),
+    }
+    os.write(int(fd), (json.dumps(event) + '\n').encode())
+
+
 def fatal(msg):
     """
     Prints fatal error messages and exits execution.
@@ -20,6 +20,7 @@ from cosalib.cmdlib import (
     get_basearch,
     image_info,
     runcmd,
+    emit_artifact_event,
     sha256sum_file,
     import_ostree_commit
 )
@@ -363,5 +364,6 @@ class QemuVariantImage(_Build):
         self._found_files[self.image_name] = img_meta
         imgs[self.platform] = img_meta
         self.meta_write(artifact_name=self.platform_image_name)
+        emit_artifact_event(self.image_path, sha256=img_meta['sha256'], size=img_meta['size'])
         if self.compress:
             subprocess.check_call(['cosa', 'compress', '--artifact=' + self.platform])
@@ -1,4 +1,5 @@
 import datetime
+import json
 import os
 import platform
 import pytest
@@ -56,6 +57,30 @@ def test_sha256sum_file(tmpdir):
     assert shasum == e
 
 
+def test_emit_artifact_event(tmpdir, monkeypatch):
+    """
+    Verify artifact events are written to COSA_EVENTS_FD when set
+    """
+    test_file = os.path.join(tmpdir, 'testfile')
+    with open(test_file, 'w') as f:
+        f.write('test')
+    # not enabled
+    monkeypatch.delenv('COSA_EVENTS_FD', raising=False)
+    cmdlib.emit_artifact_event(test_file)
+
+    r, w = os.pipe()
+    monkeypatch.setenv('COSA_EVENTS_FD', str(w))
+    cmdlib.emit_artifact_event(test_file)
+    os.close(w)
+    with os.fdopen(r) as f:
+        event = json.loads(f.read())
+    assert event['type'] == 'artifact'
+    assert event['path'] == test_file
+    assert event['size'] == 4
+    assert event['sha256'] == '9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08'
+    assert event['pid'] == os.getpid()
+
+
 def test_fatal(capsys):
     """
     Ensure that fatal does indeed attempt to exit